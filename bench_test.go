@@ -1,6 +1,8 @@
 package giraffe
 
 import (
+	"math/rand"
+	"runtime"
 	"strconv"
 	"testing"
 )
@@ -39,8 +41,8 @@ func BenchmarkDFS(b *testing.B) {
 	g, _ := NewGraph("benchGraph")
 	for n := 1; n < b.N; n++ {
 		g.InsertNode()
-		g.Nodes[uint64(n-1)].AddRelationship(g.Nodes[uint64(n)])
-		g.Root().DepthFirstSearch(g.Nodes[uint64(n)])
+		g.nodes[uint64(n-1)].AddRelationship(g.nodes[uint64(n)])
+		g.Root().DepthFirstSearch(g.nodes[uint64(n)])
 	}
 }
 
@@ -48,8 +50,8 @@ func BenchmarkBFS(b *testing.B) {
 	g, _ := NewGraph("benchGraph")
 	for n := 1; n < b.N; n++ {
 		g.InsertNode()
-		g.Nodes[uint64(n-1)].AddRelationship(g.Nodes[uint64(n)])
-		g.Root().BreadthFirstSearch(g.Nodes[uint64(n)])
+		g.nodes[uint64(n-1)].AddRelationship(g.nodes[uint64(n)])
+		g.Root().BreadthFirstSearch(g.nodes[uint64(n)])
 	}
 }
 
@@ -58,7 +60,68 @@ func BenchmarkFindByKey(b *testing.B) {
 	for n := 1; n < b.N; n++ {
 		key := strconv.Itoa(n)
 		g.InsertDataNode(key, []byte("data"))
-		g.Nodes[uint64(n-1)].AddRelationship(g.Nodes[uint64(n)])
+		g.nodes[uint64(n-1)].AddRelationship(g.nodes[uint64(n)])
 		g.FindNodeByKey(key)
 	}
 }
+
+// newBenchTree builds a random tree, so every node is reached by one path and the searches
+// visit each node once
+func newBenchTree(size int) *Graph {
+	g, _ := NewGraph("benchGraph")
+	r := rand.New(rand.NewSource(1))
+	nodes := []*Node{g.Root()}
+	for n := 1; n < size; n++ {
+		node, _ := g.InsertDataNode("node "+strconv.Itoa(n), []byte("data"))
+		nodes[r.Intn(len(nodes))].AddRelationship(node)
+		nodes = append(nodes, node)
+	}
+	return g
+}
+
+// heapInUse reports the live heap after a collection. It is signed so the growth between two
+// readings is negative, rather than wrapping, if the heap shrinks.
+func heapInUse() int64 {
+	var m runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&m)
+	return int64(m.HeapInuse)
+}
+
+func BenchmarkGraphMemory(b *testing.B) {
+	for n := 0; n < b.N; n++ {
+		before := heapInUse()
+		g := newBenchTree(100000)
+		b.ReportMetric(float64(heapInUse()-before)/float64(g.NodeCount()), "bytes/node")
+		runtime.KeepAlive(g)
+	}
+}
+
+func BenchmarkFrozenMemory(b *testing.B) {
+	g := newBenchTree(100000)
+	for n := 0; n < b.N; n++ {
+		before := heapInUse()
+		f := g.Freeze()
+		b.ReportMetric(float64(heapInUse()-before)/float64(f.NodeCount()), "bytes/node")
+		runtime.KeepAlive(f)
+	}
+}
+
+func BenchmarkGraphTraversal(b *testing.B) {
+	g := newBenchTree(100000)
+	stranded := g.InsertNode()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		g.Root().BreadthFirstSearch(stranded)
+	}
+}
+
+func BenchmarkFrozenTraversal(b *testing.B) {
+	g := newBenchTree(100000)
+	stranded := g.InsertNode()
+	f := g.Freeze()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		f.BreadthFirstSearch(0, stranded.ID)
+	}
+}
@@ -0,0 +1,450 @@
+package giraffe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"slices"
+	"sort"
+)
+
+// B+tree error constants
+const (
+	// ErrTreeKeyTooLarge returns when a B+tree key is longer than maxTreeKey
+	ErrTreeKeyTooLarge = "tree key too large"
+)
+
+const (
+	// maxTreeKey is the longest key a B+tree accepts
+	maxTreeKey = 512
+
+	// maxInlineValue is the longest value kept in a leaf page. Longer values are written to a
+	// chain of overflow pages.
+	maxInlineValue = 512
+
+	// treePageHeader is the size of a page's type, key count and next leaf or first child
+	treePageHeader = 11
+)
+
+// tree page types
+const (
+	pageLeaf byte = iota + 1
+	pageInternal
+)
+
+// btree is a B+tree stored in a pager, identified by the header slot holding its root page.
+// Pages are not merged when entries are deleted; emptied leaves stay in place.
+type btree struct {
+	p    *pager
+	tree int
+}
+
+// treeValue is a leaf value, either inline or the first page of an overflow chain
+type treeValue struct {
+	inline   []byte
+	overflow uint64
+	length   uint32
+}
+
+// treePage is a decoded tree page. Leaves hold keys and values and link to the next leaf;
+// internal pages hold len(keys)+1 children where children[i] holds keys before keys[i].
+type treePage struct {
+	leaf     bool
+	keys     [][]byte
+	values   []treeValue
+	next     uint64
+	children []uint64
+}
+
+// root returns the tree's root page, or 0 for an empty tree
+func (t btree) root() uint64 {
+	return t.p.header.roots[t.tree]
+}
+
+// get returns the value stored under key
+func (t btree) get(key []byte) ([]byte, bool, error) {
+	id := t.root()
+	for id != 0 {
+		page, err := t.load(id)
+		if err != nil {
+			return nil, false, err
+		}
+		if !page.leaf {
+			id = page.children[page.child(key)]
+			continue
+		}
+		i, found := page.find(key)
+		if !found {
+			return nil, false, nil
+		}
+		value, err := t.value(page.values[i])
+		return value, err == nil, err
+	}
+	return nil, false, nil
+}
+
+// put inserts or replaces the value stored under key
+func (t btree) put(key, value []byte) error {
+	if len(key) > maxTreeKey {
+		return errors.New(ErrTreeKeyTooLarge)
+	}
+	v, err := t.newValue(value)
+	if err != nil {
+		return err
+	}
+	key = append([]byte(nil), key...)
+
+	root := t.root()
+	if root == 0 {
+		id, err := t.p.allocate()
+		if err != nil {
+			return err
+		}
+		t.store(id, &treePage{leaf: true, keys: [][]byte{key}, values: []treeValue{v}})
+		t.p.header.roots[t.tree] = id
+		return nil
+	}
+
+	splitKey, right, err := t.insert(root, key, v)
+	if err != nil || right == 0 {
+		return err
+	}
+	// the root split, so the tree grows a level
+	id, err := t.p.allocate()
+	if err != nil {
+		return err
+	}
+	t.store(id, &treePage{keys: [][]byte{splitKey}, children: []uint64{root, right}})
+	t.p.header.roots[t.tree] = id
+	return nil
+}
+
+// insert adds an entry below the page, returning the separator key and new page if it split
+func (t btree) insert(id uint64, key []byte, v treeValue) ([]byte, uint64, error) {
+	page, err := t.load(id)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if page.leaf {
+		i, found := page.find(key)
+		if found {
+			if err := t.freeValue(page.values[i]); err != nil {
+				return nil, 0, err
+			}
+			page.values[i] = v
+		} else {
+			page.keys = slices.Insert(page.keys, i, key)
+			page.values = slices.Insert(page.values, i, v)
+		}
+	} else {
+		i := page.child(key)
+		splitKey, right, err := t.insert(page.children[i], key, v)
+		if err != nil || right == 0 {
+			return nil, 0, err
+		}
+		page.keys = slices.Insert(page.keys, i, splitKey)
+		page.children = slices.Insert(page.children, i+1, right)
+	}
+
+	if page.size() <= pageSize {
+		t.store(id, page)
+		return nil, 0, nil
+	}
+	return t.split(id, page)
+}
+
+// split moves the upper half of a full page to a new page
+func (t btree) split(id uint64, page *treePage) ([]byte, uint64, error) {
+	rightID, err := t.p.allocate()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	m := page.splitPoint()
+	right := &treePage{leaf: page.leaf}
+	var splitKey []byte
+	if page.leaf {
+		right.keys = slices.Clone(page.keys[m:])
+		right.values = slices.Clone(page.values[m:])
+		right.next = page.next
+		page.keys, page.values, page.next = page.keys[:m], page.values[:m], rightID
+		splitKey = right.keys[0]
+	} else {
+		splitKey = page.keys[m]
+		right.keys = slices.Clone(page.keys[m+1:])
+		right.children = slices.Clone(page.children[m+1:])
+		page.keys, page.children = page.keys[:m], page.children[:m+1]
+	}
+
+	t.store(id, page)
+	t.store(rightID, right)
+	return splitKey, rightID, nil
+}
+
+// delete removes the entry stored under key
+func (t btree) delete(key []byte) (bool, error) {
+	id := t.root()
+	for id != 0 {
+		page, err := t.load(id)
+		if err != nil {
+			return false, err
+		}
+		if !page.leaf {
+			id = page.children[page.child(key)]
+			continue
+		}
+		i, found := page.find(key)
+		if !found {
+			return false, nil
+		}
+		if err := t.freeValue(page.values[i]); err != nil {
+			return false, err
+		}
+		page.keys = slices.Delete(page.keys, i, i+1)
+		page.values = slices.Delete(page.values, i, i+1)
+		t.store(id, page)
+		return true, nil
+	}
+	return false, nil
+}
+
+// ascend calls fn for each entry with a key at or after start, in key order, until fn returns
+// false. fn must not modify the tree.
+func (t btree) ascend(start []byte, fn func(key, value []byte) bool) error {
+	id := t.root()
+	for id != 0 {
+		page, err := t.load(id)
+		if err != nil {
+			return err
+		}
+		if !page.leaf {
+			id = page.children[page.child(start)]
+			continue
+		}
+
+		i, _ := page.find(start)
+		for {
+			for ; i < len(page.keys); i++ {
+				value, err := t.value(page.values[i])
+				if err != nil {
+					return err
+				}
+				if !fn(page.keys[i], value) {
+					return nil
+				}
+			}
+			if page.next == 0 {
+				return nil
+			}
+			if page, err = t.load(page.next); err != nil {
+				return err
+			}
+			i = 0
+		}
+	}
+	return nil
+}
+
+// newValue stores a value inline or in a new overflow chain
+func (t btree) newValue(value []byte) (treeValue, error) {
+	if len(value) <= maxInlineValue {
+		return treeValue{inline: append([]byte{}, value...)}, nil
+	}
+
+	// overflow pages hold the next page number followed by data
+	chunk := pageSize - 8
+	ids := make([]uint64, (len(value)+chunk-1)/chunk)
+	for i := range ids {
+		id, err := t.p.allocate()
+		if err != nil {
+			return treeValue{}, err
+		}
+		ids[i] = id
+	}
+	for i, id := range ids {
+		data := make([]byte, pageSize)
+		if i+1 < len(ids) {
+			binary.BigEndian.PutUint64(data, ids[i+1])
+		}
+		copy(data[8:], value[i*chunk:])
+		t.p.write(id, data)
+	}
+	return treeValue{overflow: ids[0], length: uint32(len(value))}, nil
+}
+
+// value reads a leaf value
+func (t btree) value(v treeValue) ([]byte, error) {
+	if v.overflow == 0 {
+		return v.inline, nil
+	}
+	value := make([]byte, 0, v.length)
+	for id := v.overflow; len(value) < int(v.length); {
+		data, err := t.p.get(id)
+		if err != nil {
+			return nil, err
+		}
+		n := min(int(v.length)-len(value), pageSize-8)
+		value = append(value, data[8:8+n]...)
+		id = binary.BigEndian.Uint64(data)
+	}
+	return value, nil
+}
+
+// freeValue releases a value's overflow pages
+func (t btree) freeValue(v treeValue) error {
+	for id := v.overflow; id != 0; {
+		data, err := t.p.get(id)
+		if err != nil {
+			return err
+		}
+		next := binary.BigEndian.Uint64(data)
+		t.p.free(id)
+		id = next
+	}
+	return nil
+}
+
+// load reads and decodes a tree page
+func (t btree) load(id uint64) (*treePage, error) {
+	data, err := t.p.get(id)
+	if err != nil {
+		return nil, err
+	}
+	return decodeTreePage(data)
+}
+
+// store encodes a tree page into the current transaction
+func (t btree) store(id uint64, page *treePage) {
+	t.p.write(id, page.encode())
+}
+
+// child returns the index of the child that would hold key
+func (page *treePage) child(key []byte) int {
+	return sort.Search(len(page.keys), func(i int) bool { return bytes.Compare(page.keys[i], key) > 0 })
+}
+
+// find returns the index of the first key at or after key, and whether it is an exact match
+func (page *treePage) find(key []byte) (int, bool) {
+	i := sort.Search(len(page.keys), func(i int) bool { return bytes.Compare(page.keys[i], key) >= 0 })
+	return i, i < len(page.keys) && bytes.Equal(page.keys[i], key)
+}
+
+// cellSize returns the encoded size of the entry at i
+func (page *treePage) cellSize(i int) int {
+	size := 2 + len(page.keys[i])
+	switch {
+	case !page.leaf:
+		size += 8
+	case page.values[i].overflow != 0:
+		size += 13
+	default:
+		size += 3 + len(page.values[i].inline)
+	}
+	return size
+}
+
+// size returns the encoded size of the page
+func (page *treePage) size() int {
+	size := treePageHeader
+	for i := range page.keys {
+		size += page.cellSize(i)
+	}
+	return size
+}
+
+// splitPoint chooses where to split a full page so both halves hold about the same number of bytes
+func (page *treePage) splitPoint() int {
+	half := page.size() / 2
+	size := treePageHeader
+	for i := range page.keys {
+		size += page.cellSize(i)
+		if size > half {
+			return max(1, min(i, len(page.keys)-1))
+		}
+	}
+	return len(page.keys) / 2
+}
+
+// encode writes the page in its on disk form
+func (page *treePage) encode() []byte {
+	data := make([]byte, pageSize)
+	if page.leaf {
+		data[0] = pageLeaf
+		binary.BigEndian.PutUint64(data[3:], page.next)
+	} else {
+		data[0] = pageInternal
+		binary.BigEndian.PutUint64(data[3:], page.children[0])
+	}
+	binary.BigEndian.PutUint16(data[1:], uint16(len(page.keys)))
+
+	at := treePageHeader
+	for i, key := range page.keys {
+		binary.BigEndian.PutUint16(data[at:], uint16(len(key)))
+		at += 2
+		at += copy(data[at:], key)
+
+		if !page.leaf {
+			binary.BigEndian.PutUint64(data[at:], page.children[i+1])
+			at += 8
+			continue
+		}
+		v := page.values[i]
+		if v.overflow != 0 {
+			data[at] = 1
+			binary.BigEndian.PutUint64(data[at+1:], v.overflow)
+			binary.BigEndian.PutUint32(data[at+9:], v.length)
+			at += 13
+			continue
+		}
+		binary.BigEndian.PutUint16(data[at+1:], uint16(len(v.inline)))
+		at += 3
+		at += copy(data[at:], v.inline)
+	}
+	return data
+}
+
+// decodeTreePage reads a page written by encode
+func decodeTreePage(data []byte) (*treePage, error) {
+	if data[0] != pageLeaf && data[0] != pageInternal {
+		return nil, errors.New("corrupt tree page")
+	}
+	page := &treePage{leaf: data[0] == pageLeaf}
+	count := int(binary.BigEndian.Uint16(data[1:]))
+	first := binary.BigEndian.Uint64(data[3:])
+	page.keys = make([][]byte, count)
+	if page.leaf {
+		page.next = first
+		page.values = make([]treeValue, count)
+	} else {
+		page.children = make([]uint64, 1, count+1)
+		page.children[0] = first
+	}
+
+	at := treePageHeader
+	for i := 0; i < count; i++ {
+		n := int(binary.BigEndian.Uint16(data[at:]))
+		at += 2
+		page.keys[i] = append([]byte{}, data[at:at+n]...)
+		at += n
+
+		if !page.leaf {
+			page.children = append(page.children, binary.BigEndian.Uint64(data[at:]))
+			at += 8
+			continue
+		}
+		if data[at] == 1 {
+			page.values[i] = treeValue{
+				overflow: binary.BigEndian.Uint64(data[at+1:]),
+				length:   binary.BigEndian.Uint32(data[at+9:]),
+			}
+			at += 13
+			continue
+		}
+		n = int(binary.BigEndian.Uint16(data[at+1:]))
+		at += 3
+		page.values[i] = treeValue{inline: append([]byte{}, data[at:at+n]...)}
+		at += n
+	}
+	return page, nil
+}
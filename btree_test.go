@@ -0,0 +1,92 @@
+package giraffe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"testing"
+)
+
+func newTestTree(t *testing.T, cachePages int) btree {
+	p, err := openPager(filepath.Join(t.TempDir(), "tree.db"), cachePages)
+	if err != nil {
+		t.Fatalf("unable to open pager, error: %v", err)
+	}
+	t.Cleanup(func() { p.close() })
+	return btree{p: p, tree: treeNodes}
+}
+
+// treeValueFor returns a value for i, every tenth one large enough to overflow
+func treeValueFor(i int) []byte {
+	value := []byte(fmt.Sprintf("value %d", i))
+	if i%10 == 0 {
+		value = bytes.Repeat(value, 1000)
+	}
+	return value
+}
+
+func TestBTreePutGetDelete(t *testing.T) {
+	tree := newTestTree(t, 16)
+
+	order := rand.New(rand.NewSource(1)).Perm(5000)
+	for n, i := range order {
+		if err := tree.put(binary.BigEndian.AppendUint64(nil, uint64(i)), treeValueFor(i)); err != nil {
+			t.Fatalf("unable to put %d, error: %v", i, err)
+		}
+		if n%100 == 0 {
+			if err := tree.p.commit(false); err != nil {
+				t.Fatalf("unable to commit, error: %v", err)
+			}
+		}
+	}
+	tree.p.commit(false)
+
+	for i := 0; i < 5000; i++ {
+		value, ok, err := tree.get(binary.BigEndian.AppendUint64(nil, uint64(i)))
+		if err != nil || !ok || !bytes.Equal(value, treeValueFor(i)) {
+			t.Fatalf("got %q, %v, %v for %d", value, ok, err, i)
+		}
+	}
+
+	for i := 0; i < 5000; i += 2 {
+		if ok, err := tree.delete(binary.BigEndian.AppendUint64(nil, uint64(i))); !ok || err != nil {
+			t.Fatalf("unable to delete %d, error: %v", i, err)
+		}
+	}
+	tree.p.commit(false)
+
+	var got []uint64
+	tree.ascend(binary.BigEndian.AppendUint64(nil, 4000), func(key, value []byte) bool {
+		got = append(got, binary.BigEndian.Uint64(key))
+		return len(got) < 3
+	})
+	if fmt.Sprint(got) != "[4001 4003 4005]" {
+		t.Errorf("got %v, want [4001 4003 4005]", got)
+	}
+	if _, ok, _ := tree.get(binary.BigEndian.AppendUint64(nil, 10)); ok {
+		t.Error("deleted key still found")
+	}
+}
+
+func TestBTreeReusesFreedPages(t *testing.T) {
+	tree := newTestTree(t, 0)
+	large := bytes.Repeat([]byte("x"), 5*pageSize)
+
+	tree.put([]byte("a"), large)
+	tree.p.commit(false)
+	pages := tree.p.header.pageCount
+
+	for i := 0; i < 10; i++ {
+		tree.put([]byte("a"), large)
+		tree.p.commit(false)
+	}
+	if tree.p.header.pageCount > pages+6 {
+		t.Errorf("overflow pages not reused, got %d pages from %d", tree.p.header.pageCount, pages)
+	}
+
+	if err := tree.put(bytes.Repeat([]byte("k"), maxTreeKey+1), nil); err == nil || err.Error() != ErrTreeKeyTooLarge {
+		t.Errorf("got `%v`, want `%s`", err, ErrTreeKeyTooLarge)
+	}
+}
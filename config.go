@@ -0,0 +1,219 @@
+package giraffe
+
+import "errors"
+
+// Config error constants
+const (
+	// ErrMaxNodes returns when an insert would take a graph past Config.MaxNodes
+	ErrMaxNodes = "too many nodes"
+
+	// ErrInvalidConfig returns when New() is given settings that cannot be used
+	ErrInvalidConfig = "invalid config"
+)
+
+// Config holds a graph's settings. It is saved with the graph. See New().
+type Config struct {
+	// DuplicateKeys allows more than one node with the same key. Nodes with the empty key, such
+	// as the root, are never duplicates, even when it is false.
+	DuplicateKeys bool
+	// CircularRelationships allows relationships that form a cycle
+	CircularRelationships bool
+	// Undirected makes each relationship link both nodes. See NewUndirectedGraph().
+	Undirected bool
+	// ParallelRelationships allows more than one relationship between the same nodes and
+	// SelfRelationships allows relating a node to itself. See SetRelationshipConstraints().
+	ParallelRelationships bool
+	SelfRelationships     bool
+	// MaxNodes limits the number of nodes besides the root, or is 0 for no limit. It is a soft
+	// limit: InsertNode() has no error to return, so it may insert past the limit, though its
+	// nodes count toward it. Every other insert returns ErrMaxNodes. Replicas replay inserts
+	// without checking the limit, so they keep every node of their primary.
+	MaxNodes int
+}
+
+// DefaultConfig returns the settings of NewGraph(): a directed graph that allows duplicate keys,
+// circular, parallel and self relationships, with no limit on its size
+func DefaultConfig() Config {
+	return Config{
+		DuplicateKeys:         true,
+		CircularRelationships: true,
+		ParallelRelationships: true,
+		SelfRelationships:     true,
+	}
+}
+
+// Option sets up a graph made by New()
+type Option func(*options)
+
+// options collects the settings given to New(), including those not saved with the graph
+type options struct {
+	config     Config
+	allocator  IDAllocator
+	store      Store
+	validators []Validator
+	listeners  []func(LogEntry)
+	history    bool
+}
+
+// WithConfig replaces all of the graph's saved settings
+func WithConfig(c Config) Option {
+	return func(o *options) { o.config = c }
+}
+
+// WithDuplicateKeys controls whether nodes may share a key
+func WithDuplicateKeys(allowed bool) Option {
+	return func(o *options) { o.config.DuplicateKeys = allowed }
+}
+
+// WithCircularRelationships controls whether relationships may form a cycle
+func WithCircularRelationships(allowed bool) Option {
+	return func(o *options) { o.config.CircularRelationships = allowed }
+}
+
+// WithUndirected makes the graph's relationships undirected. See NewUndirectedGraph().
+func WithUndirected() Option {
+	return func(o *options) { o.config.Undirected = true }
+}
+
+// WithParallelRelationships controls whether nodes may be related more than once
+func WithParallelRelationships(allowed bool) Option {
+	return func(o *options) { o.config.ParallelRelationships = allowed }
+}
+
+// WithSelfRelationships controls whether a node may be related to itself
+func WithSelfRelationships(allowed bool) Option {
+	return func(o *options) { o.config.SelfRelationships = allowed }
+}
+
+// WithMaxNodes limits the number of nodes besides the root. See Config.MaxNodes for the inserts
+// it does not stop.
+func WithMaxNodes(max int) Option {
+	return func(o *options) { o.config.MaxNodes = max }
+}
+
+// WithIDAllocator chooses how node IDs are allocated. It is not saved. See SetIDAllocator().
+func WithIDAllocator(a IDAllocator) Option {
+	return func(o *options) { o.allocator = a }
+}
+
+// WithStore writes every change through to an empty store. See NewStoreGraph().
+func WithStore(s Store) Option {
+	return func(o *options) { o.store = s }
+}
+
+// WithValidator registers a validator. It is not saved. See AddValidator().
+func WithValidator(v Validator) Option {
+	return func(o *options) { o.validators = append(o.validators, v) }
+}
+
+// WithListener registers a listener. It is not saved. See AddListener().
+func WithListener(l func(LogEntry)) Option {
+	return func(o *options) { o.listeners = append(o.listeners, l) }
+}
+
+// WithHistory records the graph's history from its creation. See EnableHistory().
+func WithHistory() Option {
+	return func(o *options) { o.history = true }
+}
+
+// New creates a graph with the default settings changed by the options, such as
+//
+//	g, err := giraffe.New("math curriculum", giraffe.WithDuplicateKeys(false), giraffe.WithMaxNodes(1000))
+func New(name string, opts ...Option) (*Graph, error) {
+	o := options{config: DefaultConfig()}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.config.MaxNodes < 0 {
+		return nil, errors.New(ErrInvalidConfig)
+	}
+	if o.store != nil {
+		existing, err := o.store.Meta(graphMetaKey)
+		if err != nil {
+			return nil, err
+		}
+		if existing != nil {
+			return nil, errors.New(ErrStoreNotEmpty)
+		}
+	}
+
+	g := &Graph{
+		Name:          name,
+		nodes:         make(map[uint64]*Node),
+		keys:          make(map[string]map[uint64]bool),
+		labels:        make(map[string]map[uint64]bool),
+		indexes:       make(map[string]*propertyIndex),
+		search:        newSearchIndex(),
+		externalIDs:   make(map[string]uint64),
+		relationships: make(map[uint64]Edge),
+		allocator:     o.allocator,
+		validators:    o.validators,
+		listeners:     o.listeners,
+	}
+	g.setConfig(o.config)
+	// insert root node
+	g.nodes[0] = &Node{ID: 0, graph: g}
+	g.indexKey("", 0)
+
+	g.Lock()
+	defer g.Unlock()
+	if o.store != nil {
+		g.store = o.store
+		err := g.store.Update(func(b StoreBatch) error {
+			if err := b.PutNode(g.nodes[0].record()); err != nil {
+				return err
+			}
+			return g.writeMeta(b)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if o.history {
+		// after the store is set so the history is saved to it
+		if err := g.enableHistory(); err != nil {
+			return nil, err
+		}
+	}
+	return g, nil
+}
+
+// Config returns the graph's settings
+func (g *Graph) Config() Config {
+	g.Lock()
+	defer g.Unlock()
+
+	return Config{
+		DuplicateKeys:         g.duplicateKeys,
+		CircularRelationships: g.circularRelationship,
+		Undirected:            g.undirected,
+		ParallelRelationships: !g.forbidParallel,
+		SelfRelationships:     !g.forbidSelf,
+		MaxNodes:              g.maxNodes,
+	}
+}
+
+// setConfig applies settings to a new graph
+func (g *Graph) setConfig(c Config) {
+	g.duplicateKeys = c.DuplicateKeys
+	g.circularRelationship = c.CircularRelationships
+	g.undirected = c.Undirected
+	g.forbidParallel = !c.ParallelRelationships
+	g.forbidSelf = !c.SelfRelationships
+	g.maxNodes = c.MaxNodes
+}
+
+// checkMaxNodes reports whether the graph has room for another node. It is checked where an
+// insert starts, not when one is replayed. The caller must hold the graph lock.
+func (g *Graph) checkMaxNodes() error {
+	if g.maxNodes == 0 {
+		return nil
+	}
+	if err := g.loadAll(); err != nil {
+		return err
+	}
+	if len(g.nodes)-1 >= g.maxNodes {
+		return errors.New(ErrMaxNodes)
+	}
+	return nil
+}
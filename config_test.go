@@ -0,0 +1,115 @@
+package giraffe
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	g, err := New("testGraph")
+	if err != nil {
+		t.Fatalf("unable to create graph, error: %v", err)
+	}
+	if got := g.Config(); !reflect.DeepEqual(got, DefaultConfig()) {
+		t.Errorf("got config %+v, want %+v", got, DefaultConfig())
+	}
+	if c, _ := NewConstraintGraph("testGraph", false, true); c.Config().DuplicateKeys || !c.Config().CircularRelationships {
+		t.Errorf("got config %+v from NewConstraintGraph", c.Config())
+	}
+
+	veto := errors.New("no drafts")
+	g, err = New("testGraph",
+		WithDuplicateKeys(false),
+		WithCircularRelationships(false),
+		WithUndirected(),
+		WithSelfRelationships(false),
+		WithMaxNodes(2),
+		WithIDAllocator(NewFreeListAllocator()),
+		WithValidator(func(_ *Graph, op Operation) error {
+			if op.Key == "Draft" {
+				return veto
+			}
+			return nil
+		}),
+	)
+	if err != nil {
+		t.Fatalf("unable to create graph, error: %v", err)
+	}
+	want := Config{Undirected: true, ParallelRelationships: true, MaxNodes: 2}
+	if got := g.Config(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got config %+v, want %+v", got, want)
+	}
+
+	if _, err := g.InsertDataNode("Draft", nil); !errors.Is(err, veto) {
+		t.Errorf("got `%v`, want `%v`", err, veto)
+	}
+	n1, _ := g.InsertDataNode("Intro", nil)
+	if _, err := g.InsertDataNode("Intro", nil); err == nil || err.Error() != ErrKeyExists {
+		t.Errorf("got `%v`, want `%s`", err, ErrKeyExists)
+	}
+	n2, _ := g.InsertDataNode("Factoring", nil)
+	if _, err := g.InsertDataNode("Graphing", nil); err == nil || err.Error() != ErrMaxNodes {
+		t.Errorf("got `%v`, want `%s`", err, ErrMaxNodes)
+	}
+	g.DeleteNode(n2)
+	n2, err = g.InsertDataNode("Graphing", nil)
+	if err != nil || n2.ID != 2 {
+		t.Errorf("got node %v, error %v, want the freed ID 2 reused", n2, err)
+	}
+	if err := n1.AddRelationship(n1); err == nil || err.Error() != ErrSelfRelationship {
+		t.Errorf("got `%v`, want `%s`", err, ErrSelfRelationship)
+	}
+
+	// the config is saved with the graph
+	if got := copyGraph(t, g).Config(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got config %+v after decoding, want %+v", got, want)
+	}
+	store := NewMemoryStore()
+	if _, err := New("testGraph", WithConfig(want), WithStore(store)); err != nil {
+		t.Fatalf("unable to create graph, error: %v", err)
+	}
+	if _, err := New("again", WithStore(store)); err == nil || err.Error() != ErrStoreNotEmpty {
+		t.Errorf("got `%v`, want `%s`", err, ErrStoreNotEmpty)
+	}
+	s, err := OpenGraph(store)
+	if err != nil {
+		t.Fatalf("unable to open graph, error: %v", err)
+	}
+	if got := s.Config(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got config %+v after opening, want %+v", got, want)
+	}
+
+	if _, err := New("testGraph", WithMaxNodes(-1)); err == nil || err.Error() != ErrInvalidConfig {
+		t.Errorf("got `%v`, want `%s`", err, ErrInvalidConfig)
+	}
+
+	// InsertNode has no error to return, so the limit is soft
+	g, _ = New("testGraph", WithMaxNodes(1))
+	g.InsertNode()
+	if n := g.InsertNode(); n == nil || g.NodeCount() != 3 {
+		t.Errorf("got %d nodes, want InsertNode past the limit", g.NodeCount())
+	}
+	if _, err := g.InsertDataNode("Intro", nil); err == nil || err.Error() != ErrMaxNodes {
+		t.Errorf("got `%v`, want `%s`", err, ErrMaxNodes)
+	}
+}
+
+func TestNewHooks(t *testing.T) {
+	var seen []OpKind
+	g, _ := New("testGraph", WithListener(func(e LogEntry) { seen = append(seen, e.Op.Kind) }), WithHistory())
+	n, _ := g.InsertDataNode("Intro", nil)
+	var added []uint64
+	g.AddListener(func(e LogEntry) { added = append(added, e.Seq) })
+	n.SetValue([]byte("lesson_id 1"))
+
+	if want := []OpKind{OpInsertNode, OpSetValue}; !reflect.DeepEqual(seen, want) {
+		t.Errorf("got ops %v, want %v", seen, want)
+	}
+	if !reflect.DeepEqual(added, []uint64{2}) {
+		t.Errorf("got seqs %v, want [2]", added)
+	}
+	if revisions, err := g.History(n.ID); err != nil || len(revisions) != 2 {
+		t.Errorf("got revisions %v, error %v, want 2 recorded from creation", revisions, err)
+	}
+}
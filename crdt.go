@@ -0,0 +1,683 @@
+package giraffe
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+)
+
+// Replica error constants
+const (
+	// ErrReplicaUndirected returns when a replica is made from an undirected graph
+	ErrReplicaUndirected = "replicas of undirected graphs are not supported"
+)
+
+// Dot identifies an edit made on a replica: the replica's ID and its Lamport clock at the time.
+// Dots are unique, and ordered by counter then replica so concurrent edits resolve the same way
+// everywhere.
+type Dot struct {
+	Replica string
+	Counter uint64
+}
+
+// String satisfies the Stringer interface
+func (d Dot) String() string {
+	return fmt.Sprintf("%s.%d", d.Replica, d.Counter)
+}
+
+// after reports whether the dot is ordered after another
+func (d Dot) after(other Dot) bool {
+	if d.Counter != other.Counter {
+		return d.Counter > other.Counter
+	}
+	return d.Replica > other.Replica
+}
+
+// compareDots orders dots for sorting
+func compareDots(a, b Dot) int {
+	switch {
+	case a == b:
+		return 0
+	case b.after(a):
+		return -1
+	}
+	return 1
+}
+
+// ReplicaEdge is a relationship between two nodes of a Replica
+type ReplicaEdge struct {
+	From Dot
+	To   Dot
+}
+
+// replicaNode is a node of a Replica. The key, value and each property are last writer wins
+// registers stamped with the dot of their latest write, the kind is fixed when the node is
+// inserted, and labels are an observed remove set like relationships.
+type replicaNode struct {
+	Key        string
+	KeyStamp   Dot
+	Value      []byte
+	ValueStamp Dot
+	Deleted    bool
+
+	Kind       string
+	Labels     map[string]map[Dot]bool
+	Properties map[string]*replicaProperty
+}
+
+// replicaProperty is a property register. A delete is a write that sets Deleted.
+type replicaProperty struct {
+	Property Property
+	Stamp    Dot
+	Deleted  bool
+}
+
+// replicaState is the mergeable state of a Replica
+type replicaState struct {
+	Name    string
+	Acyclic bool
+	Clock   uint64
+	Nodes   map[Dot]*replicaNode
+
+	// Edges is an observed remove set: each add is tagged with a dot, a remove tombstones the
+	// tags it has seen, and an edge is present while it has a tag that is not removed. Removed
+	// holds the tombstones of both relationships and labels.
+	Edges   map[ReplicaEdge]map[Dot]bool
+	Removed map[Dot]bool
+}
+
+// Replica is a copy of a graph that can be edited offline and merged with other copies. Edits
+// are recorded as conflict free replicated data: nodes are identified by the dot of their insert,
+// keys and values are last writer wins registers and relationships an observed remove set, so
+// replicas that have merged the same edits hold the same graph whatever order they merged in.
+// A relationship added on one replica survives a concurrent remove on another, and a deleted node
+// stays deleted. Merging can create cycles that no single replica allowed; in an acyclic replica
+// the relationships that close them are reported and left out of Graph().
+type Replica struct {
+	id string
+
+	sync.Mutex
+	s replicaState
+
+	// adjacent caches the relationships of Graph() in an acyclic replica so adds can be checked
+	// for cycles without rebuilding them. It is kept up to date while there are no violations,
+	// and otherwise rebuilt when next needed as a change can move a violation. nil when stale.
+	adjacent map[Dot]map[Dot]bool
+	violated bool
+}
+
+// NewReplica creates an empty replica with a replica ID unique among the copies that will be
+// merged, such as a device or author name. An acyclic replica rejects relationships that would
+// create a cycle like a graph made with NewConstraintGraph().
+func NewReplica(name, replicaID string, acyclic bool) *Replica {
+	return &Replica{
+		id: replicaID,
+		s: replicaState{
+			Name:    name,
+			Acyclic: acyclic,
+			Nodes:   make(map[Dot]*replicaNode),
+			Edges:   make(map[ReplicaEdge]map[Dot]bool),
+			Removed: make(map[Dot]bool),
+		},
+	}
+}
+
+// NewReplicaFromGraph creates a replica holding a copy of a graph's nodes, with their kinds,
+// labels and properties, and relationships, so an existing graph can be edited offline. Nodes
+// are given dots in the order of their IDs. The graph's root node and its relationships are left
+// out, as Graph() makes its own root, and the replica is acyclic unless the graph allows circular
+// relationships. Undirected graphs return ErrReplicaUndirected.
+func NewReplicaFromGraph(g *Graph, replicaID string) (*Replica, error) {
+	if g.Undirected() {
+		return nil, errors.New(ErrReplicaUndirected)
+	}
+	r := NewReplica(g.Name, replicaID, !g.circularRelationship)
+
+	IDs := make(map[uint64]Dot)
+	for n := range g.Nodes() {
+		if n.ID == 0 {
+			continue
+		}
+		n.RLock()
+		kind, key, value := n.Kind, n.Key, n.Value
+		n.RUnlock()
+
+		ID := r.tick()
+		IDs[n.ID] = ID
+		rn := &replicaNode{Key: key, KeyStamp: ID, Value: value, ValueStamp: ID, Kind: kind}
+		for _, label := range n.Labels() {
+			rn.addLabel(label, r.tick())
+		}
+		for name, p := range n.Properties() {
+			rn.setProperty(name, &replicaProperty{Property: p, Stamp: r.tick()})
+		}
+		r.s.Nodes[ID] = rn
+	}
+	for e := range g.Edges() {
+		from, ok := IDs[e.From]
+		if !ok {
+			continue
+		}
+		to, ok := IDs[e.To]
+		if !ok {
+			continue
+		}
+		r.s.addEdge(ReplicaEdge{From: from, To: to}, r.tick())
+	}
+	return r, nil
+}
+
+// Fork copies a replica for editing under another replica ID, such as when an author takes a
+// copy of the shared graph offline
+func (r *Replica) Fork(replicaID string) *Replica {
+	r.Lock()
+	defer r.Unlock()
+
+	return &Replica{id: replicaID, s: r.s.clone()}
+}
+
+// ID returns the replica's ID
+func (r *Replica) ID() string {
+	return r.id
+}
+
+// tick advances the clock and returns the dot for a new edit
+func (r *Replica) tick() Dot {
+	r.s.Clock++
+	return Dot{Replica: r.id, Counter: r.s.Clock}
+}
+
+// node returns a node that has not been deleted
+func (r *Replica) node(ID Dot) (*replicaNode, error) {
+	n, ok := r.s.Nodes[ID]
+	if !ok || n.Deleted {
+		return nil, errors.New(ErrNodeNotFound)
+	}
+	return n, nil
+}
+
+// InsertNode inserts a node and returns its ID
+func (r *Replica) InsertNode(key string, value []byte) Dot {
+	return r.InsertKindNode("", key, value)
+}
+
+// InsertKindNode inserts a node of the given kind and returns its ID
+func (r *Replica) InsertKindNode(kind, key string, value []byte) Dot {
+	r.Lock()
+	defer r.Unlock()
+
+	ID := r.tick()
+	r.s.Nodes[ID] = &replicaNode{Key: key, KeyStamp: ID, Value: value, ValueStamp: ID, Kind: kind}
+	return ID
+}
+
+// SetKey replaces a node's key
+func (r *Replica) SetKey(ID Dot, key string) error {
+	r.Lock()
+	defer r.Unlock()
+
+	n, err := r.node(ID)
+	if err != nil {
+		return err
+	}
+	n.Key, n.KeyStamp = key, r.tick()
+	return nil
+}
+
+// SetValue replaces a node's value
+func (r *Replica) SetValue(ID Dot, value []byte) error {
+	r.Lock()
+	defer r.Unlock()
+
+	n, err := r.node(ID)
+	if err != nil {
+		return err
+	}
+	n.Value, n.ValueStamp = value, r.tick()
+	return nil
+}
+
+// DeleteNode deletes a node along with its relationships
+func (r *Replica) DeleteNode(ID Dot) error {
+	r.Lock()
+	defer r.Unlock()
+
+	n, err := r.node(ID)
+	if err != nil {
+		return err
+	}
+	n.Deleted = true
+	if r.adjacent != nil && !r.violated {
+		delete(r.adjacent, ID)
+		for _, to := range r.adjacent {
+			delete(to, ID)
+		}
+	} else {
+		r.adjacent = nil
+	}
+	return nil
+}
+
+// AddLabel adds a label to a node. A label added on one replica survives a concurrent remove on
+// another.
+func (r *Replica) AddLabel(ID Dot, label string) error {
+	r.Lock()
+	defer r.Unlock()
+
+	n, err := r.node(ID)
+	if err != nil {
+		return err
+	}
+	n.addLabel(label, r.tick())
+	return nil
+}
+
+// RemoveLabel removes a label from a node as this replica has seen it
+func (r *Replica) RemoveLabel(ID Dot, label string) error {
+	r.Lock()
+	defer r.Unlock()
+
+	n, err := r.node(ID)
+	if err != nil {
+		return err
+	}
+	for tag := range n.Labels[label] {
+		r.s.Removed[tag] = true
+	}
+	return nil
+}
+
+// SetProperty stores a copy of a typed property on a node, replacing any previous value
+func (r *Replica) SetProperty(ID Dot, name string, p Property) error {
+	r.Lock()
+	defer r.Unlock()
+
+	n, err := r.node(ID)
+	if err != nil {
+		return err
+	}
+	n.setProperty(name, &replicaProperty{Property: p.clone(), Stamp: r.tick()})
+	return nil
+}
+
+// DeleteProperty removes a property from a node. Like a write, the later of a delete and a
+// concurrent write wins.
+func (r *Replica) DeleteProperty(ID Dot, name string) error {
+	r.Lock()
+	defer r.Unlock()
+
+	n, err := r.node(ID)
+	if err != nil {
+		return err
+	}
+	n.setProperty(name, &replicaProperty{Stamp: r.tick(), Deleted: true})
+	return nil
+}
+
+// AddRelationship adds a relationship from one node to another. An acyclic replica returns
+// ErrCircular if the relationship would create a cycle.
+func (r *Replica) AddRelationship(from, to Dot) error {
+	r.Lock()
+	defer r.Unlock()
+
+	if _, err := r.node(from); err != nil {
+		return err
+	}
+	if _, err := r.node(to); err != nil {
+		return err
+	}
+	if r.s.Acyclic && (from == to || reachable(r.adjacency(), to, from)) {
+		return errors.New(ErrCircular)
+	}
+
+	r.s.addEdge(ReplicaEdge{From: from, To: to}, r.tick())
+	if r.adjacent != nil && !r.violated {
+		if r.adjacent[from] == nil {
+			r.adjacent[from] = make(map[Dot]bool)
+		}
+		r.adjacent[from][to] = true
+	} else {
+		r.adjacent = nil
+	}
+	return nil
+}
+
+// RemoveRelationship removes the relationship from one node to another as this replica has seen
+// it. A concurrent add on another replica is kept.
+func (r *Replica) RemoveRelationship(from, to Dot) {
+	r.Lock()
+	defer r.Unlock()
+
+	for tag := range r.s.Edges[ReplicaEdge{From: from, To: to}] {
+		r.s.Removed[tag] = true
+	}
+	if r.adjacent != nil && !r.violated {
+		delete(r.adjacent[from], to)
+	} else {
+		r.adjacent = nil
+	}
+}
+
+// Merge applies the edits of another replica and returns the relationships that close a cycle in
+// an acyclic replica. Merging is commutative, associative and idempotent.
+func (r *Replica) Merge(other *Replica) []ReplicaEdge {
+	other.Lock()
+	s := other.s.clone()
+	other.Unlock()
+
+	r.Lock()
+	defer r.Unlock()
+
+	r.s.Clock = max(r.s.Clock, s.Clock)
+	for ID, n := range s.Nodes {
+		mine, ok := r.s.Nodes[ID]
+		if !ok {
+			r.s.Nodes[ID] = n
+			continue
+		}
+		if n.KeyStamp.after(mine.KeyStamp) {
+			mine.Key, mine.KeyStamp = n.Key, n.KeyStamp
+		}
+		if n.ValueStamp.after(mine.ValueStamp) {
+			mine.Value, mine.ValueStamp = n.Value, n.ValueStamp
+		}
+		mine.Deleted = mine.Deleted || n.Deleted
+		for label, tags := range n.Labels {
+			for tag := range tags {
+				mine.addLabel(label, tag)
+			}
+		}
+		for name, p := range n.Properties {
+			mine.setProperty(name, p)
+		}
+	}
+	for e, tags := range s.Edges {
+		for tag := range tags {
+			r.s.addEdge(e, tag)
+		}
+	}
+	maps.Copy(r.s.Removed, s.Removed)
+
+	r.adjacent = nil
+	if !r.s.Acyclic {
+		return nil
+	}
+	return r.refresh()
+}
+
+// adjacency returns the relationships of Graph() by node, rebuilding them if they are stale.
+// The caller must hold the replica's lock.
+func (r *Replica) adjacency() map[Dot]map[Dot]bool {
+	if r.adjacent == nil {
+		r.refresh()
+	}
+	return r.adjacent
+}
+
+// refresh rebuilds the relationships of Graph() by node and returns the violations. The caller
+// must hold the replica's lock.
+func (r *Replica) refresh() []ReplicaEdge {
+	edges, violations := r.s.edges()
+	r.adjacent = make(map[Dot]map[Dot]bool)
+	for _, e := range edges {
+		if r.adjacent[e.From] == nil {
+			r.adjacent[e.From] = make(map[Dot]bool)
+		}
+		r.adjacent[e.From][e.To] = true
+	}
+	r.violated = len(violations) > 0
+	return violations
+}
+
+// Violations returns the relationships that close a cycle in an acyclic replica
+func (r *Replica) Violations() []ReplicaEdge {
+	r.Lock()
+	defer r.Unlock()
+
+	_, violations := r.s.edges()
+	return violations
+}
+
+// Graph builds a Graph from the replica. Nodes are inserted in the order of their IDs, with the
+// ID's String() as their external ID, and relationships in the order of their nodes.
+// Relationships reported as violations are left out.
+func (r *Replica) Graph() (*Graph, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	g, err := NewConstraintGraph(r.s.Name, true, !r.s.Acyclic)
+	if err != nil {
+		return nil, err
+	}
+	IDs := make(map[Dot]*Node)
+	for _, ID := range r.s.nodeIDs() {
+		n := r.s.Nodes[ID]
+		node, err := g.insertDataNode(Operation{
+			Kind:       OpInsertNode,
+			NodeKind:   n.Kind,
+			Key:        n.Key,
+			Value:      n.Value,
+			Properties: n.properties(),
+			ExternalID: ID.String(),
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, label := range n.labels(r.s.Removed) {
+			node.AddLabel(label)
+		}
+		IDs[ID] = node
+	}
+	edges, _ := r.s.edges()
+	for _, e := range edges {
+		if err := IDs[e.From].AddRelationship(IDs[e.To]); err != nil {
+			return nil, err
+		}
+	}
+	return g, nil
+}
+
+// GobEncode satisfies the gob.GobEncoder interface so replicas can be saved and exchanged
+func (r *Replica) GobEncode() ([]byte, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	w := new(bytes.Buffer)
+	err := gob.NewEncoder(w).Encode(struct {
+		ID    string
+		State replicaState
+	}{r.id, r.s})
+	return w.Bytes(), err
+}
+
+// GobDecode satisfies the gob.GobDecoder interface
+func (r *Replica) GobDecode(data []byte) error {
+	var decoded struct {
+		ID    string
+		State replicaState
+	}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&decoded); err != nil {
+		return err
+	}
+	fresh := NewReplica(decoded.State.Name, decoded.ID, decoded.State.Acyclic)
+	maps.Copy(fresh.s.Nodes, decoded.State.Nodes)
+	maps.Copy(fresh.s.Edges, decoded.State.Edges)
+	maps.Copy(fresh.s.Removed, decoded.State.Removed)
+	fresh.s.Clock = decoded.State.Clock
+
+	r.Lock()
+	defer r.Unlock()
+	r.id, r.s = fresh.id, fresh.s
+	return nil
+}
+
+// clone deep copies the state
+func (s replicaState) clone() replicaState {
+	c := s
+	c.Nodes = make(map[Dot]*replicaNode, len(s.Nodes))
+	for ID, n := range s.Nodes {
+		copied := *n
+		copied.Labels = make(map[string]map[Dot]bool, len(n.Labels))
+		for label, tags := range n.Labels {
+			copied.Labels[label] = maps.Clone(tags)
+		}
+		copied.Properties = make(map[string]*replicaProperty, len(n.Properties))
+		for name, p := range n.Properties {
+			prop := *p
+			prop.Property = p.Property.clone()
+			copied.Properties[name] = &prop
+		}
+		c.Nodes[ID] = &copied
+	}
+	c.Edges = make(map[ReplicaEdge]map[Dot]bool, len(s.Edges))
+	for e, tags := range s.Edges {
+		c.Edges[e] = maps.Clone(tags)
+	}
+	c.Removed = maps.Clone(s.Removed)
+	return c
+}
+
+// addEdge tags an add of a relationship
+func (s replicaState) addEdge(e ReplicaEdge, tag Dot) {
+	if s.Edges[e] == nil {
+		s.Edges[e] = make(map[Dot]bool)
+	}
+	s.Edges[e][tag] = true
+}
+
+// addLabel tags an add of a label
+func (n *replicaNode) addLabel(label string, tag Dot) {
+	if n.Labels == nil {
+		n.Labels = make(map[string]map[Dot]bool)
+	}
+	if n.Labels[label] == nil {
+		n.Labels[label] = make(map[Dot]bool)
+	}
+	n.Labels[label][tag] = true
+}
+
+// setProperty writes a property register unless it holds a later write
+func (n *replicaNode) setProperty(name string, p *replicaProperty) {
+	if n.Properties == nil {
+		n.Properties = make(map[string]*replicaProperty)
+	}
+	if mine, ok := n.Properties[name]; ok && !p.Stamp.after(mine.Stamp) {
+		return
+	}
+	n.Properties[name] = p
+}
+
+// labels returns the labels that have an add that was not removed, in sorted order
+func (n *replicaNode) labels(removed map[Dot]bool) []string {
+	var labels []string
+	for label, tags := range n.Labels {
+		for tag := range tags {
+			if !removed[tag] {
+				labels = append(labels, label)
+				break
+			}
+		}
+	}
+	slices.Sort(labels)
+	return labels
+}
+
+// properties returns the properties that are not deleted
+func (n *replicaNode) properties() map[string]Property {
+	props := make(map[string]Property)
+	for name, p := range n.Properties {
+		if !p.Deleted {
+			props[name] = p.Property
+		}
+	}
+	return props
+}
+
+// nodeIDs returns the IDs of the nodes that are not deleted in order
+func (s replicaState) nodeIDs() []Dot {
+	var IDs []Dot
+	for ID, n := range s.Nodes {
+		if !n.Deleted {
+			IDs = append(IDs, ID)
+		}
+	}
+	slices.SortFunc(IDs, compareDots)
+	return IDs
+}
+
+// present reports whether a relationship has an add that was not removed and both of its nodes
+// are not deleted
+func (s replicaState) present(e ReplicaEdge) bool {
+	from, ok := s.Nodes[e.From]
+	if !ok || from.Deleted {
+		return false
+	}
+	to, ok := s.Nodes[e.To]
+	if !ok || to.Deleted {
+		return false
+	}
+	for tag := range s.Edges[e] {
+		if !s.Removed[tag] {
+			return true
+		}
+	}
+	return false
+}
+
+// edges returns the present relationships in order, leaving out and returning those that close a
+// cycle when the replica is acyclic. Every replica with the same state finds the same violations.
+func (s replicaState) edges() (edges, violations []ReplicaEdge) {
+	var all []ReplicaEdge
+	for e := range s.Edges {
+		if s.present(e) {
+			all = append(all, e)
+		}
+	}
+	slices.SortFunc(all, func(a, b ReplicaEdge) int {
+		if c := compareDots(a.From, b.From); c != 0 {
+			return c
+		}
+		return compareDots(a.To, b.To)
+	})
+	if !s.Acyclic {
+		return all, nil
+	}
+
+	adjacent := make(map[Dot]map[Dot]bool)
+	for _, e := range all {
+		if e.From == e.To || reachable(adjacent, e.To, e.From) {
+			violations = append(violations, e)
+			continue
+		}
+		if adjacent[e.From] == nil {
+			adjacent[e.From] = make(map[Dot]bool)
+		}
+		adjacent[e.From][e.To] = true
+		edges = append(edges, e)
+	}
+	return edges, violations
+}
+
+// reachable searches an adjacency list from one node for another
+func reachable(adjacent map[Dot]map[Dot]bool, from, to Dot) bool {
+	seen := map[Dot]bool{from: true}
+	queue := []Dot{from}
+	for len(queue) > 0 {
+		at := queue[0]
+		queue = queue[1:]
+		if at == to {
+			return true
+		}
+		for next := range adjacent[at] {
+			if !seen[next] {
+				seen[next] = true
+				queue = append(queue, next)
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,241 @@
+package giraffe
+
+import (
+	"bytes"
+	"encoding/gob"
+	"reflect"
+	"testing"
+)
+
+// replicaSummary describes a replica's graph by keys so replicas can be compared
+func replicaSummary(r *Replica) (keys []string, values []string, edges [][2]string) {
+	g, err := r.Graph()
+	if err != nil {
+		return nil, nil, nil
+	}
+	for n := range g.Nodes() {
+		if n.ID != 0 {
+			keys = append(keys, n.Key)
+			values = append(values, string(n.Value))
+		}
+	}
+	for e := range g.Edges() {
+		from, _ := g.Node(e.From)
+		to, _ := g.Node(e.To)
+		edges = append(edges, [2]string{from.Key, to.Key})
+	}
+	return keys, values, edges
+}
+
+func checkReplicasEqual(t *testing.T, a, b *Replica) {
+	t.Helper()
+	aKeys, aValues, aEdges := replicaSummary(a)
+	bKeys, bValues, bEdges := replicaSummary(b)
+	if !reflect.DeepEqual(aKeys, bKeys) || !reflect.DeepEqual(aValues, bValues) || !reflect.DeepEqual(aEdges, bEdges) {
+		t.Errorf("replicas differ:\n%v %v %v\n%v %v %v", aKeys, aValues, aEdges, bKeys, bValues, bEdges)
+	}
+}
+
+func TestReplicaMerge(t *testing.T) {
+	base := NewReplica("lessons", "server", false)
+	intro := base.InsertNode("Intro", []byte("v1"))
+	factoring := base.InsertNode("Factoring", []byte("v1"))
+	base.AddRelationship(intro, factoring)
+
+	alice, bob := base.Fork("alice"), base.Fork("bob")
+
+	// concurrent value writes resolve to the later one, ties to the larger replica ID
+	alice.SetValue(intro, []byte("alice"))
+	bob.SetValue(intro, []byte("bob"))
+	bob.SetKey(factoring, "Factoring II")
+
+	// alice removes the relationship while bob adds it again: the add survives
+	alice.RemoveRelationship(intro, factoring)
+	bob.AddRelationship(intro, factoring)
+
+	quadratics := alice.InsertNode("Quadratics", nil)
+	alice.AddRelationship(factoring, quadratics)
+	extra := bob.InsertNode("Extra", nil)
+	bob.AddRelationship(intro, extra)
+	bob.DeleteNode(extra)
+
+	ab, ba := alice.Fork("ab"), bob.Fork("ba")
+	ab.Merge(bob)
+	ba.Merge(alice)
+	checkReplicasEqual(t, ab, ba)
+
+	keys, values, edges := replicaSummary(ab)
+	if want := []string{"Intro", "Factoring II", "Quadratics"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("got keys %v, want %v", keys, want)
+	}
+	if values[0] != "bob" {
+		t.Errorf("got value %q, want bob", values[0])
+	}
+	if want := [][2]string{{"Intro", "Factoring II"}, {"Factoring II", "Quadratics"}}; !reflect.DeepEqual(edges, want) {
+		t.Errorf("got edges %v, want %v", edges, want)
+	}
+
+	// merging again changes nothing
+	ab.Merge(bob)
+	ab.Merge(ab.Fork("self"))
+	checkReplicasEqual(t, ab, ba)
+
+	// a remove after seeing every add removes the relationship everywhere
+	ab.RemoveRelationship(intro, factoring)
+	ba.Merge(ab)
+	if _, _, edges := replicaSummary(ba); len(edges) != 1 {
+		t.Errorf("got edges %v, want 1", edges)
+	}
+
+	if err := ab.SetKey(extra, "Deleted"); err == nil || err.Error() != ErrNodeNotFound {
+		t.Errorf("got `%v`, want `%s`", err, ErrNodeNotFound)
+	}
+	g, err := ab.Graph()
+	if err != nil {
+		t.Fatalf("unable to build graph, error: %v", err)
+	}
+	if n, ok := g.FindNodeByExternalID(quadratics.String()); !ok || n.Key != "Quadratics" {
+		t.Errorf("node not found by external ID %s", quadratics)
+	}
+}
+
+func TestReplicaCycles(t *testing.T) {
+	base := NewReplica("lessons", "server", true)
+	a := base.InsertNode("a", nil)
+	b := base.InsertNode("b", nil)
+	c := base.InsertNode("c", nil)
+	base.AddRelationship(a, b)
+	if err := base.AddRelationship(b, a); err == nil || err.Error() != ErrCircular {
+		t.Errorf("got `%v`, want `%s`", err, ErrCircular)
+	}
+
+	alice, bob := base.Fork("alice"), base.Fork("bob")
+	if err := alice.AddRelationship(b, c); err != nil {
+		t.Fatalf("unable to add relationship, error: %v", err)
+	}
+	if err := bob.AddRelationship(c, a); err != nil {
+		t.Fatalf("unable to add relationship, error: %v", err)
+	}
+
+	violations := alice.Merge(bob)
+	bob.Merge(alice)
+	if len(violations) != 1 || !reflect.DeepEqual(violations, bob.Violations()) {
+		t.Fatalf("got violations %v and %v, want the same one", violations, bob.Violations())
+	}
+	checkReplicasEqual(t, alice, bob)
+	if _, _, edges := replicaSummary(alice); len(edges) != 2 {
+		t.Errorf("got edges %v, want 2", edges)
+	}
+
+	// removing the violating relationship resolves it
+	v := violations[0]
+	alice.RemoveRelationship(v.From, v.To)
+	if got := alice.Violations(); len(got) != 0 {
+		t.Errorf("got violations %v, want none", got)
+	}
+	if err := alice.AddRelationship(v.From, v.To); err == nil || err.Error() != ErrCircular {
+		t.Errorf("got `%v`, want `%s`", err, ErrCircular)
+	}
+
+	// removes and deletes free the relationships that closed a cycle
+	d := alice.InsertNode("d", nil)
+	alice.AddRelationship(a, d)
+	alice.AddRelationship(d, c)
+	for _, e := range []ReplicaEdge{{a, b}, {b, c}} {
+		alice.RemoveRelationship(e.From, e.To)
+	}
+	if err := alice.AddRelationship(c, b); err != nil {
+		t.Errorf("unable to add relationship, error: %v", err)
+	}
+	if err := alice.AddRelationship(c, a); err == nil || err.Error() != ErrCircular {
+		t.Errorf("got `%v`, want `%s`", err, ErrCircular)
+	}
+	alice.DeleteNode(d)
+	if err := alice.AddRelationship(c, a); err != nil {
+		t.Errorf("unable to add relationship, error: %v", err)
+	}
+}
+
+func TestReplicaFromGraph(t *testing.T) {
+	g, _ := NewGraph("lessons")
+	intro, _ := g.InsertPropertyNode("lesson", "Intro", []byte("v1"), map[string]Property{"minutes": IntProp(20)})
+	factoring, _ := g.InsertKindNode("lesson", "Factoring", nil)
+	intro.AddLabel("published")
+	intro.AddRelationship(factoring)
+	g.Root().AddRelationship(intro)
+
+	base, err := NewReplicaFromGraph(g, "server")
+	if err != nil {
+		t.Fatalf("unable to create replica, error: %v", err)
+	}
+	alice, bob := base.Fork("alice"), base.Fork("bob")
+	ID := Dot{Replica: "server", Counter: 1}
+
+	// a concurrent label add survives a remove, and the later property write wins
+	alice.RemoveLabel(ID, "published")
+	bob.AddLabel(ID, "published")
+	alice.AddLabel(ID, "draft")
+	alice.SetProperty(ID, "minutes", IntProp(25))
+	bob.DeleteProperty(ID, "minutes")
+	bob.SetProperty(ID, "level", StringProp("easy"))
+	alice.Merge(bob)
+	bob.Merge(alice)
+
+	for _, r := range []*Replica{alice, bob} {
+		merged, err := r.Graph()
+		if err != nil {
+			t.Fatalf("unable to build graph, error: %v", err)
+		}
+		n, ok := merged.FindNodeByKey("Intro")
+		if !ok {
+			t.Fatalf("node Intro not found")
+		}
+		if n.Kind != "lesson" {
+			t.Errorf("got kind %q, want lesson", n.Kind)
+		}
+		if got, want := n.Labels(), []string{"draft", "published"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("got labels %v, want %v", got, want)
+		}
+		if got, want := n.Properties(), map[string]Property{"level": StringProp("easy")}; !reflect.DeepEqual(got, want) {
+			t.Errorf("got properties %v, want %v", got, want)
+		}
+		if got := extractIDs(n.ListDestinations()); len(got) != 1 || got[0] != 2 {
+			t.Errorf("got destinations %v, want [2]", got)
+		}
+		if got := merged.Root().ListDestinations(); len(got) != 0 {
+			t.Errorf("got root destinations %v, want none", extractIDs(got))
+		}
+	}
+
+	u, _ := NewUndirectedGraph("network", false, false)
+	if _, err := NewReplicaFromGraph(u, "server"); err == nil || err.Error() != ErrReplicaUndirected {
+		t.Errorf("got `%v`, want `%s`", err, ErrReplicaUndirected)
+	}
+}
+
+func TestReplicaEncoding(t *testing.T) {
+	r := NewReplica("lessons", "alice", true)
+	a := r.InsertNode("a", []byte("value"))
+	b := r.InsertNode("b", nil)
+	r.AddRelationship(a, b)
+	r.RemoveRelationship(a, b)
+	r.AddRelationship(a, b)
+
+	w := new(bytes.Buffer)
+	if err := gob.NewEncoder(w).Encode(r); err != nil {
+		t.Fatalf("unable to encode, error: %v", err)
+	}
+	decoded := &Replica{}
+	if err := gob.NewDecoder(w).Decode(decoded); err != nil {
+		t.Fatalf("unable to decode, error: %v", err)
+	}
+	if decoded.ID() != "alice" {
+		t.Errorf("got ID %q, want alice", decoded.ID())
+	}
+	checkReplicasEqual(t, r, decoded)
+
+	// the clock continues so new edits get new dots
+	if c := decoded.InsertNode("c", nil); c.Counter != 5 {
+		t.Errorf("got dot %v, want counter 5", c)
+	}
+}
@@ -0,0 +1,203 @@
+package giraffe
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// DB error constants
+const (
+	// ErrGraphExists returns when creating or renaming to a graph name that is already in use
+	ErrGraphExists = "graph exists"
+
+	// ErrGraphNotFound returns when a named graph is not in the database
+	ErrGraphNotFound = "graph not found"
+
+	// ErrNoPath returns when saving a database that was not opened from a file
+	ErrNoPath = "no path"
+
+	// ErrStoreGraph returns when saving a database that holds a graph with a store, which the
+	// database file could not reopen it with
+	ErrStoreGraph = "graph has a store"
+)
+
+// DB holds many named graphs, each with its own constraints, and saves them together
+type DB struct {
+	sync.Mutex
+	path   string
+	graphs map[string]*Graph
+}
+
+// NewDB creates an empty database that is kept in memory
+func NewDB() *DB {
+	return &DB{graphs: make(map[string]*Graph)}
+}
+
+// OpenDB loads the database saved at path, or creates an empty one if the file does not exist.
+// Save() writes the database back to path.
+func OpenDB(path string) (*DB, error) {
+	db := NewDB()
+	db.path = path
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return db, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(db); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// CreateGraph adds a new graph to the database, set up by the options as with New()
+func (db *DB) CreateGraph(name string, opts ...Option) (*Graph, error) {
+	db.Lock()
+	defer db.Unlock()
+
+	if _, ok := db.graphs[name]; ok {
+		return nil, errors.New(ErrGraphExists)
+	}
+	g, err := New(name, opts...)
+	if err != nil {
+		return nil, err
+	}
+	db.graphs[name] = g
+	return g, nil
+}
+
+// Graph returns the named graph
+func (db *DB) Graph(name string) (*Graph, bool) {
+	db.Lock()
+	defer db.Unlock()
+
+	g, ok := db.graphs[name]
+	return g, ok
+}
+
+// DropGraph removes the named graph from the database and closes its store, if it has one. The
+// graph is removed even if closing the store fails.
+func (db *DB) DropGraph(name string) error {
+	db.Lock()
+	defer db.Unlock()
+
+	g, ok := db.graphs[name]
+	if !ok {
+		return errors.New(ErrGraphNotFound)
+	}
+	delete(db.graphs, name)
+	return g.Close()
+}
+
+// ListGraphs returns the names of the graphs in the database in sorted order
+func (db *DB) ListGraphs() []string {
+	db.Lock()
+	defer db.Unlock()
+
+	names := make([]string, 0, len(db.graphs))
+	for name := range db.graphs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// RenameGraph renames a graph, updating its Name. If the graph's store cannot save the new name
+// the graph keeps its old one.
+func (db *DB) RenameGraph(oldName, newName string) error {
+	db.Lock()
+	defer db.Unlock()
+
+	g, ok := db.graphs[oldName]
+	if !ok {
+		return errors.New(ErrGraphNotFound)
+	}
+	if _, ok := db.graphs[newName]; ok && newName != oldName {
+		return errors.New(ErrGraphExists)
+	}
+
+	g.Lock()
+	defer g.Unlock()
+	g.Name = newName
+	if err := g.saveMeta(); err != nil {
+		g.Name = oldName
+		return err
+	}
+
+	delete(db.graphs, oldName)
+	db.graphs[newName] = g
+	return nil
+}
+
+// Save writes every graph in the database to the path it was opened from. The file is replaced
+// only once the new copy is fully written. Graphs with a store are kept by their store, so Save
+// fails with ErrStoreGraph while the database holds one.
+func (db *DB) Save() error {
+	if db.path == "" {
+		return errors.New(ErrNoPath)
+	}
+	db.Lock()
+	for _, g := range db.graphs {
+		if g.store != nil {
+			db.Unlock()
+			return errors.New(ErrStoreGraph)
+		}
+	}
+	db.Unlock()
+
+	file, err := os.CreateTemp(filepath.Dir(db.path), filepath.Base(db.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if err := writeDB(file, db); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	if err := os.Rename(file.Name(), db.path); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	return nil
+}
+
+// writeDB encodes the database to a file, syncs it and closes it
+func writeDB(file *os.File, db *DB) error {
+	if err := gob.NewEncoder(file).Encode(db); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// GobEncode satisfies the gob encoder interface
+func (db *DB) GobEncode() ([]byte, error) {
+	db.Lock()
+	defer db.Unlock()
+
+	w := new(bytes.Buffer)
+	err := gob.NewEncoder(w).Encode(db.graphs)
+	if err != nil {
+		return nil, err
+	}
+	return w.Bytes(), nil
+}
+
+// GobDecode satisfies the gob encoder interface
+func (db *DB) GobDecode(buf []byte) error {
+	db.Lock()
+	defer db.Unlock()
+
+	db.graphs = make(map[string]*Graph)
+	return gob.NewDecoder(bytes.NewReader(buf)).Decode(&db.graphs)
+}
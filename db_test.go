@@ -0,0 +1,142 @@
+package giraffe
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// faultyStore is a MemoryStore whose metadata writes can be made to fail and that records Close()
+type faultyStore struct {
+	*MemoryStore
+	metaErr error
+	closed  bool
+}
+
+func (s *faultyStore) SetMeta(key string, value []byte) error {
+	if s.metaErr != nil {
+		return s.metaErr
+	}
+	return s.MemoryStore.SetMeta(key, value)
+}
+
+func (s *faultyStore) Close() error {
+	s.closed = true
+	return s.MemoryStore.Close()
+}
+
+func TestDBGraphs(t *testing.T) {
+	db := NewDB()
+	north, _ := db.CreateGraph("north", WithDuplicateKeys(false), WithCircularRelationships(false))
+	db.CreateGraph("south")
+	if _, err := db.CreateGraph("north"); err == nil || err.Error() != ErrGraphExists {
+		t.Errorf("got `%v`, want `%s`", err, ErrGraphExists)
+	}
+
+	if err := db.RenameGraph("north", "east"); err != nil {
+		t.Fatalf("unable to rename, error: %v", err)
+	}
+	if north.Name != "east" {
+		t.Errorf("got name %q, want east", north.Name)
+	}
+	if err := db.RenameGraph("east", "south"); err == nil || err.Error() != ErrGraphExists {
+		t.Errorf("got `%v`, want `%s`", err, ErrGraphExists)
+	}
+	if got, want := db.ListGraphs(), []string{"east", "south"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got graphs %v, want %v", got, want)
+	}
+
+	if err := db.DropGraph("south"); err != nil {
+		t.Errorf("unable to drop, error: %v", err)
+	}
+	if err := db.DropGraph("south"); err == nil || err.Error() != ErrGraphNotFound {
+		t.Errorf("got `%v`, want `%s`", err, ErrGraphNotFound)
+	}
+	if _, ok := db.Graph("south"); ok {
+		t.Error("dropped graph still found")
+	}
+	if err := db.Save(); err == nil || err.Error() != ErrNoPath {
+		t.Errorf("got `%v`, want `%s`", err, ErrNoPath)
+	}
+}
+
+func TestDBGraphStores(t *testing.T) {
+	db := NewDB()
+	store := &faultyStore{MemoryStore: NewMemoryStore()}
+	g, err := NewStoreGraph("north", true, true, store)
+	if err != nil {
+		t.Fatalf("unable to create graph, error: %v", err)
+	}
+	db.graphs["north"] = g
+
+	store.metaErr = errors.New("disk full")
+	if err := db.RenameGraph("north", "east"); !errors.Is(err, store.metaErr) {
+		t.Errorf("got `%v`, want `%v`", err, store.metaErr)
+	}
+	if _, ok := db.Graph("north"); !ok || g.Name != "north" {
+		t.Errorf("got name %q, want the rename rolled back", g.Name)
+	}
+
+	db.path = filepath.Join(t.TempDir(), "schools.db")
+	if err := db.Save(); err == nil || err.Error() != ErrStoreGraph {
+		t.Errorf("got `%v`, want `%s`", err, ErrStoreGraph)
+	}
+
+	if err := db.DropGraph("north"); err != nil {
+		t.Errorf("unable to drop, error: %v", err)
+	}
+	if !store.closed {
+		t.Error("dropped graph's store was not closed")
+	}
+}
+
+func TestDBSaveAndOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "schools.db")
+	db, err := OpenDB(path)
+	if err != nil {
+		t.Fatalf("unable to open db, error: %v", err)
+	}
+	north, _ := db.CreateGraph("north", WithDuplicateKeys(false))
+	n1, _ := north.InsertDataNode("Intro", []byte("lesson_id 1"))
+	north.Root().AddRelationship(n1)
+	south, _ := db.CreateGraph("south", WithCircularRelationships(false))
+	south.InsertDataNode("Intro", []byte("lesson_id 2"))
+	// concurrent saves each write their own temporary file
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := db.Save(); err != nil {
+				t.Errorf("unable to save, error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 1 {
+		t.Errorf("got %d files, want only the database", len(entries))
+	}
+
+	db, err = OpenDB(path)
+	if err != nil {
+		t.Fatalf("unable to reopen db, error: %v", err)
+	}
+	if got, want := db.ListGraphs(), []string{"north", "south"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got graphs %v, want %v", got, want)
+	}
+
+	north, _ = db.Graph("north")
+	if n, ok := north.FindNodeByKey("Intro"); !ok || string(n.Value) != "lesson_id 1" {
+		t.Error("north graph not restored")
+	}
+	if _, err := north.InsertDataNode("Intro", nil); err == nil {
+		t.Error("north graph lost its duplicate keys constraint")
+	}
+	south, _ = db.Graph("south")
+	if _, err := south.InsertDataNode("Intro", nil); err != nil {
+		t.Errorf("south graph should allow duplicate keys, got `%v`", err)
+	}
+}
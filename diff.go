@@ -0,0 +1,494 @@
+package giraffe
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Diff error constants
+const (
+	// ErrPrecondition returns when a patch is applied to a graph that no longer matches the state
+	// the patch was made from
+	ErrPrecondition = "patch precondition failed"
+)
+
+// NodeState is the part of a node compared by Diff()
+type NodeState struct {
+	Key    string
+	Value  []byte
+	Labels []string
+}
+
+// equal reports whether two states match
+func (s *NodeState) equal(other *NodeState) bool {
+	if s == nil || other == nil {
+		return s == other
+	}
+	return s.Key == other.Key && bytes.Equal(s.Value, other.Value) && slices.Equal(s.Labels, other.Labels)
+}
+
+// NodeChange is a node added, removed or changed by a Patch. Before is nil for an added node and
+// After is nil for a removed one.
+type NodeChange struct {
+	ID     uint64
+	Before *NodeState
+	After  *NodeState
+}
+
+// Patch is the difference between two graphs. Nodes are matched by ID. See Diff().
+type Patch struct {
+	Nodes        []NodeChange
+	AddedEdges   []Edge
+	RemovedEdges []Edge
+}
+
+// PatchError is returned when a patch cannot be applied
+type PatchError struct {
+	// Change describes the part of the patch that failed
+	Change string
+	Err    error
+}
+
+// Error satisfies the error interface
+func (e *PatchError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Change, e.Err)
+}
+
+// Unwrap returns the reason the patch failed
+func (e *PatchError) Unwrap() error {
+	return e.Err
+}
+
+// Conflict is a change made differently on both sides of a three way merge. Field is "key",
+// "value", "labels" or "node" for a node removed on one side and changed on the other or added
+// on both, and "edge" for a relationship to a node the other side removed.
+type Conflict struct {
+	ID    uint64
+	Field string
+	Edge  Edge
+}
+
+// String satisfies the Stringer interface
+func (c Conflict) String() string {
+	if c.Field == "edge" {
+		return fmt.Sprintf("edge %d -> %d: node %d removed on the other side", c.Edge.From, c.Edge.To, c.ID)
+	}
+	return fmt.Sprintf("node %d: %s changed on both sides", c.ID, c.Field)
+}
+
+// graphState is a copy of the parts of a graph compared by Diff()
+type graphState struct {
+	nodes map[uint64]*NodeState
+	edges map[Edge]bool
+}
+
+// state copies the graph's nodes and relationships, returning any error reading them from the
+// graph's store
+func (g *Graph) state() (graphState, error) {
+	g.Lock()
+	defer g.Unlock()
+
+	if err := g.loadAll(); err != nil {
+		return graphState{}, err
+	}
+	s := graphState{nodes: make(map[uint64]*NodeState, len(g.nodes)), edges: make(map[Edge]bool)}
+	for ID, n := range g.nodes {
+		n.RLock()
+		s.nodes[ID] = &NodeState{Key: n.Key, Value: n.Value, Labels: slices.Clone(n.labels)}
+		for _, dest := range n.destinations {
+			s.edges[g.edgeKey(ID, dest.ID)] = true
+		}
+		n.RUnlock()
+	}
+	return s, nil
+}
+
+// Diff returns the changes that turn graph a into graph b: the nodes added, removed or with a
+// changed key, value or labels, and the relationships added or removed. Nodes are matched by ID,
+// so it is meant for versions of the same graph, such as two saved copies. It returns the error
+// if either graph's store fails to read its nodes.
+func Diff(a, b *Graph) (Patch, error) {
+	as, err := a.state()
+	if err != nil {
+		return Patch{}, err
+	}
+	bs, err := b.state()
+	if err != nil {
+		return Patch{}, err
+	}
+	return diffStates(as, bs), nil
+}
+
+// diffStates does the work for Diff()
+func diffStates(a, b graphState) Patch {
+	var p Patch
+	for ID, before := range a.nodes {
+		if after, ok := b.nodes[ID]; !ok {
+			p.Nodes = append(p.Nodes, NodeChange{ID: ID, Before: before})
+		} else if !before.equal(after) {
+			p.Nodes = append(p.Nodes, NodeChange{ID: ID, Before: before, After: after})
+		}
+	}
+	for ID, after := range b.nodes {
+		if _, ok := a.nodes[ID]; !ok {
+			p.Nodes = append(p.Nodes, NodeChange{ID: ID, After: after})
+		}
+	}
+	for e := range a.edges {
+		if !b.edges[e] {
+			p.RemovedEdges = append(p.RemovedEdges, e)
+		}
+	}
+	for e := range b.edges {
+		if !a.edges[e] {
+			p.AddedEdges = append(p.AddedEdges, e)
+		}
+	}
+	p.sort()
+	return p
+}
+
+// sort orders the changes by ID
+func (p *Patch) sort() {
+	sort.Slice(p.Nodes, func(i, j int) bool { return p.Nodes[i].ID < p.Nodes[j].ID })
+	for _, edges := range [][]Edge{p.AddedEdges, p.RemovedEdges} {
+		sort.Slice(edges, func(i, j int) bool {
+			if edges[i].From != edges[j].From {
+				return edges[i].From < edges[j].From
+			}
+			return edges[i].To < edges[j].To
+		})
+	}
+}
+
+// Empty reports whether the patch has no changes
+func (p Patch) Empty() bool {
+	return len(p.Nodes) == 0 && len(p.AddedEdges) == 0 && len(p.RemovedEdges) == 0
+}
+
+// Apply makes the patch's changes to a graph. It fails with ErrPrecondition, before changing
+// anything, unless every node the patch changes or removes is as it was before, every added node
+// is missing, every removed relationship is present and every added relationship is missing.
+// The graph's validators see each change other than a label change first; an error from one, or
+// from a constraint such as ErrCircular, leaves the changes made before it in place.
+func (p Patch) Apply(g *Graph) error {
+	ops := p.operations()
+	err := g.lockValidated(func() []Operation {
+		var checked []Operation
+		for _, op := range ops {
+			if op.Kind != OpAddLabel && op.Kind != OpRemoveLabel {
+				checked = append(checked, op)
+			}
+		}
+		return checked
+	})
+	if err != nil {
+		return err
+	}
+	defer g.Unlock()
+
+	if err := p.check(g); err != nil {
+		return err
+	}
+	for _, op := range ops {
+		if op.Kind == OpInsertNode {
+			if err := g.checkMaxNodes(); err != nil {
+				return &PatchError{Change: op.Kind.String(), Err: err}
+			}
+		}
+		if _, err := g.applyLocked(op); err != nil {
+			return &PatchError{Change: op.Kind.String(), Err: err}
+		}
+	}
+	return nil
+}
+
+// operations lists the patch's changes as operations in the order they are applied: removed
+// relationships and nodes first, then added nodes, node changes and added relationships
+func (p Patch) operations() []Operation {
+	var ops []Operation
+	for _, e := range p.RemovedEdges {
+		ops = append(ops, Operation{Kind: OpRemoveRelationship, NodeID: e.From, OtherID: e.To})
+	}
+	for _, c := range p.Nodes {
+		if c.After == nil {
+			ops = append(ops, Operation{Kind: OpDeleteNode, NodeID: c.ID})
+		}
+	}
+	for _, c := range p.Nodes {
+		if c.Before == nil {
+			ops = append(ops, Operation{Kind: OpInsertNode, NodeID: c.ID, Key: c.After.Key, Value: c.After.Value})
+		}
+	}
+	for _, c := range p.Nodes {
+		if c.After == nil {
+			continue
+		}
+		var before NodeState
+		if c.Before != nil {
+			before = *c.Before
+		}
+		if c.Before != nil && c.After.Key != before.Key {
+			ops = append(ops, Operation{Kind: OpSetKey, NodeID: c.ID, Key: c.After.Key, Value: c.After.Value})
+		}
+		if c.Before != nil && !bytes.Equal(c.After.Value, before.Value) {
+			ops = append(ops, Operation{Kind: OpSetValue, NodeID: c.ID, Value: c.After.Value})
+		}
+		for _, label := range before.Labels {
+			if !slices.Contains(c.After.Labels, label) {
+				ops = append(ops, Operation{Kind: OpRemoveLabel, NodeID: c.ID, Label: label})
+			}
+		}
+		for _, label := range c.After.Labels {
+			if !slices.Contains(before.Labels, label) {
+				ops = append(ops, Operation{Kind: OpAddLabel, NodeID: c.ID, Label: label})
+			}
+		}
+	}
+	for _, e := range p.AddedEdges {
+		ops = append(ops, Operation{Kind: OpAddRelationship, NodeID: e.From, OtherID: e.To})
+	}
+	return ops
+}
+
+// check verifies the patch's preconditions. The caller must hold the graph lock.
+func (p Patch) check(g *Graph) error {
+	failed := func(format string, args ...any) error {
+		return &PatchError{Change: fmt.Sprintf(format, args...), Err: errors.New(ErrPrecondition)}
+	}
+
+	for _, c := range p.Nodes {
+		n, ok := g.node(c.ID)
+		if c.Before == nil {
+			if ok {
+				return failed("add node %d", c.ID)
+			}
+			continue
+		}
+		if !ok {
+			return failed("change node %d", c.ID)
+		}
+		n.RLock()
+		current := &NodeState{Key: n.Key, Value: n.Value, Labels: n.labels}
+		matches := current.equal(c.Before)
+		n.RUnlock()
+		if !matches {
+			return failed("change node %d", c.ID)
+		}
+	}
+
+	removed := make(map[uint64]bool)
+	for _, c := range p.Nodes {
+		removed[c.ID] = c.After == nil
+	}
+	for _, e := range p.RemovedEdges {
+		if !g.hasEdge(e) {
+			return failed("remove edge %d -> %d", e.From, e.To)
+		}
+	}
+	for _, e := range p.AddedEdges {
+		if removed[e.From] || removed[e.To] || g.hasEdge(e) {
+			return failed("add edge %d -> %d", e.From, e.To)
+		}
+	}
+	return nil
+}
+
+// hasEdge reports whether the graph has a relationship. The caller must hold the graph lock.
+func (g *Graph) hasEdge(e Edge) bool {
+	n, ok := g.node(e.From)
+	if !ok {
+		return false
+	}
+	g.link(n)
+	n.RLock()
+	defer n.RUnlock()
+
+	for _, dest := range n.destinations {
+		if dest.ID == e.To {
+			return true
+		}
+	}
+	return false
+}
+
+// Merge3 merges the changes made to a common ancestor in two graphs. It returns a patch that
+// brings theirs' changes into ours, and the conflicts where both sides changed the same key,
+// value or labels of a node differently, one side removed a node the other changed or linked
+// to, or both added a node with the same ID. Conflicting changes are left out of the patch, so
+// ours wins them until they are resolved. Like Diff(), it returns any error reading a graph.
+func Merge3(base, ours, theirs *Graph) (Patch, []Conflict, error) {
+	var states [3]graphState
+	for i, g := range []*Graph{base, ours, theirs} {
+		var err error
+		if states[i], err = g.state(); err != nil {
+			return Patch{}, nil, err
+		}
+	}
+	b, o, t := states[0], states[1], states[2]
+	var p Patch
+	var conflicts []Conflict
+
+	IDs := make(map[uint64]bool)
+	for _, s := range []graphState{b, o, t} {
+		for ID := range s.nodes {
+			IDs[ID] = true
+		}
+	}
+	for _, ID := range sortedIDs(IDs) {
+		baseNode, ourNode, theirNode := b.nodes[ID], o.nodes[ID], t.nodes[ID]
+		if theirNode.equal(baseNode) || theirNode.equal(ourNode) {
+			// theirs did not change the node, or made the same change
+			continue
+		}
+		if ourNode.equal(baseNode) {
+			p.Nodes = append(p.Nodes, NodeChange{ID: ID, Before: ourNode, After: theirNode})
+			continue
+		}
+		if baseNode == nil || ourNode == nil || theirNode == nil {
+			conflicts = append(conflicts, Conflict{ID: ID, Field: "node"})
+			continue
+		}
+
+		// both changed the node: merge field by field
+		merged := *ourNode
+		for _, field := range []string{"key", "value", "labels"} {
+			baseValue, ourValue, theirValue := fieldOf(baseNode, field), fieldOf(ourNode, field), fieldOf(theirNode, field)
+			switch {
+			case theirValue == baseValue || theirValue == ourValue:
+			case ourValue == baseValue:
+				setField(&merged, theirNode, field)
+			default:
+				conflicts = append(conflicts, Conflict{ID: ID, Field: field})
+			}
+		}
+		if !merged.equal(ourNode) {
+			p.Nodes = append(p.Nodes, NodeChange{ID: ID, Before: ourNode, After: &merged})
+		}
+	}
+
+	// a node theirs removed stays if ours linked to it since the ancestor
+	for _, e := range sortedEdges(o.edges) {
+		if b.edges[e] {
+			continue
+		}
+		for _, ID := range []uint64{e.From, e.To} {
+			if _, ok := t.nodes[ID]; !ok && b.nodes[ID] != nil {
+				conflicts = append(conflicts, Conflict{ID: ID, Field: "edge", Edge: e})
+				p.Nodes = slices.DeleteFunc(p.Nodes, func(c NodeChange) bool { return c.ID == ID })
+			}
+		}
+	}
+
+	// relationships to a node are dropped with it, so only changes between nodes that neither
+	// side removed are merged
+	kept := func(ID uint64) bool {
+		_, inOurs := o.nodes[ID]
+		_, inTheirs := t.nodes[ID]
+		_, inBase := b.nodes[ID]
+		return inTheirs && (inOurs || !inBase)
+	}
+	for e := range t.edges {
+		if b.edges[e] || o.edges[e] {
+			continue
+		}
+		if !kept(e.From) {
+			conflicts = append(conflicts, Conflict{ID: e.From, Field: "edge", Edge: e})
+			continue
+		}
+		if !kept(e.To) {
+			conflicts = append(conflicts, Conflict{ID: e.To, Field: "edge", Edge: e})
+			continue
+		}
+		p.AddedEdges = append(p.AddedEdges, e)
+	}
+	for e := range b.edges {
+		if !t.edges[e] && o.edges[e] && kept(e.From) && kept(e.To) {
+			p.RemovedEdges = append(p.RemovedEdges, e)
+		}
+	}
+
+	p.sort()
+	sort.Slice(conflicts, func(i, j int) bool {
+		if conflicts[i].ID != conflicts[j].ID {
+			return conflicts[i].ID < conflicts[j].ID
+		}
+		return conflicts[i].Field < conflicts[j].Field
+	})
+	return p, conflicts, nil
+}
+
+// sortedEdges returns the edges of a set in order
+func sortedEdges(set map[Edge]bool) []Edge {
+	p := Patch{AddedEdges: slices.Collect(maps.Keys(set))}
+	p.sort()
+	return p.AddedEdges
+}
+
+// fieldOf returns one field of a node state as a comparable string
+func fieldOf(s *NodeState, field string) string {
+	switch field {
+	case "key":
+		return s.Key
+	case "value":
+		return string(s.Value)
+	}
+	return strings.Join(s.Labels, "\x00")
+}
+
+// setField copies one field from a node state
+func setField(s, from *NodeState, field string) {
+	switch field {
+	case "key":
+		s.Key = from.Key
+	case "value":
+		s.Value = from.Value
+	default:
+		s.Labels = from.Labels
+	}
+}
+
+// String renders the patch for review, one change per line: "+" for additions, "-" for removals
+// and "~" for changes, nodes first and then relationships
+func (p Patch) String() string {
+	var b strings.Builder
+	for _, c := range p.Nodes {
+		switch {
+		case c.Before == nil:
+			fmt.Fprintf(&b, "+ node %d %s\n", c.ID, c.After)
+		case c.After == nil:
+			fmt.Fprintf(&b, "- node %d %s\n", c.ID, c.Before)
+		default:
+			if c.Before.Key != c.After.Key {
+				fmt.Fprintf(&b, "~ node %d key %q -> %q\n", c.ID, c.Before.Key, c.After.Key)
+			}
+			if !bytes.Equal(c.Before.Value, c.After.Value) {
+				fmt.Fprintf(&b, "~ node %d value %q -> %q\n", c.ID, c.Before.Value, c.After.Value)
+			}
+			if !slices.Equal(c.Before.Labels, c.After.Labels) {
+				fmt.Fprintf(&b, "~ node %d labels %v -> %v\n", c.ID, c.Before.Labels, c.After.Labels)
+			}
+		}
+	}
+	for _, e := range p.AddedEdges {
+		fmt.Fprintf(&b, "+ edge %d -> %d\n", e.From, e.To)
+	}
+	for _, e := range p.RemovedEdges {
+		fmt.Fprintf(&b, "- edge %d -> %d\n", e.From, e.To)
+	}
+	return b.String()
+}
+
+// String satisfies the Stringer interface
+func (s *NodeState) String() string {
+	str := fmt.Sprintf("%q = %q", s.Key, s.Value)
+	if len(s.Labels) > 0 {
+		str += fmt.Sprintf(" %v", s.Labels)
+	}
+	return str
+}
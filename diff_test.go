@@ -0,0 +1,177 @@
+package giraffe
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// copyGraph round trips a graph through gob like a saved copy
+func copyGraph(t *testing.T, g *Graph) *Graph {
+	w := new(bytes.Buffer)
+	if err := gob.NewEncoder(w).Encode(g); err != nil {
+		t.Fatalf("unable to encode, error: %v", err)
+	}
+	c := &Graph{}
+	if err := gob.NewDecoder(w).Decode(c); err != nil {
+		t.Fatalf("unable to decode, error: %v", err)
+	}
+	return c
+}
+
+// buildDiffGraph builds Intro(1) -> Factoring(2) -> Quadratics(3) from the root
+func buildDiffGraph() *Graph {
+	g, _ := NewGraph("testGraph")
+	n1, _ := g.InsertDataNode("Intro", []byte("lesson_id 1"))
+	n2, _ := g.InsertDataNode("Factoring", []byte("lesson_id 12"))
+	n3, _ := g.InsertDataNode("Quadratics", []byte("lesson_id 20"))
+	g.Root().AddRelationship(n1)
+	n1.AddRelationship(n2)
+	n2.AddRelationship(n3)
+	return g
+}
+
+func TestDiff(t *testing.T) {
+	a := buildDiffGraph()
+	b := copyGraph(t, a)
+	if p, _ := Diff(a, b); !p.Empty() {
+		t.Fatalf("got changes between copies:\n%s", p)
+	}
+
+	n1, _ := b.Node(1)
+	n2, _ := b.Node(2)
+	n3, _ := b.Node(3)
+	n1.SetKey("Introduction")
+	n2.SetValue([]byte("lesson_id 13"))
+	n2.AddLabel("Lesson")
+	b.DeleteNode(n3)
+	n4, _ := b.InsertDataNode("Graphing", []byte("lesson_id 30"))
+	n4.AddLabel("Draft")
+	n1.AddRelationship(n4)
+
+	p, _ := Diff(a, b)
+	want := `~ node 1 key "Intro" -> "Introduction"
+~ node 2 value "lesson_id 12" -> "lesson_id 13"
+~ node 2 labels [] -> [Lesson]
+- node 3 "Quadratics" = "lesson_id 20"
++ node 4 "Graphing" = "lesson_id 30" [Draft]
++ edge 1 -> 4
+- edge 2 -> 3
+`
+	if got := p.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	if err := p.Apply(a); err != nil {
+		t.Fatalf("unable to apply patch, error: %v", err)
+	}
+	if p, _ := Diff(a, b); !p.Empty() {
+		t.Errorf("got changes after applying:\n%s", p)
+	}
+
+	// the preconditions no longer hold
+	var pErr *PatchError
+	if err := p.Apply(a); !errors.As(err, &pErr) || pErr.Err.Error() != ErrPrecondition {
+		t.Errorf("got `%v`, want `%s`", err, ErrPrecondition)
+	}
+}
+
+func TestPatchPreconditions(t *testing.T) {
+	a := buildDiffGraph()
+	b := copyGraph(t, a)
+	n2, _ := b.Node(2)
+	n2.SetValue([]byte("lesson_id 13"))
+	n3, _ := b.Node(3)
+	n2.RemoveRelationship(n3)
+	p, _ := Diff(a, b)
+
+	// a conflicting change to the target stops the whole patch
+	c := copyGraph(t, a)
+	n3, _ = c.Node(3)
+	c.DeleteNode(n3)
+	var pErr *PatchError
+	if err := p.Apply(c); !errors.As(err, &pErr) || pErr.Err.Error() != ErrPrecondition {
+		t.Fatalf("got `%v`, want `%s`", err, ErrPrecondition)
+	}
+	if n, _ := c.Node(2); string(n.Value) != "lesson_id 12" {
+		t.Errorf("patch partly applied: got value %q", n.Value)
+	}
+
+	// validators see the changes
+	d := copyGraph(t, a)
+	d.AddValidator(func(_ *Graph, op Operation) error {
+		if op.Kind == OpSetValue {
+			return errors.New("values are frozen")
+		}
+		return nil
+	})
+	var vErr *ValidationError
+	if err := p.Apply(d); !errors.As(err, &vErr) {
+		t.Errorf("got `%v`, want a validation error", err)
+	}
+}
+
+func TestMerge3(t *testing.T) {
+	base := buildDiffGraph()
+	ours, theirs := copyGraph(t, base), copyGraph(t, base)
+
+	o1, _ := ours.Node(1)
+	o2, _ := ours.Node(2)
+	o1.SetKey("Introduction")
+	o2.SetValue([]byte("ours"))
+	o5, _ := ours.InsertNodeWithID(5, "Ours", nil)
+	o1.AddRelationship(o5)
+
+	t1, _ := theirs.Node(1)
+	t2, _ := theirs.Node(2)
+	t3, _ := theirs.Node(3)
+	t1.SetValue([]byte("lesson_id 2"))
+	t2.SetValue([]byte("theirs"))
+	t2.AddLabel("Lesson")
+	t6, _ := theirs.InsertNodeWithID(6, "Theirs", nil)
+	t3.AddRelationship(t6)
+	t1.RemoveRelationship(t2)
+
+	p, conflicts, _ := Merge3(base, ours, theirs)
+	if want := []Conflict{{ID: 2, Field: "value"}}; !reflect.DeepEqual(conflicts, want) {
+		t.Errorf("got conflicts %v, want %v", conflicts, want)
+	}
+	if err := p.Apply(ours); err != nil {
+		t.Fatalf("unable to apply merge, error: %v\n%s", err, p)
+	}
+
+	n1, _ := ours.Node(1)
+	if n1.Key != "Introduction" || string(n1.Value) != "lesson_id 2" {
+		t.Errorf("got %q = %q, want both sides' changes", n1.Key, n1.Value)
+	}
+	n2, _ := ours.Node(2)
+	if string(n2.Value) != "ours" || !n2.HasLabel("Lesson") {
+		t.Errorf("got %q %v, want ours with their label", n2.Value, n2.Labels())
+	}
+	if _, ok := ours.Node(6); !ok {
+		t.Error("their node was not merged")
+	}
+	if got := extractIDs(n1.ListDestinations()); !reflect.DeepEqual(got, []uint64{5}) {
+		t.Errorf("got destinations %v, want [5]", got)
+	}
+
+	// theirs removed a node ours linked to
+	ours, theirs = copyGraph(t, base), copyGraph(t, base)
+	o1, _ = ours.Node(1)
+	o3, _ := ours.Node(3)
+	o1.AddRelationship(o3)
+	t3, _ = theirs.Node(3)
+	theirs.DeleteNode(t3)
+	p, conflicts, _ = Merge3(base, ours, theirs)
+	if want := []Conflict{{ID: 3, Field: "edge", Edge: Edge{From: 1, To: 3}}}; !reflect.DeepEqual(conflicts, want) {
+		t.Errorf("got conflicts %v, want %v", conflicts, want)
+	}
+	if err := p.Apply(ours); err != nil {
+		t.Fatalf("unable to apply merge, error: %v", err)
+	}
+	if _, ok := ours.Node(3); !ok {
+		t.Error("removed a node ours still links to")
+	}
+}
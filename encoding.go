@@ -4,14 +4,47 @@ import (
 	"bytes"
 	"encoding/gob"
 	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
 )
 
+// ErrEncodingVersion returns when decoding a graph or node saved by a newer version
+const ErrEncodingVersion = "unknown encoding version"
+
+// encodingVersion is written before each encoded graph and node as the bytes 0 and the version.
+// A gob stream never starts with 0, so graphs and nodes saved before the version was added are
+// told apart by its absence. Version 2 keys relationship expiry by relationship ID rather than by
+// pair of nodes.
+const encodingVersion = 2
+
+// splitVersion returns the encoding version of a buffer and the data after it. Buffers saved
+// without a version return 0.
+func splitVersion(buf []byte) (byte, []byte, error) {
+	if len(buf) < 2 || buf[0] != 0 {
+		return 0, buf, nil
+	}
+	if buf[1] > encodingVersion {
+		return 0, nil, fmt.Errorf("%s %d", ErrEncodingVersion, buf[1])
+	}
+	return buf[1], buf[2:], nil
+}
+
 // GobEncode satisfies the gob encoder interface
 func (g *Graph) GobEncode() ([]byte, error) {
 	g.Lock()
 	defer g.Unlock()
 
-	w := new(bytes.Buffer)
+	return g.encode()
+}
+
+// encode is the logic for GobEncode(). The caller must hold the graph lock.
+func (g *Graph) encode() ([]byte, error) {
+	if err := g.loadAll(); err != nil {
+		return nil, err
+	}
+	w := bytes.NewBuffer([]byte{0, encodingVersion})
 	encoder := gob.NewEncoder(w)
 	err := encoder.Encode(g.Name)
 	if err != nil {
@@ -25,11 +58,16 @@ func (g *Graph) GobEncode() ([]byte, error) {
 	if err != nil {
 		return nil, errors.New("3")
 	}
-	err = encoder.Encode(g.Nodes)
+	err = encoder.Encode(g.nodes)
 	if err != nil {
 		return nil, errors.New("4")
 	}
-	err = encoder.Encode(g.keys)
+	// the key index is rebuilt on decode. the list of keys is kept for compatibility
+	keys := make(map[string]bool, len(g.keys))
+	for key := range g.keys {
+		keys[key] = true
+	}
+	err = encoder.Encode(keys)
 	if err != nil {
 		return nil, errors.New("5")
 	}
@@ -37,14 +75,72 @@ func (g *Graph) GobEncode() ([]byte, error) {
 	if err != nil {
 		return nil, errors.New("6")
 	}
+	err = encoder.Encode(g.Schema() != nil)
+	if err != nil {
+		return nil, errors.New("7")
+	}
+	if s := g.Schema(); s != nil {
+		err = encoder.Encode(s)
+		if err != nil {
+			return nil, errors.New("8")
+		}
+	}
+	err = encoder.Encode(g.indexNames())
+	if err != nil {
+		return nil, errors.New("9")
+	}
+	err = encoder.Encode(g.search != nil && g.search.values)
+	if err != nil {
+		return nil, errors.New("10")
+	}
+	err = encoder.Encode(g.edgeExpiry)
+	if err != nil {
+		return nil, errors.New("11")
+	}
+	err = encoder.Encode(g.undirected)
+	if err != nil {
+		return nil, errors.New("12")
+	}
+	err = encoder.Encode(g.topEdgeID)
+	if err != nil {
+		return nil, errors.New("13")
+	}
+	err = encoder.Encode(g.forbidParallel)
+	if err != nil {
+		return nil, errors.New("14")
+	}
+	err = encoder.Encode(g.forbidSelf)
+	if err != nil {
+		return nil, errors.New("15")
+	}
+	err = encoder.Encode(g.maxNodes)
+	if err != nil {
+		return nil, errors.New("16")
+	}
+	err = encoder.Encode(g.history != nil)
+	if err != nil {
+		return nil, errors.New("17")
+	}
+	if g.history != nil {
+		g.history.Lock()
+		err = encoder.Encode(g.history.state())
+		g.history.Unlock()
+		if err != nil {
+			return nil, errors.New("18")
+		}
+	}
 	return w.Bytes(), nil
 }
 
 // GobDecode satisfies the gob encoder interface
 func (g *Graph) GobDecode(buf []byte) error {
+	version, buf, err := splitVersion(buf)
+	if err != nil {
+		return err
+	}
 	r := bytes.NewBuffer(buf)
 	decoder := gob.NewDecoder(r)
-	err := decoder.Decode(&g.Name)
+	err = decoder.Decode(&g.Name)
 	if err != nil {
 		return err
 	}
@@ -56,11 +152,12 @@ func (g *Graph) GobDecode(buf []byte) error {
 	if err != nil {
 		return err
 	}
-	err = decoder.Decode(&g.Nodes)
+	err = decoder.Decode(&g.nodes)
 	if err != nil {
 		return err
 	}
-	err = decoder.Decode(&g.keys)
+	var keys map[string]bool
+	err = decoder.Decode(&keys)
 	if err != nil {
 		return err
 	}
@@ -69,25 +166,177 @@ func (g *Graph) GobDecode(buf []byte) error {
 		return err
 	}
 
-	// node.sources causes a locking issue
-	// on encode/decode. to work around this,
-	// we use node.sourceIDs and rebuild node.sources
-	for _, node := range g.Nodes {
+	// graphs saved before schemas were introduced end here
+	var hasSchema bool
+	err = decoder.Decode(&hasSchema)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if hasSchema {
+		g.schema = &Schema{}
+		err = decoder.Decode(g.schema)
+		if err != nil {
+			return err
+		}
+	}
+	var indexNames []string
+	if err == nil {
+		err = decoder.Decode(&indexNames)
+		if err != nil && err != io.EOF {
+			return err
+		}
+	}
+	var searchValues bool
+	if err == nil {
+		err = decoder.Decode(&searchValues)
+		if err != nil && err != io.EOF {
+			return err
+		}
+	}
+	g.edgeExpiry = nil
+	var pairExpiry map[Edge]time.Time
+	if err == nil && version >= 2 {
+		err = decoder.Decode(&g.edgeExpiry)
+		if err != nil && err != io.EOF {
+			return err
+		}
+	} else if err == nil {
+		err = decoder.Decode(&pairExpiry)
+		if err != nil && err != io.EOF {
+			return err
+		}
+	}
+	g.undirected = false
+	if err == nil {
+		err = decoder.Decode(&g.undirected)
+		if err != nil && err != io.EOF {
+			return err
+		}
+	}
+	g.topEdgeID, g.forbidParallel, g.forbidSelf = 0, false, false
+	if err == nil {
+		err = decoder.Decode(&g.topEdgeID)
+		if err != nil && err != io.EOF {
+			return err
+		}
+	}
+	if err == nil {
+		err = decoder.Decode(&g.forbidParallel)
+		if err != nil && err != io.EOF {
+			return err
+		}
+	}
+	if err == nil {
+		err = decoder.Decode(&g.forbidSelf)
+		if err != nil && err != io.EOF {
+			return err
+		}
+	}
+	g.maxNodes = 0
+	if err == nil {
+		err = decoder.Decode(&g.maxNodes)
+		if err != nil && err != io.EOF {
+			return err
+		}
+	}
+	var hasHistory bool
+	if err == nil {
+		err = decoder.Decode(&hasHistory)
+		if err != nil && err != io.EOF {
+			return err
+		}
+	}
+	var history historyState
+	if hasHistory {
+		err = decoder.Decode(&history)
+		if err != nil {
+			return err
+		}
+	}
+
+	// node.sources and node.destinations cause locking issues
+	// on encode/decode and would encode shared nodes more than once.
+	// to work around this, we use node.sourceIDs and node.destinationIDs
+	// and rebuild the pointers here
+	for _, node := range g.nodes {
+		for _, id := range node.destinationIDs {
+			node.destinations = append(node.destinations, g.nodes[id])
+		}
 		for _, id := range node.sourceIDs {
-			node.sources = append(node.sources, g.Nodes[id])
+			node.sources = append(node.sources, g.nodes[id])
 		}
-		node.sourceIDs = make([]uint64, 0)
+		node.destinationIDs = nil
+		node.sourceIDs = nil
+	}
+	if version == 0 {
+		g.rebuildSources()
+	}
+	g.rebuild(indexNames, searchValues)
+	g.expirePairs(pairExpiry)
+	g.metaDirty = false
+	g.history = nil
+	if hasHistory {
+		g.history = newHistory(g)
+		g.history.restore(history)
+		g.listen(g.history.record)
 	}
 
 	return nil
 }
 
+// rebuild links decoded nodes to the graph and rebuilds the key, external ID, label, property,
+// relationship and search indexes, none of which are saved
+func (g *Graph) rebuild(indexNames []string, searchValues bool) {
+	g.keys = make(map[string]map[uint64]bool)
+	g.labels = make(map[string]map[uint64]bool)
+	g.externalIDs = make(map[string]uint64)
+	for _, node := range g.nodes {
+		if node.graph != g {
+			node.graph = g
+		}
+		g.indexKey(node.Key, node.ID)
+		if node.externalID != "" {
+			g.externalIDs[node.externalID] = node.ID
+		}
+		for _, label := range node.labels {
+			g.indexLabel(label, node.ID)
+		}
+	}
+
+	g.rebuildRelationships()
+
+	g.indexes = make(map[string]*propertyIndex)
+	for _, name := range indexNames {
+		g.indexes[name] = g.buildIndex(name)
+	}
+	g.search = newSearchIndex()
+	g.search.values = searchValues
+	g.rebuildSearch()
+}
+
+// rebuildSources rebuilds every node's sources from the graph's relationships. Graphs saved
+// before the encoding was versioned may have saved the wrong sources.
+func (g *Graph) rebuildSources() {
+	IDs := make([]uint64, 0, len(g.nodes))
+	for ID, node := range g.nodes {
+		IDs = append(IDs, ID)
+		node.sources = nil
+	}
+	sort.Slice(IDs, func(i, j int) bool { return IDs[i] < IDs[j] })
+	for _, ID := range IDs {
+		node := g.nodes[ID]
+		for _, dest := range node.destinations {
+			dest.sources = append(dest.sources, node)
+		}
+	}
+}
+
 // GobEncode satisfies the gob encoder interface
 func (n *Node) GobEncode() ([]byte, error) {
 	n.Lock()
 	defer n.Unlock()
 
-	w := new(bytes.Buffer)
+	w := bytes.NewBuffer([]byte{0, encodingVersion})
 	encoder := gob.NewEncoder(w)
 	err := encoder.Encode(n.ID)
 	if err != nil {
@@ -105,17 +354,40 @@ func (n *Node) GobEncode() ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	err = encoder.Encode(n.destinations)
+	destinationIDs, sourceIDs := n.adjacentIDs()
+	err = encoder.Encode(destinationIDs)
 	if err != nil {
 		return nil, err
 	}
-
-	// poulate sourceIDs
-	for _, n := range n.sources {
-		n.sourceIDs = append(n.sourceIDs, n.ID)
+	err = encoder.Encode(sourceIDs)
+	if err != nil {
+		return nil, err
 	}
-
-	err = encoder.Encode(n.sourceIDs)
+	err = encoder.Encode(n.Kind)
+	if err != nil {
+		return nil, err
+	}
+	err = encoder.Encode(n.labels)
+	if err != nil {
+		return nil, err
+	}
+	err = encoder.Encode(n.properties)
+	if err != nil {
+		return nil, err
+	}
+	err = encoder.Encode(n.externalID)
+	if err != nil {
+		return nil, err
+	}
+	err = encoder.Encode(n.expires)
+	if err != nil {
+		return nil, err
+	}
+	err = encoder.Encode(n.edgeIDs)
+	if err != nil {
+		return nil, err
+	}
+	err = encoder.Encode(n.edgeTypes)
 	if err != nil {
 		return nil, err
 	}
@@ -124,6 +396,25 @@ func (n *Node) GobEncode() ([]byte, error) {
 
 // GobDecode satisfies the gob encoder interface
 func (n *Node) GobDecode(buf []byte) error {
+	version, buf, err := splitVersion(buf)
+	if err != nil {
+		return err
+	}
+	if version > 0 {
+		return n.decode(buf, false)
+	}
+	// nodes saved before the encoding was versioned list their destinations by ID, or as
+	// nested nodes before that
+	err = n.decode(buf, false)
+	if err != nil && n.decode(buf, true) == nil {
+		return nil
+	}
+	return err
+}
+
+// decode is the logic for GobDecode(). Nested destinations are decoded as whole nodes and only
+// their IDs are kept.
+func (n *Node) decode(buf []byte, nested bool) error {
 	r := bytes.NewBuffer(buf)
 	decoder := gob.NewDecoder(r)
 	err := decoder.Decode(&n.ID)
@@ -142,7 +433,13 @@ func (n *Node) GobDecode(buf []byte) error {
 	if err != nil {
 		return err
 	}
-	err = decoder.Decode(&n.destinations)
+	if nested {
+		var destinations []*Node
+		err = decoder.Decode(&destinations)
+		n.destinationIDs = extractIDs(destinations)
+	} else {
+		err = decoder.Decode(&n.destinationIDs)
+	}
 	if err != nil {
 		return err
 	}
@@ -150,5 +447,54 @@ func (n *Node) GobDecode(buf []byte) error {
 	if err != nil {
 		return err
 	}
+	// nodes saved before kinds were introduced end here
+	err = decoder.Decode(&n.Kind)
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	err = decoder.Decode(&n.labels)
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	err = decoder.Decode(&n.properties)
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	err = decoder.Decode(&n.externalID)
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	err = decoder.Decode(&n.expires)
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// relationships saved without IDs are given new ones when the graph is decoded
+	err = decoder.Decode(&n.edgeIDs)
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// relationships saved without types are given the type "" when the graph is decoded
+	err = decoder.Decode(&n.edgeTypes)
+	if err != nil && err != io.EOF {
+		return err
+	}
 	return nil
 }
@@ -1,6 +1,13 @@
 package giraffe
 
-import "testing"
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
 
 func TestEncodeDecode(t *testing.T) {
 	g, _ := newTestGraph()
@@ -18,7 +25,7 @@ func TestEncodeDecode(t *testing.T) {
 
 	var targetNode *Node
 	var ok bool
-	if targetNode, ok = decodedGraph.Nodes[10]; !ok {
+	if targetNode, ok = decodedGraph.nodes[10]; !ok {
 		t.Fatal("desired target node does not exist")
 	}
 
@@ -31,11 +38,126 @@ func TestEncodeDecode(t *testing.T) {
 		t.Error("newTestGraph should allow circular relationships, decodedGraph too")
 	}
 
-	if len(g.Nodes[10].sources) != 1 {
-		t.Errorf("node source restoration failed. Len of node 10 sources: %d", len(g.Nodes[10].sources))
+	if len(g.nodes[10].sources) != 1 {
+		t.Errorf("node source restoration failed. Len of node 10 sources: %d", len(g.nodes[10].sources))
 	}
 
-	if g.Nodes[10].sources[0].ID != uint64(6) {
-		t.Errorf("node source for restoration failed. node 10 source node not 6, got %d", g.Nodes[10].sources[0].ID)
+	if g.nodes[10].sources[0].ID != uint64(6) {
+		t.Errorf("node source for restoration failed. node 10 source node not 6, got %d", g.nodes[10].sources[0].ID)
+	}
+}
+
+func TestEncodeDecodeSharedNodes(t *testing.T) {
+	g, _ := NewGraph("testGraph")
+	n1 := g.InsertNode()
+	n2 := g.InsertNode()
+	n3 := g.InsertNode()
+	g.Root().AddRelationship(n1)
+	g.Root().AddRelationship(n2)
+	n1.AddRelationship(n3)
+	n2.AddRelationship(n3)
+	n3.AddRelationship(g.Root())
+	n3.AddLabel("Lesson")
+
+	data, err := g.GobEncode()
+	if err != nil {
+		t.Fatalf("unable to encode graph - err `%v`", err)
+	}
+	decodedGraph := &Graph{}
+	if err := decodedGraph.GobDecode(data); err != nil {
+		t.Fatalf("unable to decode graph - err `%v`", err)
+	}
+
+	decodedN3 := decodedGraph.nodes[n3.ID]
+	for _, id := range []uint64{n1.ID, n2.ID} {
+		if dest := decodedGraph.nodes[id].ListDestinations(); len(dest) != 1 || dest[0] != decodedN3 {
+			t.Errorf("node %d should point at the shared decoded node %d", id, n3.ID)
+		}
+	}
+	if sources := extractIDs(decodedN3.ListSources()); !ContainsAll(sources, []uint64{n1.ID, n2.ID}) {
+		t.Errorf("actual sources %v not expected %v", sources, []uint64{n1.ID, n2.ID})
+	}
+	if dest := decodedN3.ListDestinations(); len(dest) != 1 || dest[0] != decodedGraph.Root() {
+		t.Error("circular relationship to root not restored")
+	}
+
+	if !decodedN3.HasLabel("Lesson") {
+		t.Error("labels not restored")
+	}
+	if nodes := decodedGraph.NodesWithLabel("Lesson"); len(nodes) != 1 || nodes[0] != decodedN3 {
+		t.Error("label index not rebuilt")
+	}
+}
+
+func TestEncodeDecodeKeyIndex(t *testing.T) {
+	g, _ := NewConstraintGraph("testGraph", false, true)
+	n1, _ := g.InsertDataNode("key1", nil)
+
+	data, err := g.GobEncode()
+	if err != nil {
+		t.Fatalf("unable to encode graph - err `%v`", err)
+	}
+	decodedGraph := &Graph{}
+	if err := decodedGraph.GobDecode(data); err != nil {
+		t.Fatalf("unable to decode graph - err `%v`", err)
+	}
+
+	if ids := decodedGraph.NodeIDsByKey("key1"); len(ids) != 1 || ids[0] != n1.ID {
+		t.Errorf("got ids %v, want [%d]", ids, n1.ID)
+	}
+	if _, err := decodedGraph.InsertDataNode("key1", nil); err == nil || err.Error() != ErrKeyExists {
+		t.Errorf("got `%v`, want `%s`", err, ErrKeyExists)
+	}
+}
+
+func TestDecodeOlderVersions(t *testing.T) {
+	// both files hold the same graph: Intro -> Factoring, Quadratics; Factoring -> Quadratics;
+	// Quadratics -> node 4. baseline.gob nests destination nodes and unversioned.gob lists them
+	// by ID.
+	for _, file := range []string{"baseline.gob", "unversioned.gob"} {
+		data, err := os.ReadFile(filepath.Join("testdata", file))
+		if err != nil {
+			t.Fatalf("unable to read %s, error: %v", file, err)
+		}
+		g := &Graph{}
+		if err := g.GobDecode(data); err != nil {
+			t.Fatalf("unable to decode %s, error: %v", file, err)
+		}
+		if g.Name != "curriculum" || g.duplicateKeys || !g.circularRelationship {
+			t.Errorf("%s: got graph %q, duplicate keys %t, circular %t", file, g.Name, g.duplicateKeys, g.circularRelationship)
+		}
+		intro, ok := g.FindNodeByKey("Intro")
+		if !ok {
+			t.Fatalf("%s: unable to find Intro", file)
+		}
+		if string(intro.Value) != "lesson_id 1" {
+			t.Errorf("%s: got value %q", file, intro.Value)
+		}
+		if got := extractIDs(intro.ListDestinations()); !reflect.DeepEqual(got, []uint64{2, 3}) {
+			t.Errorf("%s: got destinations %v, want [2 3]", file, got)
+		}
+		quadratics, _ := g.Node(3)
+		if got := extractIDs(quadratics.ListSources()); !reflect.DeepEqual(got, []uint64{1, 2}) {
+			t.Errorf("%s: got sources %v, want [1 2]", file, got)
+		}
+		if _, err := g.InsertDataNode("Intro", nil); err == nil || err.Error() != ErrKeyExists {
+			t.Errorf("%s: got `%v`, want `%s`", file, err, ErrKeyExists)
+		}
+		if n := g.InsertNode(); n.ID != 5 {
+			t.Errorf("%s: got new node %d, want 5", file, n.ID)
+		}
+
+		// saving again writes the current version
+		want := slices.Collect(g.Edges())
+		if got := slices.Collect(copyGraph(t, g).Edges()); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got edges %v after saving again, want %v", file, got, want)
+		}
+	}
+
+	data, _ := NewGraph("testGraph")
+	buf, _ := data.GobEncode()
+	buf[1] = encodingVersion + 1
+	if err := (&Graph{}).GobDecode(buf); err == nil || !strings.HasPrefix(err.Error(), ErrEncodingVersion) {
+		t.Errorf("got `%v`, want `%s`", err, ErrEncodingVersion)
 	}
 }
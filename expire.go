@@ -0,0 +1,168 @@
+package giraffe
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// InsertExpiringNode inserts a node that Expire() deletes once the expiry time has passed, such
+// as a session or cached result. A zero time never expires.
+func (g *Graph) InsertExpiringNode(key string, value []byte, expires time.Time) (*Node, error) {
+	return g.insertDataNode(Operation{Kind: OpInsertNode, Key: key, Value: value, Expires: expires})
+}
+
+// Expires returns when the node expires, or zero if it does not. See InsertExpiringNode().
+func (n *Node) Expires() time.Time {
+	n.RLock()
+	defer n.RUnlock()
+
+	return n.expires
+}
+
+// AddExpiringRelationship adds a relationship that Expire() removes once the expiry time has
+// passed, and returns its ID. Expiry is kept per relationship, so parallel relationships between
+// the same nodes expire on their own. A zero time never expires.
+func (n *Node) AddExpiringRelationship(newNode *Node, expires time.Time) (uint64, error) {
+	unlock, err := n.lockValidated(func() Operation {
+		return Operation{Kind: OpAddRelationship, NodeID: n.ID, OtherID: newNode.ID, Expires: expires}
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	return n.addRelationship(newNode, 0, "", expires)
+}
+
+// RelationshipExpires returns when the relationship with the ID expires, and false if it does not
+func (g *Graph) RelationshipExpires(ID uint64) (time.Time, bool) {
+	g.Lock()
+	defer g.Unlock()
+
+	expires, ok := g.edgeExpiry[ID]
+	return expires, ok
+}
+
+// edgeKey returns the nodes a relationship relates. Undirected relationships are kept from the
+// lower ID, so either end finds them.
+func (g *Graph) edgeKey(from, to uint64) Edge {
+	if g.undirected && to < from {
+		from, to = to, from
+	}
+	return Edge{From: from, To: to}
+}
+
+// setEdgeExpiry records a relationship's expiry time, or clears it for a zero time. The caller
+// must hold the graph lock.
+func (g *Graph) setEdgeExpiry(ID uint64, expires time.Time) {
+	if _, ok := g.edgeExpiry[ID]; ok && expires.IsZero() {
+		delete(g.edgeExpiry, ID)
+		g.metaDirty = true
+		return
+	}
+	if expires.IsZero() {
+		return
+	}
+	if g.edgeExpiry == nil {
+		g.edgeExpiry = make(map[uint64]time.Time)
+	}
+	g.edgeExpiry[ID] = expires
+	g.metaDirty = true
+}
+
+// expirePairs gives every relationship between a pair of nodes the pair's expiry time. Graphs and
+// stores saved before expiry was kept per relationship kept it per pair. The caller must hold the
+// graph lock or own the graph.
+func (g *Graph) expirePairs(pairs map[Edge]time.Time) {
+	for ID, e := range g.relationships {
+		if expires, ok := pairs[e]; ok {
+			g.setEdgeExpiry(ID, expires)
+		}
+	}
+}
+
+// Expire deletes the nodes and removes the relationships whose expiry time is not after now,
+// through DeleteNodeByID() and RemoveRelationshipByID() so validators and listeners see each change.
+// It returns the number of nodes and relationships removed and the first error; items a
+// validator vetoes are left for the next call.
+func (g *Graph) Expire(now time.Time) (int, error) {
+	g.Lock()
+	if err := g.loadAll(); err != nil {
+		g.Unlock()
+		return 0, err
+	}
+	var nodes []uint64
+	for ID, n := range g.nodes {
+		n.RLock()
+		if !n.expires.IsZero() && !n.expires.After(now) {
+			nodes = append(nodes, ID)
+		}
+		n.RUnlock()
+	}
+	var edges []uint64
+	for ID, expires := range g.edgeExpiry {
+		if !expires.After(now) {
+			edges = append(edges, ID)
+		}
+	}
+	g.Unlock()
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i] < nodes[j] })
+	sort.Slice(edges, func(i, j int) bool { return edges[i] < edges[j] })
+
+	removed := 0
+	var firstErr error
+	failed := func(err error) {
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	for _, ID := range nodes {
+		n, ok := g.Node(ID)
+		if !ok {
+			continue
+		}
+		if expires := n.Expires(); expires.IsZero() || expires.After(now) {
+			// its ID was given to a new node since
+			continue
+		}
+		if err := g.DeleteNodeByID(ID); err != nil {
+			failed(err)
+			continue
+		}
+		removed++
+	}
+	for _, ID := range edges {
+		if expires, ok := g.RelationshipExpires(ID); !ok || expires.After(now) {
+			// removed with a node since
+			continue
+		}
+		if err := g.RemoveRelationshipByID(ID); err != nil {
+			failed(err)
+			continue
+		}
+		removed++
+	}
+	return removed, firstErr
+}
+
+// StartReaper runs Expire() in the background every interval until the returned function is
+// first called. Errors are left for the next run. Graphs kept in step by replication or consensus
+// should only reap on the primary or leader, whose deletions reach the other copies.
+func (g *Graph) StartReaper(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				g.Expire(now)
+			}
+		}
+	}()
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
@@ -0,0 +1,170 @@
+package giraffe
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestExpire(t *testing.T) {
+	g, _ := NewGraph("testGraph")
+	now := time.Now()
+	session, _ := g.InsertExpiringNode("session", []byte("abc"), now.Add(time.Minute))
+	user, _ := g.InsertDataNode("user", []byte("sethgrid"))
+	cache, _ := g.InsertExpiringNode("cache", nil, now.Add(time.Hour))
+	g.Root().AddRelationship(session)
+	permanent, _ := g.Root().AddRelationshipID(user)
+	user.AddExpiringRelationship(cache, now.Add(30*time.Second))
+
+	if got := session.Expires(); !got.Equal(now.Add(time.Minute)) {
+		t.Errorf("got expiry %v, want %v", got, now.Add(time.Minute))
+	}
+	if !user.Expires().IsZero() {
+		t.Errorf("got expiry %v, want none", user.Expires())
+	}
+	if _, ok := g.RelationshipExpires(permanent); ok {
+		t.Error("got an expiry on a permanent relationship")
+	}
+
+	var deleted []uint64
+	g.AddValidator(func(_ *Graph, op Operation) error {
+		if op.Kind == OpDeleteNode {
+			deleted = append(deleted, op.NodeID)
+		}
+		return nil
+	})
+	if removed, err := g.Expire(now); removed != 0 || err != nil {
+		t.Errorf("got %d removed, error %v, want nothing expired yet", removed, err)
+	}
+	if removed, err := g.Expire(now.Add(time.Minute)); removed != 2 || err != nil {
+		t.Errorf("got %d removed, error %v, want 2", removed, err)
+	}
+	if _, ok := g.Node(session.ID); ok {
+		t.Error("expired node was not deleted")
+	}
+	if !reflect.DeepEqual(deleted, []uint64{session.ID}) {
+		t.Errorf("got deletes %v, want them through DeleteNode", deleted)
+	}
+	if got := extractIDs(user.ListDestinations()); len(got) != 0 {
+		t.Errorf("got destinations %v, want the expired relationship removed", got)
+	}
+	if _, ok := g.Node(cache.ID); !ok {
+		t.Error("deleted a node that has not expired")
+	}
+
+	// parallel relationships expire on their own
+	expiring, _ := user.AddExpiringRelationship(cache, now.Add(time.Second))
+	kept, _ := user.AddRelationshipID(cache)
+	later, _ := user.AddExpiringRelationship(cache, now.Add(time.Hour))
+	if got, ok := g.RelationshipExpires(expiring); !ok || !got.Equal(now.Add(time.Second)) {
+		t.Errorf("got expiry %v, want %v after adding a parallel relationship", got, now.Add(time.Second))
+	}
+	if removed, err := g.Expire(now.Add(time.Minute)); removed != 1 || err != nil {
+		t.Errorf("got %d removed, error %v, want 1", removed, err)
+	}
+	if got := user.RelationshipIDs(cache); !reflect.DeepEqual(got, []uint64{kept, later}) {
+		t.Errorf("got relationships %v, want %v", got, []uint64{kept, later})
+	}
+
+	// vetoed deletes are left for the next call
+	veto := errors.New("keep the cache")
+	g.AddValidator(func(_ *Graph, op Operation) error {
+		if op.Kind == OpDeleteNode && op.NodeID == cache.ID {
+			return veto
+		}
+		return nil
+	})
+	if _, err := g.Expire(now.Add(2 * time.Hour)); !errors.Is(err, veto) {
+		t.Errorf("got `%v`, want `%v`", err, veto)
+	}
+	if _, ok := g.Node(cache.ID); !ok {
+		t.Error("deleted a vetoed node")
+	}
+}
+
+func TestExpirySaveLoad(t *testing.T) {
+	now := time.Now().Round(0)
+	build := func(g *Graph) {
+		n1, _ := g.InsertExpiringNode("session", nil, now)
+		n2, _ := g.InsertDataNode("user", nil)
+		n2.AddRelationship(n1)
+		n2.AddExpiringRelationship(n1, now.Add(time.Second))
+	}
+	check := func(name string, g *Graph) {
+		n1, _ := g.Node(1)
+		if !n1.Expires().Equal(now) {
+			t.Errorf("%s: got node expiry %v, want %v", name, n1.Expires(), now)
+		}
+		n2, _ := g.Node(2)
+		IDs := n2.RelationshipIDs(n1)
+		if _, ok := g.RelationshipExpires(IDs[0]); ok {
+			t.Errorf("%s: got an expiry on a permanent relationship", name)
+		}
+		if got, ok := g.RelationshipExpires(IDs[1]); !ok || !got.Equal(now.Add(time.Second)) {
+			t.Errorf("%s: got relationship expiry %v, want %v", name, got, now.Add(time.Second))
+		}
+	}
+
+	g, _ := NewGraph("testGraph")
+	build(g)
+	check("gob", copyGraph(t, g))
+
+	store := NewMemoryStore()
+	g, err := NewStoreGraph("testGraph", false, true, store)
+	if err != nil {
+		t.Fatalf("unable to create graph, error: %v", err)
+	}
+	build(g)
+	g, err = OpenGraph(store)
+	if err != nil {
+		t.Fatalf("unable to open graph, error: %v", err)
+	}
+	check("store", g)
+
+	// stores written before expiry was kept per relationship key it by pair of nodes
+	data, _ := store.Meta(graphMetaKey)
+	var meta graphMeta
+	gob.NewDecoder(bytes.NewReader(data)).Decode(&meta)
+	meta.EdgeExpiry = map[Edge]time.Time{{From: 2, To: 1}: now}
+	meta.RelationshipExpiry = nil
+	var buf bytes.Buffer
+	gob.NewEncoder(&buf).Encode(meta)
+	store.SetMeta(graphMetaKey, buf.Bytes())
+	g, err = OpenGraph(store)
+	if err != nil {
+		t.Fatalf("unable to open graph, error: %v", err)
+	}
+	n1, _ := g.Node(1)
+	n2, _ := g.Node(2)
+	for _, ID := range n2.RelationshipIDs(n1) {
+		if got, ok := g.RelationshipExpires(ID); !ok || !got.Equal(now) {
+			t.Errorf("got relationship %d expiry %v, want %v", ID, got, now)
+		}
+	}
+}
+
+func TestReaper(t *testing.T) {
+	g, _ := NewGraph("testGraph")
+	n, _ := g.InsertExpiringNode("session", nil, time.Now())
+	stop := g.StartReaper(time.Millisecond)
+	defer stop()
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if _, ok := g.Node(n.ID); !ok {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Error("reaper did not delete the expired node")
+}
+
+func TestReaperStopTwice(t *testing.T) {
+	g, _ := NewGraph("testGraph")
+	stop := g.StartReaper(time.Millisecond)
+	stop()
+	stop()
+}
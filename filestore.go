@@ -0,0 +1,463 @@
+package giraffe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"hash/crc32"
+	"io"
+	"iter"
+	"os"
+	"sort"
+	"sync"
+)
+
+// ErrCorruptRecord returns when a FileStore record fails its checksum
+const ErrCorruptRecord = "corrupt record"
+
+// record types in a FileStore log
+const (
+	fileRecNode byte = iota + 1
+	fileRecDelete
+	fileRecAdjacency
+	fileRecMeta
+	// fileRecBatch holds the records of a single Update(), each with its own header
+	fileRecBatch
+)
+
+// fileRecHeader is the size of the length and checksum that precede each record
+const fileRecHeader = 8
+
+// FileStore is an on disk Store. Every change is appended to a single log file and an index of
+// record offsets, keys and meta entries is kept in memory, while node values, properties and
+// adjacency lists stay on disk until they are read. The records of an Update() are appended as
+// one checksummed batch. A torn write at the end of the log, from a crash, is discarded when the
+// store is opened. Use Compact() to drop overwritten records.
+type FileStore struct {
+	sync.RWMutex
+	path string
+	file *os.File
+	size int64
+
+	// offsets of the latest node and adjacency records
+	nodes     map[uint64]int64
+	adjacency map[uint64]int64
+
+	keys     map[string]map[uint64]bool
+	nodeKeys map[uint64]string
+	meta     map[string][]byte
+
+	// SyncWrites makes every write wait for the log to reach stable storage
+	SyncWrites bool
+}
+
+// OpenFileStore opens or creates a FileStore at path
+func OpenFileStore(path string) (*FileStore, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &FileStore{
+		path:      path,
+		file:      file,
+		nodes:     make(map[uint64]int64),
+		adjacency: make(map[uint64]int64),
+		keys:      make(map[string]map[uint64]bool),
+		nodeKeys:  make(map[uint64]string),
+		meta:      make(map[string][]byte),
+	}
+	if err := s.load(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// load scans the log and rebuilds the in memory index. A record cut short by the end of the
+// file, or a last record that fails its checksum, is a torn write and is truncated; a damaged
+// record anywhere else is returned as an error.
+func (s *FileStore) load() error {
+	info, err := s.file.Stat()
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+
+	var offset int64
+	for offset < size {
+		payload, err := readFileRecord(s.file, offset, size)
+		if err == io.ErrUnexpectedEOF || (err != nil && err.Error() == ErrCorruptRecord && offset+fileRecHeader+int64(len(payload)) == size) {
+			if err := s.file.Truncate(offset); err != nil {
+				return err
+			}
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err := s.apply(offset, payload); err != nil {
+			return err
+		}
+		offset += fileRecHeader + int64(len(payload))
+	}
+	s.size = offset
+	return nil
+}
+
+// apply updates the index for a record at offset
+func (s *FileStore) apply(offset int64, payload []byte) error {
+	body := payload[1:]
+	switch payload[0] {
+	case fileRecNode:
+		var rec NodeRecord
+		if err := gob.NewDecoder(bytes.NewReader(body)).Decode(&rec); err != nil {
+			return err
+		}
+		s.unindexKey(rec.ID)
+		s.nodes[rec.ID] = offset
+		s.nodeKeys[rec.ID] = rec.Key
+		if s.keys[rec.Key] == nil {
+			s.keys[rec.Key] = make(map[uint64]bool)
+		}
+		s.keys[rec.Key][rec.ID] = true
+	case fileRecDelete:
+		id := binary.BigEndian.Uint64(body)
+		s.unindexKey(id)
+		delete(s.nodes, id)
+		delete(s.adjacency, id)
+	case fileRecAdjacency:
+		s.adjacency[binary.BigEndian.Uint64(body)] = offset
+	case fileRecMeta:
+		n, size := binary.Uvarint(body)
+		name := string(body[size : size+int(n)])
+		s.meta[name] = append([]byte(nil), body[size+int(n):]...)
+	case fileRecBatch:
+		// each record in a batch is indexed at its own offset, so it can be read on its own
+		for at := int64(0); at < int64(len(body)); {
+			record, err := readFileRecord(bytes.NewReader(body), at, int64(len(body)))
+			if err != nil {
+				return err
+			}
+			if err := s.apply(offset+fileRecHeader+1+at, record); err != nil {
+				return err
+			}
+			at += fileRecHeader + int64(len(record))
+		}
+	}
+	return nil
+}
+
+// unindexKey removes a node from the key index
+func (s *FileStore) unindexKey(ID uint64) {
+	key, ok := s.nodeKeys[ID]
+	if !ok {
+		return
+	}
+	delete(s.keys[key], ID)
+	if len(s.keys[key]) == 0 {
+		delete(s.keys, key)
+	}
+	delete(s.nodeKeys, ID)
+}
+
+// append writes a record to the end of the log and indexes it
+func (s *FileStore) append(payload []byte) error {
+	buf := appendFileRecord(nil, payload)
+	if _, err := s.file.WriteAt(buf, s.size); err != nil {
+		return err
+	}
+	if s.SyncWrites {
+		if err := s.file.Sync(); err != nil {
+			return err
+		}
+	}
+	offset := s.size
+	s.size += int64(len(buf))
+	return s.apply(offset, payload)
+}
+
+// appendFileRecord appends a record's header and payload to buf
+func appendFileRecord(buf, payload []byte) []byte {
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(payload)))
+	buf = binary.BigEndian.AppendUint32(buf, crc32.ChecksumIEEE(payload))
+	return append(buf, payload...)
+}
+
+// read returns the payload of the record at offset
+func (s *FileStore) read(offset int64) ([]byte, error) {
+	return readFileRecord(s.file, offset, s.size)
+}
+
+// readFileRecord reads and verifies a record in the first size bytes of r. It returns
+// io.ErrUnexpectedEOF if the record runs past size, and ErrCorruptRecord with the unverified
+// payload if the checksum does not match.
+func readFileRecord(r io.ReaderAt, offset, size int64) ([]byte, error) {
+	var header [fileRecHeader]byte
+	if size-offset < fileRecHeader {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if _, err := r.ReadAt(header[:], offset); err != nil {
+		return nil, err
+	}
+	length := int64(binary.BigEndian.Uint32(header[0:4]))
+	if size-offset-fileRecHeader < length {
+		return nil, io.ErrUnexpectedEOF
+	}
+	payload := make([]byte, length)
+	if _, err := r.ReadAt(payload, offset+fileRecHeader); err != nil {
+		return nil, err
+	}
+	if len(payload) == 0 || crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:8]) {
+		return payload, errors.New(ErrCorruptRecord)
+	}
+	return payload, nil
+}
+
+// GetNode satisfies the Store interface
+func (s *FileStore) GetNode(ID uint64) (NodeRecord, bool, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	offset, ok := s.nodes[ID]
+	if !ok {
+		return NodeRecord{}, false, nil
+	}
+	rec, err := s.readNode(offset)
+	return rec, err == nil, err
+}
+
+// readNode decodes the node record at offset
+func (s *FileStore) readNode(offset int64) (NodeRecord, error) {
+	var rec NodeRecord
+	payload, err := s.read(offset)
+	if err != nil {
+		return rec, err
+	}
+	err = gob.NewDecoder(bytes.NewReader(payload[1:])).Decode(&rec)
+	return rec, err
+}
+
+// PutNode satisfies the Store interface
+func (s *FileStore) PutNode(rec NodeRecord) error {
+	return s.Update(func(b StoreBatch) error { return b.PutNode(rec) })
+}
+
+// DeleteNode satisfies the Store interface
+func (s *FileStore) DeleteNode(ID uint64) error {
+	return s.Update(func(b StoreBatch) error { return b.DeleteNode(ID) })
+}
+
+// Adjacency satisfies the Store interface
+func (s *FileStore) Adjacency(ID uint64) ([]uint64, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	offset, ok := s.adjacency[ID]
+	if !ok {
+		return nil, nil
+	}
+	payload, err := s.read(offset)
+	if err != nil {
+		return nil, err
+	}
+	body := payload[9:]
+	destinations := make([]uint64, len(body)/8)
+	for i := range destinations {
+		destinations[i] = binary.BigEndian.Uint64(body[i*8:])
+	}
+	return destinations, nil
+}
+
+// PutAdjacency satisfies the Store interface
+func (s *FileStore) PutAdjacency(ID uint64, destinations []uint64) error {
+	return s.Update(func(b StoreBatch) error { return b.PutAdjacency(ID, destinations) })
+}
+
+// NodeIDsByKey satisfies the Store interface
+func (s *FileStore) NodeIDsByKey(key string) ([]uint64, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	return sortedIDs(s.keys[key]), nil
+}
+
+// Nodes satisfies the Store interface. Records are read from disk one at a time.
+func (s *FileStore) Nodes() iter.Seq2[NodeRecord, error] {
+	return func(yield func(NodeRecord, error) bool) {
+		s.RLock()
+		ids := make([]uint64, 0, len(s.nodes))
+		for id := range s.nodes {
+			ids = append(ids, id)
+		}
+		s.RUnlock()
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+		for _, id := range ids {
+			rec, ok, err := s.GetNode(id)
+			if !ok && err == nil {
+				// deleted since iteration started
+				continue
+			}
+			if !yield(rec, err) {
+				return
+			}
+		}
+	}
+}
+
+// Meta satisfies the Store interface
+func (s *FileStore) Meta(name string) ([]byte, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	return s.meta[name], nil
+}
+
+// SetMeta satisfies the Store interface
+func (s *FileStore) SetMeta(name string, value []byte) error {
+	return s.Update(func(b StoreBatch) error { return b.SetMeta(name, value) })
+}
+
+// Update satisfies the Store interface. A batch of one write is appended as a plain record.
+func (s *FileStore) Update(fn func(StoreBatch) error) error {
+	var b fileBatch
+	if err := fn(&b); err != nil {
+		return err
+	}
+	var payload []byte
+	switch len(b) {
+	case 0:
+		return nil
+	case 1:
+		payload = b[0]
+	default:
+		payload = []byte{fileRecBatch}
+		for _, record := range b {
+			payload = appendFileRecord(payload, record)
+		}
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	return s.append(payload)
+}
+
+// fileBatch is the StoreBatch of a FileStore. It holds the payloads of its records.
+type fileBatch [][]byte
+
+// PutNode satisfies the StoreBatch interface
+func (b *fileBatch) PutNode(rec NodeRecord) error {
+	w := bytes.NewBuffer([]byte{fileRecNode})
+	if err := gob.NewEncoder(w).Encode(rec); err != nil {
+		return err
+	}
+	*b = append(*b, w.Bytes())
+	return nil
+}
+
+// DeleteNode satisfies the StoreBatch interface
+func (b *fileBatch) DeleteNode(ID uint64) error {
+	payload := make([]byte, 9)
+	payload[0] = fileRecDelete
+	binary.BigEndian.PutUint64(payload[1:], ID)
+	*b = append(*b, payload)
+	return nil
+}
+
+// PutAdjacency satisfies the StoreBatch interface
+func (b *fileBatch) PutAdjacency(ID uint64, destinations []uint64) error {
+	payload := make([]byte, 9+8*len(destinations))
+	payload[0] = fileRecAdjacency
+	binary.BigEndian.PutUint64(payload[1:], ID)
+	for i, dest := range destinations {
+		binary.BigEndian.PutUint64(payload[9+8*i:], dest)
+	}
+	*b = append(*b, payload)
+	return nil
+}
+
+// SetMeta satisfies the StoreBatch interface
+func (b *fileBatch) SetMeta(name string, value []byte) error {
+	*b = append(*b, metaPayload(name, value))
+	return nil
+}
+
+// metaPayload builds a meta record
+func metaPayload(name string, value []byte) []byte {
+	payload := []byte{fileRecMeta}
+	payload = binary.AppendUvarint(payload, uint64(len(name)))
+	payload = append(payload, name...)
+	return append(payload, value...)
+}
+
+// Compact rewrites the log keeping only the latest record for each node, adjacency list and
+// meta entry
+func (s *FileStore) Compact() error {
+	s.Lock()
+	defer s.Unlock()
+
+	tmpPath := s.path + ".compact"
+	tmp, err := OpenFileStore(tmpPath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath)
+
+	offsets := make([]int64, 0, len(s.nodes)+len(s.adjacency))
+	for _, offset := range s.nodes {
+		offsets = append(offsets, offset)
+	}
+	for _, offset := range s.adjacency {
+		offsets = append(offsets, offset)
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+	for _, offset := range offsets {
+		payload, err := s.read(offset)
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+		if err := tmp.append(payload); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	for name, value := range s.meta {
+		if err := tmp.append(metaPayload(name, value)); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := tmp.file.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	s.file.Close()
+	s.file = tmp.file
+	s.size = tmp.size
+	s.nodes = tmp.nodes
+	s.adjacency = tmp.adjacency
+	s.keys = tmp.keys
+	s.nodeKeys = tmp.nodeKeys
+	s.meta = tmp.meta
+	return nil
+}
+
+// Close satisfies the Store interface
+func (s *FileStore) Close() error {
+	s.Lock()
+	defer s.Unlock()
+
+	if err := s.file.Sync(); err != nil {
+		s.file.Close()
+		return err
+	}
+	return s.file.Close()
+}
@@ -0,0 +1,497 @@
+package giraffe
+
+import (
+	"bytes"
+	"encoding/gob"
+	"iter"
+	"regexp"
+	"slices"
+	"sort"
+)
+
+// FrozenGraph is an immutable, compact copy of a Graph for read heavy workloads.
+// Relationships are stored in compressed sparse row form: for the node at position i,
+// its destinations are outTargets[outOffsets[i]:outOffsets[i+1]] and its sources are
+// inTargets[inOffsets[i]:inOffsets[i+1]], where targets are node positions rather than IDs.
+// Keys and values are packed into single byte slices, kinds and labels refer to a table of names,
+// and each node's properties are gob encoded. Full text search and property indexes are not
+// carried over, so FindByProperty() scans every node. See Graph.Freeze() and OpenReadOnly().
+type FrozenGraph struct {
+	Name string
+
+	// ids holds the node IDs in ascending order. a node's position is its index in ids
+	ids []uint64
+
+	outOffsets []uint32
+	outTargets []uint32
+	inOffsets  []uint32
+	inTargets  []uint32
+
+	// keys and values for the node at position i are data[offsets[i]:offsets[i+1]]
+	keyOffsets   []uint64
+	keyData      []byte
+	valueOffsets []uint64
+	valueData    []byte
+
+	// keyOrder lists node positions sorted by key and then ID for key lookups
+	keyOrder []uint32
+
+	// names is a sorted table of the kinds and labels, packed like keys. the node at position i
+	// has the kind with name kinds[i] and the labels with names
+	// labelNames[labelOffsets[i]:labelOffsets[i+1]], in order
+	nameOffsets  []uint64
+	nameData     []byte
+	kinds        []uint32
+	labelOffsets []uint32
+	labelNames   []uint32
+
+	// properties for the node at position i are gob encoded in
+	// propertyData[propertyOffsets[i]:propertyOffsets[i+1]], which is empty without properties
+	propertyOffsets []uint64
+	propertyData    []byte
+
+	// mapping is the mapped file backing the slices. see OpenReadOnly()
+	mapping []byte
+}
+
+// Freeze builds a FrozenGraph from the graph's current nodes and relationships.
+// Later changes to the graph are not reflected in the frozen copy. If the graph's store fails to
+// read its nodes, the copy holds only those already read and the error is reported by StoreErr().
+func (g *Graph) Freeze() *FrozenGraph {
+	g.Lock()
+	defer g.Unlock()
+
+	g.loadAll()
+	nodes := make([]*Node, 0, len(g.nodes))
+	for _, node := range g.nodes {
+		nodes = append(nodes, node)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID < nodes[j].ID })
+
+	f := &FrozenGraph{
+		Name:         g.Name,
+		ids:          make([]uint64, len(nodes)),
+		outOffsets:   make([]uint32, len(nodes)+1),
+		inOffsets:    make([]uint32, len(nodes)+1),
+		keyOffsets:   make([]uint64, len(nodes)+1),
+		valueOffsets: make([]uint64, len(nodes)+1),
+		keyOrder:     make([]uint32, len(nodes)),
+
+		kinds:           make([]uint32, len(nodes)),
+		labelOffsets:    make([]uint32, len(nodes)+1),
+		propertyOffsets: make([]uint64, len(nodes)+1),
+	}
+	position := make(map[uint64]uint32, len(nodes))
+	names := []string{""}
+	for i, node := range nodes {
+		f.ids[i] = node.ID
+		position[node.ID] = uint32(i)
+		node.RLock()
+		names = append(names, node.Kind)
+		names = append(names, node.labels...)
+		node.RUnlock()
+	}
+	slices.Sort(names)
+	names = slices.Compact(names)
+	name := make(map[string]uint32, len(names))
+	f.nameOffsets = make([]uint64, len(names)+1)
+	for i, s := range names {
+		name[s] = uint32(i)
+		f.nameData = append(f.nameData, s...)
+		f.nameOffsets[i+1] = uint64(len(f.nameData))
+	}
+
+	for i, node := range nodes {
+		node.RLock()
+		for _, dest := range node.destinations {
+			f.outTargets = append(f.outTargets, position[dest.ID])
+		}
+		for _, src := range node.sources {
+			f.inTargets = append(f.inTargets, position[src.ID])
+		}
+		f.keyData = append(f.keyData, node.Key...)
+		f.valueData = append(f.valueData, node.Value...)
+		f.kinds[i] = name[node.Kind]
+		for _, label := range node.labels {
+			f.labelNames = append(f.labelNames, name[label])
+		}
+		if len(node.properties) > 0 {
+			w := bytes.NewBuffer(f.propertyData)
+			// properties are plain values, which gob always encodes
+			gob.NewEncoder(w).Encode(node.properties)
+			f.propertyData = w.Bytes()
+		}
+		node.RUnlock()
+
+		f.outOffsets[i+1] = uint32(len(f.outTargets))
+		f.inOffsets[i+1] = uint32(len(f.inTargets))
+		f.keyOffsets[i+1] = uint64(len(f.keyData))
+		f.valueOffsets[i+1] = uint64(len(f.valueData))
+		f.labelOffsets[i+1] = uint32(len(f.labelNames))
+		f.propertyOffsets[i+1] = uint64(len(f.propertyData))
+		f.keyOrder[i] = uint32(i)
+	}
+
+	sort.SliceStable(f.keyOrder, func(i, j int) bool {
+		return bytes.Compare(f.keyBytes(f.keyOrder[i]), f.keyBytes(f.keyOrder[j])) < 0
+	})
+
+	return f
+}
+
+// NodeCount returns the number of nodes in the frozen graph
+func (f *FrozenGraph) NodeCount() int {
+	return len(f.ids)
+}
+
+// EdgeCount returns the number of relationships in the frozen graph
+func (f *FrozenGraph) EdgeCount() int {
+	return len(f.outTargets)
+}
+
+// HasNode reports whether a node with the ID exists
+func (f *FrozenGraph) HasNode(ID uint64) bool {
+	_, ok := f.position(ID)
+	return ok
+}
+
+// NodeIDs iterates over the node IDs in ascending order
+func (f *FrozenGraph) NodeIDs() iter.Seq[uint64] {
+	return func(yield func(uint64) bool) {
+		for _, id := range f.ids {
+			if !yield(id) {
+				return
+			}
+		}
+	}
+}
+
+// Key returns the key of the node with the ID
+func (f *FrozenGraph) Key(ID uint64) (string, bool) {
+	p, ok := f.position(ID)
+	if !ok {
+		return "", false
+	}
+	return f.key(p), true
+}
+
+// Value returns the value of the node with the ID. The returned slice must not be modified.
+func (f *FrozenGraph) Value(ID uint64) ([]byte, bool) {
+	p, ok := f.position(ID)
+	if !ok {
+		return nil, false
+	}
+	return f.valueData[f.valueOffsets[p]:f.valueOffsets[p+1]:f.valueOffsets[p+1]], true
+}
+
+// Kind returns the kind of the node with the ID
+func (f *FrozenGraph) Kind(ID uint64) (string, bool) {
+	p, ok := f.position(ID)
+	if !ok {
+		return "", false
+	}
+	return f.name(f.kinds[p]), true
+}
+
+// Labels returns the labels of the node with the ID in sorted order
+func (f *FrozenGraph) Labels(ID uint64) []string {
+	p, ok := f.position(ID)
+	if !ok {
+		return nil
+	}
+	var labels []string
+	for _, l := range f.labelNames[f.labelOffsets[p]:f.labelOffsets[p+1]] {
+		labels = append(labels, f.name(l))
+	}
+	return labels
+}
+
+// HasLabel reports whether the node with the ID carries the label
+func (f *FrozenGraph) HasLabel(ID uint64, label string) bool {
+	p, ok := f.position(ID)
+	return ok && f.hasLabels(p, []string{label})
+}
+
+// NodeIDsWithLabel returns the IDs of the nodes carrying the label in ascending order
+func (f *FrozenGraph) NodeIDsWithLabel(label string) []uint64 {
+	var ids []uint64
+	for p, id := range f.ids {
+		if f.hasLabels(uint32(p), []string{label}) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// Property returns a named property of the node with the ID
+func (f *FrozenGraph) Property(ID uint64, name string) (Property, bool) {
+	p, ok := f.position(ID)
+	if !ok {
+		return Property{}, false
+	}
+	prop, ok := f.properties(p)[name]
+	return prop, ok
+}
+
+// Properties returns a copy of the properties of the node with the ID
+func (f *FrozenGraph) Properties(ID uint64) map[string]Property {
+	p, ok := f.position(ID)
+	if !ok {
+		return nil
+	}
+	return f.properties(p)
+}
+
+// FindByProperty returns the IDs of the nodes whose named property equals the value, in
+// ascending order. Unlike Graph.FindByProperty() it needs no index, and scans every node.
+func (f *FrozenGraph) FindByProperty(name string, value Property) []uint64 {
+	return f.FindByPropertyRange(name, value, value)
+}
+
+// FindByPropertyRange returns the IDs of the nodes whose named property is between min and max
+// inclusive, in ascending order. It scans every node.
+func (f *FrozenGraph) FindByPropertyRange(name string, min, max Property) []uint64 {
+	var ids []uint64
+	for p, id := range f.ids {
+		prop, ok := f.properties(uint32(p))[name]
+		if ok && compareProperties(prop, min) >= 0 && compareProperties(prop, max) <= 0 {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// ListDestinations lists the IDs of the nodes that the node points towards
+func (f *FrozenGraph) ListDestinations(ID uint64) []uint64 {
+	p, ok := f.position(ID)
+	if !ok {
+		return nil
+	}
+	return f.idsAt(f.outTargets[f.outOffsets[p]:f.outOffsets[p+1]])
+}
+
+// ListSources lists the IDs of the nodes that point to the node
+func (f *FrozenGraph) ListSources(ID uint64) []uint64 {
+	p, ok := f.position(ID)
+	if !ok {
+		return nil
+	}
+	return f.idsAt(f.inTargets[f.inOffsets[p]:f.inOffsets[p+1]])
+}
+
+// Edges iterates over every relationship, ordered by the source node's ID
+func (f *FrozenGraph) Edges() iter.Seq[Edge] {
+	return func(yield func(Edge) bool) {
+		for p, from := range f.ids {
+			for _, t := range f.outTargets[f.outOffsets[p]:f.outOffsets[p+1]] {
+				if !yield(Edge{From: from, To: f.ids[t]}) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// FindRoots finds all nodes that do not have a source nodes below them. When labels are given,
+// only nodes carrying all of the labels are considered, giving the roots of the labeled sub graph.
+func (f *FrozenGraph) FindRoots(labels ...string) []uint64 {
+	var roots []uint64
+	for p, id := range f.ids {
+		if !f.hasLabels(uint32(p), labels) {
+			continue
+		}
+		root := true
+		for _, src := range f.inTargets[f.inOffsets[p]:f.inOffsets[p+1]] {
+			if f.hasLabels(src, labels) {
+				root = false
+				break
+			}
+		}
+		if root {
+			roots = append(roots, id)
+		}
+	}
+	return roots
+}
+
+// FindNodeIDByKey returns the lowest ID of the nodes with a matching key
+func (f *FrozenGraph) FindNodeIDByKey(key string) (uint64, bool) {
+	ids := f.NodeIDsByKey(key)
+	if len(ids) == 0 {
+		return 0, false
+	}
+	return ids[0], true
+}
+
+// NodeIDsByKey returns the IDs of all nodes with a matching key in ascending order
+func (f *FrozenGraph) NodeIDsByKey(key string) []uint64 {
+	i := sort.Search(len(f.keyOrder), func(i int) bool { return string(f.keyBytes(f.keyOrder[i])) >= key })
+
+	var ids []uint64
+	for ; i < len(f.keyOrder) && string(f.keyBytes(f.keyOrder[i])) == key; i++ {
+		ids = append(ids, f.ids[f.keyOrder[i]])
+	}
+	return ids
+}
+
+// FindNodeIDsByKeyPattern returns the IDs of the nodes whose key matches a glob pattern, ordered
+// by key and then ID. See Graph.FindNodesByKeyPattern() for the pattern, offset and limit.
+func (f *FrozenGraph) FindNodeIDsByKeyPattern(pattern string, offset, limit int) ([]uint64, error) {
+	re, err := regexp.Compile(globToRegexp(pattern))
+	if err != nil {
+		return nil, err
+	}
+	return f.FindNodeIDsByKeyRegexp(re, offset, limit), nil
+}
+
+// FindNodeIDsByKeyRegexp returns the IDs of the nodes whose key matches the regular expression,
+// ordered by key and then ID. See Graph.FindNodesByKeyRegexp().
+func (f *FrozenGraph) FindNodeIDsByKeyRegexp(re *regexp.Regexp, offset, limit int) []uint64 {
+	var ids []uint64
+	for _, p := range f.keyOrder {
+		if !re.Match(f.keyBytes(p)) {
+			continue
+		}
+		if offset > 0 {
+			offset--
+			continue
+		}
+		ids = append(ids, f.ids[p])
+		if limit > 0 && len(ids) == limit {
+			break
+		}
+	}
+	return ids
+}
+
+// DepthFirstSearch traverses the graph starting at the from node to find the to node.
+// When labels are given, only nodes carrying all of the labels are visited.
+func (f *FrozenGraph) DepthFirstSearch(from, to uint64, labels ...string) bool {
+	start, ok := f.position(from)
+	if !ok {
+		return false
+	}
+	target, ok := f.position(to)
+	if !ok {
+		return false
+	}
+
+	visited := make([]bool, len(f.ids))
+	stack := []uint32{start}
+	for len(stack) > 0 {
+		p := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		// push in reverse so destinations are explored in the order they were added
+		targets := f.outTargets[f.outOffsets[p]:f.outOffsets[p+1]]
+		for i := len(targets) - 1; i >= 0; i-- {
+			t := targets[i]
+			if !f.hasLabels(t, labels) {
+				continue
+			}
+			if t == target {
+				return true
+			}
+			if !visited[t] {
+				visited[t] = true
+				stack = append(stack, t)
+			}
+		}
+	}
+	return false
+}
+
+// BreadthFirstSearch traverses the graph starting at the from node to find the to node.
+// When labels are given, only nodes carrying all of the labels are visited.
+func (f *FrozenGraph) BreadthFirstSearch(from, to uint64, labels ...string) bool {
+	start, ok := f.position(from)
+	if !ok {
+		return false
+	}
+	target, ok := f.position(to)
+	if !ok {
+		return false
+	}
+
+	visited := make([]bool, len(f.ids))
+	queue := []uint32{start}
+	for len(queue) > 0 {
+		p := queue[0]
+		queue = queue[1:]
+		for _, t := range f.outTargets[f.outOffsets[p]:f.outOffsets[p+1]] {
+			if !f.hasLabels(t, labels) {
+				continue
+			}
+			if t == target {
+				return true
+			}
+			if !visited[t] {
+				visited[t] = true
+				queue = append(queue, t)
+			}
+		}
+	}
+	return false
+}
+
+// position finds the position of a node ID
+func (f *FrozenGraph) position(ID uint64) (uint32, bool) {
+	i := sort.Search(len(f.ids), func(i int) bool { return f.ids[i] >= ID })
+	if i == len(f.ids) || f.ids[i] != ID {
+		return 0, false
+	}
+	return uint32(i), true
+}
+
+// key returns the key of the node at a position
+func (f *FrozenGraph) key(p uint32) string {
+	return string(f.keyBytes(p))
+}
+
+// keyBytes returns the key of the node at a position without copying it
+func (f *FrozenGraph) keyBytes(p uint32) []byte {
+	return f.keyData[f.keyOffsets[p]:f.keyOffsets[p+1]]
+}
+
+// name returns an entry of the table of kinds and labels
+func (f *FrozenGraph) name(i uint32) string {
+	return string(f.nameBytes(i))
+}
+
+// nameBytes returns an entry of the table of kinds and labels without copying it
+func (f *FrozenGraph) nameBytes(i uint32) []byte {
+	return f.nameData[f.nameOffsets[i]:f.nameOffsets[i+1]]
+}
+
+// hasLabels reports whether the node at a position carries all of the labels
+func (f *FrozenGraph) hasLabels(p uint32, labels []string) bool {
+	names := f.labelNames[f.labelOffsets[p]:f.labelOffsets[p+1]]
+	for _, label := range labels {
+		i := sort.Search(len(names), func(i int) bool { return string(f.nameBytes(names[i])) >= label })
+		if i == len(names) || string(f.nameBytes(names[i])) != label {
+			return false
+		}
+	}
+	return true
+}
+
+// properties decodes the properties of the node at a position
+func (f *FrozenGraph) properties(p uint32) map[string]Property {
+	data := f.propertyData[f.propertyOffsets[p]:f.propertyOffsets[p+1]]
+	if len(data) == 0 {
+		return nil
+	}
+	var props map[string]Property
+	if gob.NewDecoder(bytes.NewReader(data)).Decode(&props) != nil {
+		return nil
+	}
+	return props
+}
+
+// idsAt maps node positions to node IDs
+func (f *FrozenGraph) idsAt(positions []uint32) []uint64 {
+	ids := make([]uint64, len(positions))
+	for i, p := range positions {
+		ids[i] = f.ids[p]
+	}
+	return ids
+}
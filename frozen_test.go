@@ -0,0 +1,151 @@
+package giraffe
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestFreeze(t *testing.T) {
+	g, _ := newTestGraph()
+	g.DeleteNodeByID(7)
+	g.nodes[4].SetKey("shared")
+	g.nodes[9].SetKey("shared")
+	g.nodes[9].SetValue([]byte("value9"))
+
+	f := g.Freeze()
+	// changes after freezing are not visible
+	g.InsertNode()
+
+	if f.NodeCount() != 12 {
+		t.Errorf("got %d nodes, want %d", f.NodeCount(), 12)
+	}
+	if f.EdgeCount() != 10 {
+		t.Errorf("got %d edges, want %d", f.EdgeCount(), 10)
+	}
+	if f.HasNode(7) || !f.HasNode(12) {
+		t.Error("frozen node set is wrong")
+	}
+
+	if got, want := f.ListDestinations(6), []uint64{9, 10}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got destinations %v, want %v", got, want)
+	}
+	if got, want := f.ListSources(10), []uint64{6}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got sources %v, want %v", got, want)
+	}
+	if got, want := slices.Collect(f.Edges()), slices.Collect(g.Edges()); !reflect.DeepEqual(got, want) {
+		t.Errorf("got edges %v, want %v", got, want)
+	}
+	if got, want := f.FindRoots(), []uint64{0, 12}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got roots %v, want %v", got, want)
+	}
+
+	if got, want := f.NodeIDsByKey("shared"), []uint64{4, 9}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got ids %v, want %v", got, want)
+	}
+	if id, ok := f.FindNodeIDByKey("shared"); !ok || id != 4 {
+		t.Errorf("got id %d, want %d", id, 4)
+	}
+	if v, _ := f.Value(9); string(v) != "value9" {
+		t.Errorf("got `%s`, want `value9`", v)
+	}
+
+	if !f.DepthFirstSearch(0, 11) || !f.BreadthFirstSearch(0, 11) {
+		t.Error("unable to find path root -> n11")
+	}
+	if f.DepthFirstSearch(0, 12) || f.BreadthFirstSearch(0, 12) {
+		t.Error("should not find a path root -> n12")
+	}
+	if f.DepthFirstSearch(0, 7) {
+		t.Error("should not find deleted node 7")
+	}
+}
+
+func TestFreezeCycles(t *testing.T) {
+	g, _ := NewGraph("testGraph")
+	n1 := g.InsertNode()
+	n2 := g.InsertNode()
+	n3 := g.InsertNode()
+	g.Root().AddRelationship(n1)
+	n1.AddRelationship(n2)
+	n2.AddRelationship(g.Root())
+
+	f := g.Freeze()
+	if f.DepthFirstSearch(0, n3.ID) || f.BreadthFirstSearch(0, n3.ID) {
+		t.Error("should not find unreachable node in a cyclic graph")
+	}
+	if !f.DepthFirstSearch(n2.ID, n1.ID) {
+		t.Error("unable to find path through the cycle")
+	}
+}
+
+// newCurriculumGraph builds a small graph with kinds, labels and properties
+func newCurriculumGraph() *Graph {
+	g, _ := NewGraph("curriculum")
+	intro, _ := g.InsertPropertyNode("Lesson", "Intro", nil, map[string]Property{"minutes": IntProp(30)})
+	factoring, _ := g.InsertPropertyNode("Lesson", "Factoring", nil, map[string]Property{"minutes": IntProp(45)})
+	quiz, _ := g.InsertPropertyNode("Quiz", "Factoring Quiz", nil, map[string]Property{"tags": ListProp(StringProp("algebra"))})
+	draft, _ := g.InsertKindNode("Lesson", "Graphing", nil)
+	intro.AddLabel("Published")
+	factoring.AddLabel("Published")
+	factoring.AddLabel("Algebra")
+	quiz.AddLabel("Published")
+	g.Root().AddRelationship(intro)
+	intro.AddRelationship(draft)
+	draft.AddRelationship(factoring)
+	intro.AddRelationship(quiz)
+	quiz.AddRelationship(factoring)
+	return g
+}
+
+func TestFreezeLabelsAndProperties(t *testing.T) {
+	f := newCurriculumGraph().Freeze()
+
+	if kind, ok := f.Kind(3); !ok || kind != "Quiz" {
+		t.Errorf("got kind %q, want Quiz", kind)
+	}
+	if kind, _ := f.Kind(0); kind != "" {
+		t.Errorf("got root kind %q, want none", kind)
+	}
+	if got, want := f.Labels(2), []string{"Algebra", "Published"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got labels %v, want %v", got, want)
+	}
+	if !f.HasLabel(1, "Published") || f.HasLabel(4, "Published") {
+		t.Error("got the wrong labels for nodes 1 and 4")
+	}
+	if got, want := f.NodeIDsWithLabel("Published"), []uint64{1, 2, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got ids %v, want %v", got, want)
+	}
+
+	if p, ok := f.Property(2, "minutes"); !ok || p.Int != 45 {
+		t.Errorf("got property %v, want 45", p)
+	}
+	if got := f.Properties(3)["tags"]; len(got.List) != 1 || got.List[0].Str != "algebra" {
+		t.Errorf("got tags %v", got)
+	}
+	if got, want := f.FindByProperty("minutes", IntProp(30)), []uint64{1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got ids %v, want %v", got, want)
+	}
+	if got, want := f.FindByPropertyRange("minutes", IntProp(0), IntProp(60)), []uint64{1, 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got ids %v, want %v", got, want)
+	}
+
+	// the published lessons start at Intro, since the root is not labeled
+	if got, want := f.FindRoots("Published"), []uint64{1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got roots %v, want %v", got, want)
+	}
+	// Factoring is reached through the unpublished Graphing or through the published quiz
+	if !f.DepthFirstSearch(1, 2, "Published") || !f.BreadthFirstSearch(1, 2, "Published") {
+		t.Error("unable to find published path Intro -> Factoring")
+	}
+	if f.DepthFirstSearch(1, 4, "Published") || f.BreadthFirstSearch(1, 2, "Algebra") {
+		t.Error("found a path through unlabeled nodes")
+	}
+
+	if got, _ := f.FindNodeIDsByKeyPattern("Factoring*", 0, 0); !reflect.DeepEqual(got, []uint64{2, 3}) {
+		t.Errorf("got ids %v, want [2 3]", got)
+	}
+	if got, _ := f.FindNodeIDsByKeyPattern("*", 1, 2); !reflect.DeepEqual(got, []uint64{2, 3}) {
+		t.Errorf("got ids %v, want the second and third keys [2 3]", got)
+	}
+}
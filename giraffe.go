@@ -1,10 +1,12 @@
 package giraffe
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 // Error constants
@@ -14,143 +16,293 @@ const (
 
 	// ErrCircular returns when adding a node relationship would result in a circular relationship
 	ErrCircular = "circular relationship"
+
+	// ErrReadOnly returns when changing a read only graph, such as a follower's replica
+	ErrReadOnly = "graph is read only"
 )
 
-// Graph is a data structure composed of Nodes that have directional relationships to one another
+// Graph is a data structure composed of Nodes that have directional relationships to one another,
+// or undirected relationships in a graph made with NewUndirectedGraph()
 type Graph struct {
 	Name string
 
 	duplicateKeys        bool
 	circularRelationship bool
+	// undirected graphs keep each relationship in both nodes' destinations and sources
+	undirected bool
+	// forbidParallel and forbidSelf are the relationship constraints.
+	// see SetRelationshipConstraints()
+	forbidParallel bool
+	forbidSelf     bool
+	// maxNodes limits the number of nodes besides the root. see Config
+	maxNodes int
 
 	sync.Mutex
-	Nodes map[uint64]*Node
-	keys  map[string]bool
+	nodes   map[uint64]*Node
+	keys    map[string]map[uint64]bool
+	labels  map[string]map[uint64]bool
+	indexes map[string]*propertyIndex
+	search  *searchIndex
 
 	topNodeID uint64
+
+	// relationships maps relationship IDs to the nodes they relate. see AddRelationshipID()
+	relationships map[uint64]Edge
+	topEdgeID     uint64
+
+	// validators and the schema run before mutations and may veto them.
+	// see AddValidator() and SetSchema()
+	hookLock   sync.RWMutex
+	validators []Validator
+	schema     *Schema
+
+	// allocator chooses node IDs, monotonically when nil. see SetIDAllocator()
+	allocator   IDAllocator
+	externalIDs map[string]uint64
+
+	// seq numbers committed mutations and listeners receive them. see Seq()
+	seq       uint64
+	listeners []func(LogEntry)
+
+	// readOnly graphs are only changed by replaying operations with apply(). see writable()
+	readOnly bool
+
+	// history records revisions once enabled. see EnableHistory()
+	history *history
+
+	// edgeExpiry holds the expiry times of relationships by ID. see AddExpiringRelationship()
+	edgeExpiry map[uint64]time.Time
+
+	// store, if set, receives every change. see NewStoreGraph()
+	store    Store
+	storeErr error
+	// partial is set while only some of a stored graph's nodes are in memory. see OpenGraph()
+	partial bool
+	// metaDirty marks graph level state, such as expiry times, to save with the next change
+	metaDirty bool
+	// unwritten holds the changes made while batching, which are written as one update
+	unwritten []change
+	batching  bool
 }
 
-// NewGraph creates a graph with default properties
+// NewGraph creates a graph with default properties. See DefaultConfig().
 func NewGraph(name string) (*Graph, error) {
-	return NewConstraintGraph(name, true, true)
+	return New(name)
 }
 
-// NewConstraintGraph allows you to control if duplicate keys or circular relationships can exist
+// NewConstraintGraph allows you to control if duplicate keys or circular relationships can exist.
+// See New() for more settings.
 func NewConstraintGraph(name string, duplicateKeys, circularRelationship bool) (*Graph, error) {
-	g := &Graph{
-		Name:                 name,
-		Nodes:                make(map[uint64]*Node),
-		keys:                 make(map[string]bool),
-		duplicateKeys:        duplicateKeys,
-		circularRelationship: circularRelationship,
-	}
-	// insert root node
-	g.Nodes[0] = &Node{ID: 0}
+	return New(name, WithDuplicateKeys(duplicateKeys), WithCircularRelationships(circularRelationship))
+}
+
+// NewUndirectedGraph creates a graph whose relationships have no direction, such as a network of
+// related lessons. AddRelationship() links both nodes, so each lists the other as a destination
+// and a source, and RemoveRelationship() unlinks both from either end. Without circular
+// relationships the graph must stay a forest: nodes that are already connected cannot be linked.
+func NewUndirectedGraph(name string, duplicateKeys, circularRelationship bool) (*Graph, error) {
+	return New(name, WithDuplicateKeys(duplicateKeys), WithCircularRelationships(circularRelationship), WithUndirected())
+}
 
-	return g, nil
+// Undirected reports whether the graph's relationships have no direction. See NewUndirectedGraph().
+func (g *Graph) Undirected() bool {
+	return g.undirected
 }
 
 // Root is a simple accessor function to get to the initial root node
 func (g *Graph) Root() *Node {
-	return g.Nodes[0]
+	g.Lock()
+	defer g.Unlock()
+
+	n, _ := g.node(0)
+	return n
 }
 
 // NodeCount returns the number of nodes in the graph
 func (g *Graph) NodeCount() int {
 	g.Lock()
 	defer g.Unlock()
-	return len(g.Nodes)
+
+	g.loadAll()
+	return len(g.nodes)
 }
 
-// LastNodeID returns the id of the last inserted node
+// LastNodeID returns the highest ID given to a node. With the default allocator this is the
+// id of the last inserted node.
 func (g *Graph) LastNodeID() uint64 {
 	g.Lock()
-	g.Unlock()
+	defer g.Unlock()
 	return g.topNodeID
 }
 
 // InsertNode inserts an empty default node into the graph. See InsertDataNode().
+// InsertNode is not passed to validators, nor held to Config.MaxNodes. It returns nil on a read
+// only graph.
 func (g *Graph) InsertNode() *Node {
 	g.Lock()
-	g.Unlock()
+	defer g.Unlock()
 
-	n := g.insertNode()
+	if g.readOnly {
+		return nil
+	}
+	n := g.insertNode(0)
+	g.committed(Operation{Kind: OpInsertNode, NodeID: n.ID}, n)
 
 	return n
 }
 
 // InsertDataNode is an alternate constructor to InsertNode() allowing you to pass in a key and value
 func (g *Graph) InsertDataNode(key string, value []byte) (*Node, error) {
-	g.Lock()
-	g.Unlock()
+	return g.InsertKindNode("", key, value)
+}
 
-	if !g.duplicateKeys {
-		if _, ok := g.keys[key]; ok {
-			return nil, errors.New(ErrKeyExists)
-		}
-		g.keys[key] = true
+// InsertKindNode inserts a node of the given kind with a key and value. See SetSchema().
+func (g *Graph) InsertKindNode(kind, key string, value []byte) (*Node, error) {
+	return g.insertDataNode(Operation{Kind: OpInsertNode, NodeKind: kind, Key: key, Value: value})
+}
+
+// insertDataNode contains shared logic for the insert data node calls. op.NodeID and
+// op.ExternalID are set when the caller supplies them.
+func (g *Graph) insertDataNode(op Operation) (*Node, error) {
+	if err := g.lockValidated(func() []Operation { return []Operation{op} }); err != nil {
+		return nil, err
 	}
+	defer g.Unlock()
 
-	n := g.insertNode()
+	if err := g.checkMaxNodes(); err != nil {
+		return nil, err
+	}
+	return g.insert(op)
+}
 
-	n.Key = key
-	n.Value = value
-	return n, nil
+// insert is the logic for the insert data node calls without validation.
+// The caller must hold the graph lock.
+func (g *Graph) insert(op Operation) (*Node, error) {
+	if g.keyExists(op.Key) {
+		return nil, errors.New(ErrKeyExists)
+	}
+	if err := g.checkNewIDs(op); err != nil {
+		return nil, err
+	}
+
+	n := g.insertNode(op.NodeID)
+	op.NodeID = n.ID
+
+	n.Kind = op.NodeKind
+	n.Key = op.Key
+	n.Value = op.Value
+	n.expires = op.Expires
+	g.unindexKey("", n.ID)
+	g.indexKey(op.Key, n.ID)
+	if len(op.Properties) > 0 {
+		n.properties = make(map[string]Property, len(op.Properties))
+		for name, p := range op.Properties {
+			p := p.clone()
+			n.properties[name] = p
+			g.indexProperty(n.ID, name, nil, &p)
+		}
+	}
+	if op.ExternalID != "" {
+		n.externalID = op.ExternalID
+		g.externalIDs[op.ExternalID] = n.ID
+	}
+	g.search.index(n)
+	return n, g.committed(op, n)
 }
 
-// insertNode contains shared logic for the insert node calls
-func (g *Graph) insertNode() *Node {
+// insertNode contains shared logic for the insert node calls. An ID of 0 is chosen by the
+// graph's allocator. The caller must hold the graph lock.
+func (g *Graph) insertNode(ID uint64) *Node {
+	if ID == 0 {
+		ID = g.nextNodeID()
+	} else if g.allocator != nil {
+		g.allocator.Use(ID)
+	}
+	if ID > atomic.LoadUint64(&g.topNodeID) {
+		atomic.StoreUint64(&g.topNodeID, ID)
+	}
+
 	n := &Node{
-		ID:                   atomic.AddUint64(&g.topNodeID, 1),
+		ID:                   ID,
 		circularRelationship: g.circularRelationship,
+		graph:                g,
 	}
 
-	g.Nodes[n.ID] = n
+	g.nodes[n.ID] = n
+	g.indexKey("", n.ID)
 
 	return n
 }
 
 // DeleteNode removes a node and its relationships from the graph
 func (g *Graph) DeleteNode(node *Node) error {
-	g.Lock()
-	defer g.Unlock()
-
-	return g.deleteNodeByID(node.ID)
+	return g.DeleteNodeByID(node.ID)
 }
 
 // DeleteNodeByID removes a node (by its ID) and its relationships from the graph
 func (g *Graph) DeleteNodeByID(ID uint64) error {
-	g.Lock()
+	if err := g.lockValidated(func() []Operation { return []Operation{{Kind: OpDeleteNode, NodeID: ID}} }); err != nil {
+		return err
+	}
 	defer g.Unlock()
 
 	return g.deleteNodeByID(ID)
 }
 
-// deleteNodeByID is a helper function to keep logic DRY in the delete node endpoints
+// deleteNodeByID is a helper function to keep logic DRY in the delete node endpoints. The
+// node is removed from memory even if the store fails, and the first store error is returned.
 func (g *Graph) deleteNodeByID(ID uint64) error {
-	sourceIDs := extractIDs(g.Nodes[ID].sources)
-	destinationIDs := extractIDs(g.Nodes[ID].destinations)
-
-	for _, nodeID := range sourceIDs {
-		g.Nodes[nodeID].RemoveRelationship(g.Nodes[ID])
+	n, ok := g.node(ID)
+	if !ok {
+		return errors.New(ErrNodeNotFound)
 	}
-	for _, nodeID := range destinationIDs {
-		g.Nodes[ID].RemoveRelationship(g.Nodes[nodeID])
+	g.link(n)
+
+	// the relationships are removed in the same store update as the node
+	g.batching = true
+	var storeErr error
+	for _, src := range append([]*Node(nil), n.sources...) {
+		if err := src.removeRelationship(n); err != nil && storeErr == nil {
+			storeErr = err
+		}
+	}
+	// read after the sources are unlinked, which in an undirected graph unlinks every relationship
+	for _, dest := range append([]*Node(nil), n.destinations...) {
+		if err := n.removeRelationship(dest); err != nil && storeErr == nil {
+			storeErr = err
+		}
 	}
 
-	delete(g.Nodes, ID)
-
-	return nil
+	g.unindexLabels(n)
+	g.unindexProperties(n)
+	g.search.remove(ID)
+	g.unindexKey(n.Key, ID)
+	delete(g.externalIDs, n.externalID)
+	delete(g.nodes, ID)
+	if g.allocator != nil {
+		g.allocator.Release(ID)
+	}
+	g.committed(Operation{Kind: OpDeleteNode, NodeID: ID}, nil)
+	g.batching = false
+	if err := g.write(g.unwritten...); err != nil && storeErr == nil {
+		storeErr = err
+	}
+	g.unwritten = nil
+	return storeErr
 }
 
-// FindRoots finds all nodes that do not have a source nodes below them
-func (g *Graph) FindRoots() []uint64 {
+// FindRoots finds all nodes that do not have a source nodes below them.
+// When labels are given, only nodes carrying all of the labels are considered,
+// giving the roots of the labeled sub graph.
+func (g *Graph) FindRoots(labels ...string) []uint64 {
 	g.Lock()
 	defer g.Unlock()
+
+	g.loadAll()
 	var roots []uint64
-	for _, node := range g.Nodes {
+	for _, node := range g.nodes {
 		node.Lock()
-		if len(node.sources) == 0 {
+		if node.hasLabels(labels) && !anyHasLabels(node.sources, labels) {
 			roots = append(roots, node.ID)
 		}
 		node.Unlock()
@@ -158,34 +310,112 @@ func (g *Graph) FindRoots() []uint64 {
 	return roots
 }
 
-// FindNodeIDByKey returns the first node's ID with a matching key
+// FindNodeIDByKey returns the lowest ID of the nodes with a matching key
 func (g *Graph) FindNodeIDByKey(key string) (uint64, bool) {
-	for id, node := range g.Nodes {
-		if node.Key == key {
-			return id, true
-		}
-	}
+	g.Lock()
+	defer g.Unlock()
 
-	return 0, false
+	return g.findNodeIDByKey(key)
 }
 
-// FindNodeByKey returns the the first node with a matching key
+// FindNodeByKey returns the node with the lowest ID and a matching key
 func (g *Graph) FindNodeByKey(key string) (*Node, bool) {
-	id, found := g.FindNodeIDByKey(key)
+	g.Lock()
+	defer g.Unlock()
+
+	id, found := g.findNodeIDByKey(key)
 	if !found {
 		return nil, false
 	}
 
-	return g.Nodes[id], true
+	return g.node(id)
+}
+
+// FindNodesByKey returns all nodes with a matching key, ordered by ID
+func (g *Graph) FindNodesByKey(key string) []*Node {
+	g.Lock()
+	defer g.Unlock()
+
+	ids := g.nodeIDsByKey(key)
+	nodes := make([]*Node, 0, len(ids))
+	for _, id := range ids {
+		if n, ok := g.node(id); ok {
+			nodes = append(nodes, n)
+		}
+	}
+	return nodes
+}
+
+// NodeIDsByKey returns the IDs of all nodes with a matching key in ascending order
+func (g *Graph) NodeIDsByKey(key string) []uint64 {
+	g.Lock()
+	defer g.Unlock()
+
+	return g.nodeIDsByKey(key)
+}
+
+// findNodeIDByKey is the logic for the find by key calls. The caller must hold the graph lock.
+func (g *Graph) findNodeIDByKey(key string) (uint64, bool) {
+	ids := g.nodeIDsByKey(key)
+	if len(ids) == 0 {
+		return 0, false
+	}
+
+	return ids[0], true
+}
+
+// nodeIDsByKey reads the key index, or the store's while the graph is partly loaded. The caller
+// must hold the graph lock.
+func (g *Graph) nodeIDsByKey(key string) []uint64 {
+	if g.partial {
+		IDs, err := g.store.NodeIDsByKey(key)
+		g.storeFailed(err)
+		return IDs
+	}
+	return sortedIDs(g.keys[key])
+}
+
+// keyExists reports whether inserting the key would break the duplicate keys constraint.
+// Nodes without a key never collide. The caller must hold the graph lock.
+func (g *Graph) keyExists(key string) bool {
+	return !g.duplicateKeys && key != "" && len(g.nodeIDsByKey(key)) > 0
+}
+
+// indexKey records the node in the key index. The caller must hold the graph lock.
+func (g *Graph) indexKey(key string, ID uint64) {
+	if g.keys[key] == nil {
+		g.keys[key] = make(map[uint64]bool)
+	}
+	g.keys[key][ID] = true
+}
+
+// unindexKey removes the node from the key index. The caller must hold the graph lock.
+func (g *Graph) unindexKey(key string, ID uint64) {
+	delete(g.keys[key], ID)
+	if len(g.keys[key]) == 0 {
+		delete(g.keys, key)
+	}
+}
+
+// jsString quotes a string for use in generated Javascript, escaping quotes and HTML
+func jsString(s string) string {
+	quoted, _ := json.Marshal(s)
+	return string(quoted)
 }
 
 // ToVisJS generates a simple HTML/Javascript view of the data
 // See http://visjs.org/ for information and styles
-func (g *Graph) ToVisJS(showID, showKey, showValue bool) string {
+// When labels are given, only nodes carrying all of the labels are shown.
+func (g *Graph) ToVisJS(showID, showKey, showValue bool, labels ...string) string {
 	dataSet := ""
 	edges := ""
 
-	for id, node := range g.Nodes {
+	for _, node := range g.sortedNodes() {
+		id := node.ID
+		if !node.hasLabels(labels) {
+			continue
+		}
+
 		label := ""
 		if showID {
 			label += fmt.Sprintf("node %d ", id)
@@ -197,9 +427,19 @@ func (g *Graph) ToVisJS(showID, showKey, showValue bool) string {
 			label += fmt.Sprintf("%s ", string(node.Value))
 		}
 
-		dataSet += fmt.Sprintf(`{id: %d, label: '%s'},`, id, label)
-		for _, nID := range extractIDs(node.ListDestinations()) {
-			edges += fmt.Sprintf(`{from: %d, to: %d, arrows:'middle',},`, id, nID)
+		dataSet += fmt.Sprintf(`{id: %d, label: %s%s},`, id, jsString(label), g.visKindStyle(node.Kind))
+		for _, dest := range node.ListDestinations() {
+			if !dest.hasLabels(labels) {
+				continue
+			}
+			if g.undirected {
+				// each relationship is listed from both ends
+				if dest.ID >= id {
+					edges += fmt.Sprintf(`{from: %d, to: %d,},`, id, dest.ID)
+				}
+				continue
+			}
+			edges += fmt.Sprintf(`{from: %d, to: %d, arrows:'middle',},`, id, dest.ID)
 		}
 	}
 
@@ -20,14 +20,14 @@ func main() {
 }
 
 func curriculumGraph() {
-	g, _ := giraffe.NewConstraintGraph("math curriculum", true, true)
+	g, _ := giraffe.New("math curriculum")
 
-	root := g.Root() // same as g.Nodes[0]
+	root := g.Root() // same as g.Node(0)
 
 	// this will be the entry point into the curriculum
 	// Keys will be lesson titles, Values will be a foreign key into a relational DB (or something)
-	root.Key = "Intro"
-	root.Value = []byte("lesson_id 1")
+	root.SetKey("Intro")
+	root.SetValue([]byte("lesson_id 1"))
 
 	// this will be the culmination of this curriculum
 	QF, _ := g.InsertDataNode("Quadratic Formula", []byte("lesson_id 55"))
@@ -76,7 +76,7 @@ func randomGraph() {
 	g, _ := giraffe.NewGraph("example")
 
 	// we automatically have access to the root node
-	root := g.Root() // same as g.Nodes[0]
+	root := g.Root() // same as g.Node(0)
 
 	// let's make a bunch of new nodes
 	nodeCount := 50
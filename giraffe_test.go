@@ -1,6 +1,11 @@
 package giraffe
 
-import "testing"
+import (
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
 
 func TestRootNodeCreate(t *testing.T) {
 	g, err := NewGraph("testGraph")
@@ -119,6 +124,51 @@ func TestDuplicateKeyError(t *testing.T) {
 	if err.Error() != ErrKeyExists {
 		t.Errorf("unexpected error message. got `%s`, want `%s`", err.Error(), ErrKeyExists)
 	}
+
+	// nodes without a key, like the root, are not duplicates of each other. Before the key index
+	// the second of these inserts failed with ErrKeyExists.
+	for i := 0; i < 2; i++ {
+		if _, err := g.InsertDataNode("", nil); err != nil {
+			t.Errorf("got `%v` inserting a node without a key", err)
+		}
+	}
+}
+
+func TestFindNodesByKeyWithDuplicates(t *testing.T) {
+	g, _ := NewGraph("testGraph")
+	g.InsertDataNode("other", nil)
+	n2, _ := g.InsertDataNode("shared", []byte("first"))
+	n3, _ := g.InsertDataNode("shared", []byte("second"))
+	n4, _ := g.InsertDataNode("shared", []byte("third"))
+
+	for i := 0; i < 10; i++ {
+		if id, ok := g.FindNodeIDByKey("shared"); !ok || id != n2.ID {
+			t.Fatalf("got id %d, want the lowest id %d", id, n2.ID)
+		}
+	}
+
+	if got, want := extractIDs(g.FindNodesByKey("shared")), []uint64{n2.ID, n3.ID, n4.ID}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	g.DeleteNode(n2)
+	n4.SetKey("moved")
+	if got, want := g.NodeIDsByKey("shared"), []uint64{n3.ID}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := g.NodeIDsByKey("moved"), []uint64{n4.ID}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestDeletedKeyCanBeReused(t *testing.T) {
+	g, _ := NewConstraintGraph("testGraph", false, true)
+	n1, _ := g.InsertDataNode("key", nil)
+	g.DeleteNode(n1)
+
+	if _, err := g.InsertDataNode("key", nil); err != nil {
+		t.Errorf("expected no error, got `%v`", err)
+	}
 }
 
 func TestCircularRelationship(t *testing.T) {
@@ -147,22 +197,79 @@ func TestCircularRelationship(t *testing.T) {
 	}
 }
 
+func TestUndirectedGraph(t *testing.T) {
+	g, _ := NewUndirectedGraph("testGraph", true, false)
+	n1, _ := g.InsertDataNode("Factoring", nil)
+	n2, _ := g.InsertDataNode("Quadratics", nil)
+	n3, _ := g.InsertDataNode("Graphing", nil)
+	if !g.Undirected() {
+		t.Error("graph should be undirected")
+	}
+
+	n1.AddRelationship(n2)
+	n3.AddRelationship(n2)
+	for _, n := range []*Node{n1, n3} {
+		if got := extractIDs(n.ListDestinations()); !reflect.DeepEqual(got, []uint64{n2.ID}) {
+			t.Errorf("node %d: got destinations %v, want [%d]", n.ID, got, n2.ID)
+		}
+	}
+	if got := extractIDs(n2.ListDestinations()); !reflect.DeepEqual(got, []uint64{n1.ID, n3.ID}) {
+		t.Errorf("got destinations %v, want both neighbours", got)
+	}
+	if !n1.DepthFirstSearch(n3) || !n3.BreadthFirstSearch(n1) {
+		t.Error("unable to find a path across undirected relationships")
+	}
+	if got := slices.Collect(g.Edges()); !reflect.DeepEqual(got, []Edge{{1, 2}, {2, 3}}) {
+		t.Errorf("got edges %v, want each relationship once", got)
+	}
+
+	// the graph must stay a forest
+	if err := n1.AddRelationship(n3); err == nil || err.Error() != ErrCircular {
+		t.Errorf("got `%v`, want `%s`", err, ErrCircular)
+	}
+	if err := n1.AddRelationship(n1); err == nil || err.Error() != ErrCircular {
+		t.Errorf("got `%v`, want `%s`", err, ErrCircular)
+	}
+
+	js := g.ToVisJS(true, false, false)
+	if strings.Contains(js, "arrows") || strings.Count(js, "{from:") != 2 {
+		t.Errorf("want edges drawn once without arrows, got\n%s", js)
+	}
+
+	// removing from either end unlinks both
+	c := copyGraph(t, g)
+	c2, _ := c.Node(n2.ID)
+	c3, _ := c.Node(n3.ID)
+	c2.RemoveRelationship(c3)
+	if len(c3.ListDestinations()) != 0 || len(c3.ListSources()) != 0 || len(c2.ListDestinations()) != 1 {
+		t.Errorf("got %v and %v, want the relationship gone from both ends", extractIDs(c3.ListDestinations()), extractIDs(c2.ListDestinations()))
+	}
+	if err := c2.AddRelationship(c3); err != nil || !c.Undirected() {
+		t.Errorf("decoded graph should stay undirected, got `%v`", err)
+	}
+
+	g.DeleteNode(n2)
+	if len(n1.ListDestinations()) != 0 || len(n1.ListSources()) != 0 {
+		t.Error("deleted node is still linked")
+	}
+}
+
 func TestSearch(t *testing.T) {
 	g, _ := newTestGraph()
 
-	if !g.Root().DepthFirstSearch(g.Nodes[11]) {
+	if !g.Root().DepthFirstSearch(g.nodes[11]) {
 		t.Error("unable to find path root -> n11")
 	}
 
-	if g.Root().DepthFirstSearch(g.Nodes[12]) {
+	if g.Root().DepthFirstSearch(g.nodes[12]) {
 		t.Error("should not find a path root -> n12")
 	}
 
-	if !g.Root().BreadthFirstSearch(g.Nodes[9]) {
+	if !g.Root().BreadthFirstSearch(g.nodes[9]) {
 		t.Error("unable to find path root -> n9")
 	}
 
-	if g.Root().BreadthFirstSearch(g.Nodes[12]) {
+	if g.Root().BreadthFirstSearch(g.nodes[12]) {
 		t.Error("should not find a path root -> n12")
 	}
 }
@@ -171,13 +278,13 @@ func TestRemoveRelationship(t *testing.T) {
 	g, _ := newTestGraph()
 
 	// cause nodes 9 and 10 (and 11) to be cut off (because 2 does not touch 6)
-	err := g.Nodes[2].RemoveRelationship(g.Nodes[6])
+	err := g.nodes[2].RemoveRelationship(g.nodes[6])
 	if err != nil {
 		t.Fatalf("RemoveRelationship should not error, got `%v`", err)
 	}
 
 	for i := uint64(9); i <= 11; i++ {
-		if g.Nodes[2].DepthFirstSearch(g.Nodes[i]) {
+		if g.nodes[2].DepthFirstSearch(g.nodes[i]) {
 			t.Errorf("found node %d but should not have", i)
 		}
 	}
@@ -187,18 +294,18 @@ func TestDeleteNode(t *testing.T) {
 	g, _ := newTestGraph()
 
 	// cause nodes 9 and 10 (and 11) to be cut off (because 6 is gone)
-	err := g.DeleteNode(g.Nodes[6])
+	err := g.DeleteNode(g.nodes[6])
 	if err != nil {
 		t.Fatalf("RemoveRelationship should not error, got `%v`", err)
 	}
 
 	for i := uint64(9); i <= 11; i++ {
-		if g.Nodes[2].DepthFirstSearch(g.Nodes[i]) {
+		if g.nodes[2].DepthFirstSearch(g.nodes[i]) {
 			t.Errorf("found node %d but should not have", i)
 		}
 	}
 
-	if _, ok := g.Nodes[6]; ok {
+	if _, ok := g.nodes[6]; ok {
 		t.Error("node 6 found but should be deleted")
 	}
 }
@@ -213,12 +320,12 @@ func TestDeleteNodeByID(t *testing.T) {
 	}
 
 	for i := uint64(9); i <= 11; i++ {
-		if g.Nodes[2].DepthFirstSearch(g.Nodes[i]) {
+		if g.nodes[2].DepthFirstSearch(g.nodes[i]) {
 			t.Errorf("found node %d but should not have", i)
 		}
 	}
 
-	if _, ok := g.Nodes[6]; ok {
+	if _, ok := g.nodes[6]; ok {
 		t.Error("node 6 found but should be deleted")
 	}
 }
@@ -236,8 +343,8 @@ func newTestGraph() (*Graph, error) {
 	n9 := g.InsertNode()
 	n10 := g.InsertNode()
 	n11 := g.InsertNode()
-	n12 := g.insertNode() // stranded
-	_ = n12               // do nothing with it
+	n12 := g.insertNode(0) // stranded
+	_ = n12                // do nothing with it
 
 	g.Root().AddRelationship(n1)
 	g.Root().AddRelationship(n2)
@@ -0,0 +1,378 @@
+package giraffe
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// History error constants
+const (
+	// ErrNoHistory returns when asking for history from a graph without EnableHistory()
+	ErrNoHistory = "history not enabled"
+)
+
+// Revision is a node's key and value as of a committed change
+type Revision struct {
+	// Seq is the change's sequence number. See Graph.Seq(). Time is zero for the revisions
+	// recorded when history was enabled.
+	Seq  uint64
+	Time time.Time
+	Kind OpKind
+
+	Key     string
+	Value   []byte
+	Deleted bool
+}
+
+// historyMetaKey is the Store meta entry holding the history as it was when it was enabled. The
+// changes recorded since are kept in entries numbered from 0. see historyEntryKey()
+const historyMetaKey = "history"
+
+// historyEntryKey returns the name of the Store meta entry holding the i-th recorded change
+func historyEntryKey(i int) string {
+	return fmt.Sprintf("%s.%d", historyMetaKey, i)
+}
+
+// edgeSpan is the time a relationship existed. Removed is zero while it still exists.
+type edgeSpan struct {
+	ID             uint64
+	Edge           Edge
+	Added, Removed time.Time
+}
+
+// historyState is the saved form of a history
+type historyState struct {
+	Revisions map[uint64][]Revision
+	Spans     []edgeSpan
+}
+
+// historyEntry is a change as recorded in the history
+type historyEntry struct {
+	Entry LogEntry
+	Time  time.Time
+}
+
+// history records the revisions of a graph's nodes and relationships
+type history struct {
+	sync.Mutex
+	graph     *Graph
+	now       func() time.Time
+	revisions map[uint64][]Revision
+	// spans lists relationships in the order they were added
+	spans []edgeSpan
+	// open holds the indexes in spans of the relationships between each pair of nodes that
+	// still exist
+	open map[Edge][]int
+	// saved is the number of changes written to the graph's store, and unsaved holds the
+	// changes still to be written with the next one
+	saved   int
+	unsaved []historyEntry
+}
+
+// newHistory creates an empty history for a graph
+func newHistory(g *Graph) *history {
+	return &history{
+		graph:     g,
+		now:       time.Now,
+		revisions: make(map[uint64][]Revision),
+		open:      make(map[Edge][]int),
+	}
+}
+
+// EnableHistory starts recording a revision of a node's key and value whenever it changes, and
+// when each relationship is added and removed, stamped with the time of the change. The current
+// nodes and relationships are recorded as of now. History is saved with the graph by GobEncode()
+// and, in a graph with a store, written through to the store and restored by OpenGraph().
+// See AsOf() and History().
+func (g *Graph) EnableHistory() error {
+	g.Lock()
+	defer g.Unlock()
+
+	return g.enableHistory()
+}
+
+// enableHistory is the logic for EnableHistory(). The caller must hold the graph lock.
+func (g *Graph) enableHistory() error {
+	if g.history != nil {
+		return nil
+	}
+	if err := g.loadAll(); err != nil {
+		return err
+	}
+	h := newHistory(g)
+	// the graph as it is now stands for every time before history was enabled
+	var before time.Time
+	nodes := make([]*Node, 0, len(g.nodes))
+	for _, n := range g.nodes {
+		nodes = append(nodes, n)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID < nodes[j].ID })
+	for _, n := range nodes {
+		n.RLock()
+		h.revisions[n.ID] = []Revision{{Seq: g.seq, Time: before, Kind: OpInsertNode, Key: n.Key, Value: n.Value}}
+		for i, dest := range n.destinations {
+			if !g.undirected || dest.ID >= n.ID {
+				h.addEdge(n.edgeIDs[i], g.edgeKey(n.ID, dest.ID), before)
+			}
+		}
+		n.RUnlock()
+	}
+	if g.store != nil {
+		data, err := gobBytes(h.state())
+		if err != nil {
+			return err
+		}
+		if err := g.store.SetMeta(historyMetaKey, data); err != nil {
+			return err
+		}
+	}
+	g.history = h
+	g.listen(h.record)
+	return nil
+}
+
+// loadHistory restores the history saved in a store, if any. The caller must hold the graph lock.
+func (g *Graph) loadHistory(store Store) error {
+	data, err := store.Meta(historyMetaKey)
+	if err != nil || data == nil {
+		return err
+	}
+	var state historyState
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&state); err != nil {
+		return err
+	}
+	h := newHistory(g)
+	h.restore(state)
+	for {
+		data, err := store.Meta(historyEntryKey(h.saved))
+		if err != nil {
+			return err
+		}
+		if data == nil {
+			break
+		}
+		var entry historyEntry
+		if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&entry); err != nil {
+			return err
+		}
+		h.add(entry.Entry, entry.Time)
+		h.saved++
+	}
+	g.history = h
+	g.listen(h.record)
+	return nil
+}
+
+// History returns a node's revisions, oldest first. A deleted node's last revision is marked
+// Deleted. When an allocator gives a deleted node's ID to a new node, the new node's revisions
+// follow the deleted one's.
+func (g *Graph) History(ID uint64) ([]Revision, error) {
+	h, err := g.historyOf()
+	if err != nil {
+		return nil, err
+	}
+	h.Lock()
+	defer h.Unlock()
+
+	return append([]Revision(nil), h.revisions[ID]...), nil
+}
+
+// AsOf returns a read only view of the graph as it was at a time: the nodes that existed with
+// their keys and values then, and the relationships between them. Times before history was
+// enabled see the graph as it was when it was enabled, less the nodes inserted since.
+func (g *Graph) AsOf(t time.Time) (*FrozenGraph, error) {
+	h, err := g.historyOf()
+	if err != nil {
+		return nil, err
+	}
+
+	view, _ := NewGraph(g.Name)
+	view.undirected = g.undirected
+	h.Lock()
+	IDs := make([]uint64, 0, len(h.revisions))
+	for ID := range h.revisions {
+		IDs = append(IDs, ID)
+	}
+	sort.Slice(IDs, func(i, j int) bool { return IDs[i] < IDs[j] })
+	for _, ID := range IDs {
+		revisions := h.revisions[ID]
+		i := sort.Search(len(revisions), func(i int) bool { return revisions[i].Time.After(t) })
+		if i == 0 || revisions[i-1].Deleted {
+			continue
+		}
+		rev := revisions[i-1]
+		if ID == 0 {
+			view.Root().SetKey(rev.Key)
+			view.Root().SetValue(rev.Value)
+			continue
+		}
+		view.InsertNodeWithID(ID, rev.Key, rev.Value)
+	}
+
+	var edges []Edge
+	for _, span := range h.spans {
+		if !span.Added.After(t) && (span.Removed.IsZero() || span.Removed.After(t)) {
+			edges = append(edges, span.Edge)
+		}
+	}
+	h.Unlock()
+
+	for _, e := range edges {
+		from, ok := view.Node(e.From)
+		to, ok2 := view.Node(e.To)
+		if ok && ok2 {
+			from.AddRelationship(to)
+		}
+	}
+	return view.Freeze(), nil
+}
+
+// historyOf returns the graph's history
+func (g *Graph) historyOf() (*history, error) {
+	g.Lock()
+	defer g.Unlock()
+
+	if g.history == nil {
+		return nil, errors.New(ErrNoHistory)
+	}
+	return g.history, nil
+}
+
+// record adds a committed change to the history. In a graph with a store the change is written
+// with the mutation by write(). It is a listener, so the graph is locked.
+func (h *history) record(e LogEntry) {
+	h.Lock()
+	defer h.Unlock()
+
+	now := h.now()
+	h.add(e, now)
+	if h.graph.store != nil {
+		h.unsaved = append(h.unsaved, historyEntry{Entry: e, Time: now})
+	}
+}
+
+// write adds the changes that are not yet in the store to a batch and returns how many it added
+func (h *history) write(b StoreBatch) (int, error) {
+	h.Lock()
+	defer h.Unlock()
+
+	for i, entry := range h.unsaved {
+		data, err := gobBytes(entry)
+		if err != nil {
+			return 0, err
+		}
+		if err := b.SetMeta(historyEntryKey(h.saved+i), data); err != nil {
+			return 0, err
+		}
+	}
+	return len(h.unsaved), nil
+}
+
+// written records that the first n unsaved changes are in the store
+func (h *history) written(n int) {
+	h.Lock()
+	defer h.Unlock()
+
+	h.saved += n
+	h.unsaved = h.unsaved[n:]
+}
+
+// add adds a change made at a time to the history. The caller must hold the history's lock.
+func (h *history) add(e LogEntry, now time.Time) {
+	op := e.Op
+	rev := Revision{Seq: e.Seq, Time: now, Kind: op.Kind}
+	var last Revision
+	if revisions := h.revisions[op.NodeID]; len(revisions) > 0 {
+		last = revisions[len(revisions)-1]
+	}
+
+	switch op.Kind {
+	case OpInsertNode:
+		rev.Key, rev.Value = op.Key, op.Value
+	case OpSetKey:
+		// set key operations carry the node's value
+		rev.Key, rev.Value = op.Key, op.Value
+	case OpSetValue:
+		rev.Key, rev.Value = last.Key, op.Value
+	case OpDeleteNode:
+		rev.Key, rev.Value, rev.Deleted = last.Key, last.Value, true
+		// the relationships were removed first, but end any left open so a node given the
+		// ID later does not inherit them
+		for e, open := range h.open {
+			if e.From == op.NodeID || e.To == op.NodeID {
+				for _, i := range open {
+					h.spans[i].Removed = now
+				}
+				delete(h.open, e)
+			}
+		}
+	case OpAddRelationship:
+		h.addEdge(op.EdgeID, h.graph.edgeKey(op.NodeID, op.OtherID), now)
+		return
+	case OpRemoveRelationship:
+		// without an ID every relationship between the nodes was removed
+		e := h.graph.edgeKey(op.NodeID, op.OtherID)
+		var open []int
+		for _, i := range h.open[e] {
+			if op.EdgeID != 0 && h.spans[i].ID != op.EdgeID {
+				open = append(open, i)
+				continue
+			}
+			h.spans[i].Removed = now
+		}
+		if len(open) == 0 {
+			delete(h.open, e)
+		} else {
+			h.open[e] = open
+		}
+		return
+	default:
+		return
+	}
+	h.revisions[op.NodeID] = append(h.revisions[op.NodeID], rev)
+}
+
+// addEdge opens a span for a relationship
+func (h *history) addEdge(ID uint64, e Edge, now time.Time) {
+	h.open[e] = append(h.open[e], len(h.spans))
+	h.spans = append(h.spans, edgeSpan{ID: ID, Edge: e, Added: now})
+}
+
+// state copies the history into its saved form. The caller must hold the history's lock.
+func (h *history) state() historyState {
+	state := historyState{
+		Revisions: make(map[uint64][]Revision, len(h.revisions)),
+		Spans:     append([]edgeSpan(nil), h.spans...),
+	}
+	for ID, revisions := range h.revisions {
+		state.Revisions[ID] = append([]Revision(nil), revisions...)
+	}
+	return state
+}
+
+// restore replaces the history with a saved one. The caller must hold the history's lock.
+func (h *history) restore(state historyState) {
+	h.revisions = state.Revisions
+	if h.revisions == nil {
+		h.revisions = make(map[uint64][]Revision)
+	}
+	h.spans = state.Spans
+	h.open = make(map[Edge][]int)
+	for i, span := range h.spans {
+		if span.Removed.IsZero() {
+			h.open[span.Edge] = append(h.open[span.Edge], i)
+		}
+	}
+}
+
+// gobBytes gob encodes a value
+func gobBytes(v any) ([]byte, error) {
+	w := new(bytes.Buffer)
+	err := gob.NewEncoder(w).Encode(v)
+	return w.Bytes(), err
+}
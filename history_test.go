@@ -0,0 +1,229 @@
+package giraffe
+
+import (
+	"bytes"
+	"encoding/gob"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testClock is a clock that moves forward a second every time it is read
+type testClock struct {
+	t time.Time
+}
+
+func (c *testClock) now() time.Time {
+	c.t = c.t.Add(time.Second)
+	return c.t
+}
+
+func TestHistory(t *testing.T) {
+	g, _ := NewGraph("testGraph")
+	n1, _ := g.InsertDataNode("Intro", []byte("lesson_id 1"))
+	g.Root().AddRelationship(n1)
+
+	if _, err := g.History(1); err == nil || err.Error() != ErrNoHistory {
+		t.Errorf("got `%v`, want `%s`", err, ErrNoHistory)
+	}
+	g.EnableHistory()
+	clock := &testClock{t: time.Now()}
+	g.history.now = clock.now
+	enabled := clock.t
+
+	// last semester
+	n2, _ := g.InsertDataNode("Factoring", []byte("lesson_id 12"))
+	n1.AddRelationship(n2)
+	n2.SetValue([]byte("lesson_id 13"))
+	lastSemester := clock.t
+
+	// this semester
+	n3, _ := g.InsertDataNode("Graphing", []byte("lesson_id 30"))
+	n1.RemoveRelationship(n2)
+	n3.AddRelationship(n2)
+	n2.SetKey("Factoring II")
+	n1.SetValue([]byte("lesson_id 2"))
+	g.DeleteNode(n1)
+
+	revisions, err := g.History(n2.ID)
+	if err != nil {
+		t.Fatalf("unable to get history, error: %v", err)
+	}
+	var got []string
+	for _, rev := range revisions {
+		got = append(got, rev.Key+"="+string(rev.Value))
+	}
+	if want := []string{"Factoring=lesson_id 12", "Factoring=lesson_id 13", "Factoring II=lesson_id 13"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got revisions %v, want %v", got, want)
+	}
+	if revisions[1].Seq <= revisions[0].Seq || !revisions[1].Time.After(revisions[0].Time) {
+		t.Errorf("revisions out of order: %+v", revisions)
+	}
+	if revisions, _ := g.History(n1.ID); len(revisions) != 3 || !revisions[2].Deleted {
+		t.Errorf("got %+v, want a deleted last revision", revisions)
+	}
+
+	// the prerequisites of Factoring last semester
+	view, err := g.AsOf(lastSemester)
+	if err != nil {
+		t.Fatalf("unable to get view, error: %v", err)
+	}
+	if got := view.ListSources(n2.ID); !reflect.DeepEqual(got, []uint64{n1.ID}) {
+		t.Errorf("got sources %v, want %v", got, []uint64{n1.ID})
+	}
+	if value, _ := view.Value(n2.ID); string(value) != "lesson_id 13" {
+		t.Errorf("got value %q, want lesson_id 13", value)
+	}
+	if view.HasNode(n3.ID) || view.NodeCount() != 3 {
+		t.Errorf("got %d nodes, want 3", view.NodeCount())
+	}
+
+	// as it was when history started
+	view, _ = g.AsOf(enabled)
+	if view.NodeCount() != 2 || !reflect.DeepEqual(view.ListDestinations(0), []uint64{n1.ID}) {
+		t.Errorf("got %d nodes and root destinations %v", view.NodeCount(), view.ListDestinations(0))
+	}
+
+	// before history started, which is the same
+	view, _ = g.AsOf(enabled.Add(-time.Hour))
+	if view.NodeCount() != 2 || !reflect.DeepEqual(view.ListDestinations(0), []uint64{n1.ID}) {
+		t.Errorf("got %d nodes and root destinations %v", view.NodeCount(), view.ListDestinations(0))
+	}
+
+	// now
+	view, _ = g.AsOf(clock.t)
+	if view.HasNode(n1.ID) || !reflect.DeepEqual(view.ListSources(n2.ID), []uint64{n3.ID}) {
+		t.Errorf("got sources %v, want %v", view.ListSources(n2.ID), []uint64{n3.ID})
+	}
+	if key, _ := view.Key(n2.ID); key != "Factoring II" {
+		t.Errorf("got key %q, want Factoring II", key)
+	}
+}
+
+func TestHistoryParallelRelationships(t *testing.T) {
+	g, _ := NewGraph("testGraph")
+	n1, _ := g.InsertDataNode("Intro", nil)
+	n2, _ := g.InsertDataNode("Factoring", nil)
+	g.EnableHistory()
+	clock := &testClock{t: time.Now()}
+	g.history.now = clock.now
+
+	first, _ := n1.AddRelationshipID(n2)
+	n1.AddRelationshipID(n2)
+	both := clock.t
+	g.RemoveRelationshipByID(first)
+	one := clock.t
+
+	view, _ := g.AsOf(both)
+	if got := view.ListDestinations(n1.ID); !reflect.DeepEqual(got, []uint64{n2.ID, n2.ID}) {
+		t.Errorf("got destinations %v, want both relationships", got)
+	}
+	// the remaining relationship is still there
+	view, _ = g.AsOf(one)
+	if got := view.ListDestinations(n1.ID); !reflect.DeepEqual(got, []uint64{n2.ID}) {
+		t.Errorf("got destinations %v, want one relationship", got)
+	}
+
+	n1.RemoveRelationship(n2)
+	view, _ = g.AsOf(clock.t)
+	if got := view.ListDestinations(n1.ID); len(got) != 0 {
+		t.Errorf("got destinations %v, want none", got)
+	}
+}
+
+func TestHistorySaved(t *testing.T) {
+	store := NewMemoryStore()
+	g, err := New("testGraph", WithStore(store), WithHistory())
+	if err != nil {
+		t.Fatalf("unable to create graph, error: %v", err)
+	}
+	clock := &testClock{t: time.Date(2024, 9, 1, 0, 0, 0, 0, time.UTC)}
+	g.history.now = clock.now
+
+	n1, _ := g.InsertDataNode("Intro", []byte("lesson_id 1"))
+	n2, _ := g.InsertDataNode("Factoring", []byte("lesson_id 12"))
+	n1.AddRelationship(n2)
+	before := clock.t
+	n2.SetValue([]byte("lesson_id 13"))
+	n1.RemoveRelationship(n2)
+	want, _ := g.History(n2.ID)
+
+	w := new(bytes.Buffer)
+	if err := gob.NewEncoder(w).Encode(g); err != nil {
+		t.Fatalf("unable to encode, error: %v", err)
+	}
+	decoded := &Graph{}
+	if err := gob.NewDecoder(w).Decode(decoded); err != nil {
+		t.Fatalf("unable to decode, error: %v", err)
+	}
+	opened, err := OpenGraph(store)
+	if err != nil {
+		t.Fatalf("unable to open graph, error: %v", err)
+	}
+
+	for name, saved := range map[string]*Graph{"decoded": decoded, "opened": opened} {
+		got, err := saved.History(n2.ID)
+		if err != nil {
+			t.Fatalf("%s: unable to get history, error: %v", name, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got revisions %+v, want %+v", name, got, want)
+		}
+		view, _ := saved.AsOf(before)
+		if got := view.ListDestinations(n1.ID); !reflect.DeepEqual(got, []uint64{n2.ID}) {
+			t.Errorf("%s: got destinations %v, want %v", name, got, []uint64{n2.ID})
+		}
+		if value, _ := view.Value(n2.ID); string(value) != "lesson_id 12" {
+			t.Errorf("%s: got value %q, want lesson_id 12", name, value)
+		}
+	}
+
+	// changes after reopening are recorded and saved too
+	n, _ := opened.Node(n2.ID)
+	n.SetKey("Factoring II")
+	reopened, err := OpenGraph(store)
+	if err != nil {
+		t.Fatalf("unable to open graph, error: %v", err)
+	}
+	if revisions, _ := reopened.History(n2.ID); len(revisions) != len(want)+1 || revisions[len(want)].Key != "Factoring II" {
+		t.Errorf("got revisions %+v, want a Factoring II revision", revisions)
+	}
+}
+
+func TestHistoryReusedIDs(t *testing.T) {
+	g, _ := New("testGraph", WithIDAllocator(NewFreeListAllocator()), WithHistory())
+	clock := &testClock{t: time.Now()}
+	g.history.now = clock.now
+
+	n1, _ := g.InsertDataNode("Intro", nil)
+	n2, _ := g.InsertDataNode("Factoring", nil)
+	n1.AddRelationship(n2)
+	linked := clock.t
+	g.DeleteNode(n2)
+	n3, _ := g.InsertDataNode("Graphing", nil)
+	if n3.ID != n2.ID {
+		t.Fatalf("got ID %d, want the reused ID %d", n3.ID, n2.ID)
+	}
+
+	revisions, _ := g.History(n3.ID)
+	if len(revisions) != 3 || !revisions[1].Deleted || revisions[2].Key != "Graphing" {
+		t.Errorf("got revisions %+v, want the deleted node's followed by Graphing", revisions)
+	}
+	view, _ := g.AsOf(clock.t)
+	if got := view.ListDestinations(n1.ID); len(got) != 0 {
+		t.Errorf("got destinations %v, want none", got)
+	}
+	view, _ = g.AsOf(linked)
+	if key, _ := view.Key(n2.ID); key != "Factoring" || !reflect.DeepEqual(view.ListDestinations(n1.ID), []uint64{n2.ID}) {
+		t.Errorf("got key %q and destinations %v", key, view.ListDestinations(n1.ID))
+	}
+
+	// a delete ends relationships left open, such as from replayed operations
+	g.history.Lock()
+	g.history.addEdge(99, Edge{From: n1.ID, To: n3.ID}, clock.now())
+	g.history.Unlock()
+	g.DeleteNode(n3)
+	if len(g.history.open) != 0 {
+		t.Errorf("got open relationships %v, want none", g.history.open)
+	}
+}
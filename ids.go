@@ -0,0 +1,233 @@
+package giraffe
+
+import (
+	"errors"
+	"math"
+	"slices"
+	"sort"
+	"sync/atomic"
+)
+
+// ID error constants
+const (
+	// ErrIDExists returns when inserting a node with an ID or external ID that is already in use
+	ErrIDExists = "id exists"
+)
+
+// IDAllocator chooses the IDs of inserted nodes. See SetIDAllocator().
+// Methods are called with the graph lock held.
+type IDAllocator interface {
+	// Allocate returns an ID for a new node. top is the highest ID used so far.
+	// If the ID is already in use the graph falls back to top+1.
+	Allocate(top uint64) uint64
+
+	// Use is called when a node is inserted with an ID supplied by the caller
+	Use(ID uint64)
+
+	// Release is called with the ID of a deleted node
+	Release(ID uint64)
+}
+
+// MonotonicAllocator gives every node the next ID after the highest used so far, so IDs are
+// never reused. It is the default.
+type MonotonicAllocator struct{}
+
+// Allocate satisfies the IDAllocator interface
+func (MonotonicAllocator) Allocate(top uint64) uint64 {
+	return top + 1
+}
+
+// Use satisfies the IDAllocator interface
+func (MonotonicAllocator) Use(ID uint64) {}
+
+// Release satisfies the IDAllocator interface
+func (MonotonicAllocator) Release(ID uint64) {}
+
+// RangeReleaser is implemented by allocators that can be given a run of IDs at once.
+// SetIDAllocator() releases the unused IDs below the highest ID with ReleaseRange() when the
+// allocator has it, and one at a time with Release() when it does not.
+type RangeReleaser interface {
+	// ReleaseRange releases the IDs from first to last, inclusive
+	ReleaseRange(first, last uint64)
+}
+
+// FreeListAllocator reuses the IDs of deleted nodes, lowest first, before allocating new ones
+type FreeListAllocator struct {
+	// free holds the released IDs as sorted ranges that neither overlap nor touch
+	free []idRange
+}
+
+// idRange is a run of IDs from first to last, inclusive
+type idRange struct {
+	first, last uint64
+}
+
+// NewFreeListAllocator creates an allocator with an empty free list
+func NewFreeListAllocator() *FreeListAllocator {
+	return &FreeListAllocator{}
+}
+
+// Allocate satisfies the IDAllocator interface
+func (a *FreeListAllocator) Allocate(top uint64) uint64 {
+	if len(a.free) == 0 {
+		return top + 1
+	}
+	r := &a.free[0]
+	ID := r.first
+	if r.first == r.last {
+		a.free = a.free[1:]
+	} else {
+		r.first++
+	}
+	return ID
+}
+
+// Use satisfies the IDAllocator interface
+func (a *FreeListAllocator) Use(ID uint64) {
+	i := sort.Search(len(a.free), func(i int) bool { return a.free[i].last >= ID })
+	if i == len(a.free) || a.free[i].first > ID {
+		return
+	}
+	r := a.free[i]
+	switch {
+	case r.first == r.last:
+		a.free = slices.Delete(a.free, i, i+1)
+	case ID == r.first:
+		a.free[i].first++
+	case ID == r.last:
+		a.free[i].last--
+	default:
+		a.free[i].last = ID - 1
+		a.free = slices.Insert(a.free, i+1, idRange{first: ID + 1, last: r.last})
+	}
+}
+
+// Release satisfies the IDAllocator interface
+func (a *FreeListAllocator) Release(ID uint64) {
+	a.ReleaseRange(ID, ID)
+}
+
+// ReleaseRange satisfies the RangeReleaser interface
+func (a *FreeListAllocator) ReleaseRange(first, last uint64) {
+	// ranges before i end short of first, and ranges from j start past last, without touching
+	i := sort.Search(len(a.free), func(i int) bool { return a.free[i].last >= first || first-a.free[i].last == 1 })
+	j := i
+	for ; j < len(a.free) && (a.free[j].first <= last || a.free[j].first-last == 1); j++ {
+		first = min(first, a.free[j].first)
+		last = max(last, a.free[j].last)
+	}
+	a.free = slices.Replace(a.free, i, j, idRange{first: first, last: last})
+}
+
+// SetIDAllocator changes how the graph assigns IDs to inserted nodes. The IDs below the
+// highest ID used so far that do not belong to a node are released to the new allocator. See
+// RangeReleaser. The allocator is not saved with the graph.
+func (g *Graph) SetIDAllocator(a IDAllocator) {
+	g.Lock()
+	defer g.Unlock()
+
+	g.loadAll()
+	IDs := make([]uint64, 0, len(g.nodes))
+	for ID := range g.nodes {
+		IDs = append(IDs, ID)
+	}
+	slices.Sort(IDs)
+	// the root's ID 0 is always first
+	for i := 1; i < len(IDs); i++ {
+		first, last := IDs[i-1]+1, IDs[i]-1
+		if first > last {
+			continue
+		}
+		if r, ok := a.(RangeReleaser); ok {
+			r.ReleaseRange(first, last)
+			continue
+		}
+		for ID := first; ID <= last; ID++ {
+			a.Release(ID)
+		}
+	}
+	g.allocator = a
+}
+
+// InsertNodeWithID inserts a node with an ID chosen by the caller, such as when importing a graph
+// that has its own IDs. It fails with ErrIDExists if the ID is in use.
+func (g *Graph) InsertNodeWithID(ID uint64, key string, value []byte) (*Node, error) {
+	if ID == 0 {
+		// the root node's ID
+		return nil, errors.New(ErrIDExists)
+	}
+	return g.insertDataNode(Operation{Kind: OpInsertNode, NodeID: ID, Key: key, Value: value})
+}
+
+// InsertExternalNode inserts a node identified by an external string ID, such as a UUID or ULID.
+// The node is given an internal ID as usual and can be found with FindNodeByExternalID().
+// It fails with ErrIDExists if the external ID is in use.
+func (g *Graph) InsertExternalNode(externalID, key string, value []byte) (*Node, error) {
+	return g.insertDataNode(Operation{Kind: OpInsertNode, ExternalID: externalID, Key: key, Value: value})
+}
+
+// FindNodeByExternalID returns the node with the external ID
+func (g *Graph) FindNodeByExternalID(externalID string) (*Node, bool) {
+	g.Lock()
+	defer g.Unlock()
+
+	g.loadAll()
+	ID, ok := g.externalIDs[externalID]
+	if !ok {
+		return nil, false
+	}
+	return g.nodes[ID], true
+}
+
+// ExternalID returns the node's external ID, or "" if it was not inserted with one.
+// See InsertExternalNode().
+func (n *Node) ExternalID() string {
+	n.RLock()
+	defer n.RUnlock()
+
+	return n.externalID
+}
+
+// nextNodeID chooses the ID of a new node. The caller must hold the graph lock.
+func (g *Graph) nextNodeID() uint64 {
+	return g.nextNodeIDAfter(atomic.LoadUint64(&g.topNodeID))
+}
+
+// nextNodeIDAfter chooses the ID of a new node as if top were the highest ID used so far. The
+// caller must hold the graph lock.
+func (g *Graph) nextNodeIDAfter(top uint64) uint64 {
+	if g.allocator != nil {
+		ID := g.allocator.Allocate(top)
+		if _, ok := g.node(ID); !ok && ID != 0 {
+			return ID
+		}
+	}
+	if top < math.MaxUint64 {
+		return top + 1
+	}
+	// the highest ID is in use, so rather than wrap around to the root's ID, take the lowest
+	// free one
+	ID := uint64(1)
+	for _, ok := g.node(ID); ok; _, ok = g.node(ID) {
+		ID++
+	}
+	return ID
+}
+
+// checkNewIDs reports whether the IDs supplied for an insert are free. The caller must hold the
+// graph lock.
+func (g *Graph) checkNewIDs(op Operation) error {
+	if _, ok := g.node(op.NodeID); ok && op.NodeID != 0 {
+		return errors.New(ErrIDExists)
+	}
+	if op.ExternalID == "" {
+		return nil
+	}
+	if err := g.loadAll(); err != nil {
+		return err
+	}
+	if _, ok := g.externalIDs[op.ExternalID]; ok {
+		return errors.New(ErrIDExists)
+	}
+	return nil
+}
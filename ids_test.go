@@ -0,0 +1,138 @@
+package giraffe
+
+import (
+	"bytes"
+	"encoding/gob"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFreeListAllocator(t *testing.T) {
+	g, _ := NewGraph("testGraph")
+	for i := 0; i < 5; i++ {
+		g.InsertNode()
+	}
+	g.DeleteNodeByID(4)
+	g.DeleteNodeByID(2)
+
+	// holes left before the allocator was set are reused too
+	g.SetIDAllocator(NewFreeListAllocator())
+	if n := g.InsertNode(); n.ID != 2 {
+		t.Errorf("got id %d, want 2", n.ID)
+	}
+
+	g.DeleteNodeByID(1)
+	if _, err := g.InsertNodeWithID(4, "imported", nil); err != nil {
+		t.Fatalf("unable to insert with id, error: %v", err)
+	}
+	if n := g.InsertNode(); n.ID != 1 {
+		t.Errorf("got id %d, want 1", n.ID)
+	}
+	if n := g.InsertNode(); n.ID != 6 {
+		t.Errorf("got id %d, want 6", n.ID)
+	}
+	if g.LastNodeID() != 6 {
+		t.Errorf("got last id %d, want 6", g.LastNodeID())
+	}
+}
+
+func TestFreeListRanges(t *testing.T) {
+	a := NewFreeListAllocator()
+	a.ReleaseRange(10, 12)
+	a.Release(14)
+	a.Release(13)
+	a.ReleaseRange(3, 5)
+	a.Release(4)
+	a.Use(11)
+	want := []idRange{{3, 5}, {10, 10}, {12, 14}}
+	if !reflect.DeepEqual(a.free, want) {
+		t.Errorf("got free ranges %v, want %v", a.free, want)
+	}
+	var got []uint64
+	for i := 0; i < 6; i++ {
+		got = append(got, a.Allocate(20))
+	}
+	if want := []uint64{3, 4, 5, 10, 12, 13}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got ids %v, want %v", got, want)
+	}
+
+	// a large gap is released as one range
+	g, _ := NewGraph("testGraph")
+	g.InsertNodeWithID(1<<40, "imported", nil)
+	done := make(chan struct{})
+	go func() {
+		g.SetIDAllocator(NewFreeListAllocator())
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("SetIDAllocator did not return")
+	}
+	if n := g.InsertNode(); n.ID != 1 {
+		t.Errorf("got id %d, want 1", n.ID)
+	}
+}
+
+func TestInsertNodeWithID(t *testing.T) {
+	g, _ := NewGraph("testGraph")
+	n, err := g.InsertNodeWithID(100, "imported", []byte("value"))
+	if err != nil || n.ID != 100 {
+		t.Fatalf("got %v, %v, want node 100", n, err)
+	}
+	if g.LastNodeID() != 100 {
+		t.Errorf("got last id %d, want 100", g.LastNodeID())
+	}
+	if n := g.InsertNode(); n.ID != 101 {
+		t.Errorf("got id %d, want 101", n.ID)
+	}
+
+	for _, ID := range []uint64{0, 100} {
+		if _, err := g.InsertNodeWithID(ID, "again", nil); err == nil || err.Error() != ErrIDExists {
+			t.Errorf("got `%v`, want `%s` for id %d", err, ErrIDExists, ID)
+		}
+	}
+
+	// IDs do not wrap around to the root's
+	if _, err := g.InsertNodeWithID(math.MaxUint64, "last", nil); err != nil {
+		t.Fatalf("unable to insert with id, error: %v", err)
+	}
+	if n := g.InsertNode(); n.ID != 1 {
+		t.Errorf("got id %d, want 1", n.ID)
+	}
+	if g.Root().Key != "" || len(g.nodes) != 5 {
+		t.Errorf("got root key %q and %d nodes, want the root kept", g.Root().Key, len(g.nodes))
+	}
+}
+
+func TestExternalIDs(t *testing.T) {
+	g, _ := NewGraph("testGraph")
+	ulid := "01ARZ3NDEKTSV4RRFFQ69G5FAV"
+	n, err := g.InsertExternalNode(ulid, "Intro", nil)
+	if err != nil {
+		t.Fatalf("unable to insert, error: %v", err)
+	}
+	if n.ExternalID() != ulid {
+		t.Errorf("got external id %q, want %q", n.ExternalID(), ulid)
+	}
+	if _, err := g.InsertExternalNode(ulid, "Other", nil); err == nil || err.Error() != ErrIDExists {
+		t.Errorf("got `%v`, want `%s`", err, ErrIDExists)
+	}
+
+	var buf bytes.Buffer
+	gob.NewEncoder(&buf).Encode(g)
+	decoded := &Graph{}
+	if err := gob.NewDecoder(&buf).Decode(decoded); err != nil {
+		t.Fatalf("unable to decode, error: %v", err)
+	}
+	if found, ok := decoded.FindNodeByExternalID(ulid); !ok || found.ID != n.ID {
+		t.Error("external id not restored")
+	}
+
+	g.DeleteNode(n)
+	if _, ok := g.FindNodeByExternalID(ulid); ok {
+		t.Error("deleted node still found by external id")
+	}
+}
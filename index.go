@@ -0,0 +1,259 @@
+package giraffe
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"strings"
+)
+
+// Index error constants
+const (
+	// ErrIndexExists returns when creating an index on a property that is already indexed
+	ErrIndexExists = "index exists"
+
+	// ErrNoIndex returns when querying a property that has not been indexed
+	ErrNoIndex = "no index"
+)
+
+// propertyIndex keeps the nodes with a given property sorted by the property's value
+type propertyIndex struct {
+	entries []indexEntry
+}
+
+// indexEntry is a single node in a property index
+type indexEntry struct {
+	value Property
+	ID    uint64
+}
+
+// CreateIndex indexes the named property on every node so it can be queried with
+// FindByProperty() and FindByPropertyRange(). Indexes are updated along with every
+// mutation and are rebuilt when a graph is decoded.
+func (g *Graph) CreateIndex(name string) error {
+	g.Lock()
+	defer g.Unlock()
+
+	if _, ok := g.indexes[name]; ok {
+		return errors.New(ErrIndexExists)
+	}
+	if err := g.loadAll(); err != nil {
+		return err
+	}
+	if g.indexes == nil {
+		g.indexes = make(map[string]*propertyIndex)
+	}
+	g.indexes[name] = g.buildIndex(name)
+	return g.saveMeta()
+}
+
+// DropIndex removes the index on the named property
+func (g *Graph) DropIndex(name string) error {
+	g.Lock()
+	defer g.Unlock()
+
+	if _, ok := g.indexes[name]; !ok {
+		return errors.New(ErrNoIndex)
+	}
+	delete(g.indexes, name)
+	return g.saveMeta()
+}
+
+// Indexes returns the names of the indexed properties in sorted order
+func (g *Graph) Indexes() []string {
+	g.Lock()
+	defer g.Unlock()
+
+	return g.indexNames()
+}
+
+// FindByProperty returns the nodes whose indexed property equals value, ordered by ID
+func (g *Graph) FindByProperty(name string, value Property) ([]*Node, error) {
+	return g.FindByPropertyRange(name, value, value)
+}
+
+// FindByPropertyRange returns the nodes whose indexed property lies between min and max
+// inclusive, ordered by value and then by ID. Integer and float values compare numerically.
+func (g *Graph) FindByPropertyRange(name string, min, max Property) ([]*Node, error) {
+	g.Lock()
+	defer g.Unlock()
+
+	if err := g.loadAll(); err != nil {
+		return nil, err
+	}
+	idx, ok := g.indexes[name]
+	if !ok {
+		return nil, errors.New(ErrNoIndex)
+	}
+
+	start := sort.Search(len(idx.entries), func(i int) bool {
+		return compareProperties(idx.entries[i].value, min) >= 0
+	})
+
+	var nodes []*Node
+	for _, entry := range idx.entries[start:] {
+		if compareProperties(entry.value, max) > 0 {
+			break
+		}
+		nodes = append(nodes, g.nodes[entry.ID])
+	}
+	return nodes, nil
+}
+
+// buildIndex scans the graph for the named property. The caller must hold the graph lock.
+func (g *Graph) buildIndex(name string) *propertyIndex {
+	idx := &propertyIndex{}
+	for id, node := range g.nodes {
+		node.RLock()
+		p, ok := node.properties[name]
+		node.RUnlock()
+		if ok {
+			idx.entries = append(idx.entries, indexEntry{value: p, ID: id})
+		}
+	}
+	sort.Slice(idx.entries, func(i, j int) bool {
+		return idx.entries[i].less(idx.entries[j])
+	})
+	return idx
+}
+
+// indexNames lists the indexed properties. The caller must hold the graph lock.
+func (g *Graph) indexNames() []string {
+	names := make([]string, 0, len(g.indexes))
+	for name := range g.indexes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// indexProperty updates the index, if any, for a property change on a node.
+// old or new may be nil when the property is being added or removed.
+// The caller must hold the graph lock.
+func (g *Graph) indexProperty(ID uint64, name string, old, new *Property) {
+	idx, ok := g.indexes[name]
+	if !ok {
+		return
+	}
+	if old != nil {
+		idx.remove(indexEntry{value: *old, ID: ID})
+	}
+	if new != nil {
+		idx.insert(indexEntry{value: *new, ID: ID})
+	}
+}
+
+// unindexProperties removes all of a node's properties from the indexes.
+// The caller must hold the graph lock.
+func (g *Graph) unindexProperties(n *Node) {
+	for name, p := range n.properties {
+		p := p
+		g.indexProperty(n.ID, name, &p, nil)
+	}
+}
+
+// insert adds an entry in sorted position
+func (idx *propertyIndex) insert(e indexEntry) {
+	i := sort.Search(len(idx.entries), func(i int) bool { return !idx.entries[i].less(e) })
+	idx.entries = append(idx.entries, indexEntry{})
+	copy(idx.entries[i+1:], idx.entries[i:])
+	idx.entries[i] = e
+}
+
+// remove deletes an entry if it is present
+func (idx *propertyIndex) remove(e indexEntry) {
+	i := sort.Search(len(idx.entries), func(i int) bool { return !idx.entries[i].less(e) })
+	for ; i < len(idx.entries) && compareProperties(idx.entries[i].value, e.value) == 0; i++ {
+		if idx.entries[i].ID == e.ID {
+			idx.entries = append(idx.entries[:i], idx.entries[i+1:]...)
+			return
+		}
+	}
+}
+
+// less orders entries by value and then by node ID
+func (e indexEntry) less(other indexEntry) bool {
+	if c := compareProperties(e.value, other.value); c != 0 {
+		return c < 0
+	}
+	return e.ID < other.ID
+}
+
+// compareProperties returns -1, 0 or 1 comparing a to b. Values of different types are
+// ordered by type, except integers and floats which compare numerically.
+func compareProperties(a, b Property) int {
+	if isNumeric(a) && isNumeric(b) && a.Type != b.Type {
+		return compareFloats(numericValue(a), numericValue(b))
+	}
+	if a.Type != b.Type {
+		if a.Type < b.Type {
+			return -1
+		}
+		return 1
+	}
+
+	switch a.Type {
+	case PropString:
+		return strings.Compare(a.Str, b.Str)
+	case PropInt:
+		switch {
+		case a.Int < b.Int:
+			return -1
+		case a.Int > b.Int:
+			return 1
+		}
+		return 0
+	case PropFloat:
+		return compareFloats(a.Float, b.Float)
+	case PropBool:
+		switch {
+		case a.Bool == b.Bool:
+			return 0
+		case !a.Bool:
+			return -1
+		}
+		return 1
+	case PropTime:
+		switch {
+		case a.Time.Before(b.Time):
+			return -1
+		case a.Time.After(b.Time):
+			return 1
+		}
+		return 0
+	case PropBytes:
+		return bytes.Compare(a.Bytes, b.Bytes)
+	case PropList:
+		for i := 0; i < len(a.List) && i < len(b.List); i++ {
+			if c := compareProperties(a.List[i], b.List[i]); c != 0 {
+				return c
+			}
+		}
+		return compareFloats(float64(len(a.List)), float64(len(b.List)))
+	}
+	return 0
+}
+
+// isNumeric reports whether the property holds an integer or float
+func isNumeric(p Property) bool {
+	return p.Type == PropInt || p.Type == PropFloat
+}
+
+// numericValue returns an integer or float property as a float64
+func numericValue(p Property) float64 {
+	if p.Type == PropInt {
+		return float64(p.Int)
+	}
+	return p.Float
+}
+
+// compareFloats returns -1, 0 or 1 comparing a to b
+func compareFloats(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
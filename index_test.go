@@ -0,0 +1,109 @@
+package giraffe
+
+import (
+	"reflect"
+	"testing"
+)
+
+func newIndexTestGraph() *Graph {
+	g, _ := NewGraph("testGraph")
+	lessons := []struct {
+		key        string
+		subject    string
+		difficulty Property
+	}{
+		{"Polynomials", "algebra", IntProp(2)},
+		{"Zero Product Property", "algebra", IntProp(3)},
+		{"Graphing Quadratics", "graphing", FloatProp(4.5)},
+		{"Quadratic Formula", "algebra", IntProp(5)},
+		{"Calculus", "calculus", IntProp(8)},
+	}
+	for _, l := range lessons {
+		g.InsertPropertyNode("", l.key, nil, map[string]Property{
+			"subject":    StringProp(l.subject),
+			"difficulty": l.difficulty,
+		})
+	}
+	return g
+}
+
+func nodeKeys(nodes []*Node) []string {
+	keys := make([]string, len(nodes))
+	for i, n := range nodes {
+		keys[i] = n.Key
+	}
+	return keys
+}
+
+func TestIndexQueries(t *testing.T) {
+	g := newIndexTestGraph()
+	if err := g.CreateIndex("difficulty"); err != nil {
+		t.Fatalf("unable to create index, error: %v", err)
+	}
+	if err := g.CreateIndex("subject"); err != nil {
+		t.Fatalf("unable to create index, error: %v", err)
+	}
+	if err := g.CreateIndex("subject"); err == nil || err.Error() != ErrIndexExists {
+		t.Errorf("got `%v`, want `%s`", err, ErrIndexExists)
+	}
+
+	nodes, err := g.FindByPropertyRange("difficulty", IntProp(3), IntProp(5))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, want := nodeKeys(nodes), []string{"Zero Product Property", "Graphing Quadratics", "Quadratic Formula"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	nodes, _ = g.FindByProperty("subject", StringProp("algebra"))
+	if got, want := nodeKeys(nodes), []string{"Polynomials", "Zero Product Property", "Quadratic Formula"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if _, err := g.FindByProperty("lesson_id", IntProp(1)); err == nil || err.Error() != ErrNoIndex {
+		t.Errorf("got `%v`, want `%s`", err, ErrNoIndex)
+	}
+}
+
+func TestIndexFollowsMutations(t *testing.T) {
+	g := newIndexTestGraph()
+	g.CreateIndex("subject")
+
+	calculus, _ := g.FindNodeByKey("Calculus")
+	calculus.SetProperty("subject", StringProp("algebra"))
+	polynomials, _ := g.FindNodeByKey("Polynomials")
+	polynomials.DeleteProperty("subject")
+	zpp, _ := g.FindNodeByKey("Zero Product Property")
+	g.DeleteNode(zpp)
+	g.InsertPropertyNode("", "Factoring", nil, map[string]Property{"subject": StringProp("algebra")})
+
+	nodes, _ := g.FindByProperty("subject", StringProp("algebra"))
+	if got, want := nodeKeys(nodes), []string{"Quadratic Formula", "Calculus", "Factoring"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestIndexRebuiltOnDecode(t *testing.T) {
+	g := newIndexTestGraph()
+	g.CreateIndex("difficulty")
+
+	data, err := g.GobEncode()
+	if err != nil {
+		t.Fatalf("unable to encode graph - err `%v`", err)
+	}
+	decodedGraph := &Graph{}
+	if err := decodedGraph.GobDecode(data); err != nil {
+		t.Fatalf("unable to decode graph - err `%v`", err)
+	}
+
+	if got, want := decodedGraph.Indexes(), []string{"difficulty"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got indexes %v, want %v", got, want)
+	}
+	nodes, err := decodedGraph.FindByPropertyRange("difficulty", FloatProp(4), FloatProp(10))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, want := nodeKeys(nodes), []string{"Graphing Quadratics", "Quadratic Formula", "Calculus"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,89 @@
+package giraffe
+
+import (
+	"iter"
+	"sort"
+)
+
+// Edge is a directional relationship from one node to another. In an undirected graph either
+// node may be From.
+type Edge struct {
+	From uint64
+	To   uint64
+}
+
+// Node returns the node with the given ID
+func (g *Graph) Node(ID uint64) (*Node, bool) {
+	g.Lock()
+	defer g.Unlock()
+
+	return g.node(ID)
+}
+
+// Nodes iterates over the graph's nodes in ID order. The nodes are captured when iteration
+// starts, so the graph may be changed while iterating. If the graph's store fails to read its
+// nodes, only those already read are iterated and the error is reported by StoreErr().
+func (g *Graph) Nodes() iter.Seq[*Node] {
+	return func(yield func(*Node) bool) {
+		for _, node := range g.sortedNodes() {
+			if !yield(node) {
+				return
+			}
+		}
+	}
+}
+
+// Edges iterates over every relationship in the graph, ordered by the source node's ID and
+// then by the order the relationships were added. Undirected relationships are listed once, from
+// the lower ID. Like Nodes(), the edges are captured when iteration starts, and may be partial
+// after a store error.
+func (g *Graph) Edges() iter.Seq[Edge] {
+	return func(yield func(Edge) bool) {
+		for _, node := range g.sortedNodes() {
+			for edge := range node.OutEdges() {
+				if g.undirected && edge.To < edge.From {
+					continue
+				}
+				if !yield(edge) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// OutEdges iterates over the relationships from this node to its destinations
+func (n *Node) OutEdges() iter.Seq[Edge] {
+	return func(yield func(Edge) bool) {
+		for _, id := range extractIDs(n.ListDestinations()) {
+			if !yield(Edge{From: n.ID, To: id}) {
+				return
+			}
+		}
+	}
+}
+
+// InEdges iterates over the relationships from this node's sources to this node
+func (n *Node) InEdges() iter.Seq[Edge] {
+	return func(yield func(Edge) bool) {
+		for _, id := range extractIDs(n.ListSources()) {
+			if !yield(Edge{From: id, To: n.ID}) {
+				return
+			}
+		}
+	}
+}
+
+// sortedNodes captures the graph's nodes in ID order
+func (g *Graph) sortedNodes() []*Node {
+	g.Lock()
+	g.loadAll()
+	nodes := make([]*Node, 0, len(g.nodes))
+	for _, node := range g.nodes {
+		nodes = append(nodes, node)
+	}
+	g.Unlock()
+
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID < nodes[j].ID })
+	return nodes
+}
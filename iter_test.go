@@ -0,0 +1,52 @@
+package giraffe
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestNodesIterator(t *testing.T) {
+	g, _ := newTestGraph()
+	g.DeleteNodeByID(5)
+
+	var got []uint64
+	for node := range g.Nodes() {
+		got = append(got, node.ID)
+		// mutating the graph while iterating must not affect the iteration
+		if node.ID == 1 {
+			g.InsertNode()
+			g.DeleteNodeByID(12)
+		}
+	}
+	if want := []uint64{0, 1, 2, 3, 4, 6, 7, 8, 9, 10, 11, 12}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if n, ok := g.Node(13); !ok || n.ID != 13 {
+		t.Error("unable to look up inserted node 13")
+	}
+	if _, ok := g.Node(12); ok {
+		t.Error("node 12 should be deleted")
+	}
+}
+
+func TestEdgeIterators(t *testing.T) {
+	g, _ := newTestGraph()
+
+	edges := slices.Collect(g.Edges())
+	if len(edges) != 11 {
+		t.Errorf("got %d edges, want %d", len(edges), 11)
+	}
+	if want := []Edge{{0, 1}, {0, 2}, {0, 3}, {1, 4}}; !reflect.DeepEqual(edges[:4], want) {
+		t.Errorf("got %v, want %v", edges[:4], want)
+	}
+
+	n6, _ := g.Node(6)
+	if got, want := slices.Collect(n6.OutEdges()), []Edge{{6, 9}, {6, 10}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := slices.Collect(n6.InEdges()), []Edge{{2, 6}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
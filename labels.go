@@ -0,0 +1,157 @@
+package giraffe
+
+import "sort"
+
+// AddLabel tags the node with a label such as "Lesson" or "Draft". Adding a label twice has no effect.
+func (n *Node) AddLabel(label string) error {
+	if n.graph != nil {
+		n.graph.Lock()
+		defer n.graph.Unlock()
+		if err := n.graph.writable(); err != nil {
+			return err
+		}
+	}
+	return n.addLabel(label)
+}
+
+// addLabel is the logic for AddLabel(). The caller must hold the graph lock.
+func (n *Node) addLabel(label string) error {
+	n.Lock()
+	defer n.Unlock()
+
+	i := sort.SearchStrings(n.labels, label)
+	if i < len(n.labels) && n.labels[i] == label {
+		return nil
+	}
+	n.labels = append(n.labels, "")
+	copy(n.labels[i+1:], n.labels[i:])
+	n.labels[i] = label
+
+	if n.graph != nil {
+		n.graph.indexLabel(label, n.ID)
+	}
+	return n.committed(Operation{Kind: OpAddLabel, NodeID: n.ID, Label: label})
+}
+
+// RemoveLabel removes a label from the node
+func (n *Node) RemoveLabel(label string) error {
+	if n.graph != nil {
+		n.graph.Lock()
+		defer n.graph.Unlock()
+		if err := n.graph.writable(); err != nil {
+			return err
+		}
+	}
+	return n.removeLabel(label)
+}
+
+// removeLabel is the logic for RemoveLabel(). The caller must hold the graph lock.
+func (n *Node) removeLabel(label string) error {
+	n.Lock()
+	defer n.Unlock()
+
+	i := sort.SearchStrings(n.labels, label)
+	if i == len(n.labels) || n.labels[i] != label {
+		return nil
+	}
+	n.labels = append(n.labels[:i], n.labels[i+1:]...)
+
+	if n.graph != nil {
+		delete(n.graph.labels[label], n.ID)
+		if len(n.graph.labels[label]) == 0 {
+			delete(n.graph.labels, label)
+		}
+	}
+	return n.committed(Operation{Kind: OpRemoveLabel, NodeID: n.ID, Label: label})
+}
+
+// Labels returns the node's labels in sorted order
+func (n *Node) Labels() []string {
+	n.RLock()
+	defer n.RUnlock()
+
+	return append([]string(nil), n.labels...)
+}
+
+// HasLabel reports whether the node carries the label
+func (n *Node) HasLabel(label string) bool {
+	n.RLock()
+	defer n.RUnlock()
+
+	return n.hasLabels([]string{label})
+}
+
+// hasLabels reports whether the node carries all of the labels. An empty list always matches.
+// The caller is responsible for locking the node.
+func (n *Node) hasLabels(labels []string) bool {
+	for _, label := range labels {
+		i := sort.SearchStrings(n.labels, label)
+		if i == len(n.labels) || n.labels[i] != label {
+			return false
+		}
+	}
+	return true
+}
+
+// anyHasLabels reports whether any node in the list carries all of the labels.
+// Every node carries an empty label list, so it then reports whether the list has any nodes.
+func anyHasLabels(nodes []*Node, labels []string) bool {
+	if len(labels) == 0 {
+		return len(nodes) > 0
+	}
+	for _, node := range nodes {
+		if node.hasLabels(labels) {
+			return true
+		}
+	}
+	return false
+}
+
+// NodesWithLabel returns the nodes carrying the label, ordered by ID
+func (g *Graph) NodesWithLabel(label string) []*Node {
+	g.Lock()
+	defer g.Unlock()
+
+	g.loadAll()
+	nodes := make([]*Node, 0, len(g.labels[label]))
+	for id := range g.labels[label] {
+		nodes = append(nodes, g.nodes[id])
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID < nodes[j].ID })
+	return nodes
+}
+
+// Labels returns every label in use in the graph in sorted order
+func (g *Graph) Labels() []string {
+	g.Lock()
+	defer g.Unlock()
+
+	g.loadAll()
+	labels := make([]string, 0, len(g.labels))
+	for label := range g.labels {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	return labels
+}
+
+// indexLabel records the node in the label index. The caller must hold the graph lock.
+func (g *Graph) indexLabel(label string, ID uint64) {
+	if g.labels == nil {
+		g.labels = make(map[string]map[uint64]bool)
+	}
+	if g.labels[label] == nil {
+		g.labels[label] = make(map[uint64]bool)
+	}
+	g.labels[label][ID] = true
+}
+
+// unindexLabels removes the node from the label index. The caller must hold the graph lock.
+func (g *Graph) unindexLabels(n *Node) {
+	for _, label := range n.labels {
+		delete(g.labels[label], n.ID)
+		if len(g.labels[label]) == 0 {
+			delete(g.labels, label)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package giraffe
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNodeLabels(t *testing.T) {
+	g, _ := NewGraph("testGraph")
+	n1 := g.InsertNode()
+	n2 := g.InsertNode()
+
+	n1.AddLabel("Lesson")
+	n1.AddLabel("Algebra")
+	n1.AddLabel("Lesson")
+	n2.AddLabel("Lesson")
+
+	if got, want := n1.Labels(), []string{"Algebra", "Lesson"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got labels %v, want %v", got, want)
+	}
+	if got, want := extractIDs(g.NodesWithLabel("Lesson")), []uint64{n1.ID, n2.ID}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got nodes %v, want %v", got, want)
+	}
+
+	n1.RemoveLabel("Lesson")
+	if n1.HasLabel("Lesson") {
+		t.Error("label should have been removed")
+	}
+	if got, want := extractIDs(g.NodesWithLabel("Lesson")), []uint64{n2.ID}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got nodes %v, want %v", got, want)
+	}
+
+	g.DeleteNode(n2)
+	if got := g.NodesWithLabel("Lesson"); len(got) != 0 {
+		t.Errorf("deleted node still indexed: %v", extractIDs(got))
+	}
+	if got, want := g.Labels(), []string{"Algebra"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got labels %v, want %v", got, want)
+	}
+}
+
+func TestLabelFilters(t *testing.T) {
+	g, _ := newTestGraph()
+	for _, id := range []uint64{2, 6, 10, 11} {
+		g.nodes[id].AddLabel("Algebra")
+	}
+
+	roots := g.FindRoots("Algebra")
+	if want := []uint64{2}; !reflect.DeepEqual(roots, want) {
+		t.Errorf("actual roots %v not expected %v", roots, want)
+	}
+
+	if !g.nodes[2].DepthFirstSearch(g.nodes[11], "Algebra") {
+		t.Error("unable to find path 2 -> 11 through Algebra nodes")
+	}
+	if g.Root().DepthFirstSearch(g.nodes[8], "Algebra") {
+		t.Error("should not find a path 0 -> 8 through Algebra nodes")
+	}
+	if g.nodes[2].BreadthFirstSearch(g.nodes[9], "Algebra") {
+		t.Error("should not reach unlabeled node 9")
+	}
+	if !g.nodes[2].BreadthFirstSearch(g.nodes[11], "Algebra") {
+		t.Error("unable to find path 2 -> 11 through Algebra nodes")
+	}
+
+	js := g.ToVisJS(true, false, false, "Algebra")
+	if strings.Contains(js, "node 9 ") || !strings.Contains(js, "node 10 ") {
+		t.Error("ToVisJS should only include Algebra nodes")
+	}
+	if strings.Contains(js, "to: 9,") {
+		t.Error("ToVisJS should not include edges to filtered nodes")
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !unix
+
+package giraffe
+
+import "os"
+
+// mapFile reads the whole file on platforms without mmap support
+func mapFile(path string) ([]byte, error) {
+	return os.ReadFile(path)
+}
+
+// unmapFile releases a mapping made by mapFile
+func unmapFile(data []byte) error {
+	return nil
+}
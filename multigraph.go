@@ -0,0 +1,275 @@
+package giraffe
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+// Relationship error constants
+const (
+	// ErrParallelRelationship returns when adding a relationship between nodes that are already
+	// related and parallel relationships are forbidden
+	ErrParallelRelationship = "parallel relationship"
+
+	// ErrSelfRelationship returns when relating a node to itself and self relationships are
+	// forbidden
+	ErrSelfRelationship = "self relationship"
+
+	// ErrRelationshipNotFound returns when removing a relationship by an ID that is not in the graph
+	ErrRelationshipNotFound = "relationship not found"
+)
+
+// SetRelationshipConstraints controls whether a node may have more than one relationship to
+// the same node, and whether a node may be related to itself. Both are allowed by default; see
+// Config to set them when the graph is made. It returns ErrParallelRelationship or
+// ErrSelfRelationship if the graph already breaks a constraint being turned on.
+func (g *Graph) SetRelationshipConstraints(parallel, self bool) error {
+	g.Lock()
+	defer g.Unlock()
+
+	if err := g.writable(); err != nil {
+		return err
+	}
+	if err := g.loadAll(); err != nil {
+		return err
+	}
+	for _, n := range g.nodes {
+		n.RLock()
+		seen := make(map[uint64]bool, len(n.destinations))
+		for _, dest := range n.destinations {
+			if !self && dest == n {
+				n.RUnlock()
+				return errors.New(ErrSelfRelationship)
+			}
+			if !parallel && seen[dest.ID] {
+				n.RUnlock()
+				return errors.New(ErrParallelRelationship)
+			}
+			seen[dest.ID] = true
+		}
+		n.RUnlock()
+	}
+	g.forbidParallel = !parallel
+	g.forbidSelf = !self
+	return g.saveMeta()
+}
+
+// AddRelationshipID adds a relationship like AddRelationship() and returns its ID, which tells
+// it apart from parallel relationships between the same nodes. See RemoveRelationshipByID().
+func (n *Node) AddRelationshipID(newNode *Node) (uint64, error) {
+	unlock, err := n.lockValidated(func() Operation {
+		return Operation{Kind: OpAddRelationship, NodeID: n.ID, OtherID: newNode.ID}
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	return n.addRelationship(newNode, 0, "", time.Time{})
+}
+
+// AddTypedRelationship adds a relationship of a type, such as "requires" or "assesses", and
+// returns its ID. A schema's edge rules can allow only some types between two kinds. Relationships
+// added without a type have the type "". See RelationshipType().
+func (n *Node) AddTypedRelationship(newNode *Node, relType string) (uint64, error) {
+	unlock, err := n.lockValidated(func() Operation {
+		return Operation{Kind: OpAddRelationship, NodeID: n.ID, OtherID: newNode.ID, RelationshipType: relType}
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	return n.addRelationship(newNode, 0, relType, time.Time{})
+}
+
+// RelationshipType returns the type of the relationship with the ID
+func (g *Graph) RelationshipType(ID uint64) (string, bool) {
+	g.Lock()
+	defer g.Unlock()
+
+	g.loadAll()
+	e, ok := g.relationships[ID]
+	if !ok {
+		return "", false
+	}
+	n := g.nodes[e.From]
+	n.RLock()
+	defer n.RUnlock()
+
+	for i, edgeID := range n.edgeIDs {
+		if edgeID == ID {
+			return n.edgeTypes[i], true
+		}
+	}
+	return "", false
+}
+
+// RelationshipIDs returns the IDs of this node's relationships to another node, oldest first
+func (n *Node) RelationshipIDs(other *Node) []uint64 {
+	unlock := n.lockGraph()
+	defer unlock()
+	n.RLock()
+	defer n.RUnlock()
+
+	var IDs []uint64
+	for i, dest := range n.destinations {
+		if dest.ID == other.ID {
+			IDs = append(IDs, n.edgeIDs[i])
+		}
+	}
+	return IDs
+}
+
+// RemoveRelationshipByID removes one relationship, leaving any parallel relationships between
+// the same nodes in place
+func (g *Graph) RemoveRelationshipByID(ID uint64) error {
+	err := g.lockValidated(func() []Operation {
+		g.Lock()
+		g.loadAll()
+		e, ok := g.relationships[ID]
+		g.Unlock()
+		if !ok {
+			return nil
+		}
+		return []Operation{{Kind: OpRemoveRelationship, NodeID: e.From, OtherID: e.To, EdgeID: ID}}
+	})
+	if err != nil {
+		return err
+	}
+	defer g.Unlock()
+
+	if err := g.loadAll(); err != nil {
+		return err
+	}
+	e, ok := g.relationships[ID]
+	if !ok {
+		return errors.New(ErrRelationshipNotFound)
+	}
+	return g.nodes[e.From].removeRelationshipID(g.nodes[e.To], ID)
+}
+
+// removeRelationshipID is the logic for RemoveRelationshipByID() without validation.
+// The caller must hold the graph lock.
+func (n *Node) removeRelationshipID(oldNode *Node, ID uint64) error {
+	n.graph.link(n)
+	n.graph.link(oldNode)
+	n.Lock()
+	defer n.Unlock()
+
+	if !n.dropDestinations(func(_ *Node, edgeID uint64) bool { return edgeID == ID }) {
+		return errors.New(ErrRelationshipNotFound)
+	}
+	if oldNode != n {
+		oldNode.Lock()
+		defer oldNode.Unlock()
+	}
+	oldNode.sources = removeOne(oldNode.sources, n)
+	if n.graph != nil && n.graph.undirected && oldNode != n {
+		oldNode.dropDestinations(func(_ *Node, edgeID uint64) bool { return edgeID == ID })
+		n.sources = removeOne(n.sources, oldNode)
+	}
+	return n.committed(Operation{Kind: OpRemoveRelationship, NodeID: n.ID, OtherID: oldNode.ID, EdgeID: ID})
+}
+
+// checkRelationship enforces the relationship constraints on a new relationship from n. The
+// caller must hold the graph lock and n's lock.
+func (g *Graph) checkRelationship(n, newNode *Node) error {
+	if g.forbidSelf && n.ID == newNode.ID {
+		return errors.New(ErrSelfRelationship)
+	}
+	if g.forbidParallel && inList(n.destinations, newNode) {
+		return errors.New(ErrParallelRelationship)
+	}
+	return nil
+}
+
+// useEdgeID allocates a relationship ID when ID is 0, or records a supplied one so it is not
+// allocated again. The highest ID is saved with the graph's settings. The caller must hold the
+// graph lock.
+func (g *Graph) useEdgeID(ID uint64) uint64 {
+	if ID == 0 {
+		ID = g.topEdgeID + 1
+	}
+	if ID > g.topEdgeID {
+		g.topEdgeID = ID
+		g.metaDirty = true
+	}
+	return ID
+}
+
+// indexRelationship records which nodes a relationship ID relates. The caller must hold the
+// graph lock.
+func (g *Graph) indexRelationship(ID, from, to uint64) {
+	if g.relationships == nil {
+		g.relationships = make(map[uint64]Edge)
+	}
+	g.relationships[ID] = g.edgeKey(from, to)
+}
+
+// rebuildRelationships rebuilds the relationship ID index, giving new IDs to relationships
+// saved without them. The caller must hold the graph lock or own the graph.
+func (g *Graph) rebuildRelationships() {
+	nodes := make([]*Node, 0, len(g.nodes))
+	for _, n := range g.nodes {
+		nodes = append(nodes, n)
+		for _, ID := range n.edgeIDs {
+			if ID > g.topEdgeID {
+				g.topEdgeID = ID
+			}
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID < nodes[j].ID })
+
+	g.relationships = make(map[uint64]Edge)
+	for _, n := range nodes {
+		if len(n.edgeTypes) != len(n.destinations) {
+			// saved before relationships had types
+			n.edgeTypes = make([]string, len(n.destinations))
+		}
+		if len(n.edgeIDs) != len(n.destinations) {
+			n.edgeIDs = make([]uint64, len(n.destinations))
+			for i := range n.edgeIDs {
+				n.edgeIDs[i] = g.useEdgeID(0)
+			}
+		}
+		for i, dest := range n.destinations {
+			g.indexRelationship(n.edgeIDs[i], n.ID, dest.ID)
+		}
+	}
+}
+
+// dropDestinations removes the relationships from n that match, and reports whether any did.
+// The caller must hold the graph lock and n's lock.
+func (n *Node) dropDestinations(match func(dest *Node, edgeID uint64) bool) bool {
+	var destinations []*Node
+	var edgeIDs []uint64
+	var edgeTypes []string
+	for i, dest := range n.destinations {
+		edgeID := n.edgeIDs[i]
+		if !match(dest, edgeID) {
+			destinations = append(destinations, dest)
+			edgeIDs = append(edgeIDs, edgeID)
+			edgeTypes = append(edgeTypes, n.edgeTypes[i])
+			continue
+		}
+		if n.graph != nil {
+			delete(n.graph.relationships, edgeID)
+			n.graph.setEdgeExpiry(edgeID, time.Time{})
+		}
+	}
+	dropped := len(destinations) != len(n.destinations)
+	n.destinations, n.edgeIDs, n.edgeTypes = destinations, edgeIDs, edgeTypes
+	return dropped
+}
+
+// removeOne returns the list without the first node with the target's ID
+func removeOne(list []*Node, target *Node) []*Node {
+	for i, node := range list {
+		if node.ID == target.ID {
+			return append(list[:i:i], list[i+1:]...)
+		}
+	}
+	return list
+}
@@ -0,0 +1,115 @@
+package giraffe
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParallelRelationships(t *testing.T) {
+	g, _ := NewGraph("testGraph")
+	n1, _ := g.InsertDataNode("Intro", nil)
+	n2, _ := g.InsertDataNode("Factoring", nil)
+
+	first, err := n1.AddRelationshipID(n2)
+	if err != nil {
+		t.Fatalf("unable to add relationship, error: %v", err)
+	}
+	second, _ := n1.AddRelationshipID(n2)
+	if first == second {
+		t.Fatalf("parallel relationships share ID %d", first)
+	}
+	if got := n1.RelationshipIDs(n2); !reflect.DeepEqual(got, []uint64{first, second}) {
+		t.Errorf("got IDs %v, want %v", got, []uint64{first, second})
+	}
+
+	if err := g.RemoveRelationshipByID(first); err != nil {
+		t.Fatalf("unable to remove relationship, error: %v", err)
+	}
+	if got := n1.RelationshipIDs(n2); !reflect.DeepEqual(got, []uint64{second}) {
+		t.Errorf("got IDs %v, want %v", got, []uint64{second})
+	}
+	if got := extractIDs(n2.ListSources()); !reflect.DeepEqual(got, []uint64{n1.ID}) {
+		t.Errorf("got sources %v, want one relationship left", got)
+	}
+	if err := g.RemoveRelationshipByID(first); err == nil || err.Error() != ErrRelationshipNotFound {
+		t.Errorf("got `%v`, want `%s`", err, ErrRelationshipNotFound)
+	}
+
+	// IDs survive saving and replaying
+	c := copyGraph(t, g)
+	c1, _ := c.Node(n1.ID)
+	c2, _ := c.Node(n2.ID)
+	if got := c1.RelationshipIDs(c2); !reflect.DeepEqual(got, []uint64{second}) {
+		t.Errorf("got IDs %v after decoding, want %v", got, []uint64{second})
+	}
+	if third, _ := c1.AddRelationshipID(c2); third <= second {
+		t.Errorf("got ID %d after decoding, want a new one", third)
+	}
+
+	store := NewMemoryStore()
+	s, _ := NewStoreGraph("testGraph", true, true, store)
+	s1, _ := s.InsertDataNode("Intro", nil)
+	s2, _ := s.InsertDataNode("Factoring", nil)
+	s1.AddRelationship(s2)
+	ID, _ := s1.AddRelationshipID(s2)
+	s, err = OpenGraph(store)
+	if err != nil {
+		t.Fatalf("unable to open graph, error: %v", err)
+	}
+	if err := s.RemoveRelationshipByID(ID); err != nil {
+		t.Errorf("unable to remove reopened relationship, error: %v", err)
+	}
+	s1, _ = s.Node(s1.ID)
+	if got := len(s1.ListDestinations()); got != 1 {
+		t.Errorf("got %d relationships, want 1", got)
+	}
+}
+
+func TestRelationshipConstraints(t *testing.T) {
+	g, _ := NewGraph("testGraph")
+	n1, _ := g.InsertDataNode("Intro", nil)
+	n2, _ := g.InsertDataNode("Factoring", nil)
+
+	// a self relationship used to deadlock
+	if err := n1.AddRelationship(n1); err != nil {
+		t.Fatalf("unable to add self relationship, error: %v", err)
+	}
+	n1.AddRelationship(n2)
+	n1.AddRelationship(n2)
+
+	if err := g.SetRelationshipConstraints(true, false); err == nil || err.Error() != ErrSelfRelationship {
+		t.Errorf("got `%v`, want `%s`", err, ErrSelfRelationship)
+	}
+	if err := g.SetRelationshipConstraints(false, true); err == nil || err.Error() != ErrParallelRelationship {
+		t.Errorf("got `%v`, want `%s`", err, ErrParallelRelationship)
+	}
+
+	n1.RemoveRelationship(n1)
+	n1.RemoveRelationship(n2)
+	if err := g.SetRelationshipConstraints(false, false); err != nil {
+		t.Fatalf("unable to set constraints, error: %v", err)
+	}
+	if err := n1.AddRelationship(n1); err == nil || err.Error() != ErrSelfRelationship {
+		t.Errorf("got `%v`, want `%s`", err, ErrSelfRelationship)
+	}
+	n1.AddRelationship(n2)
+	if err := n1.AddRelationship(n2); err == nil || err.Error() != ErrParallelRelationship {
+		t.Errorf("got `%v`, want `%s`", err, ErrParallelRelationship)
+	}
+
+	// undirected relationships are parallel from either end
+	u, _ := NewUndirectedGraph("testGraph", true, true)
+	u.SetRelationshipConstraints(false, true)
+	u1 := u.InsertNode()
+	u2 := u.InsertNode()
+	u1.AddRelationship(u2)
+	if err := u2.AddRelationship(u1); err == nil || err.Error() != ErrParallelRelationship {
+		t.Errorf("got `%v`, want `%s`", err, ErrParallelRelationship)
+	}
+
+	c := copyGraph(t, g)
+	c1, _ := c.Node(n1.ID)
+	if err := c1.AddRelationship(c1); err == nil || err.Error() != ErrSelfRelationship {
+		t.Errorf("got `%v` after decoding, want `%s`", err, ErrSelfRelationship)
+	}
+}
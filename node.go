@@ -3,37 +3,106 @@ package giraffe
 import (
 	"errors"
 	"sync"
+	"time"
 )
 
-// Node is a key value pair that has directional relationships with other Nodes
+// Node is a key value pair that has directional relationships with other Nodes, or undirected
+// relationships in an undirected graph
 type Node struct {
-	ID    uint64
+	ID   uint64
+	Kind string
+	// Key and Value are for reading. Change them with SetKey() and SetValue(), which keep the
+	// key and search indexes, the history and the store up to date; assigning them does not.
 	Key   string
 	Value []byte
 
+	// labels are kept sorted and mirrored in the graph's label index
+	labels []string
+
+	// properties are typed values. see SetProperty()
+	properties map[string]Property
+
+	// externalID is an ID from outside the graph. see InsertExternalNode()
+	externalID string
+
+	// expires is when the node is removed by Expire(), or zero. see InsertExpiringNode()
+	expires time.Time
+
 	circularRelationship bool
 
+	// graph is the graph this node belongs to. used to run validators
+	graph *Graph
+
 	sync.RWMutex
 	destinations []*Node
 	sources      []*Node
+	// edgeIDs holds the ID of each relationship in destinations. see AddRelationshipID()
+	edgeIDs []uint64
+	// edgeTypes holds the type of each relationship in destinations. see AddTypedRelationship()
+	edgeTypes []string
 
-	// used for encoding/decoding
-	sourceIDs []uint64
+	// used for encoding/decoding, and by nodes read from a store until pending is cleared
+	sourceIDs      []uint64
+	destinationIDs []uint64
+	// pending is set on a node read from a store whose relationships are not linked yet.
+	// see Graph.link()
+	pending bool
 }
 
 // AddRelationship adds a newNode as a destination of this node
 func (n *Node) AddRelationship(newNode *Node) error {
+	unlock, err := n.lockValidated(func() Operation {
+		return Operation{Kind: OpAddRelationship, NodeID: n.ID, OtherID: newNode.ID}
+	})
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	_, err = n.addRelationship(newNode, 0, "", time.Time{})
+	return err
+}
+
+// addRelationship is the logic for AddRelationship() and the other ways to add a relationship,
+// without validation. An edgeID of 0 is allocated by the graph. The caller must hold the graph
+// lock.
+func (n *Node) addRelationship(newNode *Node, edgeID uint64, relType string, expires time.Time) (uint64, error) {
+	n.graph.link(n)
+	n.graph.link(newNode)
 	n.Lock()
 	defer n.Unlock()
 
-	if !n.circularRelationship && newNode.DepthFirstSearch(n) {
-		return errors.New(ErrCircular)
+	undirected := n.graph != nil && n.graph.undirected
+	if n.graph != nil {
+		if err := n.graph.checkRelationship(n, newNode); err != nil {
+			return 0, err
+		}
+	}
+	if !n.circularRelationship && ((undirected && n.ID == newNode.ID) || newNode.dfs(n, nil, map[uint64]bool{newNode.ID: true})) {
+		return 0, errors.New(ErrCircular)
 	}
 
+	if n.graph != nil {
+		edgeID = n.graph.useEdgeID(edgeID)
+		n.graph.indexRelationship(edgeID, n.ID, newNode.ID)
+	}
 	n.destinations = append(n.destinations, newNode)
-	newNode.addSource(n)
-
-	return nil
+	n.edgeIDs = append(n.edgeIDs, edgeID)
+	n.edgeTypes = append(n.edgeTypes, relType)
+	if newNode == n {
+		// a self relationship, and n is already locked
+		n.sources = append(n.sources, n)
+	} else {
+		newNode.addSource(n)
+	}
+	if undirected && newNode != n {
+		newNode.addDestination(n, edgeID, relType)
+		n.sources = append(n.sources, newNode)
+	}
+	if n.graph != nil {
+		n.graph.setEdgeExpiry(edgeID, expires)
+	}
+	return edgeID, n.committed(Operation{Kind: OpAddRelationship, NodeID: n.ID, OtherID: newNode.ID, EdgeID: edgeID, RelationshipType: relType, Expires: expires})
 }
 
 // addSource provides a way to more easily traverse the graph.
@@ -46,82 +115,229 @@ func (n *Node) addSource(newNode *Node) {
 	n.sources = append(n.sources, newNode)
 }
 
+// addDestination links the other end of a relationship in an undirected graph
+func (n *Node) addDestination(newNode *Node, edgeID uint64, relType string) {
+	n.Lock()
+	defer n.Unlock()
+
+	n.destinations = append(n.destinations, newNode)
+	n.edgeIDs = append(n.edgeIDs, edgeID)
+	n.edgeTypes = append(n.edgeTypes, relType)
+}
+
 // RemoveRelationship removes the edge/relationship between a source node and its destination node
 func (n *Node) RemoveRelationship(oldNode *Node) error {
+	unlock, err := n.lockValidated(func() Operation {
+		return Operation{Kind: OpRemoveRelationship, NodeID: n.ID, OtherID: oldNode.ID}
+	})
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return n.removeRelationship(oldNode)
+}
+
+// removeRelationship is the logic for RemoveRelationship() without validation.
+// The caller must hold the graph lock.
+func (n *Node) removeRelationship(oldNode *Node) error {
+	n.graph.link(n)
+	n.graph.link(oldNode)
 	n.Lock()
 	defer n.Unlock()
 
-	// while typical use would dictate that any node would only have one
-	// relationship to another node, we cannot be sure. remove all relationships.
+	// unless parallel relationships are forbidden there may be several. remove them all.
+	// see RemoveRelationshipByID() to remove one.
+	n.dropDestinations(func(dest *Node, _ uint64) bool { return dest.ID == oldNode.ID })
+
+	if oldNode != n {
+		oldNode.Lock()
+		defer oldNode.Unlock()
+	}
 	var removeSources []*Node
-	for _, n := range n.sources {
-		if n.ID == oldNode.ID {
-			removeSources = append(removeSources, n)
+	for _, s := range oldNode.sources {
+		if s.ID == n.ID {
+			removeSources = append(removeSources, s)
 		}
 	}
-	var removeDestinations []*Node
-	for _, n := range n.destinations {
-		if n.ID == oldNode.ID {
-			removeDestinations = append(removeDestinations, n)
+	oldNode.sources = difference(oldNode.sources, removeSources)
+
+	if n.graph != nil && n.graph.undirected {
+		oldNode.dropDestinations(func(dest *Node, _ uint64) bool { return dest.ID == n.ID })
+		n.sources = difference(n.sources, []*Node{oldNode})
+	}
+	return n.committed(Operation{Kind: OpRemoveRelationship, NodeID: n.ID, OtherID: oldNode.ID})
+}
+
+// SetValue replaces the value stored on the node
+func (n *Node) SetValue(value []byte) error {
+	unlock, err := n.lockValidated(func() Operation {
+		n.RLock()
+		defer n.RUnlock()
+		return Operation{Kind: OpSetValue, NodeID: n.ID, Key: n.Key, Value: value}
+	})
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return n.setValue(value)
+}
+
+// setValue is the logic for SetValue() without validation. The caller must hold the graph lock.
+func (n *Node) setValue(value []byte) error {
+	n.Lock()
+	defer n.Unlock()
+
+	n.Value = value
+	if n.graph != nil {
+		n.graph.search.index(n)
+	}
+	return n.committed(Operation{Kind: OpSetValue, NodeID: n.ID, Key: n.Key, Value: value})
+}
+
+// SetKey replaces the node's key. When the graph does not allow duplicate keys,
+// SetKey fails if another node already uses the key.
+func (n *Node) SetKey(key string) error {
+	unlock, err := n.lockValidated(func() Operation {
+		n.RLock()
+		defer n.RUnlock()
+		return Operation{Kind: OpSetKey, NodeID: n.ID, Key: key, Value: n.Value}
+	})
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return n.setKey(key)
+}
+
+// setKey is the logic for SetKey() without validation. The caller must hold the graph lock.
+func (n *Node) setKey(key string) error {
+	n.Lock()
+	defer n.Unlock()
+
+	if g := n.graph; g != nil {
+		if key != n.Key && g.keyExists(key) {
+			return errors.New(ErrKeyExists)
 		}
+		g.unindexKey(n.Key, n.ID)
+		g.indexKey(key, n.ID)
+	}
+
+	n.Key = key
+	if n.graph != nil {
+		n.graph.search.index(n)
 	}
+	return n.committed(Operation{Kind: OpSetKey, NodeID: n.ID, Key: key, Value: n.Value})
+}
 
-	n.sources = difference(n.sources, removeSources)
-	n.destinations = difference(n.destinations, removeDestinations)
+// committed reports a completed mutation to the owning graph, if the node belongs to a graph,
+// and returns any error from the graph's store. The caller must hold the node's lock.
+func (n *Node) committed(op Operation) error {
+	if n.graph == nil {
+		return nil
+	}
+	return n.graph.committed(op, n)
+}
 
-	return nil
+// lockGraph locks the graph the node belongs to, if any, and links the node's relationships
+func (n *Node) lockGraph() (unlock func()) {
+	if n.graph == nil {
+		return func() {}
+	}
+	n.graph.Lock()
+	n.graph.link(n)
+	return n.graph.Unlock
 }
 
 // ListDestinations lists all nodes that this node points towards
 func (n *Node) ListDestinations() []*Node {
+	unlock := n.lockGraph()
+	defer unlock()
 	n.Lock()
 	defer n.Unlock()
 
-	return n.destinations
+	return append([]*Node(nil), n.destinations...)
 }
 
 // ListSources lists all nodes that point to this node
 func (n *Node) ListSources() []*Node {
+	unlock := n.lockGraph()
+	defer unlock()
 	n.Lock()
 	defer n.Unlock()
 
-	return n.sources
+	return append([]*Node(nil), n.sources...)
 }
 
-// DepthFirstSearch traverses the graph starting at this node to find the otherNode
-func (n *Node) DepthFirstSearch(otherNode *Node) bool {
+// DepthFirstSearch traverses the graph starting at this node to find the otherNode.
+// When labels are given, only nodes carrying all of the labels are visited.
+func (n *Node) DepthFirstSearch(otherNode *Node, labels ...string) bool {
+	unlock := n.lockGraph()
+	defer unlock()
+
+	return n.dfs(otherNode, labels, map[uint64]bool{n.ID: true})
+}
+
+// dfs is the logic for DepthFirstSearch(). seen holds the nodes already visited, so cycles and
+// undirected relationships are followed once. The caller must hold the graph lock.
+func (n *Node) dfs(otherNode *Node, labels []string, seen map[uint64]bool) bool {
+	n.graph.link(n)
 	for _, node := range n.destinations {
-		if node.ID == otherNode.ID || node.DepthFirstSearch(otherNode) {
+		if !node.hasLabels(labels) {
+			continue
+		}
+		if node.ID == otherNode.ID {
+			return true
+		}
+		if seen[node.ID] {
+			continue
+		}
+		seen[node.ID] = true
+		if node.dfs(otherNode, labels, seen) {
 			return true
 		}
 	}
 	return false
 }
 
-// BreadthFirstSearch traverses the graph starting at this node to find the otherNode
-func (n *Node) BreadthFirstSearch(otherNode *Node) bool {
+// BreadthFirstSearch traverses the graph starting at this node to find the otherNode.
+// When labels are given, only nodes carrying all of the labels are visited.
+func (n *Node) BreadthFirstSearch(otherNode *Node, labels ...string) bool {
+	unlock := n.lockGraph()
+	defer unlock()
+
 	// initialize the bfs queue
-	return bfs(otherNode, n.destinations)
+	return bfs(otherNode, n.destinations, labels, make(map[uint64]bool))
 }
 
-// bfs maintains the search queue and is the logic for BreadthFirstSearch()
-func bfs(otherNode *Node, queue []*Node) bool {
+// bfs maintains the search queue and is the logic for BreadthFirstSearch(). seen holds the nodes
+// already queued, so cycles and undirected relationships are followed once. The caller must hold
+// the graph lock.
+func bfs(otherNode *Node, queue []*Node, labels []string, seen map[uint64]bool) bool {
 	if len(queue) == 0 {
 		return false
 	}
 
 	var nextQueue []*Node
 	for _, node := range queue {
+		if !node.hasLabels(labels) {
+			continue
+		}
 		if node.ID == otherNode.ID {
 			return true
 		}
-		nextQueue = append(nextQueue, node.destinations...)
+		node.graph.link(node)
+		for _, dest := range node.destinations {
+			if !seen[dest.ID] {
+				seen[dest.ID] = true
+				nextQueue = append(nextQueue, dest)
+			}
+		}
 	}
 
-	if bfs(otherNode, nextQueue) {
-		return true
-	}
-	return false
+	return bfs(otherNode, nextQueue, labels, seen)
 }
 
 // extractIDs grabs all the ids for the nodes. useful for debugging and in tests.
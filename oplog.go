@@ -0,0 +1,104 @@
+package giraffe
+
+import "errors"
+
+// Log error constants
+const (
+	// ErrNodeNotFound returns when an operation refers to a node that is not in the graph
+	ErrNodeNotFound = "node not found"
+)
+
+// LogEntry is a committed mutation. Seq numbers a graph's mutations from 1 in the order they
+// were committed.
+type LogEntry struct {
+	Seq uint64
+	Op  Operation
+}
+
+// Seq returns the sequence number of the graph's latest mutation. A replica that has applied
+// this sequence number has seen every change made so far. See Follower.WaitFor().
+func (g *Graph) Seq() uint64 {
+	g.Lock()
+	defer g.Unlock()
+
+	return g.seq
+}
+
+// AddListener registers a function that receives every committed mutation, such as to keep a
+// cache or an audit log up to date. Listeners are called in the order they were added, with the
+// graph locked, and must not block or call back into the graph.
+func (g *Graph) AddListener(l func(LogEntry)) {
+	g.Lock()
+	defer g.Unlock()
+
+	g.listen(l)
+}
+
+// listen registers a function that receives every committed mutation. Listeners are called with
+// the graph locked and must not block or call back into the graph. The caller must hold the
+// graph lock.
+func (g *Graph) listen(l func(LogEntry)) {
+	g.listeners = append(g.listeners, l)
+}
+
+// apply makes the change an operation describes without validation, returning the ID of the
+// node it changed. Replicas use it to replay logged operations. An insert without a NodeID is
+// given one by the graph's allocator.
+func (g *Graph) apply(op Operation) (uint64, error) {
+	g.Lock()
+	defer g.Unlock()
+
+	return g.applyLocked(op)
+}
+
+// applyLocked is the logic for apply(). The caller must hold the graph lock.
+func (g *Graph) applyLocked(op Operation) (uint64, error) {
+	if op.Kind == OpInsertNode {
+		n, err := g.insert(op)
+		if err != nil {
+			return 0, err
+		}
+		return n.ID, nil
+	}
+
+	n, ok := g.node(op.NodeID)
+	if !ok {
+		return 0, errors.New(ErrNodeNotFound)
+	}
+	var other *Node
+	if op.Kind == OpAddRelationship || op.Kind == OpRemoveRelationship {
+		if other, ok = g.node(op.OtherID); !ok {
+			return 0, errors.New(ErrNodeNotFound)
+		}
+	}
+
+	var err error
+	switch op.Kind {
+	case OpDeleteNode:
+		err = g.deleteNodeByID(op.NodeID)
+	case OpAddRelationship:
+		_, err = n.addRelationship(other, op.EdgeID, op.RelationshipType, op.Expires)
+	case OpRemoveRelationship:
+		if op.EdgeID != 0 {
+			err = n.removeRelationshipID(other, op.EdgeID)
+			break
+		}
+		err = n.removeRelationship(other)
+	case OpSetValue:
+		err = n.setValue(op.Value)
+	case OpSetKey:
+		err = n.setKey(op.Key)
+	case OpSetProperty:
+		if op.Property == nil {
+			return 0, errors.New("set property without a property")
+		}
+		err = n.setProperty(op.PropertyName, *op.Property)
+	case OpDeleteProperty:
+		err = n.deleteProperty(op.PropertyName)
+	case OpAddLabel:
+		err = n.addLabel(op.Label)
+	case OpRemoveLabel:
+		err = n.removeLabel(op.Label)
+	}
+	return op.NodeID, err
+}
@@ -0,0 +1,369 @@
+package giraffe
+
+import (
+	"container/list"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+)
+
+// Pager error constants
+const (
+	// ErrNotPageFile returns when opening a file that was not written by a PageStore
+	ErrNotPageFile = "not a page file"
+
+	// ErrCorruptFrame returns when a frame of a PageStore's write ahead log fails its checksum
+	ErrCorruptFrame = "corrupt frame"
+)
+
+const (
+	// pageSize is the size of every page in a page file
+	pageSize = 4096
+
+	// pageMagic starts the header page
+	pageMagic = "giraffe\x00"
+
+	// pageWALLimit is the number of page images the write ahead log grows to before it is
+	// copied into the page file
+	pageWALLimit = 1024
+
+	// walPageSize is the size of a page image in the log, including its page number
+	walPageSize = 8 + pageSize
+
+	// DefaultCachePages is the page cache size used when OpenPageStore is given zero
+	DefaultCachePages = 1024
+)
+
+// the B+trees whose root pages are kept in the header
+const (
+	treeNodes = iota
+	treeOut
+	treeIn
+	treeKeys
+	treeMeta
+	treeCount
+)
+
+// pageHeader is the content of page 0
+type pageHeader struct {
+	pageCount uint64
+	freeHead  uint64
+	roots     [treeCount]uint64
+}
+
+// pager reads and writes fixed size pages in a file. Changes are made to dirty pages and
+// committed together: the new page images are appended to a write ahead log as one checksummed
+// frame, and only copied into the page file once the log is large, so the page file always holds
+// a consistent state and a torn frame at the end of the log is discarded on open.
+type pager struct {
+	file *os.File
+	wal  *os.File
+
+	// walIndex maps a page to the offset of its latest image in the log
+	walIndex map[uint64]int64
+	walSize  int64
+
+	header pageHeader
+	dirty  map[uint64][]byte
+
+	// cache holds recently used committed pages, least recently used at the back of lru
+	cache     map[uint64]*list.Element
+	lru       *list.List
+	cacheSize int
+}
+
+// cachedPage is an entry in the page cache
+type cachedPage struct {
+	id   uint64
+	data []byte
+}
+
+// openPager opens or creates a page file and its log, replaying any committed frames
+func openPager(path string, cacheSize int) (*pager, error) {
+	if cacheSize <= 0 {
+		cacheSize = DefaultCachePages
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	wal, err := os.OpenFile(path+"-wal", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	p := &pager{
+		file:      file,
+		wal:       wal,
+		walIndex:  make(map[uint64]int64),
+		dirty:     make(map[uint64][]byte),
+		cache:     make(map[uint64]*list.Element),
+		lru:       list.New(),
+		cacheSize: cacheSize,
+	}
+	if err := p.recover(); err != nil {
+		file.Close()
+		wal.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// recover indexes the frames in the log and loads the header. A frame cut short at the end of
+// the log, or a last frame that fails its checksum, is a torn write and is truncated; any other
+// error is returned.
+func (p *pager) recover() error {
+	info, err := p.wal.Stat()
+	if err != nil {
+		return err
+	}
+	for p.walSize < info.Size() {
+		pages, size, err := readWALFrame(p.wal, p.walSize, info.Size())
+		if err == io.ErrUnexpectedEOF || (err != nil && err.Error() == ErrCorruptFrame && p.walSize+size == info.Size()) {
+			if err := p.wal.Truncate(p.walSize); err != nil {
+				return err
+			}
+			break
+		}
+		if err != nil {
+			return err
+		}
+		for id, offset := range pages {
+			p.walIndex[id] = offset
+		}
+		p.walSize += size
+	}
+
+	info, err = p.file.Stat()
+	if err != nil {
+		return err
+	}
+	if _, ok := p.walIndex[0]; !ok && info.Size() == 0 {
+		p.header = pageHeader{pageCount: 1}
+		return p.commit(true)
+	}
+	return p.loadHeader()
+}
+
+// loadHeader reads the committed header
+func (p *pager) loadHeader() error {
+	data, err := p.get(0)
+	if err != nil {
+		return err
+	}
+	if string(data[:len(pageMagic)]) != pageMagic {
+		return errors.New(ErrNotPageFile)
+	}
+	p.header.pageCount = binary.BigEndian.Uint64(data[8:])
+	p.header.freeHead = binary.BigEndian.Uint64(data[16:])
+	for i := range p.header.roots {
+		p.header.roots[i] = binary.BigEndian.Uint64(data[24+8*i:])
+	}
+	return nil
+}
+
+// encodeHeader builds page 0
+func (p *pager) encodeHeader() []byte {
+	data := make([]byte, pageSize)
+	copy(data, pageMagic)
+	binary.BigEndian.PutUint64(data[8:], p.header.pageCount)
+	binary.BigEndian.PutUint64(data[16:], p.header.freeHead)
+	for i, root := range p.header.roots {
+		binary.BigEndian.PutUint64(data[24+8*i:], root)
+	}
+	return data
+}
+
+// readWALFrame reads a frame from a log of the given size, returning the log offset of each page
+// image in it and the frame's size. It returns io.ErrUnexpectedEOF if the frame runs past size,
+// and ErrCorruptFrame with the frame's size if it fails its checksum.
+func readWALFrame(r io.ReaderAt, offset, size int64) (map[uint64]int64, int64, error) {
+	var header [8]byte
+	if size-offset < int64(len(header)) {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	if _, err := r.ReadAt(header[:], offset); err != nil {
+		return nil, 0, err
+	}
+	count := int64(binary.BigEndian.Uint32(header[0:4]))
+	if size-offset-8 < count*walPageSize {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	if count == 0 {
+		return nil, 8, errors.New(ErrCorruptFrame)
+	}
+	body := make([]byte, count*walPageSize)
+	if _, err := r.ReadAt(body, offset+8); err != nil {
+		return nil, 0, err
+	}
+	if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(header[4:8]) {
+		return nil, 8 + int64(len(body)), errors.New(ErrCorruptFrame)
+	}
+
+	pages := make(map[uint64]int64, count)
+	for i := 0; i < int(count); i++ {
+		at := i * walPageSize
+		pages[binary.BigEndian.Uint64(body[at:])] = offset + 8 + int64(at) + 8
+	}
+	return pages, 8 + int64(len(body)), nil
+}
+
+// get returns a page. The returned slice must not be modified; use write to change a page.
+func (p *pager) get(id uint64) ([]byte, error) {
+	if data, ok := p.dirty[id]; ok {
+		return data, nil
+	}
+	if e, ok := p.cache[id]; ok {
+		p.lru.MoveToFront(e)
+		return e.Value.(*cachedPage).data, nil
+	}
+
+	data := make([]byte, pageSize)
+	var err error
+	if offset, ok := p.walIndex[id]; ok {
+		_, err = p.wal.ReadAt(data, offset)
+	} else {
+		_, err = p.file.ReadAt(data, int64(id)*pageSize)
+	}
+	if err != nil {
+		return nil, err
+	}
+	p.cached(id, data)
+	return data, nil
+}
+
+// cached adds a committed page to the cache, evicting the least recently used pages
+func (p *pager) cached(id uint64, data []byte) {
+	if e, ok := p.cache[id]; ok {
+		e.Value.(*cachedPage).data = data
+		p.lru.MoveToFront(e)
+		return
+	}
+	p.cache[id] = p.lru.PushFront(&cachedPage{id: id, data: data})
+	for p.lru.Len() > p.cacheSize {
+		e := p.lru.Back()
+		p.lru.Remove(e)
+		delete(p.cache, e.Value.(*cachedPage).id)
+	}
+}
+
+// write replaces a page in the current transaction
+func (p *pager) write(id uint64, data []byte) {
+	p.dirty[id] = data
+}
+
+// allocate returns an unused page, reusing freed pages first
+func (p *pager) allocate() (uint64, error) {
+	if id := p.header.freeHead; id != 0 {
+		data, err := p.get(id)
+		if err != nil {
+			return 0, err
+		}
+		p.header.freeHead = binary.BigEndian.Uint64(data)
+		return id, nil
+	}
+	id := p.header.pageCount
+	p.header.pageCount++
+	return id, nil
+}
+
+// free returns a page to the free list
+func (p *pager) free(id uint64) {
+	data := make([]byte, pageSize)
+	binary.BigEndian.PutUint64(data, p.header.freeHead)
+	p.write(id, data)
+	p.header.freeHead = id
+}
+
+// commit appends the dirty pages to the log as one frame
+func (p *pager) commit(sync bool) error {
+	p.write(0, p.encodeHeader())
+	ids := make([]uint64, 0, len(p.dirty))
+	for id := range p.dirty {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	frame := make([]byte, 8, 8+len(ids)*walPageSize)
+	for _, id := range ids {
+		frame = binary.BigEndian.AppendUint64(frame, id)
+		frame = append(frame, p.dirty[id]...)
+	}
+	binary.BigEndian.PutUint32(frame[0:4], uint32(len(ids)))
+	binary.BigEndian.PutUint32(frame[4:8], crc32.ChecksumIEEE(frame[8:]))
+
+	if _, err := p.wal.WriteAt(frame, p.walSize); err != nil {
+		p.rollback()
+		return err
+	}
+	if sync {
+		if err := p.wal.Sync(); err != nil {
+			p.rollback()
+			return err
+		}
+	}
+
+	for i, id := range ids {
+		p.walIndex[id] = p.walSize + 8 + int64(i*walPageSize) + 8
+		p.cached(id, p.dirty[id])
+	}
+	p.walSize += int64(len(frame))
+	p.dirty = make(map[uint64][]byte)
+
+	if p.walSize >= pageWALLimit*walPageSize {
+		return p.checkpoint()
+	}
+	return nil
+}
+
+// rollback discards the dirty pages
+func (p *pager) rollback() {
+	p.dirty = make(map[uint64][]byte)
+	p.loadHeader()
+}
+
+// checkpoint copies the latest page images from the log into the page file and empties the log
+func (p *pager) checkpoint() error {
+	if p.walSize == 0 {
+		return nil
+	}
+	// the log must be durable before the page file is overwritten
+	if err := p.wal.Sync(); err != nil {
+		return err
+	}
+
+	data := make([]byte, pageSize)
+	for id, offset := range p.walIndex {
+		if _, err := p.wal.ReadAt(data, offset); err != nil {
+			return err
+		}
+		if _, err := p.file.WriteAt(data, int64(id)*pageSize); err != nil {
+			return err
+		}
+	}
+	if err := p.file.Sync(); err != nil {
+		return err
+	}
+	if err := p.wal.Truncate(0); err != nil {
+		return err
+	}
+	p.walIndex = make(map[uint64]int64)
+	p.walSize = 0
+	return nil
+}
+
+// close checkpoints the log and closes both files
+func (p *pager) close() error {
+	err := p.checkpoint()
+	if cErr := p.wal.Close(); err == nil {
+		err = cErr
+	}
+	if cErr := p.file.Close(); err == nil {
+		err = cErr
+	}
+	return err
+}
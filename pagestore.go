@@ -0,0 +1,400 @@
+package giraffe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"iter"
+	"sort"
+	"sync"
+)
+
+// keyIndexLimit is the longest key prefix kept in the key tree. Longer keys are matched by
+// reading the node records.
+const keyIndexLimit = maxTreeKey - 2 - 8
+
+// pageStoreBatch is the number of node records Nodes() reads per lock
+const pageStoreBatch = 256
+
+// PageStore is an on disk Store built from B+trees in 4KB pages, for graphs larger than memory.
+// Node records are kept in a tree keyed by node ID, relationships in an adjacency tree keyed by
+// (source, label, destination) and a reverse tree keyed by (destination, label, source), and keys
+// in a tree of their own so none of them have to be loaded to be found. Recently used pages are
+// kept in a page cache. Each change is committed through a write ahead log, so a crash leaves
+// either all or none of it on disk. Relationships are unlabeled, so the label is always 0.
+type PageStore struct {
+	sync.Mutex
+	p *pager
+
+	nodes btree
+	out   btree
+	in    btree
+	keys  btree
+	meta  btree
+
+	// SyncWrites makes every write wait for the log to reach stable storage
+	SyncWrites bool
+}
+
+// OpenPageStore opens or creates a PageStore at path, caching up to cachePages pages in memory.
+// A cachePages of zero uses DefaultCachePages. The write ahead log is kept at path + "-wal".
+func OpenPageStore(path string, cachePages int) (*PageStore, error) {
+	p, err := openPager(path, cachePages)
+	if err != nil {
+		return nil, err
+	}
+	return &PageStore{
+		p:     p,
+		nodes: btree{p: p, tree: treeNodes},
+		out:   btree{p: p, tree: treeOut},
+		in:    btree{p: p, tree: treeIn},
+		keys:  btree{p: p, tree: treeKeys},
+		meta:  btree{p: p, tree: treeMeta},
+	}, nil
+}
+
+// update runs fn as a single transaction
+func (s *PageStore) update(fn func() error) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if err := fn(); err != nil {
+		s.p.rollback()
+		return err
+	}
+	return s.p.commit(s.SyncWrites)
+}
+
+// GetNode satisfies the Store interface
+func (s *PageStore) GetNode(ID uint64) (NodeRecord, bool, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.getNode(ID)
+}
+
+// getNode reads a node record
+func (s *PageStore) getNode(ID uint64) (NodeRecord, bool, error) {
+	data, ok, err := s.nodes.get(nodeTreeKey(ID))
+	if err != nil || !ok {
+		return NodeRecord{}, false, err
+	}
+	rec, err := decodeNodeRecord(data)
+	return rec, err == nil, err
+}
+
+// PutNode satisfies the Store interface
+func (s *PageStore) PutNode(rec NodeRecord) error {
+	return s.Update(func(b StoreBatch) error { return b.PutNode(rec) })
+}
+
+// putNode writes a node record and its key
+func (s *PageStore) putNode(rec NodeRecord) error {
+	w := new(bytes.Buffer)
+	if err := gob.NewEncoder(w).Encode(rec); err != nil {
+		return err
+	}
+	old, ok, err := s.getNode(rec.ID)
+	if err != nil {
+		return err
+	}
+	if ok {
+		if _, err := s.keys.delete(keyTreeKey(old.Key, old.ID)); err != nil {
+			return err
+		}
+	}
+	if err := s.nodes.put(nodeTreeKey(rec.ID), w.Bytes()); err != nil {
+		return err
+	}
+	return s.keys.put(keyTreeKey(rec.Key, rec.ID), nil)
+}
+
+// DeleteNode satisfies the Store interface
+func (s *PageStore) DeleteNode(ID uint64) error {
+	return s.Update(func(b StoreBatch) error { return b.DeleteNode(ID) })
+}
+
+// deleteNode removes a node record, its key and its relationships
+func (s *PageStore) deleteNode(ID uint64) error {
+	old, ok, err := s.getNode(ID)
+	if err != nil || !ok {
+		return err
+	}
+	if _, err := s.keys.delete(keyTreeKey(old.Key, ID)); err != nil {
+		return err
+	}
+	if err := s.setAdjacency(ID, nil); err != nil {
+		return err
+	}
+	_, err = s.nodes.delete(nodeTreeKey(ID))
+	return err
+}
+
+// Adjacency satisfies the Store interface
+func (s *PageStore) Adjacency(ID uint64) ([]uint64, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	edges, err := s.outEdges(ID)
+	if err != nil {
+		return nil, err
+	}
+	destinations := make([]uint64, len(edges))
+	for i, e := range edges {
+		destinations[i] = e.dest
+	}
+	return destinations, nil
+}
+
+// Sources returns the IDs of the nodes with a relationship to the node, in ascending order,
+// from the reverse adjacency tree
+func (s *PageStore) Sources(ID uint64) ([]uint64, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	prefix := nodeTreeKey(ID)
+	var sources []uint64
+	err := s.in.ascend(prefix, func(key, value []byte) bool {
+		if !bytes.HasPrefix(key, prefix) {
+			return false
+		}
+		sources = append(sources, binary.BigEndian.Uint64(key[16:]))
+		return true
+	})
+	return sources, err
+}
+
+// PutAdjacency satisfies the Store interface
+func (s *PageStore) PutAdjacency(ID uint64, destinations []uint64) error {
+	return s.Update(func(b StoreBatch) error { return b.PutAdjacency(ID, destinations) })
+}
+
+// adjacentEdge is a relationship read from the adjacency tree. seq orders a node's relationships.
+type adjacentEdge struct {
+	dest uint64
+	seq  uint64
+}
+
+// outEdges reads a node's relationships in the order they were added
+func (s *PageStore) outEdges(ID uint64) ([]adjacentEdge, error) {
+	prefix := nodeTreeKey(ID)
+	var edges []adjacentEdge
+	err := s.out.ascend(prefix, func(key, value []byte) bool {
+		if !bytes.HasPrefix(key, prefix) {
+			return false
+		}
+		// the value holds the sequence number of each relationship to the destination
+		dest := binary.BigEndian.Uint64(key[16:])
+		for i := 0; i+8 <= len(value); i += 8 {
+			edges = append(edges, adjacentEdge{dest: dest, seq: binary.BigEndian.Uint64(value[i:])})
+		}
+		return true
+	})
+	sort.Slice(edges, func(i, j int) bool { return edges[i].seq < edges[j].seq })
+	return edges, err
+}
+
+// setAdjacency replaces a node's relationships. Relationships that keep their order are left in
+// place and the rest are appended, so adding or removing one relationship rewrites one entry.
+func (s *PageStore) setAdjacency(ID uint64, destinations []uint64) error {
+	edges, err := s.outEdges(ID)
+	if err != nil {
+		return err
+	}
+	var next uint64
+	if len(edges) > 0 {
+		next = edges[len(edges)-1].seq + 1
+	}
+
+	seqs := make(map[uint64][]uint64)
+	changed := make(map[uint64]bool)
+	j := 0
+	for _, e := range edges {
+		if j < len(destinations) && destinations[j] == e.dest {
+			seqs[e.dest] = append(seqs[e.dest], e.seq)
+			j++
+			continue
+		}
+		changed[e.dest] = true
+	}
+	for _, dest := range destinations[j:] {
+		seqs[dest] = append(seqs[dest], next)
+		changed[dest] = true
+		next++
+	}
+
+	for _, dest := range sortedIDs(changed) {
+		if len(seqs[dest]) == 0 {
+			if _, err := s.out.delete(edgeTreeKey(ID, dest)); err != nil {
+				return err
+			}
+			if _, err := s.in.delete(edgeTreeKey(dest, ID)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		value := make([]byte, 0, 8*len(seqs[dest]))
+		for _, seq := range seqs[dest] {
+			value = binary.BigEndian.AppendUint64(value, seq)
+		}
+		if err := s.out.put(edgeTreeKey(ID, dest), value); err != nil {
+			return err
+		}
+		if err := s.in.put(edgeTreeKey(dest, ID), nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NodeIDsByKey satisfies the Store interface
+func (s *PageStore) NodeIDsByKey(key string) ([]uint64, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	prefix := keyTreeKey(key, 0)
+	prefix = prefix[:len(prefix)-8]
+	var ids []uint64
+	err := s.keys.ascend(prefix, func(k, value []byte) bool {
+		if !bytes.HasPrefix(k, prefix) || len(k) != len(prefix)+8 {
+			return false
+		}
+		ids = append(ids, binary.BigEndian.Uint64(k[len(prefix):]))
+		return true
+	})
+	if err != nil || len(key) <= keyIndexLimit {
+		return ids, err
+	}
+
+	// only a prefix of long keys is indexed
+	matched := ids[:0]
+	for _, id := range ids {
+		rec, ok, err := s.getNode(id)
+		if err != nil {
+			return nil, err
+		}
+		if ok && rec.Key == key {
+			matched = append(matched, id)
+		}
+	}
+	return matched, nil
+}
+
+// Nodes satisfies the Store interface. Records are read from disk a batch at a time.
+func (s *PageStore) Nodes() iter.Seq2[NodeRecord, error] {
+	return func(yield func(NodeRecord, error) bool) {
+		start := nodeTreeKey(0)
+		for {
+			var batch [][]byte
+			var last []byte
+			s.Lock()
+			err := s.nodes.ascend(start, func(key, value []byte) bool {
+				batch = append(batch, value)
+				last = key
+				return len(batch) < pageStoreBatch
+			})
+			s.Unlock()
+			if err != nil {
+				yield(NodeRecord{}, err)
+				return
+			}
+
+			for _, data := range batch {
+				if !yield(decodeNodeRecord(data)) {
+					return
+				}
+			}
+			if len(batch) < pageStoreBatch {
+				return
+			}
+			start = nodeTreeKey(binary.BigEndian.Uint64(last) + 1)
+		}
+	}
+}
+
+// Meta satisfies the Store interface
+func (s *PageStore) Meta(name string) ([]byte, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	value, _, err := s.meta.get([]byte(name))
+	return value, err
+}
+
+// SetMeta satisfies the Store interface
+func (s *PageStore) SetMeta(name string, value []byte) error {
+	return s.Update(func(b StoreBatch) error { return b.SetMeta(name, value) })
+}
+
+// Update satisfies the Store interface. The batch's writes are made to the trees as fn adds them
+// and committed through the write ahead log as one transaction.
+func (s *PageStore) Update(fn func(StoreBatch) error) error {
+	return s.update(func() error { return fn(pageBatch{s}) })
+}
+
+// pageBatch is the StoreBatch of a PageStore. It writes to the store's trees with its lock held.
+type pageBatch struct {
+	s *PageStore
+}
+
+// PutNode satisfies the StoreBatch interface
+func (b pageBatch) PutNode(rec NodeRecord) error {
+	return b.s.putNode(rec)
+}
+
+// DeleteNode satisfies the StoreBatch interface
+func (b pageBatch) DeleteNode(ID uint64) error {
+	return b.s.deleteNode(ID)
+}
+
+// PutAdjacency satisfies the StoreBatch interface
+func (b pageBatch) PutAdjacency(ID uint64, destinations []uint64) error {
+	return b.s.setAdjacency(ID, destinations)
+}
+
+// SetMeta satisfies the StoreBatch interface
+func (b pageBatch) SetMeta(name string, value []byte) error {
+	return b.s.meta.put([]byte(name), value)
+}
+
+// Close satisfies the Store interface. The write ahead log is copied into the page file first.
+func (s *PageStore) Close() error {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.p.close()
+}
+
+// decodeNodeRecord decodes a record from the node tree
+func decodeNodeRecord(data []byte) (NodeRecord, error) {
+	var rec NodeRecord
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&rec)
+	return rec, err
+}
+
+// nodeTreeKey is a node's key in the node tree
+func nodeTreeKey(ID uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, ID)
+}
+
+// edgeTreeKey is a relationship's key in an adjacency tree
+func edgeTreeKey(from, to uint64) []byte {
+	key := make([]byte, 24)
+	binary.BigEndian.PutUint64(key, from)
+	// relationships are unlabeled
+	binary.BigEndian.PutUint64(key[8:], 0)
+	binary.BigEndian.PutUint64(key[16:], to)
+	return key
+}
+
+// keyTreeKey is a node's entry in the key tree: the length of the indexed key prefix, the prefix
+// and the node's ID
+func keyTreeKey(key string, ID uint64) []byte {
+	if len(key) > keyIndexLimit {
+		key = key[:keyIndexLimit]
+	}
+	k := binary.BigEndian.AppendUint16(nil, uint16(len(key)))
+	k = append(k, key...)
+	return binary.BigEndian.AppendUint64(k, ID)
+}
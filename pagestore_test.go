@@ -0,0 +1,171 @@
+package giraffe
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPageStoreGraph(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "graph.db")
+	store, err := OpenPageStore(path, 4)
+	if err != nil {
+		t.Fatalf("unable to open store, error: %v", err)
+	}
+	g := buildStoreGraph(t, store)
+	if sources, _ := store.Sources(2); !reflect.DeepEqual(sources, []uint64{1}) {
+		t.Errorf("got sources %v, want [1]", sources)
+	}
+	if err := g.Close(); err != nil {
+		t.Fatalf("unable to close graph, error: %v", err)
+	}
+
+	store, _ = OpenPageStore(path, 4)
+	g, err = OpenGraph(store)
+	if err != nil {
+		t.Fatalf("unable to open graph, error: %v", err)
+	}
+	checkStoreGraph(t, g)
+	g.Close()
+}
+
+func TestPageStoreLazyGraph(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "graph.db")
+	page, _ := OpenPageStore(path, 4)
+	buildStoreGraph(t, page).Close()
+
+	page, _ = OpenPageStore(path, 4)
+	store := &countingStore{Store: page}
+	g, err := OpenGraph(store)
+	if err != nil {
+		t.Fatalf("unable to open graph, error: %v", err)
+	}
+	defer g.Close()
+	if store.reads != 0 {
+		t.Errorf("opening read %d nodes, want 0", store.reads)
+	}
+	if n, ok := g.FindNodeByKey("Factoring"); !ok || n.ID != 2 || store.reads != 1 {
+		t.Errorf("got node %v after %d reads, want node 2 after 1", n, store.reads)
+	}
+	checkStoreGraph(t, g)
+}
+
+func TestPageStoreAdjacencyOrder(t *testing.T) {
+	store, _ := OpenPageStore(filepath.Join(t.TempDir(), "graph.db"), 0)
+	defer store.Close()
+
+	steps := [][]uint64{
+		{5, 3, 9},
+		{5, 3, 9, 1},
+		{5, 9, 1},
+		{1, 5, 9, 5},
+		{},
+	}
+	for _, destinations := range steps {
+		store.PutAdjacency(7, destinations)
+		got, err := store.Adjacency(7)
+		if err != nil || len(got) != len(destinations) || (len(got) > 0 && !reflect.DeepEqual(got, destinations)) {
+			t.Errorf("got %v, %v, want %v", got, err, destinations)
+		}
+	}
+	if sources, _ := store.Sources(5); len(sources) != 0 {
+		t.Errorf("got sources %v, want none", sources)
+	}
+}
+
+func TestPageStoreLongKeysAndLargeGraph(t *testing.T) {
+	store, _ := OpenPageStore(filepath.Join(t.TempDir(), "graph.db"), 8)
+	defer store.Close()
+
+	long := strings.Repeat("k", 600)
+	store.PutNode(NodeRecord{ID: 1, Key: long + "a"})
+	store.PutNode(NodeRecord{ID: 2, Key: long + "b"})
+	if ids, _ := store.NodeIDsByKey(long + "b"); !reflect.DeepEqual(ids, []uint64{2}) {
+		t.Errorf("got ids %v, want [2]", ids)
+	}
+
+	for i := uint64(3); i < 1000; i++ {
+		store.PutNode(NodeRecord{ID: i, Key: "node", Value: []byte(strings.Repeat("v", int(i)))})
+	}
+	count := 0
+	for rec, err := range store.Nodes() {
+		if err != nil {
+			t.Fatalf("unable to read nodes, error: %v", err)
+		}
+		if rec.ID >= 3 && len(rec.Value) != int(rec.ID) {
+			t.Fatalf("got value length %d for node %d", len(rec.Value), rec.ID)
+		}
+		count++
+	}
+	if count != 999 {
+		t.Errorf("got %d nodes, want 999", count)
+	}
+	if ids, _ := store.NodeIDsByKey("node"); len(ids) != 997 {
+		t.Errorf("got %d ids, want 997", len(ids))
+	}
+}
+
+func TestPageStoreCrashRecovery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "graph.db")
+	store, _ := OpenPageStore(path, 0)
+	store.PutNode(NodeRecord{ID: 1, Key: "first"})
+	store.PutNode(NodeRecord{ID: 2, Key: "second"})
+	// the process dies without closing the store, tearing the last frame of the log
+	info, _ := os.Stat(path + "-wal")
+	os.Truncate(path+"-wal", info.Size()-10)
+
+	store, err := OpenPageStore(path, 0)
+	if err != nil {
+		t.Fatalf("unable to reopen store, error: %v", err)
+	}
+	defer store.Close()
+	if _, ok, _ := store.GetNode(1); !ok {
+		t.Error("committed node lost")
+	}
+	if _, ok, _ := store.GetNode(2); ok {
+		t.Error("torn write applied")
+	}
+	if err := store.PutNode(NodeRecord{ID: 3, Key: "third"}); err != nil {
+		t.Errorf("unable to write after recovery, error: %v", err)
+	}
+	if ids, _ := store.NodeIDsByKey("third"); !reflect.DeepEqual(ids, []uint64{3}) {
+		t.Errorf("got ids %v, want [3]", ids)
+	}
+}
+
+func TestPageStoreCorruptFrame(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "graph.db")
+	store, _ := OpenPageStore(path, 0)
+	store.PutNode(NodeRecord{ID: 1, Key: "first"})
+	store.PutNode(NodeRecord{ID: 2, Key: "second"})
+	data, _ := os.ReadFile(path + "-wal")
+
+	// a damaged frame before the end of the log is not a torn write
+	corrupt := append([]byte(nil), data...)
+	corrupt[16] ^= 0xff
+	os.WriteFile(path+"-wal", corrupt, 0644)
+	if _, err := OpenPageStore(path, 0); err == nil || err.Error() != ErrCorruptFrame {
+		t.Errorf("got `%v`, want `%s`", err, ErrCorruptFrame)
+	}
+	if info, _ := os.Stat(path + "-wal"); info.Size() != int64(len(data)) {
+		t.Errorf("corrupt log truncated to %d bytes", info.Size())
+	}
+
+	// a last frame that fails its checksum is
+	torn := append([]byte(nil), data...)
+	torn[len(torn)-1] ^= 0xff
+	os.WriteFile(path+"-wal", torn, 0644)
+	store, err := OpenPageStore(path, 0)
+	if err != nil {
+		t.Fatalf("unable to reopen store, error: %v", err)
+	}
+	defer store.Close()
+	if _, ok, _ := store.GetNode(1); !ok {
+		t.Error("committed node lost")
+	}
+	if _, ok, _ := store.GetNode(2); ok {
+		t.Error("torn write applied")
+	}
+}
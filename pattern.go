@@ -0,0 +1,109 @@
+package giraffe
+
+import (
+	"iter"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// FindNodesByKeyPattern returns the nodes whose key matches a glob pattern, ordered by key and then ID.
+// In the pattern '*' matches any run of characters, '?' matches a single character and '[...]'
+// matches a character class. offset skips that many matches and a limit of zero or less returns
+// every match after the offset. It returns any error reading the graph's nodes from its store.
+func (g *Graph) FindNodesByKeyPattern(pattern string, offset, limit int) (iter.Seq[*Node], error) {
+	re, err := regexp.Compile(globToRegexp(pattern))
+	if err != nil {
+		return nil, err
+	}
+	g.Lock()
+	err = g.loadAll()
+	g.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return g.FindNodesByKeyRegexp(re, offset, limit), nil
+}
+
+// FindNodesByKeyRegexp returns the nodes whose key matches the regular expression, ordered by
+// key and then ID. See FindNodesByKeyPattern() for offset and limit. Use anchors to match
+// the whole key.
+func (g *Graph) FindNodesByKeyRegexp(re *regexp.Regexp, offset, limit int) iter.Seq[*Node] {
+	g.Lock()
+	g.loadAll()
+	var matches []*Node
+	for _, node := range g.nodes {
+		node.RLock()
+		if re.MatchString(node.Key) {
+			matches = append(matches, node)
+		}
+		node.RUnlock()
+	}
+	g.Unlock()
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Key != matches[j].Key {
+			return matches[i].Key < matches[j].Key
+		}
+		return matches[i].ID < matches[j].ID
+	})
+	matches = paginate(matches, offset, limit)
+
+	return func(yield func(*Node) bool) {
+		for _, node := range matches {
+			if !yield(node) {
+				return
+			}
+		}
+	}
+}
+
+// paginate applies an offset and limit to a list of nodes. A limit of zero or less means no limit.
+func paginate(nodes []*Node, offset, limit int) []*Node {
+	if offset < 0 {
+		offset = 0
+	}
+	if offset >= len(nodes) {
+		return nil
+	}
+	nodes = nodes[offset:]
+	if limit > 0 && len(nodes) > limit {
+		nodes = nodes[:limit]
+	}
+	return nodes
+}
+
+// globToRegexp translates a glob pattern into an anchored regular expression
+func globToRegexp(pattern string) string {
+	var re strings.Builder
+	re.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '*':
+			re.WriteString("(?s:.*)")
+		case '?':
+			re.WriteString("(?s:.)")
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				re.WriteString(`\[`)
+				continue
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			re.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+			}
+			re.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		default:
+			re.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	re.WriteString("$")
+	return re.String()
+}
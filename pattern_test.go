@@ -0,0 +1,61 @@
+package giraffe
+
+import (
+	"reflect"
+	"regexp"
+	"slices"
+	"testing"
+)
+
+func newPatternTestGraph() *Graph {
+	g, _ := NewGraph("testGraph")
+	for _, key := range []string{"lesson_3", "quiz_1", "lesson_1", "lesson_10", "lesson_2", "Lesson_4"} {
+		g.InsertDataNode(key, nil)
+	}
+	return g
+}
+
+func TestFindNodesByKeyPattern(t *testing.T) {
+	g := newPatternTestGraph()
+
+	tests := []struct {
+		pattern       string
+		offset, limit int
+		want          []string
+	}{
+		{"lesson_*", 0, 0, []string{"lesson_1", "lesson_10", "lesson_2", "lesson_3"}},
+		{"lesson_?", 0, 0, []string{"lesson_1", "lesson_2", "lesson_3"}},
+		{"[lL]esson_[!1]", 0, 0, []string{"Lesson_4", "lesson_2", "lesson_3"}},
+		{"lesson_*", 1, 2, []string{"lesson_10", "lesson_2"}},
+		{"lesson_*", 10, 0, nil},
+		{"*_1", 0, 0, []string{"lesson_1", "quiz_1"}},
+	}
+	for _, test := range tests {
+		nodes, err := g.FindNodesByKeyPattern(test.pattern, test.offset, test.limit)
+		if err != nil {
+			t.Fatalf("unexpected error for %q: %v", test.pattern, err)
+		}
+		got := nodeKeys(slices.Collect(nodes))
+		if len(got) == 0 && len(test.want) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q offset %d limit %d: got %v, want %v", test.pattern, test.offset, test.limit, got, test.want)
+		}
+	}
+}
+
+func TestFindNodesByKeyRegexp(t *testing.T) {
+	g := newPatternTestGraph()
+
+	var got []string
+	for node := range g.FindNodesByKeyRegexp(regexp.MustCompile(`(?i)^lesson_\d$`), 0, 0) {
+		got = append(got, node.Key)
+		if len(got) == 2 {
+			break
+		}
+	}
+	if want := []string{"Lesson_4", "lesson_1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
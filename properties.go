@@ -0,0 +1,233 @@
+package giraffe
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// PropertyType identifies the type of value held by a Property
+type PropertyType uint8
+
+// Property types
+const (
+	PropString PropertyType = iota + 1
+	PropInt
+	PropFloat
+	PropBool
+	PropTime
+	PropBytes
+	PropList
+)
+
+// String satisfies the Stringer interface
+func (t PropertyType) String() string {
+	switch t {
+	case PropString:
+		return "string"
+	case PropInt:
+		return "int"
+	case PropFloat:
+		return "float"
+	case PropBool:
+		return "bool"
+	case PropTime:
+		return "time"
+	case PropBytes:
+		return "bytes"
+	case PropList:
+		return "list"
+	}
+	return fmt.Sprintf("type %d", int(t))
+}
+
+// Property is a typed value stored on a node alongside its Key and Value.
+// Only the field matching Type is meaningful. Use the StringProp(), IntProp(), ... constructors.
+type Property struct {
+	Type  PropertyType
+	Str   string
+	Int   int64
+	Float float64
+	Bool  bool
+	Time  time.Time
+	Bytes []byte
+	List  []Property
+}
+
+// StringProp creates a string property
+func StringProp(s string) Property { return Property{Type: PropString, Str: s} }
+
+// IntProp creates an integer property
+func IntProp(i int64) Property { return Property{Type: PropInt, Int: i} }
+
+// FloatProp creates a floating point property
+func FloatProp(f float64) Property { return Property{Type: PropFloat, Float: f} }
+
+// BoolProp creates a boolean property
+func BoolProp(b bool) Property { return Property{Type: PropBool, Bool: b} }
+
+// TimeProp creates a time property
+func TimeProp(t time.Time) Property { return Property{Type: PropTime, Time: t} }
+
+// BytesProp creates a byte slice property
+func BytesProp(b []byte) Property { return Property{Type: PropBytes, Bytes: b} }
+
+// ListProp creates a list property
+func ListProp(items ...Property) Property { return Property{Type: PropList, List: items} }
+
+// Interface returns the property's value as a string, int64, float64, bool, time.Time, []byte or []interface{}
+func (p Property) Interface() interface{} {
+	switch p.Type {
+	case PropString:
+		return p.Str
+	case PropInt:
+		return p.Int
+	case PropFloat:
+		return p.Float
+	case PropBool:
+		return p.Bool
+	case PropTime:
+		return p.Time
+	case PropBytes:
+		return p.Bytes
+	case PropList:
+		list := make([]interface{}, len(p.List))
+		for i, item := range p.List {
+			list[i] = item.Interface()
+		}
+		return list
+	}
+	return nil
+}
+
+// String satisfies the Stringer interface
+func (p Property) String() string {
+	switch p.Type {
+	case PropBytes:
+		return string(p.Bytes)
+	case PropTime:
+		return p.Time.Format(time.RFC3339Nano)
+	case PropList:
+		items := make([]string, len(p.List))
+		for i, item := range p.List {
+			items[i] = item.String()
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	}
+	return fmt.Sprint(p.Interface())
+}
+
+// clone returns a deep copy of the property, so its Bytes and List are not shared
+func (p Property) clone() Property {
+	if p.Bytes != nil {
+		p.Bytes = append([]byte(nil), p.Bytes...)
+	}
+	if p.List != nil {
+		list := make([]Property, len(p.List))
+		for i, item := range p.List {
+			list[i] = item.clone()
+		}
+		p.List = list
+	}
+	return p
+}
+
+// Property returns a copy of the named property
+func (n *Node) Property(name string) (Property, bool) {
+	n.RLock()
+	defer n.RUnlock()
+
+	p, ok := n.properties[name]
+	return p.clone(), ok
+}
+
+// Properties returns a copy of all of the node's properties
+func (n *Node) Properties() map[string]Property {
+	n.RLock()
+	defer n.RUnlock()
+
+	props := make(map[string]Property, len(n.properties))
+	for name, p := range n.properties {
+		props[name] = p.clone()
+	}
+	return props
+}
+
+// PropertyNames returns the names of the node's properties in sorted order
+func (n *Node) PropertyNames() []string {
+	n.RLock()
+	defer n.RUnlock()
+
+	names := make([]string, 0, len(n.properties))
+	for name := range n.properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SetProperty stores a copy of a typed property on the node, replacing any previous value
+func (n *Node) SetProperty(name string, p Property) error {
+	unlock, err := n.lockValidated(func() Operation {
+		return Operation{Kind: OpSetProperty, NodeID: n.ID, PropertyName: name, Property: &p}
+	})
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return n.setProperty(name, p)
+}
+
+// setProperty is the logic for SetProperty() without validation. The caller must hold the graph lock.
+func (n *Node) setProperty(name string, p Property) error {
+	n.Lock()
+	defer n.Unlock()
+
+	if n.properties == nil {
+		n.properties = make(map[string]Property)
+	}
+	p = p.clone()
+	if n.graph != nil {
+		var oldp *Property
+		if old, ok := n.properties[name]; ok {
+			oldp = &old
+		}
+		n.graph.indexProperty(n.ID, name, oldp, &p)
+	}
+	n.properties[name] = p
+	return n.committed(Operation{Kind: OpSetProperty, NodeID: n.ID, PropertyName: name, Property: &p})
+}
+
+// DeleteProperty removes a property from the node
+func (n *Node) DeleteProperty(name string) error {
+	unlock, err := n.lockValidated(func() Operation {
+		return Operation{Kind: OpDeleteProperty, NodeID: n.ID, PropertyName: name}
+	})
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return n.deleteProperty(name)
+}
+
+// deleteProperty is the logic for DeleteProperty() without validation. The caller must hold the
+// graph lock.
+func (n *Node) deleteProperty(name string) error {
+	n.Lock()
+	defer n.Unlock()
+
+	if old, ok := n.properties[name]; ok && n.graph != nil {
+		n.graph.indexProperty(n.ID, name, &old, nil)
+	}
+	delete(n.properties, name)
+	return n.committed(Operation{Kind: OpDeleteProperty, NodeID: n.ID, PropertyName: name})
+}
+
+// InsertPropertyNode inserts a node of the given kind with a key, value and initial properties.
+// The properties are checked by the schema as a whole, so required properties can be supplied.
+func (g *Graph) InsertPropertyNode(kind, key string, value []byte, props map[string]Property) (*Node, error) {
+	return g.insertDataNode(Operation{Kind: OpInsertNode, NodeKind: kind, Key: key, Value: value, Properties: props})
+}
@@ -0,0 +1,103 @@
+package giraffe
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNodeProperties(t *testing.T) {
+	g, _ := NewGraph("testGraph")
+	n1, _ := g.InsertDataNode("Quadratic Formula", []byte("lesson_id 55"))
+
+	published := time.Date(2015, 7, 5, 0, 0, 0, 0, time.UTC)
+	n1.SetProperty("lesson_id", IntProp(55))
+	n1.SetProperty("difficulty", FloatProp(4.5))
+	n1.SetProperty("published", TimeProp(published))
+	n1.SetProperty("tags", ListProp(StringProp("algebra"), StringProp("quadratics")))
+
+	p, ok := n1.Property("lesson_id")
+	if !ok || p.Type != PropInt || p.Int != 55 {
+		t.Errorf("got property %+v, want int 55", p)
+	}
+	if p, _ := n1.Property("published"); !p.Time.Equal(published) {
+		t.Errorf("got time %v, want %v", p.Time, published)
+	}
+	if got, want := n1.PropertyNames(), []string{"difficulty", "lesson_id", "published", "tags"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got names %v, want %v", got, want)
+	}
+	if p, _ := n1.Property("tags"); p.String() != "[algebra, quadratics]" {
+		t.Errorf("got `%s`, want `[algebra, quadratics]`", p.String())
+	}
+
+	n1.DeleteProperty("difficulty")
+	if _, ok := n1.Property("difficulty"); ok {
+		t.Error("property should have been deleted")
+	}
+
+	// slices are copied in and out
+	raw := []byte("abc")
+	n1.SetProperty("raw", BytesProp(raw))
+	raw[0] = 'x'
+	p, _ = n1.Property("raw")
+	p.Bytes[1] = 'y'
+	tags, _ := n1.Property("tags")
+	tags.List[0].Str = "geometry"
+	n1.Properties()["tags"].List[1].Str = "geometry"
+	if p, _ := n1.Property("raw"); string(p.Bytes) != "abc" {
+		t.Errorf("got bytes %q, want abc", p.Bytes)
+	}
+	if p, _ := n1.Property("tags"); p.String() != "[algebra, quadratics]" {
+		t.Errorf("got `%s`, want `[algebra, quadratics]`", p.String())
+	}
+}
+
+func TestSchemaRequiredProperties(t *testing.T) {
+	g, _ := NewGraph("testGraph")
+	g.SetSchema(&Schema{
+		Kinds: map[string]KindDef{
+			"Lesson": {
+				Required:   []string{"lesson_id"},
+				Properties: map[string]PropertyType{"lesson_id": PropInt},
+			},
+		},
+	})
+
+	if _, err := g.InsertKindNode("Lesson", "Intro", nil); err == nil {
+		t.Error("expected schema to require lesson_id")
+	}
+	if _, err := g.InsertPropertyNode("Lesson", "Intro", nil, map[string]Property{"lesson_id": StringProp("1")}); err == nil {
+		t.Error("expected schema to reject a string lesson_id")
+	}
+
+	n1, err := g.InsertPropertyNode("Lesson", "Intro", nil, map[string]Property{"lesson_id": IntProp(1)})
+	if err != nil {
+		t.Fatalf("expected no error, got `%v`", err)
+	}
+	if err := n1.SetProperty("lesson_id", BoolProp(true)); err == nil {
+		t.Error("expected schema to reject a bool lesson_id")
+	}
+	if err := n1.DeleteProperty("lesson_id"); err == nil {
+		t.Error("expected schema to reject deleting a required property")
+	}
+}
+
+func TestPropertiesEncodeDecode(t *testing.T) {
+	g, _ := NewGraph("testGraph")
+	n1, _ := g.InsertDataNode("Intro", nil)
+	n1.SetProperty("lesson_id", IntProp(1))
+	n1.SetProperty("tags", ListProp(StringProp("algebra"), BytesProp([]byte("raw"))))
+
+	data, err := g.GobEncode()
+	if err != nil {
+		t.Fatalf("unable to encode graph - err `%v`", err)
+	}
+	decodedGraph := &Graph{}
+	if err := decodedGraph.GobDecode(data); err != nil {
+		t.Fatalf("unable to decode graph - err `%v`", err)
+	}
+
+	if got, want := decodedGraph.nodes[n1.ID].Properties(), n1.Properties(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got properties %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,1072 @@
+package giraffe
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Raft error constants
+const (
+	// ErrNotLeader returns when a change is proposed to a member that is not the leader, or the
+	// leader lost its leadership before the change was committed. See RaftNode.Leader().
+	ErrNotLeader = "not leader"
+
+	// ErrMembershipChange returns when a membership change is proposed while another is pending
+	ErrMembershipChange = "membership change in progress"
+
+	// ErrUnreachable returns when a transport cannot deliver a message
+	ErrUnreachable = "unreachable"
+)
+
+// RaftConfig tunes a RaftNode. Zero fields use the defaults.
+type RaftConfig struct {
+	// HeartbeatInterval is how often the leader replicates its log. Defaults to 50ms.
+	HeartbeatInterval time.Duration
+
+	// ElectionTimeout is the shortest time a follower waits to hear from a leader before
+	// starting an election. Each wait is chosen at random between it and twice it.
+	// Defaults to 500ms.
+	ElectionTimeout time.Duration
+
+	// SnapshotThreshold is the number of applied entries kept in the log before it is replaced
+	// by a snapshot of the graph. Defaults to 1000.
+	SnapshotThreshold int
+
+	// ProposalTimeout is how long Apply() waits for a change to commit. Defaults to 5s.
+	ProposalTimeout time.Duration
+
+	// Store, if set, keeps the member's term, vote, log and snapshot in its meta entries. They
+	// are written before the member answers a message, and a member started on a store that
+	// holds them carries on from them. See NewRaftNode().
+	Store Store
+}
+
+// Raft state kept in RaftConfig.Store: the term, vote and last log index, the latest snapshot
+// and the log entries after it. see raftEntryKey()
+const (
+	raftStateKey    = "raft"
+	raftSnapshotKey = "raft.snapshot"
+)
+
+// raftEntryKey returns the name of the Store meta entry holding the log entry at an index
+func raftEntryKey(index uint64) string {
+	return fmt.Sprintf("raft.log.%d", index)
+}
+
+// raftState is the state a member must not forget once it has answered a message
+type raftState struct {
+	Term      uint64
+	VotedFor  string
+	LastIndex uint64
+}
+
+// raftSavedSnapshot is a snapshot as kept in a Store. The founding members are kept as a snapshot at
+// index 0 without a graph.
+type raftSavedSnapshot struct {
+	Index   uint64
+	Term    uint64
+	Members []string
+	Graph   []byte
+}
+
+// RaftEntry is an entry in the replicated log: a graph operation, a membership change or, when
+// both are empty, the no-op a new leader commits to learn which entries are committed
+type RaftEntry struct {
+	Term    uint64
+	Op      *Operation
+	Members []string
+}
+
+// raft message kinds
+const (
+	raftVote uint8 = iota + 1
+	raftAppend
+	raftSnapshot
+)
+
+// RaftMessage is a request or reply between cluster members. Transports deliver it as is.
+type RaftMessage struct {
+	Kind uint8
+	From string
+	Term uint64
+
+	// vote requests
+	LastLogIndex uint64
+	LastLogTerm  uint64
+
+	// log appends and snapshots. a snapshot replaces the log up to PrevLogIndex.
+	PrevLogIndex uint64
+	PrevLogTerm  uint64
+	Entries      []RaftEntry
+	LeaderCommit uint64
+	Snapshot     []byte
+	Members      []string
+
+	// replies. MatchIndex is the last index known to match the leader's log.
+	Success    bool
+	MatchIndex uint64
+}
+
+// RaftTransport delivers messages between cluster members
+type RaftTransport interface {
+	// Call delivers a message to a member, which handles it with RaftNode.Handle(), and
+	// returns the reply
+	Call(to string, msg RaftMessage) (RaftMessage, error)
+}
+
+// raft roles
+const (
+	raftFollower = iota
+	raftCandidate
+	raftLeader
+)
+
+// raftMaxEntries is the most entries sent in one append
+const raftMaxEntries = 256
+
+// RaftNode is a member of a cluster that keeps a Graph consistent with the Raft consensus
+// algorithm. Changes are proposed to the leader with Apply(), appended to the replicated log and
+// applied to every member's graph once a majority has stored them. Members elect a new leader
+// when the leader fails, can be added and removed one at a time, and catch up from a snapshot
+// made with the graph encoder when they are too far behind. A member's state is kept in memory
+// unless RaftConfig.Store is set; without a store a restarted member should rejoin under a new ID.
+type RaftNode struct {
+	id        string
+	transport RaftTransport
+	config    RaftConfig
+
+	sync.Mutex
+	g        *Graph
+	role     int
+	term     uint64
+	votedFor string
+	leader   string
+	votes    map[string]bool
+	deadline time.Time
+	members  []string
+
+	// log[0] stands for the entries replaced by the snapshot and holds the last one's term
+	log             []RaftEntry
+	snapshotIndex   uint64
+	snapshot        []byte
+	snapshotMembers []string
+	commitIndex     uint64
+	lastApplied     uint64
+
+	// leader state
+	nextIndex  map[string]uint64
+	matchIndex map[string]uint64
+	inflight   map[string]bool
+	waiters    map[uint64]raftWaiter
+
+	// reservedTop is the highest node ID given to an insert in the log. see reserveID()
+	reservedTop uint64
+
+	// saved is the index of the first log entry not yet written to the store, and savedState
+	// the state last written. see persist()
+	saved      uint64
+	savedState raftState
+
+	random *rand.Rand
+	kick   chan struct{}
+	stop   chan struct{}
+	closed bool
+}
+
+// raftWaiter is a proposal waiting for its entry to be applied
+type raftWaiter struct {
+	term   uint64
+	result chan raftResult
+}
+
+// raftResult is the outcome of applying a proposed entry
+type raftResult struct {
+	ID  uint64
+	err error
+}
+
+// NewRaftNode starts a cluster member with the ID id. Every founding member is given the same
+// members and an identical graph, usually an empty one. A member joining an existing cluster is
+// given no members and waits for the leader to add it with AddMember(). The graph becomes read
+// only, as it must only be changed through Apply().
+//
+// A member restarted on a RaftConfig.Store that holds its state carries on under its ID: the
+// members are read from the store and the graph is replaced by the saved snapshot, if any, so
+// it must be given the graph it was first started with.
+func NewRaftNode(id string, members []string, g *Graph, transport RaftTransport, config RaftConfig) (*RaftNode, error) {
+	if config.HeartbeatInterval <= 0 {
+		config.HeartbeatInterval = 50 * time.Millisecond
+	}
+	if config.ElectionTimeout <= 0 {
+		config.ElectionTimeout = 500 * time.Millisecond
+	}
+	if config.SnapshotThreshold <= 0 {
+		config.SnapshotThreshold = 1000
+	}
+	if config.ProposalTimeout <= 0 {
+		config.ProposalTimeout = 5 * time.Second
+	}
+
+	seed := fnv.New64a()
+	seed.Write([]byte(id))
+	r := &RaftNode{
+		id:              id,
+		transport:       transport,
+		config:          config,
+		g:               g,
+		members:         slices.Clone(members),
+		snapshotMembers: slices.Clone(members),
+		log:             []RaftEntry{{}},
+		waiters:         make(map[uint64]raftWaiter),
+		random:          rand.New(rand.NewSource(time.Now().UnixNano() ^ int64(seed.Sum64()))),
+		kick:            make(chan struct{}, 1),
+		stop:            make(chan struct{}),
+		saved:           1,
+	}
+	if config.Store != nil {
+		if err := r.load(); err != nil {
+			return nil, err
+		}
+	}
+	r.g.Lock()
+	r.g.readOnly = true
+	r.g.Unlock()
+
+	r.resetDeadline()
+	go r.run()
+	return r, nil
+}
+
+// load restores the member's state from the store, or saves the founding members to an empty one
+func (r *RaftNode) load() error {
+	store := r.config.Store
+	data, err := store.Meta(raftSnapshotKey)
+	if err != nil {
+		return err
+	}
+	if data == nil {
+		return r.saveSnapshot(raftSavedSnapshot{Members: r.members}, 0)
+	}
+	var snap raftSavedSnapshot
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&snap); err != nil {
+		return err
+	}
+	if snap.Graph != nil {
+		g, err := r.restoreGraph(snap.Graph)
+		if err != nil {
+			return err
+		}
+		r.g = g
+	}
+	r.log = []RaftEntry{{Term: snap.Term}}
+	r.snapshotIndex, r.snapshot, r.snapshotMembers = snap.Index, snap.Graph, snap.Members
+	r.commitIndex, r.lastApplied = snap.Index, snap.Index
+
+	var state raftState
+	if data, err = store.Meta(raftStateKey); err != nil {
+		return err
+	}
+	if data != nil {
+		if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&state); err != nil {
+			return err
+		}
+	}
+	r.term, r.votedFor = state.Term, state.VotedFor
+	for index := snap.Index + 1; index <= state.LastIndex; index++ {
+		data, err := store.Meta(raftEntryKey(index))
+		if err != nil {
+			return err
+		}
+		if data == nil {
+			break
+		}
+		var e RaftEntry
+		if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&e); err != nil {
+			return err
+		}
+		r.log = append(r.log, e)
+		r.track(e)
+	}
+	r.members = r.membersAt(r.lastIndex())
+	r.saved = r.lastIndex() + 1
+	r.savedState = raftState{Term: r.term, VotedFor: r.votedFor, LastIndex: r.lastIndex()}
+	return nil
+}
+
+// persist writes the log entries and state that are not yet in the store as one update
+func (r *RaftNode) persist() error {
+	store := r.config.Store
+	state := raftState{Term: r.term, VotedFor: r.votedFor, LastIndex: r.lastIndex()}
+	if store == nil || (r.saved > r.lastIndex() && state == r.savedState) {
+		return nil
+	}
+	err := store.Update(func(b StoreBatch) error {
+		for index := r.saved; index <= r.lastIndex(); index++ {
+			data, err := gobBytes(r.entry(index))
+			if err != nil {
+				return err
+			}
+			if err := b.SetMeta(raftEntryKey(index), data); err != nil {
+				return err
+			}
+		}
+		if state == r.savedState {
+			return nil
+		}
+		data, err := gobBytes(state)
+		if err != nil {
+			return err
+		}
+		return b.SetMeta(raftStateKey, data)
+	})
+	if err != nil {
+		return err
+	}
+	r.saved = r.lastIndex() + 1
+	r.savedState = state
+	return nil
+}
+
+// saveSnapshot writes a snapshot to the store and clears the saved log entries after the
+// previous snapshot that it replaces
+func (r *RaftNode) saveSnapshot(snap raftSavedSnapshot, previous uint64) error {
+	store := r.config.Store
+	if store == nil {
+		return nil
+	}
+	data, err := gobBytes(snap)
+	if err != nil {
+		return err
+	}
+	return store.Update(func(b StoreBatch) error {
+		if err := b.SetMeta(raftSnapshotKey, data); err != nil {
+			return err
+		}
+		for index := previous + 1; index <= snap.Index && index < r.saved; index++ {
+			if err := b.SetMeta(raftEntryKey(index), nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// restoreGraph decodes a snapshot into a read only graph that keeps the ID allocator and
+// validators of the member's graph
+func (r *RaftNode) restoreGraph(data []byte) (*Graph, error) {
+	g := &Graph{}
+	if err := g.GobDecode(data); err != nil {
+		return nil, err
+	}
+
+	r.g.Lock()
+	allocator := r.g.allocator
+	r.g.Unlock()
+	r.g.hookLock.RLock()
+	g.validators = slices.Clone(r.g.validators)
+	r.g.hookLock.RUnlock()
+
+	if allocator != nil {
+		g.SetIDAllocator(allocator)
+	}
+	g.readOnly = true
+	return g, nil
+}
+
+// ID returns the member's ID
+func (r *RaftNode) ID() string {
+	return r.id
+}
+
+// Graph returns the member's copy of the graph for reading. Reads are served locally, so a
+// follower may lag the leader. The graph is replaced when the member installs a snapshot. It is
+// read only: changes are made through Apply().
+func (r *RaftNode) Graph() *Graph {
+	r.Lock()
+	defer r.Unlock()
+
+	return r.g
+}
+
+// Leader returns the ID of the member this member believes is the leader, or "" if unknown
+func (r *RaftNode) Leader() string {
+	r.Lock()
+	defer r.Unlock()
+
+	return r.leader
+}
+
+// IsLeader reports whether this member is the leader
+func (r *RaftNode) IsLeader() bool {
+	r.Lock()
+	defer r.Unlock()
+
+	return r.role == raftLeader
+}
+
+// Term returns the member's current term
+func (r *RaftNode) Term() uint64 {
+	r.Lock()
+	defer r.Unlock()
+
+	return r.term
+}
+
+// Members returns the IDs of the cluster's members as this member knows them
+func (r *RaftNode) Members() []string {
+	r.Lock()
+	defer r.Unlock()
+
+	return slices.Clone(r.members)
+}
+
+// AppliedIndex returns the index of the last log entry applied to the member's graph
+func (r *RaftNode) AppliedIndex() uint64 {
+	r.Lock()
+	defer r.Unlock()
+
+	return r.lastApplied
+}
+
+// Apply proposes a change to the graph and waits until it has been committed and applied,
+// returning the ID of the node it changed. Inserts without a NodeID are given one by the
+// leader's ID allocator before they are proposed, so every member inserts the same ID. The change
+// is checked by the leader's schema and validators first. It fails with ErrNotLeader on members
+// that are not the leader.
+func (r *RaftNode) Apply(op Operation) (uint64, error) {
+	if err := r.Graph().validate(op); err != nil {
+		return 0, err
+	}
+	return r.propose(RaftEntry{Op: &op})
+}
+
+// AddMember adds a member to the cluster. It must be called on the leader and the new member
+// must be reachable through the transport.
+func (r *RaftNode) AddMember(id string) error {
+	return r.changeMembers(func(members []string) []string {
+		if slices.Contains(members, id) {
+			return members
+		}
+		return append(members, id)
+	})
+}
+
+// RemoveMember removes a member from the cluster. It must be called on the leader. A leader that
+// removes itself steps down once the change is committed.
+func (r *RaftNode) RemoveMember(id string) error {
+	return r.changeMembers(func(members []string) []string {
+		return slices.DeleteFunc(members, func(m string) bool { return m == id })
+	})
+}
+
+// changeMembers proposes a new membership. Only one change may be pending at a time.
+func (r *RaftNode) changeMembers(change func([]string) []string) error {
+	r.Lock()
+	if r.role == raftLeader && !slices.Equal(r.membersAt(r.commitIndex), r.members) {
+		r.Unlock()
+		return errors.New(ErrMembershipChange)
+	}
+	members := change(slices.Clone(r.members))
+	r.Unlock()
+
+	_, err := r.propose(RaftEntry{Members: members})
+	return err
+}
+
+// Close stops the member. Pending proposals fail with ErrNotLeader.
+func (r *RaftNode) Close() error {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	close(r.stop)
+	r.failWaiters(0)
+	return nil
+}
+
+// propose appends an entry to the leader's log and waits for it to be applied
+func (r *RaftNode) propose(e RaftEntry) (uint64, error) {
+	r.Lock()
+	if r.role != raftLeader || r.closed {
+		r.Unlock()
+		return 0, errors.New(ErrNotLeader)
+	}
+	e.Term = r.term
+	if e.Op != nil && e.Op.Kind == OpInsertNode && e.Op.NodeID == 0 {
+		e.Op.NodeID = r.reserveID()
+	}
+	index := r.append(e)
+	result := make(chan raftResult, 1)
+	r.waiters[index] = raftWaiter{term: r.term, result: result}
+	r.advanceCommit()
+	r.wake()
+	r.Unlock()
+
+	timer := time.NewTimer(r.config.ProposalTimeout)
+	defer timer.Stop()
+	select {
+	case res := <-result:
+		return res.ID, res.err
+	case <-timer.C:
+		r.Lock()
+		delete(r.waiters, index)
+		r.Unlock()
+		return 0, errors.New(ErrTimeout)
+	}
+}
+
+// append adds an entry to the leader's log and returns its index
+func (r *RaftNode) append(e RaftEntry) uint64 {
+	r.log = append(r.log, e)
+	index := r.lastIndex()
+	r.track(e)
+	if e.Members != nil {
+		r.setMembers(e.Members)
+	}
+	if r.persist() == nil {
+		// the leader counts toward a majority once the entry is stored
+		r.matchIndex[r.id] = index
+	}
+	return index
+}
+
+// reserveID chooses the ID of a node inserted by a proposal, after the IDs of the inserts in the
+// log, which may not be applied yet
+func (r *RaftNode) reserveID() uint64 {
+	r.g.Lock()
+	defer r.g.Unlock()
+
+	ID := r.g.nextNodeIDAfter(max(atomic.LoadUint64(&r.g.topNodeID), r.reservedTop))
+	r.reservedTop = max(r.reservedTop, ID)
+	return ID
+}
+
+// track notes the node ID of an insert added to the log. see reserveID()
+func (r *RaftNode) track(e RaftEntry) {
+	if e.Op != nil && e.Op.Kind == OpInsertNode {
+		r.reservedTop = max(r.reservedTop, e.Op.NodeID)
+	}
+}
+
+// setMembers changes the membership, which takes effect as soon as the entry is in the log
+func (r *RaftNode) setMembers(members []string) {
+	r.members = slices.Clone(members)
+	if r.role != raftLeader {
+		return
+	}
+	for _, m := range members {
+		if _, ok := r.nextIndex[m]; !ok {
+			r.nextIndex[m] = r.lastIndex() + 1
+			r.matchIndex[m] = 0
+		}
+	}
+}
+
+// run drives elections and heartbeats
+func (r *RaftNode) run() {
+	ticker := time.NewTicker(r.config.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+		case <-r.kick:
+		}
+
+		r.Lock()
+		if r.role == raftLeader {
+			r.broadcast()
+		} else if time.Now().After(r.deadline) && slices.Contains(r.members, r.id) {
+			r.campaign()
+		}
+		r.Unlock()
+	}
+}
+
+// wake makes run replicate without waiting for the next heartbeat
+func (r *RaftNode) wake() {
+	select {
+	case r.kick <- struct{}{}:
+	default:
+	}
+}
+
+// resetDeadline chooses when to start the next election
+func (r *RaftNode) resetDeadline() {
+	timeout := r.config.ElectionTimeout + time.Duration(r.random.Int63n(int64(r.config.ElectionTimeout)))
+	r.deadline = time.Now().Add(timeout)
+}
+
+// campaign starts an election for the next term
+func (r *RaftNode) campaign() {
+	r.role = raftCandidate
+	r.term++
+	r.votedFor = r.id
+	r.leader = ""
+	r.votes = map[string]bool{r.id: true}
+	r.resetDeadline()
+	if r.persist() != nil {
+		// votes must not be asked for in a term the member could forget
+		return
+	}
+	if r.hasQuorum(r.votes) {
+		r.becomeLeader()
+		return
+	}
+
+	msg := RaftMessage{Kind: raftVote, From: r.id, Term: r.term, LastLogIndex: r.lastIndex(), LastLogTerm: r.lastTerm()}
+	for _, m := range r.members {
+		if m != r.id {
+			go r.requestVote(m, msg)
+		}
+	}
+}
+
+// requestVote asks a member for its vote
+func (r *RaftNode) requestVote(to string, msg RaftMessage) {
+	reply, err := r.transport.Call(to, msg)
+	if err != nil {
+		return
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	if reply.Term > r.term {
+		r.stepDown(reply.Term)
+		return
+	}
+	if r.role != raftCandidate || r.term != msg.Term || !reply.Success {
+		return
+	}
+	r.votes[to] = true
+	if r.hasQuorum(r.votes) {
+		r.becomeLeader()
+	}
+}
+
+// hasQuorum reports whether a majority of the members are in the set
+func (r *RaftNode) hasQuorum(set map[string]bool) bool {
+	count := 0
+	for _, m := range r.members {
+		if set[m] {
+			count++
+		}
+	}
+	return count > len(r.members)/2
+}
+
+// becomeLeader takes over as leader and appends a no-op so earlier entries can be committed
+func (r *RaftNode) becomeLeader() {
+	r.role = raftLeader
+	r.leader = r.id
+	r.nextIndex = make(map[string]uint64)
+	r.matchIndex = make(map[string]uint64)
+	r.inflight = make(map[string]bool)
+	r.setMembers(r.members)
+	r.append(RaftEntry{Term: r.term})
+	r.advanceCommit()
+	r.broadcast()
+}
+
+// stepDown becomes a follower, moving to a newer term if there is one
+func (r *RaftNode) stepDown(term uint64) {
+	if term > r.term {
+		r.term = term
+		r.votedFor = ""
+		r.leader = ""
+	}
+	if r.role == raftLeader {
+		r.resetDeadline()
+	}
+	r.role = raftFollower
+}
+
+// broadcast sends each member the entries it is missing, or a snapshot if they are gone
+func (r *RaftNode) broadcast() {
+	for _, m := range r.members {
+		if m == r.id || r.inflight[m] {
+			continue
+		}
+		r.inflight[m] = true
+		go r.replicate(m, r.messageFor(m))
+	}
+}
+
+// messageFor builds the next append or snapshot for a member
+func (r *RaftNode) messageFor(to string) RaftMessage {
+	next := r.nextIndex[to]
+	if next <= r.snapshotIndex {
+		return RaftMessage{
+			Kind:         raftSnapshot,
+			From:         r.id,
+			Term:         r.term,
+			PrevLogIndex: r.snapshotIndex,
+			PrevLogTerm:  r.log[0].Term,
+			Snapshot:     r.snapshot,
+			Members:      slices.Clone(r.snapshotMembers),
+		}
+	}
+
+	entries := r.log[next-r.snapshotIndex:]
+	if len(entries) > raftMaxEntries {
+		entries = entries[:raftMaxEntries]
+	}
+	return RaftMessage{
+		Kind:         raftAppend,
+		From:         r.id,
+		Term:         r.term,
+		PrevLogIndex: next - 1,
+		PrevLogTerm:  r.termAt(next - 1),
+		Entries:      slices.Clone(entries),
+		LeaderCommit: r.commitIndex,
+	}
+}
+
+// replicate sends a message to a member and records how far its log matches
+func (r *RaftNode) replicate(to string, msg RaftMessage) {
+	reply, err := r.transport.Call(to, msg)
+
+	r.Lock()
+	defer r.Unlock()
+	r.inflight[to] = false
+	if err != nil {
+		return
+	}
+	if reply.Term > r.term {
+		r.stepDown(reply.Term)
+		return
+	}
+	if r.role != raftLeader || r.term != msg.Term {
+		return
+	}
+
+	if !reply.Success {
+		r.nextIndex[to] = max(1, min(reply.MatchIndex+1, msg.PrevLogIndex))
+		r.wake()
+		return
+	}
+	match := msg.PrevLogIndex + uint64(len(msg.Entries))
+	if match > r.matchIndex[to] {
+		r.matchIndex[to] = match
+	}
+	r.nextIndex[to] = max(r.nextIndex[to], match+1)
+	r.advanceCommit()
+	if r.nextIndex[to] <= r.lastIndex() {
+		r.wake()
+	}
+}
+
+// advanceCommit commits the latest entry of the current term stored by a majority
+func (r *RaftNode) advanceCommit() {
+	for n := r.lastIndex(); n > r.commitIndex && n > r.snapshotIndex; n-- {
+		if r.termAt(n) != r.term {
+			return
+		}
+		stored := make(map[string]bool)
+		for m, match := range r.matchIndex {
+			stored[m] = match >= n
+		}
+		if r.hasQuorum(stored) {
+			r.commitIndex = n
+			r.applyCommitted()
+			return
+		}
+	}
+}
+
+// applyCommitted applies committed entries to the graph and answers their proposals
+func (r *RaftNode) applyCommitted() {
+	for r.lastApplied < r.commitIndex {
+		r.lastApplied++
+		e := r.entry(r.lastApplied)
+
+		var res raftResult
+		if e.Op != nil {
+			res.ID, res.err = r.g.apply(*e.Op)
+		}
+		if w, ok := r.waiters[r.lastApplied]; ok {
+			delete(r.waiters, r.lastApplied)
+			if w.term != e.Term {
+				res = raftResult{err: errors.New(ErrNotLeader)}
+			}
+			w.result <- res
+		}
+
+		if e.Members != nil && r.role == raftLeader && !slices.Contains(e.Members, r.id) {
+			r.stepDown(r.term)
+		}
+	}
+	r.compact()
+}
+
+// compact replaces the applied part of the log with a snapshot once it is long enough
+func (r *RaftNode) compact() {
+	if r.lastApplied-r.snapshotIndex < uint64(r.config.SnapshotThreshold) {
+		return
+	}
+	r.g.Lock()
+	data, err := r.g.encode()
+	r.g.Unlock()
+	if err != nil {
+		return
+	}
+
+	snap := raftSavedSnapshot{
+		Index:   r.lastApplied,
+		Term:    r.termAt(r.lastApplied),
+		Members: r.membersAt(r.lastApplied),
+		Graph:   data,
+	}
+	if r.saveSnapshot(snap, r.snapshotIndex) != nil {
+		// keep the log until the snapshot can be stored
+		return
+	}
+	r.log = append([]RaftEntry{{Term: snap.Term}}, r.log[snap.Index-r.snapshotIndex+1:]...)
+	r.snapshotIndex = snap.Index
+	r.snapshot = data
+	r.snapshotMembers = snap.Members
+	r.saved = max(r.saved, snap.Index+1)
+}
+
+// failWaiters fails the proposals at or below index, or all of them when index is 0
+func (r *RaftNode) failWaiters(index uint64) {
+	for i, w := range r.waiters {
+		if index == 0 || i <= index {
+			delete(r.waiters, i)
+			w.result <- raftResult{err: errors.New(ErrNotLeader)}
+		}
+	}
+}
+
+// Handle answers a message from another member. Transports call it on the receiving member.
+// With a RaftConfig.Store, changes to the member's state are stored before it answers.
+func (r *RaftNode) Handle(msg RaftMessage) (reply RaftMessage) {
+	r.Lock()
+	defer r.Unlock()
+	defer func() {
+		if err := r.persist(); err != nil {
+			// the member must not vouch for state it could lose
+			reply.Success = false
+		}
+	}()
+
+	if msg.Term > r.term {
+		r.stepDown(msg.Term)
+	}
+	reply = RaftMessage{Kind: msg.Kind, From: r.id, Term: r.term}
+	if r.closed || msg.Term < r.term {
+		return reply
+	}
+
+	switch msg.Kind {
+	case raftVote:
+		upToDate := msg.LastLogTerm > r.lastTerm() ||
+			msg.LastLogTerm == r.lastTerm() && msg.LastLogIndex >= r.lastIndex()
+		if (r.votedFor == "" || r.votedFor == msg.From) && upToDate {
+			r.votedFor = msg.From
+			r.resetDeadline()
+			reply.Success = true
+		}
+	case raftAppend:
+		r.follow(msg.From)
+		reply.Success, reply.MatchIndex = r.appendEntries(msg)
+	case raftSnapshot:
+		r.follow(msg.From)
+		reply.Success = r.installSnapshot(msg)
+	}
+	return reply
+}
+
+// follow accepts a member as leader of the current term
+func (r *RaftNode) follow(leader string) {
+	r.role = raftFollower
+	r.leader = leader
+	r.resetDeadline()
+}
+
+// appendEntries stores the leader's entries after checking that the logs match up to them
+func (r *RaftNode) appendEntries(msg RaftMessage) (bool, uint64) {
+	prev := msg.PrevLogIndex
+	if prev > r.lastIndex() {
+		return false, r.lastIndex()
+	}
+	if prev >= r.snapshotIndex && r.termAt(prev) != msg.PrevLogTerm {
+		return false, min(r.commitIndex, prev-1)
+	}
+
+	for i, e := range msg.Entries {
+		index := prev + 1 + uint64(i)
+		if index <= r.snapshotIndex {
+			continue
+		}
+		if index <= r.lastIndex() {
+			if r.termAt(index) == e.Term {
+				continue
+			}
+			// a conflicting entry and everything after it were never committed
+			r.log = r.log[:index-r.snapshotIndex]
+			r.members = r.membersAt(r.lastIndex())
+			r.saved = min(r.saved, index)
+		}
+		r.log = append(r.log, e)
+		r.track(e)
+		if e.Members != nil {
+			r.members = slices.Clone(e.Members)
+		}
+	}
+
+	match := prev + uint64(len(msg.Entries))
+	if msg.LeaderCommit > r.commitIndex {
+		r.commitIndex = min(msg.LeaderCommit, match)
+		r.applyCommitted()
+	}
+	return true, match
+}
+
+// installSnapshot replaces the member's graph and log with the leader's snapshot
+func (r *RaftNode) installSnapshot(msg RaftMessage) bool {
+	if msg.PrevLogIndex <= r.lastApplied {
+		return true
+	}
+	g, err := r.restoreGraph(msg.Snapshot)
+	if err != nil {
+		return false
+	}
+	snap := raftSavedSnapshot{Index: msg.PrevLogIndex, Term: msg.PrevLogTerm, Members: msg.Members, Graph: msg.Snapshot}
+	if err := r.saveSnapshot(snap, r.snapshotIndex); err != nil {
+		return false
+	}
+
+	r.g = g
+	r.saved = msg.PrevLogIndex + 1
+	r.log = []RaftEntry{{Term: msg.PrevLogTerm}}
+	r.snapshotIndex = msg.PrevLogIndex
+	r.snapshot = msg.Snapshot
+	r.snapshotMembers = slices.Clone(msg.Members)
+	r.members = slices.Clone(msg.Members)
+	r.commitIndex = msg.PrevLogIndex
+	r.lastApplied = msg.PrevLogIndex
+	r.failWaiters(msg.PrevLogIndex)
+	return true
+}
+
+// lastIndex returns the index of the last entry in the log
+func (r *RaftNode) lastIndex() uint64 {
+	return r.snapshotIndex + uint64(len(r.log)) - 1
+}
+
+// lastTerm returns the term of the last entry in the log
+func (r *RaftNode) lastTerm() uint64 {
+	return r.log[len(r.log)-1].Term
+}
+
+// entry returns the entry at an index after the snapshot
+func (r *RaftNode) entry(index uint64) RaftEntry {
+	return r.log[index-r.snapshotIndex]
+}
+
+// termAt returns the term of the entry at an index no earlier than the snapshot
+func (r *RaftNode) termAt(index uint64) uint64 {
+	return r.log[index-r.snapshotIndex].Term
+}
+
+// membersAt returns the membership in effect at an index no earlier than the snapshot
+func (r *RaftNode) membersAt(index uint64) []string {
+	for i := index; i > r.snapshotIndex; i-- {
+		if members := r.entry(i).Members; members != nil {
+			return slices.Clone(members)
+		}
+	}
+	return slices.Clone(r.snapshotMembers)
+}
+
+// MemoryTransport connects RaftNodes in the same process, such as in tests. Messages are
+// encoded and decoded on the way like they would be over a network, and the members can be
+// partitioned into groups that cannot reach each other.
+type MemoryTransport struct {
+	sync.Mutex
+	nodes  map[string]*RaftNode
+	groups map[string]int
+}
+
+// NewMemoryTransport creates a transport with no members
+func NewMemoryTransport() *MemoryTransport {
+	return &MemoryTransport{nodes: make(map[string]*RaftNode), groups: make(map[string]int)}
+}
+
+// Register makes a member reachable
+func (t *MemoryTransport) Register(r *RaftNode) {
+	t.Lock()
+	defer t.Unlock()
+
+	t.nodes[r.ID()] = r
+}
+
+// Partition splits the members into groups that can only reach members of their own group.
+// Members not in any group are put in the first one.
+func (t *MemoryTransport) Partition(groups ...[]string) {
+	t.Lock()
+	defer t.Unlock()
+
+	t.groups = make(map[string]int)
+	for i, group := range groups {
+		for _, id := range group {
+			t.groups[id] = i
+		}
+	}
+}
+
+// Heal removes all partitions
+func (t *MemoryTransport) Heal() {
+	t.Partition()
+}
+
+// Call satisfies the RaftTransport interface
+func (t *MemoryTransport) Call(to string, msg RaftMessage) (RaftMessage, error) {
+	t.Lock()
+	r, ok := t.nodes[to]
+	reachable := t.groups[msg.From] == t.groups[to]
+	t.Unlock()
+	if !ok || !reachable {
+		return RaftMessage{}, errors.New(ErrUnreachable)
+	}
+
+	if err := roundTrip(&msg); err != nil {
+		return RaftMessage{}, err
+	}
+	reply := r.Handle(msg)
+	if err := roundTrip(&reply); err != nil {
+		return RaftMessage{}, err
+	}
+
+	// the reply may have been sent before a partition that stops it arriving
+	t.Lock()
+	reachable = t.groups[msg.From] == t.groups[to]
+	t.Unlock()
+	if !reachable {
+		return RaftMessage{}, errors.New(ErrUnreachable)
+	}
+	return reply, nil
+}
+
+// roundTrip encodes and decodes a message so the receiver shares no memory with the sender
+func roundTrip(msg *RaftMessage) error {
+	w := new(bytes.Buffer)
+	if err := gob.NewEncoder(w).Encode(msg); err != nil {
+		return err
+	}
+	*msg = RaftMessage{}
+	return gob.NewDecoder(w).Decode(msg)
+}
@@ -0,0 +1,351 @@
+package giraffe
+
+import (
+	"errors"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+)
+
+var testRaftConfig = RaftConfig{
+	HeartbeatInterval: 10 * time.Millisecond,
+	ElectionTimeout:   50 * time.Millisecond,
+}
+
+// newTestCluster starts members with the IDs on a memory transport
+func newTestCluster(t *testing.T, config RaftConfig, ids ...string) (*MemoryTransport, map[string]*RaftNode) {
+	transport := NewMemoryTransport()
+	nodes := make(map[string]*RaftNode)
+	for _, id := range ids {
+		g, _ := NewGraph("testGraph")
+		r, err := NewRaftNode(id, ids, g, transport, config)
+		if err != nil {
+			t.Fatalf("unable to start member, error: %v", err)
+		}
+		nodes[id] = r
+		transport.Register(r)
+	}
+	t.Cleanup(func() {
+		for _, r := range nodes {
+			r.Close()
+		}
+	})
+	return transport, nodes
+}
+
+// waitFor polls until cond holds
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// waitForLeader returns the single leader among the members
+func waitForLeader(t *testing.T, nodes ...*RaftNode) *RaftNode {
+	t.Helper()
+	var leader *RaftNode
+	waitFor(t, "a leader", func() bool {
+		leader = nil
+		for _, r := range nodes {
+			if r.IsLeader() {
+				if leader != nil {
+					return false
+				}
+				leader = r
+			}
+		}
+		return leader != nil
+	})
+	return leader
+}
+
+// checkConverged waits for every member to apply the leader's log and compares their graphs
+func checkConverged(t *testing.T, leader *RaftNode, nodes ...*RaftNode) {
+	t.Helper()
+	index := leader.AppliedIndex()
+	for _, r := range nodes {
+		waitFor(t, r.ID()+" to catch up", func() bool { return r.AppliedIndex() >= index })
+		if got, want := slices.Collect(r.Graph().Edges()), slices.Collect(leader.Graph().Edges()); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got edges %v, want %v", r.ID(), got, want)
+		}
+		if got, want := r.Graph().NodeCount(), leader.Graph().NodeCount(); got != want {
+			t.Errorf("%s: got %d nodes, want %d", r.ID(), got, want)
+		}
+	}
+}
+
+func TestRaftReplication(t *testing.T) {
+	_, nodes := newTestCluster(t, testRaftConfig, "a", "b", "c")
+	leader := waitForLeader(t, nodes["a"], nodes["b"], nodes["c"])
+
+	ID, err := leader.Apply(Operation{Kind: OpInsertNode, Key: "Intro", Value: []byte("lesson_id 1")})
+	if err != nil {
+		t.Fatalf("unable to insert, error: %v", err)
+	}
+	if _, err := leader.Apply(Operation{Kind: OpAddRelationship, NodeID: 0, OtherID: ID}); err != nil {
+		t.Fatalf("unable to add relationship, error: %v", err)
+	}
+	p := IntProp(3)
+	if _, err := leader.Apply(Operation{Kind: OpSetProperty, NodeID: ID, PropertyName: "difficulty", Property: &p}); err != nil {
+		t.Fatalf("unable to set property, error: %v", err)
+	}
+	checkConverged(t, leader, nodes["a"], nodes["b"], nodes["c"])
+
+	for _, r := range nodes {
+		n, ok := r.Graph().FindNodeByKey("Intro")
+		if !ok || n.ID != ID {
+			t.Fatalf("%s: node not replicated", r.ID())
+		}
+		if p, _ := n.Property("difficulty"); p.Int != 3 {
+			t.Errorf("%s: got property %v, want 3", r.ID(), p)
+		}
+		if r != leader {
+			if _, err := r.Apply(Operation{Kind: OpInsertNode, Key: "Other"}); err == nil || err.Error() != ErrNotLeader {
+				t.Errorf("got `%v`, want `%s`", err, ErrNotLeader)
+			}
+		}
+		// graphs only change through the log
+		if err := n.SetValue(nil); err == nil || err.Error() != ErrReadOnly {
+			t.Errorf("got `%v`, want `%s`", err, ErrReadOnly)
+		}
+	}
+}
+
+// offsetAllocator gives IDs far from the default ones
+type offsetAllocator struct{}
+
+func (offsetAllocator) Allocate(top uint64) uint64 { return top + 100 }
+func (offsetAllocator) Use(ID uint64)              {}
+func (offsetAllocator) Release(ID uint64)          {}
+
+func TestRaftNodeIDs(t *testing.T) {
+	_, nodes := newTestCluster(t, testRaftConfig, "a", "b", "c")
+	for _, r := range nodes {
+		if r.ID() == "b" {
+			r.Graph().SetIDAllocator(offsetAllocator{})
+		}
+	}
+	leader := waitForLeader(t, nodes["a"], nodes["b"], nodes["c"])
+
+	// IDs are chosen by the leader, whatever the other members' allocators would choose
+	var IDs []uint64
+	for _, key := range []string{"Intro", "Factoring"} {
+		ID, err := leader.Apply(Operation{Kind: OpInsertNode, Key: key})
+		if err != nil {
+			t.Fatalf("unable to insert, error: %v", err)
+		}
+		IDs = append(IDs, ID)
+	}
+	checkConverged(t, leader, nodes["a"], nodes["b"], nodes["c"])
+	for _, r := range nodes {
+		for i, key := range []string{"Intro", "Factoring"} {
+			if n, ok := r.Graph().FindNodeByKey(key); !ok || n.ID != IDs[i] {
+				t.Errorf("%s: got %+v, want %s with ID %d", r.ID(), n, key, IDs[i])
+			}
+		}
+	}
+}
+
+func TestRaftRestart(t *testing.T) {
+	config := testRaftConfig
+	config.SnapshotThreshold = 5
+	transport := NewMemoryTransport()
+	ids := []string{"a", "b", "c"}
+	stores := make(map[string]*MemoryStore)
+	nodes := make(map[string]*RaftNode)
+	start := func(id string) *RaftNode {
+		g, _ := NewGraph("testGraph")
+		c := config
+		c.Store = stores[id]
+		r, err := NewRaftNode(id, ids, g, transport, c)
+		if err != nil {
+			t.Fatalf("unable to start member, error: %v", err)
+		}
+		nodes[id] = r
+		transport.Register(r)
+		return r
+	}
+	for _, id := range ids {
+		stores[id] = NewMemoryStore()
+		start(id)
+	}
+	t.Cleanup(func() {
+		for _, r := range nodes {
+			r.Close()
+		}
+	})
+
+	leader := waitForLeader(t, nodes["a"], nodes["b"], nodes["c"])
+	for i := 0; i < 8; i++ {
+		if _, err := leader.Apply(Operation{Kind: OpInsertNode}); err != nil {
+			t.Fatalf("unable to insert, error: %v", err)
+		}
+	}
+	checkConverged(t, leader, nodes["a"], nodes["b"], nodes["c"])
+
+	// a restarted follower carries on from its snapshot, log and term
+	var follower *RaftNode
+	for _, r := range nodes {
+		if r != leader {
+			follower = r
+			break
+		}
+	}
+	term, applied := follower.Term(), follower.AppliedIndex()
+	follower.Close()
+	restarted := start(follower.ID())
+	if restarted.Term() < term {
+		t.Errorf("got term %d, want at least %d", restarted.Term(), term)
+	}
+	if got := restarted.AppliedIndex(); got == 0 || got > applied {
+		t.Errorf("got applied index %d, want the snapshot's up to %d", got, applied)
+	}
+	if got := restarted.Graph().NodeCount(); got < 2 {
+		t.Errorf("got %d nodes, want the snapshot's", got)
+	}
+	if got := restarted.Members(); !reflect.DeepEqual(got, ids) {
+		t.Errorf("got members %v, want %v", got, ids)
+	}
+
+	leader = waitForLeader(t, nodes["a"], nodes["b"], nodes["c"])
+	if _, err := leader.Apply(Operation{Kind: OpInsertNode, Key: "Restarted"}); err != nil {
+		t.Fatalf("unable to insert, error: %v", err)
+	}
+	checkConverged(t, leader, nodes["a"], nodes["b"], nodes["c"])
+}
+
+func TestRaftValidation(t *testing.T) {
+	_, nodes := newTestCluster(t, testRaftConfig, "a", "b", "c")
+	leader := waitForLeader(t, nodes["a"], nodes["b"], nodes["c"])
+	leader.Graph().AddValidator(func(_ *Graph, op Operation) error {
+		if op.Key == "Forbidden" {
+			return errors.New("forbidden")
+		}
+		return nil
+	})
+
+	var vErr *ValidationError
+	if _, err := leader.Apply(Operation{Kind: OpInsertNode, Key: "Forbidden"}); !errors.As(err, &vErr) {
+		t.Errorf("got `%v`, want a validation error", err)
+	}
+	if _, err := leader.Apply(Operation{Kind: OpSetKey, NodeID: 42, Key: "Missing"}); err == nil || err.Error() != ErrNodeNotFound {
+		t.Errorf("got `%v`, want `%s`", err, ErrNodeNotFound)
+	}
+}
+
+func TestRaftPartition(t *testing.T) {
+	transport, nodes := newTestCluster(t, testRaftConfig, "a", "b", "c", "d", "e")
+	all := []*RaftNode{nodes["a"], nodes["b"], nodes["c"], nodes["d"], nodes["e"]}
+	old := waitForLeader(t, all...)
+	if _, err := old.Apply(Operation{Kind: OpInsertNode, Key: "Before"}); err != nil {
+		t.Fatalf("unable to insert, error: %v", err)
+	}
+
+	// isolate the leader with one follower
+	var rest []*RaftNode
+	for _, r := range all {
+		if r != old {
+			rest = append(rest, r)
+		}
+	}
+	var majority []string
+	for _, r := range rest[1:] {
+		majority = append(majority, r.ID())
+	}
+	transport.Partition(majority, []string{old.ID(), rest[0].ID()})
+
+	// the isolated leader cannot commit
+	go old.Apply(Operation{Kind: OpInsertNode, Key: "Lost"})
+
+	leader := waitForLeader(t, rest[1:]...)
+	if _, err := leader.Apply(Operation{Kind: OpInsertNode, Key: "After"}); err != nil {
+		t.Fatalf("unable to insert in the majority, error: %v", err)
+	}
+
+	transport.Heal()
+	waitFor(t, "the old leader to step down", func() bool { return !old.IsLeader() })
+	leader = waitForLeader(t, all...)
+	if _, err := leader.Apply(Operation{Kind: OpInsertNode, Key: "Healed"}); err != nil {
+		t.Fatalf("unable to insert after healing, error: %v", err)
+	}
+	checkConverged(t, leader, all...)
+
+	for _, r := range all {
+		g := r.Graph()
+		for _, key := range []string{"Before", "After", "Healed"} {
+			if _, ok := g.FindNodeByKey(key); !ok {
+				t.Errorf("%s: missing %s", r.ID(), key)
+			}
+		}
+		if _, ok := g.FindNodeByKey("Lost"); ok {
+			t.Errorf("%s: uncommitted insert was applied", r.ID())
+		}
+	}
+}
+
+func TestRaftMembership(t *testing.T) {
+	config := testRaftConfig
+	config.SnapshotThreshold = 5
+	transport, nodes := newTestCluster(t, config, "a", "b", "c")
+	leader := waitForLeader(t, nodes["a"], nodes["b"], nodes["c"])
+	for i := 0; i < 10; i++ {
+		if _, err := leader.Apply(Operation{Kind: OpInsertNode}); err != nil {
+			t.Fatalf("unable to insert, error: %v", err)
+		}
+	}
+
+	// the new member is behind the snapshot, so it is sent one
+	g, _ := NewGraph("empty")
+	allocator := NewFreeListAllocator()
+	g.SetIDAllocator(allocator)
+	g.AddValidator(func(*Graph, Operation) error { return nil })
+	d, err := NewRaftNode("d", nil, g, transport, config)
+	if err != nil {
+		t.Fatalf("unable to start member, error: %v", err)
+	}
+	transport.Register(d)
+	defer d.Close()
+	if err := leader.AddMember("d"); err != nil {
+		t.Fatalf("unable to add member, error: %v", err)
+	}
+	if _, err := leader.Apply(Operation{Kind: OpInsertNode, Key: "Joined"}); err != nil {
+		t.Fatalf("unable to insert, error: %v", err)
+	}
+	checkConverged(t, leader, d)
+	if d.Graph().Name != "testGraph" {
+		t.Errorf("got name %q, want testGraph", d.Graph().Name)
+	}
+	// the snapshot keeps the member's allocator and validators
+	if got := d.Graph(); got.allocator != allocator || len(got.validators) != 1 {
+		t.Errorf("got allocator %v and %d validators, want the member's", got.allocator, len(got.validators))
+	}
+	if got := d.Members(); len(got) != 4 {
+		t.Errorf("got members %v, want 4", got)
+	}
+
+	// removing the leader makes the others elect a new one
+	if err := leader.RemoveMember(leader.ID()); err != nil {
+		t.Fatalf("unable to remove member, error: %v", err)
+	}
+	leader.Close()
+	var rest []*RaftNode
+	for _, r := range []*RaftNode{nodes["a"], nodes["b"], nodes["c"], d} {
+		if r != leader {
+			rest = append(rest, r)
+		}
+	}
+	next := waitForLeader(t, rest...)
+	if slices.Contains(next.Members(), leader.ID()) {
+		t.Errorf("got members %v, want %s removed", next.Members(), leader.ID())
+	}
+	if _, err := next.Apply(Operation{Kind: OpInsertNode, Key: "Removed"}); err != nil {
+		t.Fatalf("unable to insert, error: %v", err)
+	}
+	checkConverged(t, next, rest...)
+}
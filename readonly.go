@@ -0,0 +1,306 @@
+package giraffe
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"unsafe"
+)
+
+// Read only file error constants
+const (
+	// ErrNotFrozenFile returns when opening a file that was not written by FrozenGraph.WriteTo()
+	ErrNotFrozenFile = "not a frozen graph file"
+)
+
+// frozenMagic starts a frozen graph file. Files starting with frozenMagicV1 were written before
+// kinds, labels and properties were kept, and open without them.
+const (
+	frozenMagic   = "giraffe2"
+	frozenMagicV1 = "giraffeF"
+)
+
+// frozenHeaderSize is the size of the magic followed by the name length, node count, edge count,
+// key and value data lengths, name count, name data length, label count and property data length.
+// The header of a frozenMagicV1 file ends after the value data length.
+const (
+	frozenHeaderSize   = 8 + 9*8
+	frozenHeaderSizeV1 = 8 + 5*8
+)
+
+// nativeLittleEndian reports whether sections can be used in place on this machine
+var nativeLittleEndian = func() bool {
+	x := uint16(1)
+	return *(*byte)(unsafe.Pointer(&x)) == 1
+}()
+
+// WriteTo writes the frozen graph in the file format read by OpenReadOnly(). The header is
+// followed by each of the graph's slices in little endian order, each padded to 8 bytes so they
+// can be used directly from a memory mapped file.
+func (f *FrozenGraph) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+	header := make([]byte, frozenHeaderSize)
+	copy(header, frozenMagic)
+	for i, n := range []int{len(f.Name), len(f.ids), len(f.outTargets), len(f.keyData), len(f.valueData),
+		len(f.nameOffsets) - 1, len(f.nameData), len(f.labelNames), len(f.propertyData)} {
+		binary.LittleEndian.PutUint64(header[8+8*i:], uint64(n))
+	}
+	cw.write(header)
+	cw.write([]byte(f.Name))
+	cw.pad()
+
+	for _, section := range []any{f.ids, f.outOffsets, f.outTargets, f.inOffsets, f.inTargets,
+		f.keyOffsets, f.valueOffsets, f.keyOrder} {
+		if cw.err == nil {
+			cw.err = binary.Write(cw, binary.LittleEndian, section)
+		}
+		cw.pad()
+	}
+	cw.write(f.keyData)
+	cw.pad()
+	cw.write(f.valueData)
+	cw.pad()
+
+	for _, section := range []any{f.nameOffsets, f.kinds, f.labelOffsets, f.labelNames, f.propertyOffsets} {
+		if cw.err == nil {
+			cw.err = binary.Write(cw, binary.LittleEndian, section)
+		}
+		cw.pad()
+	}
+	cw.write(f.nameData)
+	cw.pad()
+	cw.write(f.propertyData)
+	cw.pad()
+
+	if cw.err == nil {
+		cw.err = cw.w.(*bufio.Writer).Flush()
+	}
+	return cw.n, cw.err
+}
+
+// WriteFile writes the frozen graph to a file that can be opened with OpenReadOnly()
+func (f *FrozenGraph) WriteFile(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteTo(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// OpenReadOnly memory maps a file written by FrozenGraph.WriteFile(). Lookups and traversals are
+// served from the mapped file, so opening is fast regardless of the graph's size and the pages
+// are shared between processes. The graph must not be used after Close().
+func OpenReadOnly(path string) (*FrozenGraph, error) {
+	data, err := mapFile(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := decodeFrozen(data)
+	if err != nil {
+		unmapFile(data)
+		return nil, err
+	}
+	f.mapping = data
+	return f, nil
+}
+
+// Close releases the file mapping of a graph opened with OpenReadOnly(). It does nothing for a
+// graph built by Freeze().
+func (f *FrozenGraph) Close() error {
+	if f.mapping == nil {
+		return nil
+	}
+	data := f.mapping
+	*f = FrozenGraph{}
+	return unmapFile(data)
+}
+
+// decodeFrozen points a FrozenGraph's slices at the sections of a frozen graph file
+func decodeFrozen(data []byte) (*FrozenGraph, error) {
+	headerSize := frozenHeaderSize
+	if len(data) >= 8 && string(data[:8]) == frozenMagicV1 {
+		headerSize = frozenHeaderSizeV1
+	} else if len(data) < 8 || string(data[:8]) != frozenMagic {
+		return nil, errors.New(ErrNotFrozenFile)
+	}
+	if len(data) < headerSize {
+		return nil, errors.New(ErrNotFrozenFile)
+	}
+	var counts [9]uint64
+	for i := 0; 8+8*i < headerSize; i++ {
+		counts[i] = binary.LittleEndian.Uint64(data[8+8*i:])
+		if counts[i] > uint64(len(data)) {
+			return nil, errors.New(ErrNotFrozenFile)
+		}
+	}
+	nameLen, nodes, edges, keyLen, valueLen := int(counts[0]), int(counts[1]), int(counts[2]), int(counts[3]), int(counts[4])
+	names, nameDataLen, labels, propertyLen := int(counts[5]), int(counts[6]), int(counts[7]), int(counts[8])
+
+	r := &sectionReader{data: data, at: headerSize}
+	f := &FrozenGraph{Name: string(r.bytes(nameLen))}
+	f.ids = r.uint64s(nodes)
+	f.outOffsets = r.uint32s(nodes + 1)
+	f.outTargets = r.uint32s(edges)
+	f.inOffsets = r.uint32s(nodes + 1)
+	f.inTargets = r.uint32s(edges)
+	f.keyOffsets = r.uint64s(nodes + 1)
+	f.valueOffsets = r.uint64s(nodes + 1)
+	f.keyOrder = r.uint32s(nodes)
+	f.keyData = r.bytes(keyLen)
+	f.valueData = r.bytes(valueLen)
+	if headerSize == frozenHeaderSizeV1 {
+		// every node has the kind "" and no labels or properties
+		f.nameOffsets = make([]uint64, 2)
+		f.kinds = make([]uint32, nodes)
+		f.labelOffsets = make([]uint32, nodes+1)
+		f.propertyOffsets = make([]uint64, nodes+1)
+		names = 1
+	} else {
+		f.nameOffsets = r.uint64s(names + 1)
+		f.kinds = r.uint32s(nodes)
+		f.labelOffsets = r.uint32s(nodes + 1)
+		f.labelNames = r.uint32s(labels)
+		f.propertyOffsets = r.uint64s(nodes + 1)
+		f.nameData = r.bytes(nameDataLen)
+		f.propertyData = r.bytes(propertyLen)
+	}
+
+	if r.short || f.outOffsets[nodes] != uint32(edges) || f.inOffsets[nodes] != uint32(edges) ||
+		f.keyOffsets[nodes] != uint64(keyLen) || f.valueOffsets[nodes] != uint64(valueLen) ||
+		f.nameOffsets[names] != uint64(nameDataLen) || f.labelOffsets[nodes] != uint32(labels) ||
+		f.propertyOffsets[nodes] != uint64(propertyLen) || !f.valid() {
+		return nil, errors.New(ErrNotFrozenFile)
+	}
+	return f, nil
+}
+
+// valid checks that a decoded frozen graph's offsets start at 0 and never decrease, and that
+// every node position and name is in range, so lookups in a corrupt file cannot panic
+func (f *FrozenGraph) valid() bool {
+	nodes := uint32(len(f.ids))
+	names := uint32(len(f.nameOffsets) - 1)
+	for i := 1; i < len(f.ids); i++ {
+		if f.ids[i] <= f.ids[i-1] {
+			return false
+		}
+	}
+	for _, offsets := range [][]uint32{f.outOffsets, f.inOffsets, f.labelOffsets} {
+		if offsets[0] != 0 {
+			return false
+		}
+		for i := 1; i < len(offsets); i++ {
+			if offsets[i] < offsets[i-1] {
+				return false
+			}
+		}
+	}
+	for _, offsets := range [][]uint64{f.keyOffsets, f.valueOffsets, f.nameOffsets, f.propertyOffsets} {
+		if offsets[0] != 0 {
+			return false
+		}
+		for i := 1; i < len(offsets); i++ {
+			if offsets[i] < offsets[i-1] {
+				return false
+			}
+		}
+	}
+	for _, positions := range [][]uint32{f.outTargets, f.inTargets, f.keyOrder} {
+		for _, p := range positions {
+			if p >= nodes {
+				return false
+			}
+		}
+	}
+	for _, refs := range [][]uint32{f.kinds, f.labelNames} {
+		for _, i := range refs {
+			if i >= names {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// countingWriter writes 8 byte aligned sections and keeps the first error
+type countingWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+// Write satisfies io.Writer
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// write writes p unless an earlier write failed
+func (cw *countingWriter) write(p []byte) {
+	if cw.err == nil {
+		_, cw.err = cw.Write(p)
+	}
+}
+
+// pad writes zeros up to the next multiple of 8 bytes
+func (cw *countingWriter) pad() {
+	if n := cw.n % 8; n != 0 {
+		cw.write(make([]byte, 8-n))
+	}
+}
+
+// sectionReader slices 8 byte aligned sections out of a frozen graph file
+type sectionReader struct {
+	data  []byte
+	at    int
+	short bool
+}
+
+// bytes returns the next n bytes
+func (r *sectionReader) bytes(n int) []byte {
+	if r.short || n > len(r.data)-r.at {
+		r.short = true
+		return make([]byte, n)
+	}
+	b := r.data[r.at : r.at+n : r.at+n]
+	r.at += (n + 7) &^ 7
+	return b
+}
+
+// uint32s returns the next n little endian uint32s, in place when the machine allows
+func (r *sectionReader) uint32s(n int) []uint32 {
+	b := r.bytes(4 * n)
+	if n == 0 {
+		return nil
+	}
+	if nativeLittleEndian {
+		return unsafe.Slice((*uint32)(unsafe.Pointer(&b[0])), n)
+	}
+	s := make([]uint32, n)
+	for i := range s {
+		s[i] = binary.LittleEndian.Uint32(b[4*i:])
+	}
+	return s
+}
+
+// uint64s returns the next n little endian uint64s, in place when the machine allows
+func (r *sectionReader) uint64s(n int) []uint64 {
+	b := r.bytes(8 * n)
+	if n == 0 {
+		return nil
+	}
+	if nativeLittleEndian {
+		return unsafe.Slice((*uint64)(unsafe.Pointer(&b[0])), n)
+	}
+	s := make([]uint64, n)
+	for i := range s {
+		s[i] = binary.LittleEndian.Uint64(b[8*i:])
+	}
+	return s
+}
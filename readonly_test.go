@@ -0,0 +1,129 @@
+package giraffe
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestOpenReadOnly(t *testing.T) {
+	g, _ := newTestGraph()
+	g.nodes[4].SetKey("shared")
+	g.nodes[9].SetKey("shared")
+	g.nodes[9].SetValue([]byte("value9"))
+	f := g.Freeze()
+
+	path := filepath.Join(t.TempDir(), "graph.frozen")
+	if err := f.WriteFile(path); err != nil {
+		t.Fatalf("unable to write file, error: %v", err)
+	}
+	info, _ := os.Stat(path)
+	if info.Size()%8 != 0 {
+		t.Errorf("file size %d is not padded", info.Size())
+	}
+
+	ro, err := OpenReadOnly(path)
+	if err != nil {
+		t.Fatalf("unable to open file, error: %v", err)
+	}
+	if ro.Name != f.Name || ro.NodeCount() != f.NodeCount() || ro.EdgeCount() != f.EdgeCount() {
+		t.Errorf("got %q with %d nodes and %d edges", ro.Name, ro.NodeCount(), ro.EdgeCount())
+	}
+	if got, want := slices.Collect(ro.Edges()), slices.Collect(f.Edges()); !reflect.DeepEqual(got, want) {
+		t.Errorf("got edges %v, want %v", got, want)
+	}
+	if got, want := ro.ListSources(10), f.ListSources(10); !reflect.DeepEqual(got, want) {
+		t.Errorf("got sources %v, want %v", got, want)
+	}
+	if got, want := ro.NodeIDsByKey("shared"), []uint64{4, 9}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got ids %v, want %v", got, want)
+	}
+	if v, _ := ro.Value(9); string(v) != "value9" {
+		t.Errorf("got `%s`, want `value9`", v)
+	}
+	if !ro.DepthFirstSearch(0, 11) || !ro.BreadthFirstSearch(0, 11) {
+		t.Error("unable to find path root -> n11")
+	}
+
+	// kinds, labels and properties are kept
+	path = filepath.Join(t.TempDir(), "curriculum.frozen")
+	newCurriculumGraph().Freeze().WriteFile(path)
+	c, err := OpenReadOnly(path)
+	if err != nil {
+		t.Fatalf("unable to open file, error: %v", err)
+	}
+	defer c.Close()
+	if kind, _ := c.Kind(3); kind != "Quiz" {
+		t.Errorf("got kind %q, want Quiz", kind)
+	}
+	if got, want := c.Labels(2), []string{"Algebra", "Published"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got labels %v, want %v", got, want)
+	}
+	if got, want := c.FindByProperty("minutes", IntProp(45)), []uint64{2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got ids %v, want %v", got, want)
+	}
+	if got, want := c.FindRoots("Published"), []uint64{1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got roots %v, want %v", got, want)
+	}
+
+	// files written before kinds, labels and properties were kept open without them
+	old, err := OpenReadOnly(filepath.Join("testdata", "v1.frozen"))
+	if err != nil {
+		t.Fatalf("unable to open old file, error: %v", err)
+	}
+	defer old.Close()
+	if got, want := old.NodeIDsByKey("shared"), []uint64{4, 9}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got ids %v from old file, want %v", got, want)
+	}
+	if kind, ok := old.Kind(4); !ok || kind != "" || len(old.Labels(4)) != 0 || old.Properties(4) != nil {
+		t.Errorf("got kind %q, labels %v and properties %v from old file", kind, old.Labels(4), old.Properties(4))
+	}
+	if !old.DepthFirstSearch(0, 11) {
+		t.Error("unable to find path root -> n11 in old file")
+	}
+
+	if err := ro.Close(); err != nil {
+		t.Errorf("unable to close, error: %v", err)
+	}
+	if ro.HasNode(0) {
+		t.Error("closed graph still serves lookups")
+	}
+}
+
+func TestOpenReadOnlyRejectsBadFiles(t *testing.T) {
+	g, _ := newTestGraph()
+	var buf bytes.Buffer
+	g.Freeze().WriteTo(&buf)
+
+	// corrupt sections that are the right size
+	corrupt := func(change func(f *FrozenGraph)) []byte {
+		f := g.Freeze()
+		change(f)
+		var buf bytes.Buffer
+		f.WriteTo(&buf)
+		return buf.Bytes()
+	}
+
+	dir := t.TempDir()
+	for name, data := range map[string][]byte{
+		"empty":     nil,
+		"truncated": buf.Bytes()[:buf.Len()-16],
+		"gob":       []byte("not a frozen graph file at all, just some text"),
+		"target":    corrupt(func(f *FrozenGraph) { f.outTargets[0] = 1 << 30 }),
+		"offset":    corrupt(func(f *FrozenGraph) { f.outOffsets[1] = f.outOffsets[len(f.outOffsets)-1] + 1 }),
+		"key order": corrupt(func(f *FrozenGraph) { f.keyOrder[0] = uint32(len(f.ids)) }),
+		"ids":       corrupt(func(f *FrozenGraph) { f.ids[1] = f.ids[0] }),
+		"kind":      corrupt(func(f *FrozenGraph) { f.kinds[0] = uint32(len(f.nameOffsets)) }),
+		"labels":    corrupt(func(f *FrozenGraph) { f.labelOffsets[1] = f.labelOffsets[len(f.labelOffsets)-1] + 1 }),
+		"names":     corrupt(func(f *FrozenGraph) { f.nameOffsets[0] = 1 }),
+	} {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, data, 0644)
+		if _, err := OpenReadOnly(path); err == nil || err.Error() != ErrNotFrozenFile {
+			t.Errorf("%s: got `%v`, want `%s`", name, err, ErrNotFrozenFile)
+		}
+	}
+}
@@ -0,0 +1,405 @@
+package giraffe
+
+import (
+	"crypto/rand"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// Replication error constants
+const (
+	// ErrTimeout returns when a follower does not catch up in time
+	ErrTimeout = "timeout"
+
+	// ErrClosed returns when using a closed primary or follower
+	ErrClosed = "closed"
+)
+
+// DefaultBacklog is the number of log entries a primary keeps for followers that reconnect
+const DefaultBacklog = 10000
+
+// replicaRetry is how long a follower waits before reconnecting
+const replicaRetry = 100 * time.Millisecond
+
+// replicaHello is the first message from a follower. From is the sequence number of the next
+// entry it needs, or 0 if it has no graph yet, and Primary the ID of the primary its graph came
+// from. Sequence numbers are only meaningful to the primary that assigned them.
+type replicaHello struct {
+	From    uint64
+	Primary string
+}
+
+// replicaMessage is sent from a primary to a follower. A snapshot replaces the follower's graph
+// with the encoded graph as of Seq and carries the primary's ID; entries are applied in order.
+type replicaMessage struct {
+	Snapshot []byte
+	Seq      uint64
+	Primary  string
+	Entries  []LogEntry
+}
+
+// Primary streams a graph's mutations to followers over TCP. Recent entries are kept in a
+// backlog so a follower that reconnects can catch up; a follower that is further behind is sent
+// a snapshot of the whole graph first. Index, schema and ID allocator changes are not streamed
+// and only reach followers through snapshots. Each primary has a random ID, so followers of a
+// restarted primary, whose sequence numbers start again, are sent a snapshot.
+type Primary struct {
+	g        *Graph
+	listener net.Listener
+	backlog  int
+	id       string
+
+	sync.Mutex
+	cond *sync.Cond
+	// log is a ring buffer of up to backlog entries. The entry numbered first is at head.
+	log    []LogEntry
+	head   int
+	first  uint64
+	closed bool
+	conns  map[net.Conn]bool
+}
+
+// NewPrimary serves the graph's mutations to followers connecting to the listener, keeping up
+// to backlog entries for catching up. A backlog of zero uses DefaultBacklog.
+func NewPrimary(g *Graph, listener net.Listener, backlog int) *Primary {
+	if backlog <= 0 {
+		backlog = DefaultBacklog
+	}
+	p := &Primary{
+		g:        g,
+		listener: listener,
+		backlog:  backlog,
+		id:       newPrimaryID(),
+		conns:    make(map[net.Conn]bool),
+	}
+	p.cond = sync.NewCond(&p.Mutex)
+
+	g.Lock()
+	p.first = g.seq + 1
+	g.listen(p.record)
+	g.Unlock()
+
+	go p.accept()
+	return p
+}
+
+// newPrimaryID returns a random primary ID
+func newPrimaryID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Addr returns the address followers connect to
+func (p *Primary) Addr() net.Addr {
+	return p.listener.Addr()
+}
+
+// Close stops serving followers. The graph keeps working.
+func (p *Primary) Close() error {
+	p.Lock()
+	defer p.Unlock()
+
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	p.cond.Broadcast()
+	for conn := range p.conns {
+		conn.Close()
+	}
+	return p.listener.Close()
+}
+
+// record adds a committed mutation to the backlog. It is a graph listener.
+func (p *Primary) record(e LogEntry) {
+	p.Lock()
+	defer p.Unlock()
+
+	if p.closed {
+		return
+	}
+	if len(p.log) < p.backlog {
+		p.log = append(p.log, e)
+	} else {
+		// overwrite the oldest entry
+		p.log[p.head] = e
+		p.head = (p.head + 1) % len(p.log)
+		p.first++
+	}
+	p.cond.Broadcast()
+}
+
+// entries copies the backlog from a sequence number on. The caller must hold the primary's lock.
+func (p *Primary) entries(from uint64) []LogEntry {
+	entries := make([]LogEntry, len(p.log)-int(from-p.first))
+	start := (p.head + int(from-p.first)) % len(p.log)
+	n := copy(entries, p.log[start:])
+	copy(entries[n:], p.log)
+	return entries
+}
+
+// accept serves each connecting follower
+func (p *Primary) accept() {
+	for {
+		conn, err := p.listener.Accept()
+		if err != nil {
+			return
+		}
+		p.Lock()
+		if p.closed {
+			p.Unlock()
+			conn.Close()
+			return
+		}
+		p.conns[conn] = true
+		p.Unlock()
+
+		go p.serve(conn)
+	}
+}
+
+// serve streams entries to a follower, starting with a snapshot when the entries it needs are
+// no longer in the backlog
+func (p *Primary) serve(conn net.Conn) {
+	defer func() {
+		p.Lock()
+		delete(p.conns, conn)
+		p.Unlock()
+		conn.Close()
+	}()
+
+	var hello replicaHello
+	if err := gob.NewDecoder(conn).Decode(&hello); err != nil {
+		return
+	}
+	encoder := gob.NewEncoder(conn)
+
+	next := hello.From
+	if hello.Primary != p.id {
+		// the follower's sequence numbers came from another primary
+		next = 0
+	}
+	for {
+		p.Lock()
+		for !p.closed && next >= p.first && next >= p.first+uint64(len(p.log)) {
+			p.cond.Wait()
+		}
+		if p.closed {
+			p.Unlock()
+			return
+		}
+		if next == 0 || next < p.first {
+			p.Unlock()
+			msg, err := p.snapshot()
+			if err != nil || encoder.Encode(msg) != nil {
+				return
+			}
+			next = msg.Seq + 1
+			continue
+		}
+		entries := p.entries(next)
+		p.Unlock()
+
+		if err := encoder.Encode(replicaMessage{Entries: entries}); err != nil {
+			return
+		}
+		next += uint64(len(entries))
+	}
+}
+
+// snapshot encodes the graph along with the sequence number it is current to
+func (p *Primary) snapshot() (replicaMessage, error) {
+	p.g.Lock()
+	defer p.g.Unlock()
+
+	data, err := p.g.encode()
+	return replicaMessage{Snapshot: data, Seq: p.g.seq, Primary: p.id}, err
+}
+
+// Follower keeps a read only replica of a primary's graph, reconnecting as needed
+type Follower struct {
+	dial func() (net.Conn, error)
+
+	sync.Mutex
+	cond    *sync.Cond
+	g       *Graph
+	applied uint64
+	primary string
+	err     error
+	conn    net.Conn
+	closed  bool
+}
+
+// Follow replicates the graph served by the primary at a TCP address
+func Follow(addr string) *Follower {
+	return NewFollower(func() (net.Conn, error) {
+		return net.Dial("tcp", addr)
+	})
+}
+
+// NewFollower replicates a primary's graph over connections made by dial
+func NewFollower(dial func() (net.Conn, error)) *Follower {
+	f := &Follower{dial: dial}
+	f.cond = sync.NewCond(&f.Mutex)
+	go f.run()
+	return f
+}
+
+// Graph returns the replica, or nil before the first snapshot arrives. The replica is replaced
+// when the follower has to catch up from a snapshot. It is read only: changes to it return
+// ErrReadOnly, and InsertNode() returns nil.
+func (f *Follower) Graph() *Graph {
+	f.Lock()
+	defer f.Unlock()
+
+	return f.g
+}
+
+// Seq returns the sequence number of the latest entry applied to the replica
+func (f *Follower) Seq() uint64 {
+	f.Lock()
+	defer f.Unlock()
+
+	return f.applied
+}
+
+// Err returns the last error that interrupted replication, if any
+func (f *Follower) Err() error {
+	f.Lock()
+	defer f.Unlock()
+
+	return f.err
+}
+
+// WaitFor blocks until the replica has applied the sequence number, giving read your writes:
+// read Seq() from the primary's graph after a write and wait for it before reading the replica.
+func (f *Follower) WaitFor(seq uint64, timeout time.Duration) error {
+	timer := time.AfterFunc(timeout, func() {
+		f.Lock()
+		defer f.Unlock()
+		f.cond.Broadcast()
+	})
+	defer timer.Stop()
+	deadline := time.Now().Add(timeout)
+
+	f.Lock()
+	defer f.Unlock()
+	for f.applied < seq || f.g == nil {
+		if f.closed {
+			return errors.New(ErrClosed)
+		}
+		if !time.Now().Before(deadline) {
+			return errors.New(ErrTimeout)
+		}
+		f.cond.Wait()
+	}
+	return nil
+}
+
+// Close stops replicating. The replica can still be read.
+func (f *Follower) Close() error {
+	f.Lock()
+	defer f.Unlock()
+
+	f.closed = true
+	f.cond.Broadcast()
+	if f.conn != nil {
+		return f.conn.Close()
+	}
+	return nil
+}
+
+// run connects to the primary until the follower is closed
+func (f *Follower) run() {
+	for {
+		err := f.replicate()
+
+		f.Lock()
+		if f.closed {
+			f.Unlock()
+			return
+		}
+		f.err = err
+		f.conn = nil
+		f.Unlock()
+		time.Sleep(replicaRetry)
+	}
+}
+
+// replicate applies messages from one connection until it fails
+func (f *Follower) replicate() error {
+	conn, err := f.dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	f.Lock()
+	if f.closed {
+		f.Unlock()
+		return errors.New(ErrClosed)
+	}
+	f.conn = conn
+	hello := replicaHello{}
+	if f.g != nil {
+		hello.From, hello.Primary = f.applied+1, f.primary
+	}
+	f.Unlock()
+
+	if err := gob.NewEncoder(conn).Encode(hello); err != nil {
+		return err
+	}
+	decoder := gob.NewDecoder(conn)
+	for {
+		var msg replicaMessage
+		if err := decoder.Decode(&msg); err != nil {
+			return err
+		}
+		if err := f.receive(msg); err != nil {
+			return err
+		}
+	}
+}
+
+// receive applies a message from the primary
+func (f *Follower) receive(msg replicaMessage) error {
+	if msg.Snapshot != nil {
+		g := &Graph{}
+		if err := g.GobDecode(msg.Snapshot); err != nil {
+			return err
+		}
+		g.seq = msg.Seq
+		g.readOnly = true
+
+		f.Lock()
+		f.g = g
+		f.applied, f.primary = msg.Seq, msg.Primary
+		f.cond.Broadcast()
+		f.Unlock()
+		return nil
+	}
+
+	f.Lock()
+	g, applied := f.g, f.applied
+	f.Unlock()
+	for _, e := range msg.Entries {
+		if e.Seq != applied+1 {
+			return errors.New("replication log out of order")
+		}
+		if _, err := g.apply(e.Op); err != nil {
+			return err
+		}
+		applied = e.Seq
+
+		f.Lock()
+		f.applied = applied
+		f.cond.Broadcast()
+		f.Unlock()
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+package giraffe
+
+import (
+	"net"
+	"reflect"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestPrimary(t *testing.T, g *Graph, backlog int) *Primary {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen, error: %v", err)
+	}
+	p := NewPrimary(g, l, backlog)
+	t.Cleanup(func() { p.Close() })
+	return p
+}
+
+// checkReplica waits for the follower to catch up and compares it with the primary's graph
+func checkReplica(t *testing.T, g *Graph, f *Follower) *Graph {
+	if err := f.WaitFor(g.Seq(), 5*time.Second); err != nil {
+		t.Fatalf("follower did not catch up, error: %v", err)
+	}
+	r := f.Graph()
+	if got, want := slices.Collect(r.Edges()), slices.Collect(g.Edges()); !reflect.DeepEqual(got, want) {
+		t.Errorf("got edges %v, want %v", got, want)
+	}
+	if r.NodeCount() != g.NodeCount() {
+		t.Errorf("got %d nodes, want %d", r.NodeCount(), g.NodeCount())
+	}
+	return r
+}
+
+func TestReplication(t *testing.T) {
+	g, _ := NewConstraintGraph("testGraph", false, false)
+	n1, _ := g.InsertDataNode("Intro", []byte("lesson_id 1"))
+	g.Root().AddRelationship(n1)
+	p := newTestPrimary(t, g, 0)
+
+	f := Follow(p.Addr().String())
+	defer f.Close()
+	r := checkReplica(t, g, f)
+	if r.Name != "testGraph" {
+		t.Errorf("got name %q, want testGraph", r.Name)
+	}
+
+	n2, _ := g.InsertDataNode("Factoring", []byte("lesson_id 12"))
+	n1.AddRelationship(n2)
+	n2.SetValue([]byte("lesson_id 13"))
+	n2.SetProperty("difficulty", IntProp(3))
+	n2.AddLabel("Lesson")
+	n3 := g.InsertNode()
+	n3.SetKey("Extra")
+	g.Root().AddRelationship(n3)
+	g.DeleteNode(n3)
+
+	r = checkReplica(t, g, f)
+	n, ok := r.FindNodeByKey("Factoring")
+	if !ok || string(n.Value) != "lesson_id 13" || !n.HasLabel("Lesson") {
+		t.Fatalf("replica node not updated: %+v", n)
+	}
+	if p, _ := n.Property("difficulty"); p.Int != 3 {
+		t.Errorf("got property %v, want 3", p)
+	}
+	if _, ok := r.FindNodeByKey("Extra"); ok {
+		t.Error("deleted node still on replica")
+	}
+
+	if _, err := r.InsertDataNode("Local", nil); err == nil || err.Error() != ErrReadOnly {
+		t.Errorf("got `%v`, want `%s`", err, ErrReadOnly)
+	}
+	if err := n.AddLabel("Local"); err == nil || err.Error() != ErrReadOnly {
+		t.Errorf("got `%v`, want `%s`", err, ErrReadOnly)
+	}
+	if err := r.SetSchema(&Schema{}); err == nil || err.Error() != ErrReadOnly {
+		t.Errorf("got `%v`, want `%s`", err, ErrReadOnly)
+	}
+	if n := r.InsertNode(); n != nil || r.NodeCount() != g.NodeCount() {
+		t.Errorf("got node %+v, want none", n)
+	}
+}
+
+func TestReplicationMaxNodes(t *testing.T) {
+	g, _ := New("testGraph", WithMaxNodes(1))
+	g.InsertNode()
+	p := newTestPrimary(t, g, 0)
+
+	f := Follow(p.Addr().String())
+	defer f.Close()
+	checkReplica(t, g, f)
+
+	// InsertNode goes past the soft limit, and the replica follows
+	g.InsertNode()
+	checkReplica(t, g, f)
+	n1, _ := g.Node(1)
+	n2, _ := g.Node(2)
+	n1.AddRelationship(n2)
+	checkReplica(t, g, f)
+}
+
+func TestReplicationPrimaryRestart(t *testing.T) {
+	g, _ := NewGraph("testGraph")
+	g.InsertDataNode("Intro", nil)
+	p := newTestPrimary(t, g, 0)
+
+	var addr atomic.Value
+	addr.Store(p.Addr().String())
+	f := NewFollower(func() (net.Conn, error) {
+		return net.Dial("tcp", addr.Load().(string))
+	})
+	defer f.Close()
+	first := checkReplica(t, g, f)
+
+	// a restarted primary numbers its changes from its graph's sequence number again, so the
+	// follower's position means nothing to it
+	restarted, _ := NewGraph("testGraph")
+	restarted.InsertDataNode("Factoring", nil)
+	p.Close()
+	addr.Store(newTestPrimary(t, restarted, 0).Addr().String())
+	restarted.InsertDataNode("Graphing", nil)
+
+	r := checkReplica(t, restarted, f)
+	if r == first {
+		t.Error("replica not replaced by a snapshot")
+	}
+	if _, ok := r.FindNodeByKey("Intro"); ok {
+		t.Error("replica kept a node of the old primary")
+	}
+}
+
+func TestReplicationBacklog(t *testing.T) {
+	g, _ := NewGraph("testGraph")
+	p := newTestPrimary(t, g, 3)
+	for i := 0; i < 5; i++ {
+		g.InsertNode()
+	}
+
+	p.Lock()
+	defer p.Unlock()
+	if p.first != 3 || len(p.log) != 3 {
+		t.Fatalf("got backlog from %d of %d entries, want from 3 of 3", p.first, len(p.log))
+	}
+	var got []uint64
+	for _, e := range p.entries(4) {
+		got = append(got, e.Seq)
+	}
+	if want := []uint64{4, 5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got entries %v, want %v", got, want)
+	}
+}
+
+func TestReplicationCatchUp(t *testing.T) {
+	g, _ := NewGraph("testGraph")
+	p := newTestPrimary(t, g, 3)
+
+	conns := make(chan net.Conn, 1)
+	allow := make(chan bool, 1)
+	allow <- true
+	f := NewFollower(func() (net.Conn, error) {
+		<-allow
+		conn, err := net.Dial("tcp", p.Addr().String())
+		if err == nil {
+			conns <- conn
+		}
+		return conn, err
+	})
+	defer f.Close()
+
+	g.InsertNode()
+	first := checkReplica(t, g, f)
+
+	// a short disconnection is caught up from the backlog
+	(<-conns).Close()
+	g.InsertNode()
+	allow <- true
+	if checkReplica(t, g, f) != first {
+		t.Error("replica replaced when the backlog was enough")
+	}
+
+	// a long one needs a snapshot
+	(<-conns).Close()
+	for i := 0; i < 10; i++ {
+		g.InsertNode()
+	}
+	allow <- true
+	if checkReplica(t, g, f) == first {
+		t.Error("replica not replaced by a snapshot")
+	}
+
+	if err := f.WaitFor(g.Seq()+1, 10*time.Millisecond); err == nil || err.Error() != ErrTimeout {
+		t.Errorf("got `%v`, want `%s`", err, ErrTimeout)
+	}
+}
@@ -0,0 +1,330 @@
+package giraffe
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"unicode/utf8"
+)
+
+// Value formats understood by KindDef.Format
+const (
+	// FormatAny accepts any value
+	FormatAny = ""
+
+	// FormatJSON requires the value to be valid JSON
+	FormatJSON = "json"
+
+	// FormatText requires the value to be valid UTF-8
+	FormatText = "text"
+
+	// FormatInteger requires the value to be a base 10 integer
+	FormatInteger = "integer"
+)
+
+// Schema declares which kinds of nodes a graph holds and which kinds may be related.
+// A schema is enforced by SetSchema() before validators run and is saved with the graph.
+type Schema struct {
+	// Kinds maps a node kind to its definition
+	Kinds map[string]KindDef
+
+	// Edges lists the allowed relationships between kinds. When empty, any kinds may be related.
+	Edges []EdgeRule
+
+	// Strict rejects nodes without a declared kind. The root node, and relationships
+	// to and from it, are always allowed.
+	Strict bool
+}
+
+// KindDef describes the nodes of a single kind
+type KindDef struct {
+	// RequireKey rejects nodes of this kind with an empty key
+	RequireKey bool
+
+	// RequireValue rejects nodes of this kind with an empty value
+	RequireValue bool
+
+	// Format is one of the Format constants and is checked on insert and SetValue()
+	Format string
+
+	// Required lists properties that nodes of this kind must always have.
+	// Such nodes must be created with InsertPropertyNode().
+	Required []string
+
+	// Properties restricts the type of the named properties
+	Properties map[string]PropertyType
+
+	// Color is any CSS color and is used by ToVisJS()
+	Color string
+}
+
+// EdgeRule allows relationships from nodes of kind From to nodes of kind To. When Type is set,
+// only relationships of that type are allowed, such as Lesson -requires-> Lesson. See
+// AddTypedRelationship().
+type EdgeRule struct {
+	From string
+	To   string
+	Type string
+}
+
+// SetSchema installs a copy of a schema on the graph after checking the existing nodes and
+// relationships against it, so later changes to s have no effect. Passing nil removes the schema.
+func (g *Graph) SetSchema(s *Schema) error {
+	g.Lock()
+	defer g.Unlock()
+
+	if err := g.writable(); err != nil {
+		return err
+	}
+	s = s.clone()
+	if s != nil {
+		if err := g.loadAll(); err != nil {
+			return err
+		}
+		if err := s.checkGraph(g); err != nil {
+			return err
+		}
+	}
+
+	g.hookLock.Lock()
+	old := g.schema
+	g.schema = s
+	g.hookLock.Unlock()
+
+	if err := g.saveMeta(); err != nil {
+		g.hookLock.Lock()
+		g.schema = old
+		g.hookLock.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Schema returns a copy of the schema installed on the graph, if any. Use SetSchema() to
+// change it.
+func (g *Graph) Schema() *Schema {
+	g.hookLock.RLock()
+	defer g.hookLock.RUnlock()
+
+	return g.schema.clone()
+}
+
+// clone returns a deep copy of the schema
+func (s *Schema) clone() *Schema {
+	if s == nil {
+		return nil
+	}
+	c := &Schema{Edges: slices.Clone(s.Edges), Strict: s.Strict}
+	if s.Kinds != nil {
+		c.Kinds = make(map[string]KindDef, len(s.Kinds))
+		for kind, def := range s.Kinds {
+			def.Required = slices.Clone(def.Required)
+			def.Properties = maps.Clone(def.Properties)
+			c.Kinds[kind] = def
+		}
+	}
+	return c
+}
+
+// check verifies a single pending operation against the schema
+func (s *Schema) check(g *Graph, op Operation) error {
+	switch op.Kind {
+	case OpInsertNode:
+		if err := s.checkNode(op.NodeKind, op.Key, op.Value); err != nil {
+			return err
+		}
+		return s.checkProperties(op.NodeKind, op.Properties)
+	case OpSetProperty, OpDeleteProperty:
+		kind, ok := g.nodeKind(op.NodeID)
+		if !ok || op.NodeID == 0 {
+			return nil
+		}
+		return s.checkProperty(kind, op.PropertyName, op.Property)
+	case OpSetValue, OpSetKey:
+		kind, ok := g.nodeKind(op.NodeID)
+		if !ok || op.NodeID == 0 {
+			return nil
+		}
+		return s.checkNode(kind, op.Key, op.Value)
+	case OpAddRelationship:
+		if op.NodeID == 0 || op.OtherID == 0 {
+			return nil
+		}
+		from, _ := g.nodeKind(op.NodeID)
+		to, _ := g.nodeKind(op.OtherID)
+		return s.checkEdge(from, to, op.RelationshipType, g.undirected)
+	}
+	return nil
+}
+
+// checkGraph verifies every node and relationship in the graph against the schema. The caller
+// must hold the graph lock.
+func (s *Schema) checkGraph(g *Graph) error {
+	for id, node := range g.nodes {
+		node.RLock()
+		kind, key, value := node.Kind, node.Key, node.Value
+		destinations, edgeTypes := node.destinations, node.edgeTypes
+		props := node.properties
+		node.RUnlock()
+
+		if id == 0 {
+			continue
+		}
+		if err := s.checkNode(kind, key, value); err != nil {
+			return fmt.Errorf("node %d: %v", id, err)
+		}
+		if err := s.checkProperties(kind, props); err != nil {
+			return fmt.Errorf("node %d: %v", id, err)
+		}
+		for i, dest := range destinations {
+			if dest.ID == 0 {
+				continue
+			}
+			if err := s.checkEdge(kind, dest.Kind, edgeTypes[i], g.undirected); err != nil {
+				return fmt.Errorf("node %d -> node %d: %v", id, dest.ID, err)
+			}
+		}
+	}
+	return nil
+}
+
+// checkNode verifies a node's kind, key and value
+func (s *Schema) checkNode(kind, key string, value []byte) error {
+	def, ok := s.Kinds[kind]
+	if !ok {
+		if kind == "" && !s.Strict {
+			return nil
+		}
+		return fmt.Errorf("schema: undeclared kind %q", kind)
+	}
+
+	if def.RequireKey && key == "" {
+		return fmt.Errorf("schema: kind %q requires a key", kind)
+	}
+	if def.RequireValue && len(value) == 0 {
+		return fmt.Errorf("schema: kind %q requires a value", kind)
+	}
+	if err := checkFormat(def.Format, value); err != nil {
+		return fmt.Errorf("schema: kind %q: %v", kind, err)
+	}
+	return nil
+}
+
+// checkProperties verifies a complete set of properties for a node of the given kind
+func (s *Schema) checkProperties(kind string, props map[string]Property) error {
+	def := s.Kinds[kind]
+	for _, name := range def.Required {
+		if _, ok := props[name]; !ok {
+			return fmt.Errorf("schema: kind %q requires property %q", kind, name)
+		}
+	}
+	for name, p := range props {
+		p := p
+		if err := s.checkProperty(kind, name, &p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkProperty verifies a single property change. A nil property is a delete.
+func (s *Schema) checkProperty(kind, name string, p *Property) error {
+	def := s.Kinds[kind]
+	if p == nil {
+		for _, required := range def.Required {
+			if required == name {
+				return fmt.Errorf("schema: kind %q requires property %q", kind, name)
+			}
+		}
+		return nil
+	}
+
+	if want, ok := def.Properties[name]; ok && p.Type != want {
+		return fmt.Errorf("schema: kind %q property %q must be %s, got %s", kind, name, want, p.Type)
+	}
+	return nil
+}
+
+// checkEdge verifies that nodes of kind from may point to nodes of kind to with a relationship
+// of type relType. Untyped nodes may be related to anything unless the schema is strict.
+// Rules match either way round in an undirected graph.
+func (s *Schema) checkEdge(from, to, relType string, undirected bool) error {
+	if len(s.Edges) == 0 {
+		return nil
+	}
+	if (from == "" || to == "") && !s.Strict {
+		return nil
+	}
+
+	for _, rule := range s.Edges {
+		if rule.Type != "" && rule.Type != relType {
+			continue
+		}
+		if rule.From == from && rule.To == to {
+			return nil
+		}
+		if undirected && rule.From == to && rule.To == from {
+			return nil
+		}
+	}
+	if relType != "" {
+		return fmt.Errorf("schema: relationship %q -%s-> %q is not allowed", from, relType, to)
+	}
+	return fmt.Errorf("schema: relationship %q -> %q is not allowed", from, to)
+}
+
+// checkFormat verifies that the value matches the named format
+func checkFormat(format string, value []byte) error {
+	switch format {
+	case FormatAny:
+		return nil
+	case FormatJSON:
+		if !json.Valid(value) {
+			return errors.New("value is not valid json")
+		}
+	case FormatText:
+		if !utf8.Valid(value) {
+			return errors.New("value is not valid text")
+		}
+	case FormatInteger:
+		if _, err := strconv.ParseInt(string(value), 10, 64); err != nil {
+			return errors.New("value is not an integer")
+		}
+	default:
+		return fmt.Errorf("unknown value format %q", format)
+	}
+	return nil
+}
+
+// nodeKind looks up the kind of a node by its ID
+func (g *Graph) nodeKind(ID uint64) (string, bool) {
+	g.Lock()
+	node, ok := g.node(ID)
+	g.Unlock()
+	if !ok {
+		return "", false
+	}
+
+	node.RLock()
+	defer node.RUnlock()
+	return node.Kind, true
+}
+
+// visKindStyle returns the extra vis.js node attributes used to color code a node kind.
+// Kinds share a vis.js group so undeclared colors are still distinguished.
+func (g *Graph) visKindStyle(kind string) string {
+	if kind == "" {
+		return ""
+	}
+
+	style := fmt.Sprintf(`, group: %s`, jsString(kind))
+	g.hookLock.RLock()
+	defer g.hookLock.RUnlock()
+	if s := g.schema; s != nil && s.Kinds[kind].Color != "" {
+		style += fmt.Sprintf(`, color: %s`, jsString(s.Kinds[kind].Color))
+	}
+	return style
+}
@@ -0,0 +1,189 @@
+package giraffe
+
+import (
+	"strings"
+	"testing"
+)
+
+func newTestSchema() *Schema {
+	return &Schema{
+		Kinds: map[string]KindDef{
+			"Lesson": {RequireKey: true, Format: FormatJSON, Color: "lightblue"},
+			"Quiz":   {RequireKey: true},
+		},
+		Edges: []EdgeRule{
+			{From: "Lesson", To: "Lesson"},
+			{From: "Lesson", To: "Quiz"},
+		},
+		Strict: true,
+	}
+}
+
+func TestSchemaRejectsNodes(t *testing.T) {
+	g, _ := NewGraph("testGraph")
+	if err := g.SetSchema(newTestSchema()); err != nil {
+		t.Fatalf("unable to set schema, error: %v", err)
+	}
+
+	if _, err := g.InsertKindNode("Lesson", "Intro", []byte(`{"lesson_id": 1}`)); err != nil {
+		t.Errorf("expected no error, got `%v`", err)
+	}
+
+	tests := []struct {
+		kind, key, value string
+	}{
+		{"Lesson", "Bad Value", "lesson_id 1"},
+		{"Lesson", "", "{}"},
+		{"Video", "Unknown Kind", ""},
+		{"", "Untyped", ""},
+	}
+	for _, test := range tests {
+		if _, err := g.InsertKindNode(test.kind, test.key, []byte(test.value)); err == nil {
+			t.Errorf("expected schema to reject %+v", test)
+		}
+	}
+
+	n, _ := g.FindNodeByKey("Intro")
+	if err := n.SetValue([]byte("not json")); err == nil {
+		t.Error("expected schema to reject SetValue")
+	}
+}
+
+func TestSchemaRejectsRelationships(t *testing.T) {
+	g, _ := NewGraph("testGraph")
+	g.SetSchema(newTestSchema())
+
+	l1, _ := g.InsertKindNode("Lesson", "Factoring", []byte("{}"))
+	l2, _ := g.InsertKindNode("Lesson", "Quadratic Formula", []byte("{}"))
+	q1, _ := g.InsertKindNode("Quiz", "Quadratic Quiz", nil)
+
+	if err := g.Root().AddRelationship(l1); err != nil {
+		t.Errorf("relationships from root should be allowed, got `%v`", err)
+	}
+	if err := l1.AddRelationship(l2); err != nil {
+		t.Errorf("expected no error, got `%v`", err)
+	}
+	if err := l2.AddRelationship(q1); err != nil {
+		t.Errorf("expected no error, got `%v`", err)
+	}
+	if err := q1.AddRelationship(l1); err == nil {
+		t.Error("expected schema to reject Quiz -> Lesson")
+	}
+}
+
+func TestSchemaRelationshipTypes(t *testing.T) {
+	g, _ := NewGraph("testGraph")
+	g.SetSchema(&Schema{
+		Kinds: map[string]KindDef{"Lesson": {}, "Quiz": {}},
+		Edges: []EdgeRule{
+			{From: "Lesson", To: "Lesson", Type: "requires"},
+			{From: "Lesson", To: "Quiz", Type: "assesses"},
+		},
+	})
+	l1, _ := g.InsertKindNode("Lesson", "Factoring", nil)
+	l2, _ := g.InsertKindNode("Lesson", "Quadratic Formula", nil)
+	q1, _ := g.InsertKindNode("Quiz", "Quadratic Quiz", nil)
+
+	ID, err := l2.AddTypedRelationship(l1, "requires")
+	if err != nil {
+		t.Fatalf("unable to add relationship, error: %v", err)
+	}
+	if _, err := l2.AddTypedRelationship(q1, "assesses"); err != nil {
+		t.Errorf("expected no error, got `%v`", err)
+	}
+	if _, err := l2.AddTypedRelationship(q1, "requires"); err == nil {
+		t.Error("expected schema to reject Lesson -requires-> Quiz")
+	}
+	if err := l2.AddRelationship(l1); err == nil {
+		t.Error("expected schema to reject an untyped Lesson -> Lesson")
+	}
+
+	// types are saved with the graph and the store
+	if relType, ok := copyGraph(t, g).RelationshipType(ID); !ok || relType != "requires" {
+		t.Errorf("got type %q after decoding, want requires", relType)
+	}
+	store := NewMemoryStore()
+	s, _ := New("testGraph", WithStore(store))
+	s1 := s.InsertNode()
+	ID, _ = s.Root().AddTypedRelationship(s1, "contains")
+	s, _ = OpenGraph(store)
+	if relType, ok := s.RelationshipType(ID); !ok || relType != "contains" {
+		t.Errorf("got type %q after opening, want contains", relType)
+	}
+
+	// existing relationships are checked by type
+	g, _ = NewGraph("testGraph")
+	l1, _ = g.InsertKindNode("Lesson", "Factoring", nil)
+	q1, _ = g.InsertKindNode("Quiz", "Quadratic Quiz", nil)
+	l1.AddTypedRelationship(q1, "requires")
+	if err := g.SetSchema(&Schema{Edges: []EdgeRule{{From: "Lesson", To: "Quiz", Type: "assesses"}}}); err == nil {
+		t.Error("expected existing relationship to fail the schema")
+	}
+}
+
+func TestSetSchemaChecksExistingGraph(t *testing.T) {
+	g, _ := NewGraph("testGraph")
+	g.InsertKindNode("Lesson", "Intro", []byte("lesson_id 1"))
+
+	if err := g.SetSchema(newTestSchema()); err == nil {
+		t.Error("expected existing node to fail the schema")
+	}
+	if g.Schema() != nil {
+		t.Error("schema should not be installed")
+	}
+}
+
+func TestSchemaEncodeDecodeAndVisJS(t *testing.T) {
+	g, _ := NewGraph("testGraph")
+	g.SetSchema(newTestSchema())
+	g.InsertKindNode("Lesson", "Intro", []byte("{}"))
+
+	data, err := g.GobEncode()
+	if err != nil {
+		t.Fatalf("unable to encode graph - err `%v`", err)
+	}
+	decodedGraph := &Graph{}
+	if err := decodedGraph.GobDecode(data); err != nil {
+		t.Fatalf("unable to decode graph - err `%v`", err)
+	}
+
+	s := decodedGraph.Schema()
+	if s == nil || !s.Strict || s.Kinds["Lesson"].Format != FormatJSON || len(s.Edges) != 2 {
+		t.Fatalf("schema not restored, got %+v", s)
+	}
+	if decodedGraph.nodes[1].Kind != "Lesson" {
+		t.Errorf("got kind `%s`, want `Lesson`", decodedGraph.nodes[1].Kind)
+	}
+
+	if _, err := decodedGraph.InsertKindNode("Video", "Unknown", nil); err == nil {
+		t.Error("expected restored schema to be enforced")
+	}
+
+	js := decodedGraph.ToVisJS(false, true, false)
+	if !strings.Contains(js, `group: "Lesson", color: "lightblue"`) {
+		t.Error("expected Lesson nodes to be color coded")
+	}
+}
+
+func TestSchemaCopied(t *testing.T) {
+	g, _ := NewGraph("testGraph")
+	s := newTestSchema()
+	if err := g.SetSchema(s); err != nil {
+		t.Fatalf("unable to set schema, error: %v", err)
+	}
+
+	// changing the schema afterwards does not skip the check of the existing nodes
+	s.Strict = false
+	g.Schema().Strict = false
+	if _, err := g.InsertDataNode("Untyped", nil); err == nil {
+		t.Error("strict schema not enforced")
+	}
+
+	// kinds are escaped in the generated Javascript
+	g.SetSchema(nil)
+	g.InsertKindNode("x'});alert(1);//</script>", "Intro", nil)
+	js := g.ToVisJS(false, true, false)
+	if !strings.Contains(js, `group: "x'});alert(1);//\u003c/script\u003e"}`) {
+		t.Errorf("kind not escaped in %s", js)
+	}
+}
@@ -0,0 +1,181 @@
+package giraffe
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// weights given to terms found in a node's key and value
+const (
+	keyTermWeight   = 2
+	valueTermWeight = 1
+)
+
+// searchIndex is an inverted index from case folded terms to the nodes containing them
+type searchIndex struct {
+	// values controls whether text values are indexed alongside keys
+	values bool
+
+	postings map[string]map[uint64]int
+	docs     map[uint64]map[string]int
+
+	// terms is the sorted list of postings keys used for prefix matching.
+	// it is nil when it needs to be rebuilt
+	terms []string
+}
+
+// newSearchIndex creates an empty search index
+func newSearchIndex() *searchIndex {
+	return &searchIndex{
+		postings: make(map[string]map[uint64]int),
+		docs:     make(map[uint64]map[string]int),
+	}
+}
+
+// Search finds nodes whose key, and text value if enabled with SetSearchValues(), contain words
+// starting with the words in the query. Matching is case insensitive. Results are ranked by the
+// number of query words matched, then by score, then by ID. A limit of zero or less returns every match.
+func (g *Graph) Search(query string, limit int) []uint64 {
+	g.Lock()
+	defer g.Unlock()
+
+	g.loadAll()
+	return g.search.search(query, limit)
+}
+
+// SetSearchValues controls whether node values that are valid text are included in Search().
+// Keys are always searched. Changing the setting rebuilds the search index.
+func (g *Graph) SetSearchValues(enabled bool) {
+	g.Lock()
+	defer g.Unlock()
+
+	g.loadAll()
+	g.search = newSearchIndex()
+	g.search.values = enabled
+	g.rebuildSearch()
+	g.storeFailed(g.saveMeta())
+}
+
+// rebuildSearch indexes every node. The caller must hold the graph lock.
+func (g *Graph) rebuildSearch() {
+	for _, node := range g.nodes {
+		node.RLock()
+		g.search.index(node)
+		node.RUnlock()
+	}
+}
+
+// index replaces any indexed terms for the node with the terms in its current key and value.
+// The caller must hold the graph lock and at least a read lock on the node.
+func (s *searchIndex) index(n *Node) {
+	s.remove(n.ID)
+
+	doc := make(map[string]int)
+	for _, term := range tokenize(n.Key) {
+		doc[term] += keyTermWeight
+	}
+	if s.values && utf8.Valid(n.Value) {
+		for _, term := range tokenize(string(n.Value)) {
+			doc[term] += valueTermWeight
+		}
+	}
+	if len(doc) == 0 {
+		return
+	}
+
+	s.docs[n.ID] = doc
+	for term, weight := range doc {
+		if s.postings[term] == nil {
+			s.postings[term] = make(map[uint64]int)
+			s.terms = nil
+		}
+		s.postings[term][n.ID] = weight
+	}
+}
+
+// remove drops a node from the index
+func (s *searchIndex) remove(ID uint64) {
+	for term := range s.docs[ID] {
+		delete(s.postings[term], ID)
+		if len(s.postings[term]) == 0 {
+			delete(s.postings, term)
+			s.terms = nil
+		}
+	}
+	delete(s.docs, ID)
+}
+
+// search ranks the nodes matching the query
+func (s *searchIndex) search(query string, limit int) []uint64 {
+	if s.terms == nil {
+		s.terms = make([]string, 0, len(s.postings))
+		for term := range s.postings {
+			s.terms = append(s.terms, term)
+		}
+		sort.Strings(s.terms)
+	}
+
+	scores := make(map[uint64]int)
+	matched := make(map[uint64]int)
+	for _, word := range uniqueStrings(tokenize(query)) {
+		hits := make(map[uint64]int)
+		for i := sort.SearchStrings(s.terms, word); i < len(s.terms) && strings.HasPrefix(s.terms[i], word); i++ {
+			term := s.terms[i]
+			for id, weight := range s.postings[term] {
+				// exact word matches rank above prefix matches
+				if term == word {
+					weight *= 2
+				}
+				if weight > hits[id] {
+					hits[id] = weight
+				}
+			}
+		}
+		for id, weight := range hits {
+			scores[id] += weight
+			matched[id]++
+		}
+	}
+
+	results := make([]uint64, 0, len(scores))
+	for id := range scores {
+		results = append(results, id)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if matched[a] != matched[b] {
+			return matched[a] > matched[b]
+		}
+		if scores[a] != scores[b] {
+			return scores[a] > scores[b]
+		}
+		return a < b
+	})
+
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+// tokenize splits text into lower case words made of letters and digits
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// uniqueStrings removes repeated strings while keeping their order
+func uniqueStrings(list []string) []string {
+	seen := make(map[string]bool, len(list))
+	var unique []string
+	for _, s := range list {
+		if !seen[s] {
+			seen[s] = true
+			unique = append(unique, s)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,104 @@
+package giraffe
+
+import (
+	"reflect"
+	"testing"
+)
+
+func newSearchTestGraph() *Graph {
+	g, _ := NewGraph("testGraph")
+	for _, key := range []string{
+		"Quadratic Formula",
+		"Factoring Quadratic Polynomials",
+		"Graphing Quadratics",
+		"Graphing Linear Equations",
+		"Polynomials",
+	} {
+		g.InsertDataNode(key, nil)
+	}
+	return g
+}
+
+func TestSearchKeys(t *testing.T) {
+	g := newSearchTestGraph()
+
+	// exact word matches rank above prefix matches, then lower IDs first
+	if got, want := g.Search("quadratic", 0), []uint64{1, 2, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := g.Search("QUADRATICS", 0), []uint64{3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// nodes matching more query words rank first
+	if got, want := g.Search("graphing quad", 0), []uint64{3, 4, 1, 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := g.Search("graph", 1), []uint64{3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got := g.Search("calculus", 0); len(got) != 0 {
+		t.Errorf("got %v, want no results", got)
+	}
+}
+
+func TestSearchFollowsMutations(t *testing.T) {
+	g := newSearchTestGraph()
+
+	g.nodes[1].SetKey("The Quadratic Formula")
+	g.DeleteNodeByID(2)
+	g.nodes[4].SetKey("Solving Linear Equations")
+	n, _ := g.InsertDataNode("Completing the Square", nil)
+
+	if got, want := g.Search("quadratic", 0), []uint64{1, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := g.Search("graphing", 0), []uint64{3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := g.Search("square", 0), []uint64{n.ID}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestSearchValues(t *testing.T) {
+	g := newSearchTestGraph()
+	g.nodes[5].SetValue([]byte("introduces the quadratic"))
+
+	if got := g.Search("introduces", 0); len(got) != 0 {
+		t.Errorf("values should not be searched by default, got %v", got)
+	}
+
+	g.SetSearchValues(true)
+	if got, want := g.Search("introduces", 0), []uint64{5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// key matches outrank value matches
+	if got, want := g.Search("quadratic", 0), []uint64{1, 2, 3, 5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	data, _ := g.GobEncode()
+	decodedGraph := &Graph{}
+	if err := decodedGraph.GobDecode(data); err != nil {
+		t.Fatalf("unable to decode graph - err `%v`", err)
+	}
+	if got, want := decodedGraph.Search("introduces", 0), []uint64{5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestSetKeyDuplicateKeys(t *testing.T) {
+	g, _ := NewConstraintGraph("testGraph", false, true)
+	g.InsertDataNode("key1", nil)
+	n2, _ := g.InsertDataNode("key2", nil)
+
+	if err := n2.SetKey("key1"); err == nil || err.Error() != ErrKeyExists {
+		t.Errorf("got `%v`, want `%s`", err, ErrKeyExists)
+	}
+	if err := n2.SetKey("key3"); err != nil {
+		t.Errorf("expected no error, got `%v`", err)
+	}
+	if _, err := g.InsertDataNode("key2", nil); err != nil {
+		t.Errorf("key2 should be free again, got `%v`", err)
+	}
+}
@@ -0,0 +1,820 @@
+package giraffe
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"hash/fnv"
+	"net"
+	"slices"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Sharding error constants
+const (
+	// ErrNoShards returns when creating a ShardedGraph on a transport without shards
+	ErrNoShards = "no shards"
+)
+
+// Partition chooses the shard a new node is placed on
+type Partition int
+
+const (
+	// PartitionByID spreads nodes evenly over the shards by their IDs
+	PartitionByID Partition = iota
+
+	// PartitionByKey places nodes by the hash of their key, so all nodes with the same key are
+	// on one shard and key lookups go to a single shard
+	PartitionByKey
+)
+
+// shard request kinds
+const (
+	shardInsert uint8 = iota + 1
+	shardGet
+	shardFindKey
+	shardAddLabel
+	shardDelete
+	shardAddEdge
+	shardRemoveEdge
+	shardAddSource
+	shardRemoveSource
+	shardSources
+	shardExpand
+)
+
+// ShardRequest is a request from a router to a shard. Transports deliver it as is.
+type ShardRequest struct {
+	Kind   uint8
+	IDs    []uint64
+	Other  uint64
+	Key    string
+	Value  []byte
+	Labels []string
+}
+
+// ShardResponse is a shard's reply. Err holds the error text, which matches the error constants.
+type ShardResponse struct {
+	IDs      []uint64
+	Key      string
+	Value    []byte
+	Labels   []string
+	Found    bool
+	Matched  []bool
+	Adjacent [][]uint64
+	Err      string
+}
+
+// ShardTransport delivers requests from a router to the shards
+type ShardTransport interface {
+	// Shards returns the number of shards
+	Shards() int
+
+	// Call delivers a request to a shard, which handles it with Shard.Handle(), and returns the
+	// reply
+	Call(shard int, req ShardRequest) (ShardResponse, error)
+}
+
+// Shard holds the nodes of a ShardedGraph whose IDs are index modulo count. Relationships
+// between nodes on the shard are kept in its graph and relationships with nodes on other shards
+// as remote references. GobEncode() saves both.
+type Shard struct {
+	index, count int
+	g            *Graph
+
+	sync.Mutex
+	// remoteOut holds each node's destinations on other shards, remoteIn its sources
+	remoteOut map[uint64][]uint64
+	remoteIn  map[uint64][]uint64
+}
+
+// NewShard creates shard index of count, keeping its nodes in g. The graph's constraints apply to
+// the shard's nodes only: keys are unique across shards only with PartitionByKey, and cycles
+// through other shards are not detected.
+func NewShard(index, count int, g *Graph) *Shard {
+	g.SetIDAllocator(shardAllocator{index: uint64(index), count: uint64(count)})
+	return &Shard{
+		index:     index,
+		count:     count,
+		g:         g,
+		remoteOut: make(map[uint64][]uint64),
+		remoteIn:  make(map[uint64][]uint64),
+	}
+}
+
+// Graph returns the graph holding the shard's nodes
+func (s *Shard) Graph() *Graph {
+	return s.g
+}
+
+// shardState is the saved form of a Shard
+type shardState struct {
+	Index, Count        int
+	Graph               *Graph
+	RemoteOut, RemoteIn map[uint64][]uint64
+}
+
+// GobEncode satisfies the gob encoder interface. The shard's graph is saved along with its
+// relationships with nodes on other shards.
+func (s *Shard) GobEncode() ([]byte, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	w := new(bytes.Buffer)
+	err := gob.NewEncoder(w).Encode(shardState{
+		Index:     s.index,
+		Count:     s.count,
+		Graph:     s.g,
+		RemoteOut: s.remoteOut,
+		RemoteIn:  s.remoteIn,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return w.Bytes(), nil
+}
+
+// GobDecode satisfies the gob encoder interface
+func (s *Shard) GobDecode(buf []byte) error {
+	var state shardState
+	if err := gob.NewDecoder(bytes.NewReader(buf)).Decode(&state); err != nil {
+		return err
+	}
+	restored := NewShard(state.Index, state.Count, state.Graph)
+	for ID, out := range state.RemoteOut {
+		restored.remoteOut[ID] = out
+	}
+	for ID, in := range state.RemoteIn {
+		restored.remoteIn[ID] = in
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	s.index, s.count, s.g = restored.index, restored.count, restored.g
+	s.remoteOut, s.remoteIn = restored.remoteOut, restored.remoteIn
+	return nil
+}
+
+// shardAllocator gives a shard's nodes the IDs that are its index modulo the shard count
+type shardAllocator struct {
+	index, count uint64
+}
+
+// Allocate satisfies the IDAllocator interface
+func (a shardAllocator) Allocate(top uint64) uint64 {
+	ID := top + 1
+	if r := ID % a.count; r != a.index {
+		ID += (a.index + a.count - r) % a.count
+	}
+	return ID
+}
+
+// Use satisfies the IDAllocator interface
+func (a shardAllocator) Use(ID uint64) {}
+
+// Release satisfies the IDAllocator interface
+func (a shardAllocator) Release(ID uint64) {}
+
+// owns reports whether a node belongs on the shard
+func (s *Shard) owns(ID uint64) bool {
+	return ID != 0 && ID%uint64(s.count) == uint64(s.index)
+}
+
+// node returns one of the shard's nodes
+func (s *Shard) node(ID uint64) (*Node, error) {
+	if !s.owns(ID) {
+		return nil, errors.New(ErrNodeNotFound)
+	}
+	n, ok := s.g.Node(ID)
+	if !ok {
+		return nil, errors.New(ErrNodeNotFound)
+	}
+	return n, nil
+}
+
+// Handle answers a request from a router. Transports call it on the receiving shard.
+func (s *Shard) Handle(req ShardRequest) ShardResponse {
+	resp, err := s.handle(req)
+	if err != nil {
+		resp.Err = err.Error()
+	}
+	return resp
+}
+
+// handle does the work for Handle()
+func (s *Shard) handle(req ShardRequest) (ShardResponse, error) {
+	var resp ShardResponse
+	var ID uint64
+	if len(req.IDs) > 0 {
+		ID = req.IDs[0]
+	}
+
+	switch req.Kind {
+	case shardInsert:
+		n, err := s.g.InsertDataNode(req.Key, req.Value)
+		if err != nil {
+			return resp, err
+		}
+		resp.IDs = []uint64{n.ID}
+
+	case shardGet:
+		n, err := s.node(ID)
+		if err != nil {
+			return resp, nil
+		}
+		n.RLock()
+		resp.Found, resp.Key, resp.Value = true, n.Key, n.Value
+		n.RUnlock()
+		resp.Labels = n.Labels()
+
+	case shardFindKey:
+		for _, ID := range s.g.NodeIDsByKey(req.Key) {
+			if s.owns(ID) {
+				resp.IDs = append(resp.IDs, ID)
+			}
+		}
+
+	case shardAddLabel:
+		n, err := s.node(ID)
+		if err != nil {
+			return resp, err
+		}
+		for _, label := range req.Labels {
+			if err := n.AddLabel(label); err != nil {
+				return resp, err
+			}
+		}
+
+	case shardDelete:
+		if _, err := s.node(ID); err != nil {
+			return resp, err
+		}
+		if err := s.g.DeleteNodeByID(ID); err != nil {
+			return resp, err
+		}
+		s.Lock()
+		resp.Adjacent = [][]uint64{s.remoteOut[ID], s.remoteIn[ID]}
+		delete(s.remoteOut, ID)
+		delete(s.remoteIn, ID)
+		s.Unlock()
+
+	case shardAddEdge, shardRemoveEdge:
+		n, err := s.node(ID)
+		if err != nil {
+			return resp, err
+		}
+		if s.owns(req.Other) {
+			other, err := s.node(req.Other)
+			if err != nil {
+				return resp, err
+			}
+			if req.Kind == shardAddEdge {
+				return resp, n.AddRelationship(other)
+			}
+			return resp, n.RemoveRelationship(other)
+		}
+		s.Lock()
+		s.remoteOut[ID] = changeAdjacency(s.remoteOut[ID], req.Other, req.Kind == shardAddEdge)
+		s.Unlock()
+
+	case shardAddSource, shardRemoveSource:
+		if _, err := s.node(ID); err != nil {
+			return resp, err
+		}
+		s.Lock()
+		s.remoteIn[ID] = changeAdjacency(s.remoteIn[ID], req.Other, req.Kind == shardAddSource)
+		s.Unlock()
+
+	case shardSources:
+		n, err := s.node(ID)
+		if err != nil {
+			return resp, err
+		}
+		resp.IDs = extractIDs(n.ListSources())
+		s.Lock()
+		resp.IDs = append(resp.IDs, s.remoteIn[ID]...)
+		s.Unlock()
+
+	case shardExpand:
+		resp.Matched = make([]bool, len(req.IDs))
+		resp.Adjacent = make([][]uint64, len(req.IDs))
+		for i, ID := range req.IDs {
+			n, err := s.node(ID)
+			if err != nil {
+				continue
+			}
+			n.RLock()
+			matched := n.hasLabels(req.Labels)
+			n.RUnlock()
+			if !matched {
+				continue
+			}
+			resp.Matched[i] = true
+			resp.Adjacent[i] = extractIDs(n.ListDestinations())
+			s.Lock()
+			resp.Adjacent[i] = append(resp.Adjacent[i], s.remoteOut[ID]...)
+			s.Unlock()
+		}
+
+	default:
+		return resp, errors.New("unknown shard request")
+	}
+	return resp, nil
+}
+
+// changeAdjacency adds a remote reference, or removes every reference to the node like
+// RemoveRelationship() does
+func changeAdjacency(IDs []uint64, ID uint64, add bool) []uint64 {
+	if add {
+		return append(IDs, ID)
+	}
+	return slices.DeleteFunc(IDs, func(other uint64) bool { return other == ID })
+}
+
+// ShardNode is a node read from a ShardedGraph
+type ShardNode struct {
+	ID     uint64
+	Key    string
+	Value  []byte
+	Labels []string
+}
+
+// ShardedGraph routes a graph's operations to nodes hash partitioned across shards, which may
+// run in other processes. A node is owned by the shard numbered by its ID modulo the shard count,
+// and relationships between shards are stored as remote references on both ends. Traversals are
+// run a level at a time, with each shard expanding the nodes it owns in parallel and the router
+// merging the results. Changes that touch two shards are not atomic.
+type ShardedGraph struct {
+	Name      string
+	partition Partition
+	transport ShardTransport
+	count     int
+	next      atomic.Uint64
+}
+
+// NewShardedGraph routes a graph across the shards reached through the transport
+func NewShardedGraph(name string, partition Partition, transport ShardTransport) (*ShardedGraph, error) {
+	if transport.Shards() <= 0 {
+		return nil, errors.New(ErrNoShards)
+	}
+	return &ShardedGraph{Name: name, partition: partition, transport: transport, count: transport.Shards()}, nil
+}
+
+// call sends a request to a shard and turns its error text back into an error
+func (sg *ShardedGraph) call(shard int, req ShardRequest) (ShardResponse, error) {
+	resp, err := sg.transport.Call(shard, req)
+	if err != nil {
+		return resp, err
+	}
+	if resp.Err != "" {
+		return resp, errors.New(resp.Err)
+	}
+	return resp, nil
+}
+
+// owner returns the shard that holds a node
+func (sg *ShardedGraph) owner(ID uint64) int {
+	return int(ID % uint64(sg.count))
+}
+
+// keyShard returns the shard that holds a key under PartitionByKey
+func (sg *ShardedGraph) keyShard(key string) int {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return int(h.Sum64() % uint64(sg.count))
+}
+
+// InsertDataNode inserts a node on the shard chosen by the partition and returns its ID
+func (sg *ShardedGraph) InsertDataNode(key string, value []byte) (uint64, error) {
+	shard := int(sg.next.Add(1) % uint64(sg.count))
+	if sg.partition == PartitionByKey {
+		shard = sg.keyShard(key)
+	}
+	resp, err := sg.call(shard, ShardRequest{Kind: shardInsert, Key: key, Value: value})
+	if err != nil {
+		return 0, err
+	}
+	return resp.IDs[0], nil
+}
+
+// Node returns the node with the given ID
+func (sg *ShardedGraph) Node(ID uint64) (ShardNode, bool, error) {
+	resp, err := sg.call(sg.owner(ID), ShardRequest{Kind: shardGet, IDs: []uint64{ID}})
+	if err != nil || !resp.Found {
+		return ShardNode{}, false, err
+	}
+	return ShardNode{ID: ID, Key: resp.Key, Value: resp.Value, Labels: resp.Labels}, true, nil
+}
+
+// NodeIDsByKey returns the IDs of the nodes with the key in ascending order. With PartitionByID
+// every shard is asked.
+func (sg *ShardedGraph) NodeIDsByKey(key string) ([]uint64, error) {
+	req := ShardRequest{Kind: shardFindKey, Key: key}
+	if sg.partition == PartitionByKey {
+		resp, err := sg.call(sg.keyShard(key), req)
+		sort.Slice(resp.IDs, func(i, j int) bool { return resp.IDs[i] < resp.IDs[j] })
+		return resp.IDs, err
+	}
+
+	reqs := make(map[int]ShardRequest, sg.count)
+	for shard := 0; shard < sg.count; shard++ {
+		reqs[shard] = req
+	}
+	resps, err := sg.scatter(reqs)
+	if err != nil {
+		return nil, err
+	}
+	var IDs []uint64
+	for _, resp := range resps {
+		IDs = append(IDs, resp.IDs...)
+	}
+	sort.Slice(IDs, func(i, j int) bool { return IDs[i] < IDs[j] })
+	return IDs, nil
+}
+
+// AddLabel tags a node with labels
+func (sg *ShardedGraph) AddLabel(ID uint64, labels ...string) error {
+	_, err := sg.call(sg.owner(ID), ShardRequest{Kind: shardAddLabel, IDs: []uint64{ID}, Labels: labels})
+	return err
+}
+
+// AddRelationship adds a relationship from one node to another
+func (sg *ShardedGraph) AddRelationship(from, to uint64) error {
+	if sg.owner(from) == sg.owner(to) {
+		_, err := sg.call(sg.owner(from), ShardRequest{Kind: shardAddEdge, IDs: []uint64{from}, Other: to})
+		return err
+	}
+
+	// the destination's reference is added first so a missing destination adds nothing
+	if _, err := sg.call(sg.owner(to), ShardRequest{Kind: shardAddSource, IDs: []uint64{to}, Other: from}); err != nil {
+		return err
+	}
+	if _, err := sg.call(sg.owner(from), ShardRequest{Kind: shardAddEdge, IDs: []uint64{from}, Other: to}); err != nil {
+		sg.call(sg.owner(to), ShardRequest{Kind: shardRemoveSource, IDs: []uint64{to}, Other: from})
+		return err
+	}
+	return nil
+}
+
+// RemoveRelationship removes the relationships from one node to another
+func (sg *ShardedGraph) RemoveRelationship(from, to uint64) error {
+	if _, err := sg.call(sg.owner(from), ShardRequest{Kind: shardRemoveEdge, IDs: []uint64{from}, Other: to}); err != nil {
+		return err
+	}
+	if sg.owner(from) == sg.owner(to) {
+		return nil
+	}
+	_, err := sg.call(sg.owner(to), ShardRequest{Kind: shardRemoveSource, IDs: []uint64{to}, Other: from})
+	return err
+}
+
+// DeleteNode deletes a node and the relationships to and from it on other shards
+func (sg *ShardedGraph) DeleteNode(ID uint64) error {
+	resp, err := sg.call(sg.owner(ID), ShardRequest{Kind: shardDelete, IDs: []uint64{ID}})
+	if err != nil {
+		return err
+	}
+	for _, to := range resp.Adjacent[0] {
+		if _, err := sg.call(sg.owner(to), ShardRequest{Kind: shardRemoveSource, IDs: []uint64{to}, Other: ID}); err != nil && err.Error() != ErrNodeNotFound {
+			return err
+		}
+	}
+	for _, from := range resp.Adjacent[1] {
+		if _, err := sg.call(sg.owner(from), ShardRequest{Kind: shardRemoveEdge, IDs: []uint64{from}, Other: ID}); err != nil && err.Error() != ErrNodeNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListDestinations returns the IDs of the nodes a node has relationships to
+func (sg *ShardedGraph) ListDestinations(ID uint64) ([]uint64, error) {
+	adjacent, err := sg.expand([]uint64{ID}, nil)
+	if err != nil {
+		return nil, err
+	}
+	destinations, ok := adjacent[ID]
+	if !ok {
+		return nil, errors.New(ErrNodeNotFound)
+	}
+	return destinations, nil
+}
+
+// ListSources returns the IDs of the nodes with relationships to a node
+func (sg *ShardedGraph) ListSources(ID uint64) ([]uint64, error) {
+	resp, err := sg.call(sg.owner(ID), ShardRequest{Kind: shardSources, IDs: []uint64{ID}})
+	return resp.IDs, err
+}
+
+// BreadthFirstSearch reports whether the node to can be reached from the node from.
+// When labels are given, only nodes carrying all of the labels are visited.
+func (sg *ShardedGraph) BreadthFirstSearch(from, to uint64, labels ...string) (bool, error) {
+	path, err := sg.ShortestPath(from, to, labels...)
+	return path != nil, err
+}
+
+// ShortestPath returns the IDs of the nodes on a path with the fewest relationships from one
+// node to another, starting with from and ending with to, or nil if there is none. When labels
+// are given, only nodes carrying all of the labels are visited. A node reaches itself only
+// through a cycle.
+func (sg *ShardedGraph) ShortestPath(from, to uint64, labels ...string) ([]uint64, error) {
+	var path []uint64
+	err := sg.traverse(from, -1, labels, func(ID uint64, parents map[uint64]uint64) bool {
+		if ID != to {
+			return true
+		}
+		path = []uint64{to}
+		for at := parents[to]; at != from; at = parents[at] {
+			path = append(path, at)
+		}
+		path = append(path, from)
+		slices.Reverse(path)
+		return false
+	})
+	return path, err
+}
+
+// KHop returns the IDs of the nodes reachable from a node by following at most k relationships,
+// in ascending order. When labels are given, only nodes carrying all of the labels are visited.
+func (sg *ShardedGraph) KHop(from uint64, k int, labels ...string) ([]uint64, error) {
+	var IDs []uint64
+	err := sg.traverse(from, k, labels, func(ID uint64, parents map[uint64]uint64) bool {
+		if ID != from {
+			IDs = append(IDs, ID)
+		}
+		return true
+	})
+	sort.Slice(IDs, func(i, j int) bool { return IDs[i] < IDs[j] })
+	return IDs, err
+}
+
+// traverse runs a breadth first search from a node for up to depth levels, or without limit if
+// depth is negative. visit is called with each node carrying the labels, closest first, and the
+// node each was reached from, and stops the search by returning false.
+func (sg *ShardedGraph) traverse(from uint64, depth int, labels []string, visit func(uint64, map[uint64]uint64) bool) error {
+	// the start node is not filtered by labels
+	adjacent, err := sg.expand([]uint64{from}, nil)
+	if err != nil {
+		return err
+	}
+	if _, ok := adjacent[from]; !ok {
+		return errors.New(ErrNodeNotFound)
+	}
+
+	parents := make(map[uint64]uint64)
+	seen := map[uint64]bool{}
+	frontier := []uint64{from}
+	for level := 1; len(frontier) > 0 && (depth < 0 || level <= depth); level++ {
+		var next []uint64
+		for _, ID := range frontier {
+			for _, dest := range adjacent[ID] {
+				if !seen[dest] {
+					seen[dest] = true
+					parents[dest] = ID
+					next = append(next, dest)
+				}
+			}
+		}
+
+		if adjacent, err = sg.expand(next, labels); err != nil {
+			return err
+		}
+		frontier = frontier[:0]
+		for _, ID := range next {
+			if _, ok := adjacent[ID]; !ok {
+				continue
+			}
+			if !visit(ID, parents) {
+				return nil
+			}
+			frontier = append(frontier, ID)
+		}
+	}
+	return nil
+}
+
+// expand asks each shard in parallel for the destinations of the nodes it owns. Nodes that are
+// missing or do not carry the labels are left out.
+func (sg *ShardedGraph) expand(IDs []uint64, labels []string) (map[uint64][]uint64, error) {
+	reqs := make(map[int]ShardRequest)
+	for _, ID := range IDs {
+		req := reqs[sg.owner(ID)]
+		req.Kind, req.Labels = shardExpand, labels
+		req.IDs = append(req.IDs, ID)
+		reqs[sg.owner(ID)] = req
+	}
+	resps, err := sg.scatter(reqs)
+	if err != nil {
+		return nil, err
+	}
+
+	adjacent := make(map[uint64][]uint64, len(IDs))
+	for shard, resp := range resps {
+		for i, ID := range reqs[shard].IDs {
+			if i < len(resp.Matched) && resp.Matched[i] {
+				adjacent[ID] = resp.Adjacent[i]
+			}
+		}
+	}
+	return adjacent, nil
+}
+
+// scatter sends requests to shards in parallel and gathers the replies
+func (sg *ShardedGraph) scatter(reqs map[int]ShardRequest) (map[int]ShardResponse, error) {
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	resps := make(map[int]ShardResponse, len(reqs))
+	var firstErr error
+	for shard, req := range reqs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := sg.call(shard, req)
+			lock.Lock()
+			defer lock.Unlock()
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+			resps[shard] = resp
+		}()
+	}
+	wg.Wait()
+	return resps, firstErr
+}
+
+// LocalShardTransport reaches shards in the same process
+type LocalShardTransport struct {
+	shards []*Shard
+}
+
+// NewLocalShards creates count shards, each with a new graph, and a transport to reach them
+func NewLocalShards(count int) *LocalShardTransport {
+	t := &LocalShardTransport{}
+	for i := 0; i < count; i++ {
+		g, _ := NewGraph("shard")
+		t.shards = append(t.shards, NewShard(i, count, g))
+	}
+	return t
+}
+
+// Shard returns one of the transport's shards
+func (t *LocalShardTransport) Shard(i int) *Shard {
+	return t.shards[i]
+}
+
+// Shards satisfies the ShardTransport interface
+func (t *LocalShardTransport) Shards() int {
+	return len(t.shards)
+}
+
+// Call satisfies the ShardTransport interface
+func (t *LocalShardTransport) Call(shard int, req ShardRequest) (ShardResponse, error) {
+	return t.shards[shard].Handle(req), nil
+}
+
+// ShardServer serves a shard to routers over TCP
+type ShardServer struct {
+	shard    *Shard
+	listener net.Listener
+
+	sync.Mutex
+	conns  map[net.Conn]bool
+	closed bool
+}
+
+// ServeShard serves requests for a shard from routers connecting to the listener
+func ServeShard(s *Shard, listener net.Listener) *ShardServer {
+	srv := &ShardServer{shard: s, listener: listener, conns: make(map[net.Conn]bool)}
+	go srv.accept()
+	return srv
+}
+
+// Addr returns the address routers connect to
+func (srv *ShardServer) Addr() net.Addr {
+	return srv.listener.Addr()
+}
+
+// Close stops serving the shard
+func (srv *ShardServer) Close() error {
+	srv.Lock()
+	defer srv.Unlock()
+
+	if srv.closed {
+		return nil
+	}
+	srv.closed = true
+	for conn := range srv.conns {
+		conn.Close()
+	}
+	return srv.listener.Close()
+}
+
+// accept serves each connecting router
+func (srv *ShardServer) accept() {
+	for {
+		conn, err := srv.listener.Accept()
+		if err != nil {
+			return
+		}
+		srv.Lock()
+		if srv.closed {
+			srv.Unlock()
+			conn.Close()
+			return
+		}
+		srv.conns[conn] = true
+		srv.Unlock()
+
+		go srv.serve(conn)
+	}
+}
+
+// serve answers a router's requests in order
+func (srv *ShardServer) serve(conn net.Conn) {
+	defer func() {
+		srv.Lock()
+		delete(srv.conns, conn)
+		srv.Unlock()
+		conn.Close()
+	}()
+
+	decoder, encoder := gob.NewDecoder(conn), gob.NewEncoder(conn)
+	for {
+		var req ShardRequest
+		if err := decoder.Decode(&req); err != nil {
+			return
+		}
+		if err := encoder.Encode(srv.shard.Handle(req)); err != nil {
+			return
+		}
+	}
+}
+
+// NetShardTransport reaches shards served by ServeShard() over TCP, keeping a connection to each
+type NetShardTransport struct {
+	conns []*shardConn
+}
+
+// shardConn is a connection to one shard, used by one request at a time
+type shardConn struct {
+	sync.Mutex
+	addr    string
+	conn    net.Conn
+	encoder *gob.Encoder
+	decoder *gob.Decoder
+}
+
+// NewNetShardTransport reaches the shards at the addresses, in shard order
+func NewNetShardTransport(addrs ...string) *NetShardTransport {
+	t := &NetShardTransport{}
+	for _, addr := range addrs {
+		t.conns = append(t.conns, &shardConn{addr: addr})
+	}
+	return t
+}
+
+// Shards satisfies the ShardTransport interface
+func (t *NetShardTransport) Shards() int {
+	return len(t.conns)
+}
+
+// Call satisfies the ShardTransport interface. A failed connection is redialed by the next call.
+func (t *NetShardTransport) Call(shard int, req ShardRequest) (ShardResponse, error) {
+	c := t.conns[shard]
+	c.Lock()
+	defer c.Unlock()
+
+	if c.conn == nil {
+		conn, err := net.Dial("tcp", c.addr)
+		if err != nil {
+			return ShardResponse{}, err
+		}
+		c.conn, c.encoder, c.decoder = conn, gob.NewEncoder(conn), gob.NewDecoder(conn)
+	}
+
+	var resp ShardResponse
+	err := c.encoder.Encode(req)
+	if err == nil {
+		err = c.decoder.Decode(&resp)
+	}
+	if err != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+	return resp, err
+}
+
+// Close closes the transport's connections
+func (t *NetShardTransport) Close() error {
+	for _, c := range t.conns {
+		c.Lock()
+		if c.conn != nil {
+			c.conn.Close()
+			c.conn = nil
+		}
+		c.Unlock()
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package giraffe
+
+import (
+	"bytes"
+	"encoding/gob"
+	"net"
+	"reflect"
+	"testing"
+)
+
+// buildShardedGraph builds a chain 1 -> 2 -> 3 -> 4 with a shortcut 1 -> 3 and a branch 2 -> 5,
+// returning the IDs in that order
+func buildShardedGraph(t *testing.T, sg *ShardedGraph) []uint64 {
+	var IDs []uint64
+	for _, key := range []string{"one", "two", "three", "four", "five"} {
+		ID, err := sg.InsertDataNode(key, []byte(key))
+		if err != nil {
+			t.Fatalf("unable to insert, error: %v", err)
+		}
+		IDs = append(IDs, ID)
+	}
+	for _, e := range [][2]int{{0, 1}, {1, 2}, {2, 3}, {0, 2}, {1, 4}} {
+		if err := sg.AddRelationship(IDs[e[0]], IDs[e[1]]); err != nil {
+			t.Fatalf("unable to add relationship, error: %v", err)
+		}
+	}
+	return IDs
+}
+
+func TestShardAllocator(t *testing.T) {
+	a := shardAllocator{index: 2, count: 3}
+	for _, tc := range []struct{ top, want uint64 }{{0, 2}, {2, 5}, {3, 5}, {4, 5}, {5, 8}} {
+		if got := a.Allocate(tc.top); got != tc.want {
+			t.Errorf("Allocate(%d): got %d, want %d", tc.top, got, tc.want)
+		}
+	}
+	a = shardAllocator{index: 0, count: 3}
+	if got := a.Allocate(0); got != 3 {
+		t.Errorf("got %d, want 3", got)
+	}
+}
+
+func TestShardedGraph(t *testing.T) {
+	transport := NewLocalShards(3)
+	sg, err := NewShardedGraph("testGraph", PartitionByID, transport)
+	if err != nil {
+		t.Fatalf("unable to create graph, error: %v", err)
+	}
+	IDs := buildShardedGraph(t, sg)
+
+	shards := make(map[int]bool)
+	for _, ID := range IDs {
+		shards[sg.owner(ID)] = true
+		if n, ok := transport.Shard(sg.owner(ID)).Graph().Node(ID); !ok || n.ID != ID {
+			t.Errorf("node %d not on shard %d", ID, sg.owner(ID))
+		}
+	}
+	if len(shards) != 3 {
+		t.Errorf("got nodes on %d shards, want 3", len(shards))
+	}
+
+	n, ok, err := sg.Node(IDs[2])
+	if err != nil || !ok || n.Key != "three" || string(n.Value) != "three" {
+		t.Errorf("got %+v %t %v, want three", n, ok, err)
+	}
+	if got, _ := sg.NodeIDsByKey("four"); !reflect.DeepEqual(got, []uint64{IDs[3]}) {
+		t.Errorf("got %v, want %v", got, []uint64{IDs[3]})
+	}
+	if got, _ := sg.ListDestinations(IDs[0]); !reflect.DeepEqual(got, []uint64{IDs[1], IDs[2]}) {
+		t.Errorf("got destinations %v, want %v", got, []uint64{IDs[1], IDs[2]})
+	}
+	if got, _ := sg.ListSources(IDs[2]); len(got) != 2 {
+		t.Errorf("got sources %v, want 2", got)
+	}
+
+	if found, err := sg.BreadthFirstSearch(IDs[0], IDs[3]); err != nil || !found {
+		t.Errorf("got %t %v, want found", found, err)
+	}
+	if found, _ := sg.BreadthFirstSearch(IDs[3], IDs[0]); found {
+		t.Error("found a node against the relationships")
+	}
+	if got, _ := sg.ShortestPath(IDs[0], IDs[3]); !reflect.DeepEqual(got, []uint64{IDs[0], IDs[2], IDs[3]}) {
+		t.Errorf("got path %v, want %v", got, []uint64{IDs[0], IDs[2], IDs[3]})
+	}
+	if got, _ := sg.KHop(IDs[0], 1); !reflect.DeepEqual(got, []uint64{IDs[1], IDs[2]}) {
+		t.Errorf("got %v, want %v", got, []uint64{IDs[1], IDs[2]})
+	}
+	if got, _ := sg.KHop(IDs[0], 2); len(got) != 4 {
+		t.Errorf("got %v, want 4 nodes", got)
+	}
+	if _, err := sg.ShortestPath(42, IDs[0]); err == nil || err.Error() != ErrNodeNotFound {
+		t.Errorf("got `%v`, want `%s`", err, ErrNodeNotFound)
+	}
+
+	// only labeled nodes are visited
+	sg.AddLabel(IDs[1], "Lesson")
+	sg.AddLabel(IDs[3], "Lesson")
+	if got, _ := sg.ShortestPath(IDs[0], IDs[3], "Lesson"); got != nil {
+		t.Errorf("got path %v through an unlabeled node", got)
+	}
+	sg.AddLabel(IDs[2], "Lesson")
+	if got, _ := sg.ShortestPath(IDs[0], IDs[3], "Lesson"); len(got) != 3 {
+		t.Errorf("got path %v, want 3 nodes", got)
+	}
+
+	if err := sg.DeleteNode(IDs[2]); err != nil {
+		t.Fatalf("unable to delete, error: %v", err)
+	}
+	if got, _ := sg.ShortestPath(IDs[0], IDs[3]); got != nil {
+		t.Errorf("got path %v through a deleted node", got)
+	}
+	if got, _ := sg.ListDestinations(IDs[0]); !reflect.DeepEqual(got, []uint64{IDs[1]}) {
+		t.Errorf("got destinations %v, want %v", got, []uint64{IDs[1]})
+	}
+
+	if err := sg.RemoveRelationship(IDs[0], IDs[1]); err != nil {
+		t.Fatalf("unable to remove relationship, error: %v", err)
+	}
+	if got, _ := sg.ListSources(IDs[1]); len(got) != 0 {
+		t.Errorf("got sources %v, want none", got)
+	}
+	if err := sg.AddRelationship(IDs[0], 42); err == nil || err.Error() != ErrNodeNotFound {
+		t.Errorf("got `%v`, want `%s`", err, ErrNodeNotFound)
+	}
+	if got, _ := sg.ListDestinations(IDs[0]); len(got) != 0 {
+		t.Errorf("got destinations %v, want none", got)
+	}
+}
+
+func TestShardSaved(t *testing.T) {
+	transport := NewLocalShards(3)
+	sg, _ := NewShardedGraph("testGraph", PartitionByID, transport)
+	IDs := buildShardedGraph(t, sg)
+
+	// a shard is saved with its relationships to nodes on other shards
+	shard := transport.Shard(sg.owner(IDs[2]))
+	w := new(bytes.Buffer)
+	if err := gob.NewEncoder(w).Encode(shard); err != nil {
+		t.Fatalf("unable to encode shard, error: %v", err)
+	}
+	restored := &Shard{}
+	if err := gob.NewDecoder(w).Decode(restored); err != nil {
+		t.Fatalf("unable to decode shard, error: %v", err)
+	}
+	want := shard.Handle(ShardRequest{Kind: shardSources, IDs: []uint64{IDs[2]}})
+	got := restored.Handle(ShardRequest{Kind: shardSources, IDs: []uint64{IDs[2]}})
+	if len(want.IDs) != 2 || !reflect.DeepEqual(got, want) {
+		t.Errorf("got sources %v, want %v", got.IDs, want.IDs)
+	}
+	if n, err := restored.Graph().InsertDataNode("six", nil); err != nil || !restored.owns(n.ID) {
+		t.Errorf("got node %v, %v, want one owned by the shard", n, err)
+	}
+
+	// a label the shard's graph rejects is reported to the router
+	shard.Graph().readOnly = true
+	if err := sg.AddLabel(IDs[2], "Lesson"); err == nil || err.Error() != ErrReadOnly {
+		t.Errorf("got `%v`, want `%s`", err, ErrReadOnly)
+	}
+}
+
+func TestShardedGraphByKey(t *testing.T) {
+	transport := NewLocalShards(4)
+	sg, _ := NewShardedGraph("testGraph", PartitionByKey, transport)
+	a, _ := sg.InsertDataNode("same", nil)
+	b, _ := sg.InsertDataNode("same", nil)
+	if sg.owner(a) != sg.owner(b) || sg.owner(a) != sg.keyShard("same") {
+		t.Errorf("got shards %d and %d, want %d", sg.owner(a), sg.owner(b), sg.keyShard("same"))
+	}
+	if got, _ := sg.NodeIDsByKey("same"); !reflect.DeepEqual(got, []uint64{a, b}) {
+		t.Errorf("got %v, want %v", got, []uint64{a, b})
+	}
+
+	if _, err := NewShardedGraph("empty", PartitionByKey, NewLocalShards(0)); err == nil || err.Error() != ErrNoShards {
+		t.Errorf("got `%v`, want `%s`", err, ErrNoShards)
+	}
+}
+
+func TestShardedGraphOverTCP(t *testing.T) {
+	var addrs []string
+	for i := 0; i < 3; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("unable to listen, error: %v", err)
+		}
+		g, _ := NewGraph("shard")
+		srv := ServeShard(NewShard(i, 3, g), l)
+		defer srv.Close()
+		addrs = append(addrs, srv.Addr().String())
+	}
+	transport := NewNetShardTransport(addrs...)
+	defer transport.Close()
+
+	sg, _ := NewShardedGraph("testGraph", PartitionByID, transport)
+	IDs := buildShardedGraph(t, sg)
+	if got, err := sg.ShortestPath(IDs[0], IDs[4]); err != nil || !reflect.DeepEqual(got, []uint64{IDs[0], IDs[1], IDs[4]}) {
+		t.Errorf("got path %v %v, want %v", got, err, []uint64{IDs[0], IDs[1], IDs[4]})
+	}
+	if err := sg.AddRelationship(IDs[0], 42); err == nil || err.Error() != ErrNodeNotFound {
+		t.Errorf("got `%v`, want `%s`", err, ErrNodeNotFound)
+	}
+}
@@ -0,0 +1,660 @@
+package giraffe
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"iter"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Store error constants
+const (
+	// ErrNoGraph returns when opening a graph from a store that does not hold one
+	ErrNoGraph = "no graph in store"
+
+	// ErrStoreNotEmpty returns when creating a graph in a store that already holds one
+	ErrStoreNotEmpty = "store not empty"
+)
+
+// graphMetaKey is the Store meta entry holding the graph's settings
+const graphMetaKey = "graph"
+
+// NodeRecord is the stored form of a Node. Relationships are stored separately as adjacency lists.
+type NodeRecord struct {
+	ID         uint64
+	Kind       string
+	Key        string
+	Value      []byte
+	Labels     []string
+	Properties map[string]Property
+	ExternalID string
+	Expires    time.Time
+	// EdgeIDs holds the IDs of the node's relationships, in the order of its adjacency
+	EdgeIDs []uint64
+	// EdgeTypes holds the types of the node's relationships, in the same order
+	EdgeTypes []string
+	// SourceIDs holds the IDs of the nodes related to this one, so a node can be read from the
+	// store without its sources
+	SourceIDs []uint64
+}
+
+// Store is a storage backend for a graph. A Graph created with NewStoreGraph() writes every
+// mutation through to its store, each with a single Update(), and can be reopened with
+// OpenGraph(). Implementations must be safe to use concurrently.
+type Store interface {
+	// GetNode returns the record for a node, and false if it does not exist
+	GetNode(ID uint64) (NodeRecord, bool, error)
+
+	// PutNode inserts or replaces a node record
+	PutNode(rec NodeRecord) error
+
+	// DeleteNode removes a node record and its adjacency list
+	DeleteNode(ID uint64) error
+
+	// Adjacency returns the IDs of a node's destinations, in the order they were added
+	Adjacency(ID uint64) ([]uint64, error)
+
+	// PutAdjacency replaces a node's destinations
+	PutAdjacency(ID uint64, destinations []uint64) error
+
+	// NodeIDsByKey returns the IDs of the nodes with a matching key in ascending order
+	NodeIDsByKey(key string) ([]uint64, error)
+
+	// Nodes iterates over every node record in ID order
+	Nodes() iter.Seq2[NodeRecord, error]
+
+	// Meta and SetMeta store graph level settings by name. Meta returns nil for unset names.
+	Meta(name string) ([]byte, error)
+	SetMeta(name string, value []byte) error
+
+	// Update makes the writes fn adds to a batch as one change, so a failure or a crash keeps
+	// all of them or none. Nothing is written if fn returns an error. fn must not call the store.
+	Update(fn func(StoreBatch) error) error
+
+	// Close releases the store's resources
+	Close() error
+}
+
+// StoreBatch collects the writes of a single Store.Update(). The writes take effect when fn
+// returns.
+type StoreBatch interface {
+	PutNode(rec NodeRecord) error
+	DeleteNode(ID uint64) error
+	PutAdjacency(ID uint64, destinations []uint64) error
+	SetMeta(name string, value []byte) error
+}
+
+// graphMeta is the graph level state kept in a Store
+type graphMeta struct {
+	Name                 string
+	DuplicateKeys        bool
+	CircularRelationship bool
+	TopNodeID            uint64
+	Schema               *Schema
+	Indexes              []string
+	SearchValues         bool
+	// EdgeExpiry keys relationship expiry by pair of nodes in stores written before it was
+	// kept per relationship
+	EdgeExpiry         map[Edge]time.Time
+	RelationshipExpiry map[uint64]time.Time
+	Undirected         bool
+	ForbidParallel     bool
+	ForbidSelf         bool
+	MaxNodes           int
+	TopEdgeID          uint64
+	// SourceIDs is set once every node record holds its sources. Stores written before then
+	// are read whole by OpenGraph() and rewritten.
+	SourceIDs bool
+}
+
+// NewStoreGraph creates a graph like NewConstraintGraph() that writes every change through to
+// an empty store. Nodes are kept in memory once they are inserted.
+func NewStoreGraph(name string, duplicateKeys, circularRelationship bool, store Store) (*Graph, error) {
+	return New(name, WithDuplicateKeys(duplicateKeys), WithCircularRelationships(circularRelationship), WithStore(store))
+}
+
+// OpenGraph opens the graph held in a store. Only the graph's settings are read up front: nodes
+// are read from the store as they are reached, by ID, key or relationship, and calls that need
+// the whole graph, such as Nodes(), label and property queries or GobEncode(), read the rest.
+// Those that return an error return a failure to read; the others, such as NodeCount() or
+// Search(), see only the nodes already read, and the error is reported by StoreErr().
+// Further changes are written through to the store.
+func OpenGraph(store Store) (*Graph, error) {
+	data, err := store.Meta(graphMetaKey)
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return nil, errors.New(ErrNoGraph)
+	}
+	var meta graphMeta
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&meta); err != nil {
+		return nil, err
+	}
+
+	g := &Graph{
+		Name:                 meta.Name,
+		nodes:                make(map[uint64]*Node),
+		duplicateKeys:        meta.DuplicateKeys,
+		circularRelationship: meta.CircularRelationship,
+		undirected:           meta.Undirected,
+		forbidParallel:       meta.ForbidParallel,
+		forbidSelf:           meta.ForbidSelf,
+		maxNodes:             meta.MaxNodes,
+		topNodeID:            meta.TopNodeID,
+		topEdgeID:            meta.TopEdgeID,
+		schema:               meta.Schema,
+		edgeExpiry:           meta.RelationshipExpiry,
+		store:                store,
+		// expiry kept by pair of nodes is moved to relationship IDs by a whole read
+		partial: meta.SourceIDs && meta.EdgeExpiry == nil,
+	}
+	if g.partial {
+		// the indexes fill in as nodes are read and are rebuilt by loadAll()
+		g.rebuild(meta.Indexes, meta.SearchValues)
+	} else {
+		if err := g.readAll(); err != nil {
+			return nil, err
+		}
+		g.rebuildSources()
+		g.rebuild(meta.Indexes, meta.SearchValues)
+		g.expirePairs(meta.EdgeExpiry)
+		// keep the sources in every record so the graph can be read lazily next time
+		err := store.Update(func(b StoreBatch) error {
+			for _, n := range g.nodes {
+				if err := b.PutNode(n.record()); err != nil {
+					return err
+				}
+			}
+			return g.writeMeta(b)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	g.metaDirty = false
+	if err := g.loadHistory(store); err != nil {
+		return nil, err
+	}
+
+	return g, nil
+}
+
+// node returns the node with the ID. While the graph is partly loaded a node that is not in
+// memory is read from the store, and its relationships are linked by link() when they are
+// needed. The caller must hold the graph lock.
+func (g *Graph) node(ID uint64) (*Node, bool) {
+	if n, ok := g.nodes[ID]; ok || !g.partial {
+		return n, ok
+	}
+
+	rec, ok, err := g.store.GetNode(ID)
+	if err != nil || !ok {
+		g.storeFailed(err)
+		return nil, false
+	}
+	destinations, err := g.store.Adjacency(ID)
+	if err != nil {
+		g.storeFailed(err)
+		return nil, false
+	}
+	n := g.readNode(rec, destinations)
+	g.nodes[ID] = n
+	return n, true
+}
+
+// readNode makes a node from its record and adjacency list, with its relationships not yet linked
+func (g *Graph) readNode(rec NodeRecord, destinations []uint64) *Node {
+	return &Node{
+		ID:                   rec.ID,
+		Kind:                 rec.Kind,
+		Key:                  rec.Key,
+		Value:                rec.Value,
+		labels:               rec.Labels,
+		properties:           rec.Properties,
+		externalID:           rec.ExternalID,
+		expires:              rec.Expires,
+		edgeIDs:              rec.EdgeIDs,
+		edgeTypes:            rec.EdgeTypes,
+		circularRelationship: g.circularRelationship,
+		graph:                g,
+		destinationIDs:       destinations,
+		sourceIDs:            rec.SourceIDs,
+		pending:              true,
+	}
+}
+
+// link resolves the relationships of a node read from the store, reading the nodes at their
+// other ends if need be. Relationships to nodes missing from the store are dropped. link does
+// nothing for other nodes, or a nil graph. The caller must hold the graph lock.
+func (g *Graph) link(n *Node) {
+	if !n.pending {
+		return
+	}
+
+	var edgeIDs []uint64
+	var edgeTypes []string
+	for i, ID := range n.destinationIDs {
+		dest, ok := g.node(ID)
+		if !ok {
+			continue
+		}
+		n.destinations = append(n.destinations, dest)
+		if len(n.edgeIDs) == len(n.destinationIDs) {
+			edgeIDs = append(edgeIDs, n.edgeIDs[i])
+		}
+		if len(n.edgeTypes) == len(n.destinationIDs) {
+			edgeTypes = append(edgeTypes, n.edgeTypes[i])
+		}
+	}
+	if len(n.destinations) != len(n.destinationIDs) {
+		n.edgeIDs, n.edgeTypes = edgeIDs, edgeTypes
+	}
+	for _, ID := range n.sourceIDs {
+		if src, ok := g.node(ID); ok {
+			n.sources = append(n.sources, src)
+		}
+	}
+	n.destinationIDs, n.sourceIDs = nil, nil
+	n.pending = false
+}
+
+// loadAll reads the rest of a partly loaded graph and rebuilds its indexes. Errors are also
+// recorded for StoreErr(). The caller must hold the graph lock.
+func (g *Graph) loadAll() error {
+	if !g.partial {
+		return nil
+	}
+	if err := g.readAll(); err != nil {
+		g.storeFailed(err)
+		return err
+	}
+	g.partial = false
+	g.rebuild(g.indexNames(), g.search.values)
+	return nil
+}
+
+// readAll reads every node that is not in memory from the store and links the relationships of
+// every node. The caller must hold the graph lock or own the graph.
+func (g *Graph) readAll() error {
+	for rec, err := range g.store.Nodes() {
+		if err != nil {
+			return err
+		}
+		if _, ok := g.nodes[rec.ID]; ok {
+			continue
+		}
+		destinations, err := g.store.Adjacency(rec.ID)
+		if err != nil {
+			return err
+		}
+		g.nodes[rec.ID] = g.readNode(rec, destinations)
+	}
+	for _, n := range g.nodes {
+		g.link(n)
+	}
+	return nil
+}
+
+// StoreErr returns the first error the graph's store reported, if any. Mutations are applied in
+// memory even when the store fails to write them, and return the store's error. Reads with no
+// error to return see only the nodes read before the store failed.
+func (g *Graph) StoreErr() error {
+	g.hookLock.RLock()
+	defer g.hookLock.RUnlock()
+
+	return g.storeErr
+}
+
+// Close closes the graph's store, if it has one, and reports any store error
+func (g *Graph) Close() error {
+	if g.store == nil {
+		return nil
+	}
+	if err := g.store.Close(); err != nil {
+		return err
+	}
+	return g.StoreErr()
+}
+
+// change is a mutation and the node it changed, waiting to be written to the store
+type change struct {
+	op Operation
+	n  *Node
+}
+
+// committed is called after every mutation, while the graph and the mutated node, if any, are
+// locked. It numbers the change, passes it to the graph's listeners and writes it through to the
+// store as a single update, returning any store error. While the graph is batching the change is
+// kept for the caller to write with the rest of the batch.
+func (g *Graph) committed(op Operation, n *Node) error {
+	g.seq++
+	for _, l := range g.listeners {
+		l(LogEntry{Seq: g.seq, Op: op})
+	}
+
+	if g.store == nil {
+		return nil
+	}
+	if g.batching {
+		g.unwritten = append(g.unwritten, change{op, n})
+		return nil
+	}
+	return g.write(change{op, n})
+}
+
+// write writes changes to the store as one update. The caller must hold the graph lock.
+func (g *Graph) write(changes ...change) error {
+	if g.store == nil || len(changes) == 0 {
+		return nil
+	}
+
+	var entries int
+	err := g.store.Update(func(b StoreBatch) error {
+		inserted := false
+		for _, c := range changes {
+			var err error
+			switch c.op.Kind {
+			case OpDeleteNode:
+				err = b.DeleteNode(c.op.NodeID)
+			case OpAddRelationship, OpRemoveRelationship:
+				// the node record keeps the relationship IDs
+				err = putAdjacency(b, c.n)
+				if other, ok := g.nodes[c.op.OtherID]; err == nil && ok && other != c.n {
+					if g.undirected {
+						// both ends of an undirected relationship list it
+						err = putAdjacency(b, other)
+					} else {
+						// the other node's record keeps its sources
+						err = b.PutNode(other.record())
+					}
+				}
+			default:
+				err = b.PutNode(c.n.record())
+			}
+			if err != nil {
+				return err
+			}
+			inserted = inserted || c.op.Kind == OpInsertNode
+		}
+		if inserted || g.metaDirty {
+			if err := g.writeMeta(b); err != nil {
+				return err
+			}
+		}
+		if g.history != nil {
+			var err error
+			entries, err = g.history.write(b)
+			return err
+		}
+		return nil
+	})
+	if err == nil {
+		// inserts and relationship changes hold the graph lock
+		g.metaDirty = false
+		if g.history != nil {
+			g.history.written(entries)
+		}
+	}
+	g.storeFailed(err)
+	return err
+}
+
+// putAdjacency writes a node's relationships and its record, which holds their IDs. The caller
+// must hold the graph lock.
+func putAdjacency(b StoreBatch, n *Node) error {
+	destinations, _ := n.adjacentIDs()
+	if err := b.PutAdjacency(n.ID, destinations); err != nil {
+		return err
+	}
+	return b.PutNode(n.record())
+}
+
+// saveMeta writes the graph level settings to the store. The caller must hold the graph lock.
+func (g *Graph) saveMeta() error {
+	if g.store == nil {
+		return nil
+	}
+	return g.writeMeta(g.store)
+}
+
+// writeMeta adds the graph level settings to a batch. The caller must hold the graph lock.
+func (g *Graph) writeMeta(b StoreBatch) error {
+	meta := graphMeta{
+		Name:                 g.Name,
+		DuplicateKeys:        g.duplicateKeys,
+		CircularRelationship: g.circularRelationship,
+		Undirected:           g.undirected,
+		ForbidParallel:       g.forbidParallel,
+		ForbidSelf:           g.forbidSelf,
+		MaxNodes:             g.maxNodes,
+		TopNodeID:            atomic.LoadUint64(&g.topNodeID),
+		TopEdgeID:            g.topEdgeID,
+		Schema:               g.Schema(),
+		Indexes:              g.indexNames(),
+		SearchValues:         g.search != nil && g.search.values,
+		RelationshipExpiry:   g.edgeExpiry,
+		SourceIDs:            true,
+	}
+	data, err := gobBytes(meta)
+	if err != nil {
+		return err
+	}
+	return b.SetMeta(graphMetaKey, data)
+}
+
+// storeFailed records the first store error
+func (g *Graph) storeFailed(err error) {
+	if err == nil {
+		return
+	}
+
+	g.hookLock.Lock()
+	defer g.hookLock.Unlock()
+	if g.storeErr == nil {
+		g.storeErr = err
+	}
+}
+
+// record copies the node into its stored form. The caller must hold the node's lock.
+func (n *Node) record() NodeRecord {
+	_, sources := n.adjacentIDs()
+	rec := NodeRecord{
+		ID:         n.ID,
+		Kind:       n.Kind,
+		Key:        n.Key,
+		Value:      append([]byte(nil), n.Value...),
+		Labels:     append([]string(nil), n.labels...),
+		ExternalID: n.externalID,
+		Expires:    n.expires,
+		EdgeIDs:    append([]uint64(nil), n.edgeIDs...),
+		EdgeTypes:  append([]string(nil), n.edgeTypes...),
+		SourceIDs:  sources,
+	}
+	if len(n.properties) > 0 {
+		rec.Properties = make(map[string]Property, len(n.properties))
+		for name, p := range n.properties {
+			rec.Properties[name] = p.clone()
+		}
+	}
+	return rec
+}
+
+// adjacentIDs returns the IDs of the node's destinations and sources, whether or not they are
+// linked yet. The caller must hold the node's lock.
+func (n *Node) adjacentIDs() (destinations, sources []uint64) {
+	if n.pending {
+		return append([]uint64(nil), n.destinationIDs...), append([]uint64(nil), n.sourceIDs...)
+	}
+	return extractIDs(n.destinations), extractIDs(n.sources)
+}
+
+// MemoryStore is the in memory Store implementation
+type MemoryStore struct {
+	sync.RWMutex
+	nodes     map[uint64]NodeRecord
+	adjacency map[uint64][]uint64
+	keys      map[string]map[uint64]bool
+	meta      map[string][]byte
+}
+
+// NewMemoryStore creates an empty in memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		nodes:     make(map[uint64]NodeRecord),
+		adjacency: make(map[uint64][]uint64),
+		keys:      make(map[string]map[uint64]bool),
+		meta:      make(map[string][]byte),
+	}
+}
+
+// GetNode satisfies the Store interface
+func (s *MemoryStore) GetNode(ID uint64) (NodeRecord, bool, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	rec, ok := s.nodes[ID]
+	return rec, ok, nil
+}
+
+// PutNode satisfies the Store interface
+func (s *MemoryStore) PutNode(rec NodeRecord) error {
+	return s.Update(func(b StoreBatch) error { return b.PutNode(rec) })
+}
+
+// DeleteNode satisfies the Store interface
+func (s *MemoryStore) DeleteNode(ID uint64) error {
+	return s.Update(func(b StoreBatch) error { return b.DeleteNode(ID) })
+}
+
+// Adjacency satisfies the Store interface
+func (s *MemoryStore) Adjacency(ID uint64) ([]uint64, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	return append([]uint64(nil), s.adjacency[ID]...), nil
+}
+
+// PutAdjacency satisfies the Store interface
+func (s *MemoryStore) PutAdjacency(ID uint64, destinations []uint64) error {
+	return s.Update(func(b StoreBatch) error { return b.PutAdjacency(ID, destinations) })
+}
+
+// NodeIDsByKey satisfies the Store interface
+func (s *MemoryStore) NodeIDsByKey(key string) ([]uint64, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	return sortedIDs(s.keys[key]), nil
+}
+
+// Nodes satisfies the Store interface
+func (s *MemoryStore) Nodes() iter.Seq2[NodeRecord, error] {
+	return func(yield func(NodeRecord, error) bool) {
+		s.RLock()
+		records := make([]NodeRecord, 0, len(s.nodes))
+		for _, rec := range s.nodes {
+			records = append(records, rec)
+		}
+		s.RUnlock()
+
+		sort.Slice(records, func(i, j int) bool { return records[i].ID < records[j].ID })
+		for _, rec := range records {
+			if !yield(rec, nil) {
+				return
+			}
+		}
+	}
+}
+
+// Meta satisfies the Store interface
+func (s *MemoryStore) Meta(name string) ([]byte, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	return s.meta[name], nil
+}
+
+// SetMeta satisfies the Store interface
+func (s *MemoryStore) SetMeta(name string, value []byte) error {
+	return s.Update(func(b StoreBatch) error { return b.SetMeta(name, value) })
+}
+
+// Update satisfies the Store interface. The batch's writes are applied together under the
+// store's lock.
+func (s *MemoryStore) Update(fn func(StoreBatch) error) error {
+	var b memoryBatch
+	if err := fn(&b); err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	for _, write := range b {
+		write(s)
+	}
+	return nil
+}
+
+// memoryBatch is the StoreBatch of a MemoryStore. It keeps its writes until the update is applied.
+type memoryBatch []func(s *MemoryStore)
+
+// PutNode satisfies the StoreBatch interface
+func (b *memoryBatch) PutNode(rec NodeRecord) error {
+	*b = append(*b, func(s *MemoryStore) {
+		if old, ok := s.nodes[rec.ID]; ok {
+			delete(s.keys[old.Key], rec.ID)
+		}
+		s.nodes[rec.ID] = rec
+		if s.keys[rec.Key] == nil {
+			s.keys[rec.Key] = make(map[uint64]bool)
+		}
+		s.keys[rec.Key][rec.ID] = true
+	})
+	return nil
+}
+
+// DeleteNode satisfies the StoreBatch interface
+func (b *memoryBatch) DeleteNode(ID uint64) error {
+	*b = append(*b, func(s *MemoryStore) {
+		if old, ok := s.nodes[ID]; ok {
+			delete(s.keys[old.Key], ID)
+		}
+		delete(s.nodes, ID)
+		delete(s.adjacency, ID)
+	})
+	return nil
+}
+
+// PutAdjacency satisfies the StoreBatch interface
+func (b *memoryBatch) PutAdjacency(ID uint64, destinations []uint64) error {
+	destinations = append([]uint64(nil), destinations...)
+	*b = append(*b, func(s *MemoryStore) { s.adjacency[ID] = destinations })
+	return nil
+}
+
+// SetMeta satisfies the StoreBatch interface
+func (b *memoryBatch) SetMeta(name string, value []byte) error {
+	value = append([]byte(nil), value...)
+	*b = append(*b, func(s *MemoryStore) { s.meta[name] = value })
+	return nil
+}
+
+// Close satisfies the Store interface
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+// sortedIDs returns the members of an ID set in ascending order
+func sortedIDs(set map[uint64]bool) []uint64 {
+	ids := make([]uint64, 0, len(set))
+	for id := range set {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
@@ -0,0 +1,377 @@
+package giraffe
+
+import (
+	"errors"
+	"iter"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+// buildStoreGraph applies the same changes to any store backed graph
+func buildStoreGraph(t *testing.T, store Store) *Graph {
+	g, err := NewStoreGraph("testGraph", false, true, store)
+	if err != nil {
+		t.Fatalf("unable to create graph, error: %v", err)
+	}
+	n1, _ := g.InsertDataNode("Intro", []byte("lesson_id 1"))
+	n2, _ := g.InsertDataNode("Factoring", []byte("lesson_id 12"))
+	n3, _ := g.InsertDataNode("Quadratic Formula", []byte("lesson_id 55"))
+	n4 := g.InsertNode()
+	g.Root().AddRelationship(n1)
+	n1.AddRelationship(n2)
+	n1.AddRelationship(n4)
+	n2.AddRelationship(n3)
+	n1.AddLabel("Lesson")
+	n3.SetProperty("difficulty", IntProp(5))
+	n2.SetValue([]byte("lesson_id 13"))
+	n3.SetKey("The Quadratic Formula")
+	g.DeleteNode(n4)
+	g.CreateIndex("difficulty")
+	return g
+}
+
+// checkStoreGraph verifies a graph reopened from a store built by buildStoreGraph
+func checkStoreGraph(t *testing.T, g *Graph) {
+	if g.Name != "testGraph" || g.NodeCount() != 4 || g.LastNodeID() != 4 {
+		t.Fatalf("got graph %q with %d nodes and last id %d", g.Name, g.NodeCount(), g.LastNodeID())
+	}
+	if got, want := slices.Collect(g.Edges()), []Edge{{0, 1}, {1, 2}, {2, 3}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got edges %v, want %v", got, want)
+	}
+	n2, _ := g.Node(2)
+	if string(n2.Value) != "lesson_id 13" {
+		t.Errorf("got value `%s`, want `lesson_id 13`", n2.Value)
+	}
+	if got := extractIDs(n2.ListSources()); !reflect.DeepEqual(got, []uint64{1}) {
+		t.Errorf("got sources %v, want [1]", got)
+	}
+	if n, ok := g.FindNodeByKey("The Quadratic Formula"); !ok || n.ID != 3 {
+		t.Error("key index not restored")
+	}
+	if nodes := g.NodesWithLabel("Lesson"); len(nodes) != 1 || nodes[0].ID != 1 {
+		t.Error("labels not restored")
+	}
+	if nodes, err := g.FindByProperty("difficulty", IntProp(5)); err != nil || len(nodes) != 1 {
+		t.Errorf("property index not restored, error: %v", err)
+	}
+	if _, err := g.InsertDataNode("Intro", nil); err == nil {
+		t.Error("duplicate keys constraint not restored")
+	}
+}
+
+func TestMemoryStoreGraph(t *testing.T) {
+	store := NewMemoryStore()
+	buildStoreGraph(t, store)
+
+	if ids, _ := store.NodeIDsByKey("Factoring"); !reflect.DeepEqual(ids, []uint64{2}) {
+		t.Errorf("got ids %v, want [2]", ids)
+	}
+	if _, ok, _ := store.GetNode(4); ok {
+		t.Error("deleted node still stored")
+	}
+	if _, err := NewStoreGraph("again", true, true, store); err == nil || err.Error() != ErrStoreNotEmpty {
+		t.Errorf("got `%v`, want `%s`", err, ErrStoreNotEmpty)
+	}
+
+	g, err := OpenGraph(store)
+	if err != nil {
+		t.Fatalf("unable to open graph, error: %v", err)
+	}
+	checkStoreGraph(t, g)
+
+	if _, err := OpenGraph(NewMemoryStore()); err == nil || err.Error() != ErrNoGraph {
+		t.Errorf("got `%v`, want `%s`", err, ErrNoGraph)
+	}
+}
+
+func TestFileStoreGraph(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "graph.log")
+	store, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatalf("unable to open store, error: %v", err)
+	}
+	g := buildStoreGraph(t, store)
+	if err := g.Close(); err != nil {
+		t.Fatalf("unable to close graph, error: %v", err)
+	}
+
+	store, _ = OpenFileStore(path)
+	g, err = OpenGraph(store)
+	if err != nil {
+		t.Fatalf("unable to open graph, error: %v", err)
+	}
+	checkStoreGraph(t, g)
+	g.Close()
+}
+
+func TestFileStoreTornWriteAndCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "graph.log")
+	store, _ := OpenFileStore(path)
+	buildStoreGraph(t, store).Close()
+
+	// simulate a crash in the middle of appending a record
+	before, _ := os.Stat(path)
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	f.Write([]byte{0, 0, 1, 0, 1, 2, 3})
+	f.Close()
+
+	store, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatalf("unable to open store, error: %v", err)
+	}
+	if after, _ := os.Stat(path); after.Size() != before.Size() {
+		t.Errorf("torn write not truncated, got size %d, want %d", after.Size(), before.Size())
+	}
+
+	if err := store.Compact(); err != nil {
+		t.Fatalf("unable to compact store, error: %v", err)
+	}
+	if after, _ := os.Stat(path); after.Size() >= before.Size() {
+		t.Errorf("compact did not shrink the log, got size %d from %d", after.Size(), before.Size())
+	}
+
+	g, err := OpenGraph(store)
+	if err != nil {
+		t.Fatalf("unable to open graph, error: %v", err)
+	}
+	checkStoreGraph(t, g)
+	g.Close()
+
+	store, _ = OpenFileStore(path)
+	g, err = OpenGraph(store)
+	if err != nil {
+		t.Fatalf("unable to reopen compacted graph, error: %v", err)
+	}
+	checkStoreGraph(t, g)
+	g.Close()
+}
+
+// countingStore counts the node records read from a store and the updates written to it
+type countingStore struct {
+	Store
+	reads   int
+	updates int
+	fail    bool
+	readErr error
+}
+
+func (s *countingStore) GetNode(ID uint64) (NodeRecord, bool, error) {
+	s.reads++
+	return s.Store.GetNode(ID)
+}
+
+func (s *countingStore) Nodes() iter.Seq2[NodeRecord, error] {
+	return func(yield func(NodeRecord, error) bool) {
+		if s.readErr != nil {
+			yield(NodeRecord{}, s.readErr)
+			return
+		}
+		for rec, err := range s.Store.Nodes() {
+			s.reads++
+			if !yield(rec, err) {
+				return
+			}
+		}
+	}
+}
+
+func (s *countingStore) Update(fn func(StoreBatch) error) error {
+	if s.fail {
+		return errors.New("disk full")
+	}
+	s.updates++
+	return s.Store.Update(fn)
+}
+
+func TestOpenGraphLazy(t *testing.T) {
+	store := &countingStore{Store: NewMemoryStore()}
+	buildStoreGraph(t, store)
+
+	store.reads = 0
+	g, err := OpenGraph(store)
+	if err != nil {
+		t.Fatalf("unable to open graph, error: %v", err)
+	}
+	if store.reads != 0 {
+		t.Errorf("opening read %d nodes, want 0", store.reads)
+	}
+
+	n3, ok := g.FindNodeByKey("The Quadratic Formula")
+	if !ok || n3.ID != 3 || store.reads != 1 {
+		t.Fatalf("got node %v after %d reads, want node 3 after 1", n3, store.reads)
+	}
+	if got := extractIDs(n3.ListSources()); !reflect.DeepEqual(got, []uint64{2}) || store.reads != 2 {
+		t.Errorf("got sources %v after %d reads, want [2] after 2", got, store.reads)
+	}
+	if _, err := g.InsertDataNode("Factoring", nil); err == nil || err.Error() != ErrKeyExists {
+		t.Errorf("got `%v`, want `%s`", err, ErrKeyExists)
+	}
+
+	// changes to nodes read on demand are stored with both ends of their relationships
+	n2, _ := g.Node(2)
+	n5, _ := g.InsertDataNode("Graphing", nil)
+	if err := n5.AddRelationship(n2); err != nil {
+		t.Fatalf("unable to add relationship, error: %v", err)
+	}
+	if err := g.DeleteNode(n3); err != nil {
+		t.Fatalf("unable to delete node, error: %v", err)
+	}
+
+	g, _ = OpenGraph(store)
+	n2, _ = g.Node(2)
+	if got := extractIDs(n2.ListSources()); !reflect.DeepEqual(got, []uint64{1, 5}) {
+		t.Errorf("got sources %v, want [1 5]", got)
+	}
+	if got := extractIDs(n2.ListDestinations()); len(got) != 0 {
+		t.Errorf("got destinations %v, want none", got)
+	}
+	if g.NodeCount() != 4 {
+		t.Errorf("got %d nodes, want 4", g.NodeCount())
+	}
+	n1, _ := g.Node(1)
+	if ID, _ := n2.AddRelationshipID(n1); ID != 6 {
+		t.Errorf("got relationship id %d, want 6", ID)
+	}
+
+	// the cycle check follows relationships through nodes that are not read yet
+	store = &countingStore{Store: NewMemoryStore()}
+	g, _ = NewStoreGraph("acyclic", false, false, store)
+	a, _ := g.InsertDataNode("a", nil)
+	b, _ := g.InsertDataNode("b", nil)
+	c, _ := g.InsertDataNode("c", nil)
+	a.AddRelationship(b)
+	b.AddRelationship(c)
+	g, _ = OpenGraph(store)
+	c, _ = g.FindNodeByKey("c")
+	a, _ = g.FindNodeByKey("a")
+	if err := c.AddRelationship(a); err == nil || err.Error() != ErrCircular {
+		t.Errorf("got `%v`, want `%s`", err, ErrCircular)
+	}
+}
+
+func TestStoreErrors(t *testing.T) {
+	store := &countingStore{Store: NewMemoryStore()}
+	g, _ := NewStoreGraph("testGraph", false, true, store)
+	n1, _ := g.InsertDataNode("Intro", nil)
+
+	store.fail = true
+	if err := n1.SetValue([]byte("lesson_id 1")); err == nil {
+		t.Error("store error not returned")
+	}
+	if string(n1.Value) != "lesson_id 1" {
+		t.Errorf("got value `%s`, want the change applied in memory", n1.Value)
+	}
+	if err := g.Root().AddRelationship(n1); err == nil {
+		t.Error("store error not returned")
+	}
+	if g.StoreErr() == nil {
+		t.Error("store error not recorded")
+	}
+
+	// a failed read is returned where it can be, and otherwise leaves the result partial
+	store = &countingStore{Store: NewMemoryStore()}
+	buildStoreGraph(t, store)
+	store.readErr = errors.New("bad sector")
+	g, _ = OpenGraph(store)
+	if _, err := Diff(g, g); err == nil || err.Error() != "bad sector" {
+		t.Errorf("got `%v`, want `bad sector`", err)
+	}
+	if _, err := g.FindNodesByKeyPattern("*", 0, 0); err == nil {
+		t.Error("store error not returned")
+	}
+	if f := g.Freeze(); f.NodeCount() != 0 || g.StoreErr() == nil {
+		t.Errorf("got %d frozen nodes and `%v`, want none read and the store error", f.NodeCount(), g.StoreErr())
+	}
+}
+
+func TestFileStoreCorruptRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "graph.log")
+	store, _ := OpenFileStore(path)
+	buildStoreGraph(t, store).Close()
+	data, _ := os.ReadFile(path)
+
+	// a last record that fails its checksum is a torn write
+	torn := append([]byte(nil), data...)
+	torn[len(torn)-1] ^= 0xff
+	os.WriteFile(path, torn, 0644)
+	store, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatalf("unable to open store, error: %v", err)
+	}
+	store.Close()
+	if after, _ := os.Stat(path); after.Size() >= int64(len(data)) {
+		t.Errorf("torn record not truncated, got size %d", after.Size())
+	}
+
+	// a damaged record before the end is not
+	corrupt := append([]byte(nil), data...)
+	corrupt[fileRecHeader] ^= 0xff
+	os.WriteFile(path, corrupt, 0644)
+	if _, err := OpenFileStore(path); err == nil || err.Error() != ErrCorruptRecord {
+		t.Errorf("got `%v`, want `%s`", err, ErrCorruptRecord)
+	}
+	if after, _ := os.Stat(path); after.Size() != int64(len(data)) {
+		t.Errorf("corrupt log truncated to %d bytes", after.Size())
+	}
+}
+
+func TestStoreUpdate(t *testing.T) {
+	store := &countingStore{Store: NewMemoryStore()}
+	g, _ := NewStoreGraph("testGraph", false, true, store)
+	n1, _ := g.InsertDataNode("Intro", nil)
+	n2, _ := g.InsertDataNode("Factoring", nil)
+
+	// each change is one update, however many records it writes
+	store.updates = 0
+	n1.AddRelationship(n2)
+	n2.SetProperty("difficulty", IntProp(3))
+	g.DeleteNode(n1)
+	if store.updates != 3 {
+		t.Errorf("got %d updates for 3 changes", store.updates)
+	}
+
+	// nothing is written when the update fails
+	err := store.Update(func(b StoreBatch) error {
+		b.PutNode(NodeRecord{ID: 9, Key: "Graphing"})
+		return errors.New("abandoned")
+	})
+	if err == nil || err.Error() != "abandoned" {
+		t.Errorf("got `%v`, want `abandoned`", err)
+	}
+	if _, ok, _ := store.GetNode(9); ok {
+		t.Error("abandoned update written")
+	}
+}
+
+func TestFileStoreTornUpdate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "graph.log")
+	store, _ := OpenFileStore(path)
+	store.PutNode(NodeRecord{ID: 1, Key: "first"})
+	store.Update(func(b StoreBatch) error {
+		b.PutNode(NodeRecord{ID: 2, Key: "second"})
+		b.PutAdjacency(1, []uint64{2})
+		return nil
+	})
+	store.Close()
+
+	// the process dies while appending the update, so neither of its writes is kept
+	info, _ := os.Stat(path)
+	os.Truncate(path, info.Size()-3)
+	store, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatalf("unable to reopen store, error: %v", err)
+	}
+	defer store.Close()
+	if _, ok, _ := store.GetNode(1); !ok {
+		t.Error("committed node lost")
+	}
+	if _, ok, _ := store.GetNode(2); ok {
+		t.Error("torn update applied")
+	}
+	if destinations, _ := store.Adjacency(1); len(destinations) != 0 {
+		t.Errorf("got destinations %v, want none", destinations)
+	}
+}
@@ -0,0 +1,217 @@
+package giraffe
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// OpKind identifies the type of mutation described by an Operation
+type OpKind int
+
+// Mutation kinds. Label changes and InsertNode() are not passed to validators.
+const (
+	// OpInsertNode is an InsertDataNode() call. NodeID is 0 unless the caller supplied one
+	// with InsertNodeWithID(), as it is not assigned until the node is inserted.
+	OpInsertNode OpKind = iota
+
+	// OpAddRelationship is an AddRelationship() call from NodeID to OtherID
+	OpAddRelationship
+
+	// OpSetValue is a SetValue() call on NodeID
+	OpSetValue
+
+	// OpDeleteNode is a DeleteNode() or DeleteNodeByID() call on NodeID
+	OpDeleteNode
+
+	// OpSetProperty is a SetProperty() call on NodeID
+	OpSetProperty
+
+	// OpDeleteProperty is a DeleteProperty() call on NodeID
+	OpDeleteProperty
+
+	// OpSetKey is a SetKey() call on NodeID. Value holds the node's current value.
+	OpSetKey
+
+	// OpRemoveRelationship is a RemoveRelationship() call from NodeID to OtherID, or a
+	// RemoveRelationshipByID() call when EdgeID is set
+	OpRemoveRelationship
+
+	// OpAddLabel is an AddLabel() call on NodeID
+	OpAddLabel
+
+	// OpRemoveLabel is a RemoveLabel() call on NodeID
+	OpRemoveLabel
+)
+
+// String satisfies the Stringer interface
+func (k OpKind) String() string {
+	switch k {
+	case OpInsertNode:
+		return "insert node"
+	case OpAddRelationship:
+		return "add relationship"
+	case OpSetValue:
+		return "set value"
+	case OpDeleteNode:
+		return "delete node"
+	case OpSetProperty:
+		return "set property"
+	case OpDeleteProperty:
+		return "delete property"
+	case OpSetKey:
+		return "set key"
+	case OpRemoveRelationship:
+		return "remove relationship"
+	case OpAddLabel:
+		return "add label"
+	case OpRemoveLabel:
+		return "remove label"
+	}
+	return fmt.Sprintf("op %d", int(k))
+}
+
+// Operation describes a pending mutation of the graph
+type Operation struct {
+	Kind     OpKind
+	NodeID   uint64
+	OtherID  uint64
+	NodeKind string
+	Key      string
+	Value    []byte
+
+	// PropertyName and Property describe SetProperty() and DeleteProperty() calls.
+	// Property is nil for deletes.
+	PropertyName string
+	Property     *Property
+
+	// Properties holds the initial properties for OpInsertNode
+	Properties map[string]Property
+
+	// ExternalID is the external ID given to InsertExternalNode()
+	ExternalID string
+
+	// Label is the label added or removed by OpAddLabel and OpRemoveLabel
+	Label string
+
+	// EdgeID identifies the relationship added or removed by OpAddRelationship and
+	// OpRemoveRelationship. It is 0 for a pending add, and for a remove of every relationship
+	// between the nodes.
+	EdgeID uint64
+
+	// RelationshipType is the type given to AddTypedRelationship() for OpAddRelationship
+	RelationshipType string
+
+	// Expires is the expiry time of a node inserted with InsertExpiringNode() or a relationship
+	// added with AddExpiringRelationship(), and zero otherwise
+	Expires time.Time
+}
+
+// Validator inspects a pending mutation and returns a non nil error to veto it.
+// Validators run before the graph or any node is locked, so they are free to
+// call read methods on the graph and its nodes. If another change is committed
+// while they run, they run again against the changed graph, so a mutation is
+// only made to the graph its validators saw. Validators may therefore run more
+// than once for a mutation and should not have side effects.
+type Validator func(g *Graph, op Operation) error
+
+// ValidationError is returned when a validator vetoes a mutation
+type ValidationError struct {
+	Op  Operation
+	Err error
+}
+
+// Error satisfies the error interface
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s rejected: %v", e.Op.Kind, e.Err)
+}
+
+// Unwrap returns the error reported by the validator
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// AddValidator registers a validator that runs before InsertDataNode(), AddRelationship(),
+// RemoveRelationship(), SetValue(), SetKey(), SetProperty(), DeleteProperty() and DeleteNode().
+// Validators run after the schema checks, in the order they were added, and the first error
+// stops the mutation.
+func (g *Graph) AddValidator(v Validator) {
+	g.hookLock.Lock()
+	defer g.hookLock.Unlock()
+
+	g.validators = append(g.validators, v)
+}
+
+// lockValidated runs the schema and validators against the operations and locks the graph. ops
+// is called again to rebuild the operations, and they are validated again, whenever another
+// change was committed while the validators ran. A read only graph returns ErrReadOnly. On
+// success the caller must unlock the graph.
+func (g *Graph) lockValidated(ops func() []Operation) error {
+	for {
+		g.hookLock.RLock()
+		unchecked := g.schema == nil && len(g.validators) == 0
+		g.hookLock.RUnlock()
+
+		var seq uint64
+		if !unchecked {
+			g.Lock()
+			seq = g.seq
+			g.Unlock()
+			for _, op := range ops() {
+				if err := g.validate(op); err != nil {
+					return err
+				}
+			}
+		}
+		g.Lock()
+		if unchecked || g.seq == seq {
+			if err := g.writable(); err != nil {
+				g.Unlock()
+				return err
+			}
+			return nil
+		}
+		g.Unlock()
+	}
+}
+
+// writable returns ErrReadOnly for a read only graph. The caller must hold the graph lock.
+func (g *Graph) writable() error {
+	if g.readOnly {
+		return errors.New(ErrReadOnly)
+	}
+	return nil
+}
+
+// lockValidated validates the operation op returns and locks the owning graph, if the node
+// belongs to one. See Graph.lockValidated(). On success the caller must call unlock.
+func (n *Node) lockValidated(op func() Operation) (unlock func(), err error) {
+	if n.graph == nil {
+		return func() {}, nil
+	}
+	if err := n.graph.lockValidated(func() []Operation { return []Operation{op()} }); err != nil {
+		return nil, err
+	}
+	return n.graph.Unlock, nil
+}
+
+// validate runs all registered validators against the operation
+func (g *Graph) validate(op Operation) error {
+	g.hookLock.RLock()
+	validators := g.validators
+	schema := g.schema
+	g.hookLock.RUnlock()
+
+	if schema != nil {
+		if err := schema.check(g, op); err != nil {
+			return &ValidationError{Op: op, Err: err}
+		}
+	}
+
+	for _, v := range validators {
+		if err := v(g, op); err != nil {
+			return &ValidationError{Op: op, Err: err}
+		}
+	}
+	return nil
+}
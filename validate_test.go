@@ -0,0 +1,148 @@
+package giraffe
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestValidatorVetoesInsert(t *testing.T) {
+	g, _ := NewGraph("testGraph")
+	g.AddValidator(func(g *Graph, op Operation) error {
+		if op.Kind == OpInsertNode && !json.Valid(op.Value) {
+			return errors.New("value must be json")
+		}
+		return nil
+	})
+
+	if _, err := g.InsertDataNode("good", []byte(`{"lesson_id": 1}`)); err != nil {
+		t.Errorf("expected no error, got `%v`", err)
+	}
+
+	_, err := g.InsertDataNode("bad", []byte("lesson_id 1"))
+	if err == nil {
+		t.Fatal("expected validator to veto insert")
+	}
+
+	var vErr *ValidationError
+	if !errors.As(err, &vErr) {
+		t.Fatalf("got error type %T, want *ValidationError", err)
+	}
+	if vErr.Op.Kind != OpInsertNode || vErr.Op.Key != "bad" {
+		t.Errorf("unexpected operation in error: %+v", vErr.Op)
+	}
+	if g.NodeCount() != 2 {
+		t.Errorf("got %d nodes, want %d", g.NodeCount(), 2)
+	}
+}
+
+func TestValidatorLimitsRelationships(t *testing.T) {
+	g, _ := NewGraph("testGraph")
+	g.AddValidator(func(g *Graph, op Operation) error {
+		if op.Kind == OpAddRelationship && len(g.nodes[op.NodeID].ListDestinations()) >= 2 {
+			return errors.New("too many prerequisites")
+		}
+		return nil
+	})
+
+	n1 := g.InsertNode()
+	n2 := g.InsertNode()
+	n3 := g.InsertNode()
+
+	if err := g.Root().AddRelationship(n1); err != nil {
+		t.Errorf("expected no error, got `%v`", err)
+	}
+	if err := g.Root().AddRelationship(n2); err != nil {
+		t.Errorf("expected no error, got `%v`", err)
+	}
+	if err := g.Root().AddRelationship(n3); err == nil {
+		t.Error("expected validator to veto third relationship")
+	}
+
+	if got := len(g.Root().ListDestinations()); got != 2 {
+		t.Errorf("got %d destinations, want %d", got, 2)
+	}
+}
+
+func TestValidatorLimitsConcurrentRelationships(t *testing.T) {
+	g, _ := NewGraph("testGraph")
+	g.AddValidator(func(g *Graph, op Operation) error {
+		if op.Kind != OpAddRelationship {
+			return nil
+		}
+		n, _ := g.Node(op.NodeID)
+		if len(n.ListDestinations()) >= 5 {
+			return errors.New("too many prerequisites")
+		}
+		return nil
+	})
+
+	var nodes []*Node
+	for i := 0; i < 50; i++ {
+		nodes = append(nodes, g.InsertNode())
+	}
+	var wg sync.WaitGroup
+	for _, n := range nodes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			g.Root().AddRelationship(n)
+		}()
+	}
+	wg.Wait()
+
+	if got := len(g.Root().ListDestinations()); got != 5 {
+		t.Errorf("got %d destinations, want %d", got, 5)
+	}
+
+	// patches are checked against the graph they are applied to as well
+	from := nodes[0]
+	for _, to := range nodes[1:5] {
+		from.AddRelationship(to)
+	}
+	wg.Add(2)
+	for _, to := range nodes[5:7] {
+		go func() {
+			defer wg.Done()
+			Patch{AddedEdges: []Edge{{From: from.ID, To: to.ID}}}.Apply(g)
+		}()
+	}
+	wg.Wait()
+	if got := len(from.ListDestinations()); got != 5 {
+		t.Errorf("got %d destinations, want %d", got, 5)
+	}
+}
+
+func TestValidatorSetValueAndDelete(t *testing.T) {
+	g, _ := NewGraph("testGraph")
+	n1, _ := g.InsertDataNode("locked", []byte("original"))
+
+	g.AddValidator(func(g *Graph, op Operation) error {
+		if op.NodeID == n1.ID && (op.Kind == OpSetValue || op.Kind == OpDeleteNode) {
+			return errors.New("node is locked")
+		}
+		return nil
+	})
+
+	if err := n1.SetValue([]byte("changed")); err == nil {
+		t.Error("expected validator to veto SetValue")
+	}
+	if string(n1.Value) != "original" {
+		t.Errorf("got `%s`, want `original`", n1.Value)
+	}
+
+	if err := g.DeleteNode(n1); err == nil {
+		t.Error("expected validator to veto DeleteNode")
+	}
+	if err := g.DeleteNodeByID(n1.ID); err == nil {
+		t.Error("expected validator to veto DeleteNodeByID")
+	}
+	if _, ok := g.nodes[n1.ID]; !ok {
+		t.Error("node should not have been deleted")
+	}
+
+	if err := g.Root().SetValue([]byte("root")); err != nil {
+		t.Errorf("expected no error, got `%v`", err)
+	}
+}